@@ -0,0 +1,322 @@
+// Package client is a Go client for go-mem-kv, and for Redis servers in
+// general, with typed methods for the commands the server supports.
+//
+// A Client keeps a small pool of connections per server and pipelines the
+// commands of concurrent callers on them automatically, so it is meant to be
+// shared by the whole program:
+//
+//	c := client.New(client.Options{Addr: "localhost:6379"})
+//	defer c.Close()
+//	if err := c.Set(ctx, "greeting", "hello", time.Minute); err != nil { ... }
+//	value, err := c.Get(ctx, "greeting") // client.ErrNil when missing
+//
+// Replies are decoded from RESP2 and RESP3. Commands that change the state
+// of a connection, such as SELECT and CLIENT TRACKING, are methods of a
+// dedicated Conn instead, and pub/sub and MONITOR use connections of their
+// own as well. MOVED and ASK redirects of a cluster are followed.
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configures a Client. The zero value connects to localhost:6379.
+type Options struct {
+	// Network is "tcp" or "unix"; tcp by default
+	Network string
+	// Addr is the host:port, or the path of a Unix socket
+	Addr string
+	// DB is the database the connections SELECT
+	DB int
+	// Protocol is 2 for RESP2 or 3 for RESP3, the default
+	Protocol int
+	// ClientName is set as the name of every connection
+	ClientName string
+	// PoolSize is the number of connections per server commands are
+	// pipelined on; 4 by default
+	PoolSize int
+	// DialTimeout bounds connecting; 5 seconds by default
+	DialTimeout time.Duration
+	// KeepAlive is the TCP keepalive period; zero leaves the system default
+	KeepAlive time.Duration
+	// MaxRedirects is the number of MOVED and ASK redirects a command
+	// follows; 3 by default, negative disables following them
+	MaxRedirects int
+}
+
+func (opts *Options) init() {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.Addr == "" {
+		opts.Addr = "localhost:6379"
+	}
+	if opts.Protocol == 0 {
+		opts.Protocol = 3
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 4
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.MaxRedirects == 0 {
+		opts.MaxRedirects = 3
+	}
+}
+
+// ErrClosed is returned by the commands of a closed Client
+var ErrClosed = errors.New("client: closed")
+
+// Client is a pool of pipelined connections, safe for concurrent use
+type Client struct {
+	cmdable
+	opts Options
+
+	mu     sync.Mutex
+	nodes  map[string]*node
+	closed bool
+	// slots maps the hash slots MOVED redirects taught to the address
+	// serving them
+	slots map[int]string
+}
+
+// New returns a client for the server of opts. It connects on first use.
+func New(opts Options) *Client {
+	opts.init()
+	c := &Client{opts: opts, nodes: map[string]*node{}, slots: map[int]string{}}
+	c.cmdable = cmdable{do: c.process}
+	return c
+}
+
+// Close closes the connections of the client
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, n := range c.nodes {
+		n.close()
+	}
+	return nil
+}
+
+// node returns the pool of the server at addr
+func (c *Client) node(addr string) (*node, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClosed
+	}
+	n := c.nodes[addr]
+	if n == nil {
+		n = &node{addr: addr, opts: &c.opts}
+		c.nodes[addr] = n
+	}
+	return n, nil
+}
+
+// process runs a command on the server serving its key, following redirects
+func (c *Client) process(ctx context.Context, args ...string) (any, error) {
+	addr := c.opts.Addr
+	if key, ok := commandKey(args); ok {
+		c.mu.Lock()
+		if slotAddr, ok := c.slots[keySlot(key)]; ok {
+			addr = slotAddr
+		}
+		c.mu.Unlock()
+	}
+	asking := false
+	for redirects := 0; ; redirects++ {
+		n, err := c.node(addr)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := n.do(ctx, args, asking)
+		var e Error
+		if !errors.As(err, &e) || redirects >= c.opts.MaxRedirects {
+			return reply, err
+		}
+		kind, slot, target, ok := parseRedirect(e, addr)
+		if !ok {
+			return reply, err
+		}
+		asking = kind == "ASK"
+		if !asking {
+			c.mu.Lock()
+			c.slots[slot] = target
+			c.mu.Unlock()
+		}
+		addr = target
+	}
+}
+
+// parseRedirect parses "MOVED <slot> <host:port>" and "ASK <slot>
+// <host:port>". An empty host means the one of from.
+func parseRedirect(e Error, from string) (kind string, slot int, addr string, ok bool) {
+	fields := strings.Fields(string(e))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", 0, "", false
+	}
+	slot, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, "", false
+	}
+	host, port, err := net.SplitHostPort(fields[2])
+	if err != nil {
+		return "", 0, "", false
+	}
+	if host == "" || host == "?" {
+		host, _, _ = net.SplitHostPort(from)
+	}
+	return fields[0], slot, net.JoinHostPort(host, port), true
+}
+
+// commandKey returns the first key of a command, to find the hash slot it
+// belongs to
+func commandKey(args []string) (string, bool) {
+	if len(args) < 2 {
+		return "", false
+	}
+	switch strings.ToLower(args[0]) {
+	case "object", "memory":
+		if len(args) < 3 {
+			return "", false
+		}
+		return args[2], true
+	case "get", "set", "setnx", "setex", "psetex", "getset", "getdel", "getex", "mget", "mset", "msetnx",
+		"incr", "decr", "incrby", "decrby", "incrbyfloat", "append", "getrange", "substr", "setrange", "strlen",
+		"del", "unlink", "exists", "touch", "expire", "pexpire", "ttl", "pttl", "persist", "type",
+		"rename", "renamenx", "copy", "move":
+		return args[1], true
+	}
+	return "", false
+}
+
+// keySlot returns the cluster hash slot of key: the CRC16 of the key, or of
+// its hash tag between { and }, modulo 16384
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % 16384
+}
+
+// node is the pool of connections to one server
+type node struct {
+	addr string
+	opts *Options
+
+	mu     sync.Mutex
+	conns  []*conn
+	closed bool
+}
+
+// get returns the least busy connection, opening another one while the pool
+// is not full and every connection is busy
+func (n *node) get(ctx context.Context) (*conn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return nil, ErrClosed
+	}
+	live := n.conns[:0]
+	for _, c := range n.conns {
+		if !c.broken() {
+			live = append(live, c)
+		}
+	}
+	clear(n.conns[len(live):])
+	n.conns = live
+
+	var best *conn
+	for _, c := range n.conns {
+		if best == nil || c.inflight.Load() < best.inflight.Load() {
+			best = c
+		}
+	}
+	if best != nil && (best.inflight.Load() == 0 || len(n.conns) >= n.opts.PoolSize) {
+		return best, nil
+	}
+	netConn, reader, err := dial(ctx, n.opts, n.addr)
+	if err != nil {
+		if best != nil {
+			return best, nil
+		}
+		return nil, err
+	}
+	c := newConn(netConn, reader)
+	n.conns = append(n.conns, c)
+	return c, nil
+}
+
+func (n *node) do(ctx context.Context, args []string, asking bool) (any, error) {
+	c, err := n.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(ctx, args, asking)
+}
+
+func (n *node) close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.closed = true
+	for _, c := range n.conns {
+		c.close(errConnClosed)
+	}
+	n.conns = nil
+}
+
+// Conn is a connection of its own, for commands that change the state of
+// the connection, such as SELECT, CLIENT SETNAME and CLIENT TRACKING. It
+// pipelines concurrent commands like the connections of a Client, but does
+// not follow redirects.
+type Conn struct {
+	cmdable
+	conn *conn
+}
+
+// Conn opens a dedicated connection to the server of the client
+func (c *Client) Conn(ctx context.Context) (*Conn, error) {
+	netConn, reader, err := dial(ctx, &c.opts, c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &Conn{conn: newConn(netConn, reader)}
+	cn.cmdable = cmdable{do: func(ctx context.Context, args ...string) (any, error) {
+		return cn.conn.do(ctx, args, false)
+	}}
+	return cn, nil
+}
+
+// SetPushHandler sets the function the RESP3 pushes of the connection go
+// to, such as the invalidation messages of CLIENT TRACKING. It runs on the
+// reader of the connection, so it must not block.
+func (cn *Conn) SetPushHandler(fn func(push []any)) {
+	cn.conn.push.Store(&fn)
+}
+
+// Close closes the connection
+func (cn *Conn) Close() error {
+	cn.conn.close(errConnClosed)
+	return nil
+}
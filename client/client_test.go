@@ -0,0 +1,302 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tluyben/go-mem-kv/kvstore"
+)
+
+// startServer serves a fresh server on a local port and returns its address
+func startServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go kvstore.NewRedisServer(nil).ServeListener(ctx, listener)
+	return listener.Addr().String()
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	addr := startServer(t)
+	for _, protocol := range []int{2, 3} {
+		t.Run("RESP"+strconv.Itoa(protocol), func(t *testing.T) {
+			c := New(Options{Addr: addr, Protocol: protocol})
+			defer c.Close()
+			c.FlushAll(ctx, false)
+
+			if err := c.Set(ctx, "greeting", "hello", time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if value, err := c.Get(ctx, "greeting"); value != "hello" || err != nil {
+				t.Fatalf("Get returned %q, %v", value, err)
+			}
+			if _, err := c.Get(ctx, "missing"); err != ErrNil {
+				t.Fatalf("Get of a missing key returned %v", err)
+			}
+			if _, err := c.SetArgs(ctx, "greeting", "hi", SetArgs{NX: true}); err != ErrNil {
+				t.Fatalf("SET NX of an existing key returned %v", err)
+			}
+			if old, err := c.SetArgs(ctx, "greeting", "hi", SetArgs{KeepTTL: true, Get: true}); old != "hello" || err != nil {
+				t.Fatalf("SET GET returned %q, %v", old, err)
+			}
+			if ttl, err := c.TTL(ctx, "greeting"); ttl <= 0 || ttl > time.Minute || err != nil {
+				t.Fatalf("TTL returned %v, %v", ttl, err)
+			}
+			if ttl, _ := c.TTL(ctx, "missing"); ttl != TTLNoKey {
+				t.Fatalf("TTL of a missing key returned %v", ttl)
+			}
+			if n, err := c.IncrBy(ctx, "n", 5); n != 5 || err != nil {
+				t.Fatalf("IncrBy returned %d, %v", n, err)
+			}
+			if f, err := c.IncrByFloat(ctx, "n", 0.5); f != 5.5 || err != nil {
+				t.Fatalf("IncrByFloat returned %v, %v", f, err)
+			}
+			var e Error
+			if _, err := c.Incr(ctx, "greeting"); !errors.As(err, &e) || e.Prefix() != "ERR" {
+				t.Fatalf("Incr of a string returned %v", err)
+			}
+			values, found, err := c.MGet(ctx, "greeting", "missing")
+			if err != nil || values[0] != "hi" || !found[0] || found[1] {
+				t.Fatalf("MGet returned %q %v, %v", values, found, err)
+			}
+			keys, cursor, err := c.Scan(ctx, 0, "gree*", 100)
+			if err != nil || cursor != 0 || len(keys) != 1 || keys[0] != "greeting" {
+				t.Fatalf("Scan returned %q %d, %v", keys, cursor, err)
+			}
+			if cfg, err := c.ConfigGet(ctx, "maxclients"); err != nil || cfg["maxclients"] != "10000" {
+				t.Fatalf("ConfigGet returned %v, %v", cfg, err)
+			}
+			if n, err := c.Del(ctx, "greeting", "n", "missing"); n != 2 || err != nil {
+				t.Fatalf("Del returned %d, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	ctx := context.Background()
+	c := New(Options{Addr: startServer(t), PoolSize: 2})
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if _, err := c.Incr(ctx, "counter"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n, _ := c.Incr(ctx, "counter"); n != 5001 {
+		t.Fatalf("counter is %d", n)
+	}
+	for _, n := range c.nodes {
+		if len(n.conns) > 2 {
+			t.Fatalf("the pool opened %d connections", len(n.conns))
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Get(cancelled, "counter"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Get with a cancelled context returned %v", err)
+	}
+	if value, err := c.Get(ctx, "counter"); value != "5001" || err != nil {
+		t.Fatalf("Get after a cancelled one returned %q, %v", value, err)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	ctx := context.Background()
+	c := New(Options{Addr: startServer(t)})
+	defer c.Close()
+
+	ps, err := c.Subscribe(ctx, "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.PSubscribe(ctx, "user.*"); err != nil {
+		t.Fatal(err)
+	}
+	c.Publish(ctx, "news", "hello")
+	c.Publish(ctx, "user.1", "joined")
+	for _, want := range []Message{{Channel: "news", Payload: "hello"}, {Channel: "user.1", Pattern: "user.*", Payload: "joined"}} {
+		select {
+		case msg := <-ps.Messages():
+			if msg != want {
+				t.Fatalf("got %+v, want %+v", msg, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message")
+		}
+	}
+	if err := ps.Unsubscribe(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Publish(ctx, "news", "again"); n != 0 {
+		t.Fatalf("%d subscribers after unsubscribing", n)
+	}
+	if err := ps.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ps.Close()
+	if _, ok := <-ps.Messages(); ok {
+		t.Fatal("Messages is open after Close")
+	}
+}
+
+func TestConnTracking(t *testing.T) {
+	ctx := context.Background()
+	c := New(Options{Addr: startServer(t)})
+	defer c.Close()
+
+	cn, err := c.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+	invalidated := make(chan []any, 1)
+	cn.SetPushHandler(func(push []any) { invalidated <- push })
+	if err := cn.ClientTracking(ctx, true, TrackingArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cn.Select(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	cn.Set(ctx, "k", "v", 0)
+	cn.Get(ctx, "k")
+	if _, err := c.Get(ctx, "k"); err != ErrNil {
+		t.Fatalf("the client sees the key SELECTed away on the Conn: %v", err)
+	}
+	// Another connection changing the key invalidates it
+	c.Set(ctx, "source", "w", 0)
+	if copied, err := c.CopyToDB(ctx, "source", "k", 1, true); !copied || err != nil {
+		t.Fatalf("CopyToDB returned %v, %v", copied, err)
+	}
+	select {
+	case push := <-invalidated:
+		if fmt.Sprint(push) != "[invalidate [k]]" {
+			t.Fatalf("got push %v", push)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no invalidation")
+	}
+}
+
+// TestRedirects serves a node that redirects every command to the real server
+// TestReplyDuringWrite checks that a command larger than the write buffer
+// gets its reply when the reply arrives before the command is fully written
+func TestReplyDuringWrite(t *testing.T) {
+	local, remote := net.Pipe()
+	c := newConn(local, bufio.NewReader(local))
+	defer c.close(errConnClosed)
+	answered := make(chan struct{})
+	go func() {
+		// Answer once the start of the command is in, and read the rest
+		// only after the reply was handed out
+		var buf [64]byte
+		remote.Read(buf[:])
+		remote.Write([]byte("+OK\r\n"))
+		<-answered
+		for {
+			if _, err := remote.Read(buf[:]); err != nil {
+				return
+			}
+		}
+	}()
+	value := strings.Repeat("x", 64<<10)
+	reply, err := c.do(context.Background(), []string{"SET", "k", value}, false)
+	close(answered)
+	if reply != "OK" || err != nil {
+		t.Fatalf("SET returned %v, %v", reply, err)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	ctx := context.Background()
+	addr := startServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var redirected atomic.Int64
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					reply, _, err := readReply(reader)
+					if err != nil {
+						return
+					}
+					args := reply.([]any)
+					kind := "MOVED"
+					if args[1] == "ask" {
+						kind = "ASK"
+					}
+					redirected.Add(1)
+					fmt.Fprintf(conn, "-%s %d %s\r\n", kind, keySlot(args[1].(string)), addr)
+				}
+			}()
+		}
+	}()
+
+	c := New(Options{Addr: listener.Addr().String(), Protocol: 2})
+	defer c.Close()
+	if err := c.Set(ctx, "k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	// The slot of k is known to be served elsewhere now
+	if value, err := c.Get(ctx, "k"); value != "v" || err != nil {
+		t.Fatalf("Get returned %q, %v", value, err)
+	}
+	if n := redirected.Load(); n != 1 {
+		t.Fatalf("%d commands were redirected", n)
+	}
+	// ASK redirects once without remembering the slot
+	if _, err := c.Get(ctx, "ask"); err != ErrNil {
+		t.Fatalf("Get after ASK returned %v", err)
+	}
+	if _, err := c.Get(ctx, "ask"); err != ErrNil || redirected.Load() != 3 {
+		t.Fatalf("Get after ASK returned %v with %d redirects", err, redirected.Load())
+	}
+}
+
+func TestKeySlot(t *testing.T) {
+	// The example of the Redis cluster specification
+	if slot := keySlot("123456789"); slot != 0x31c3 {
+		t.Errorf("slot of 123456789 is %d", slot)
+	}
+	if keySlot("{user1000}.following") != keySlot("{user1000}.followers") || keySlot("{user1000}.following") != keySlot("user1000") {
+		t.Error("keys with the same hash tag are in different slots")
+	}
+	// An empty hash tag does not count
+	if keySlot("foo{}{bar}") == keySlot("bar") {
+		t.Error("an empty hash tag was used")
+	}
+}
@@ -0,0 +1,907 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The durations TTL and PTTL return for missing keys and keys without a
+// time to live
+const (
+	TTLNoKey      time.Duration = -2
+	TTLPersistent time.Duration = -1
+)
+
+// cmdable has the typed methods of the commands that Client and Conn share
+type cmdable struct {
+	do func(ctx context.Context, args ...string) (any, error)
+}
+
+// Do runs any command and returns its decoded reply, for commands without a
+// typed method. Error replies are returned as Error.
+func (c *cmdable) Do(ctx context.Context, args ...string) (any, error) {
+	return c.do(ctx, args...)
+}
+
+func unexpected(reply any) error {
+	return fmt.Errorf("client: unexpected reply %T", reply)
+}
+
+func replyOK(reply any, err error) error {
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return ErrNil
+	}
+	return nil
+}
+
+func replyString(reply any, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return "", ErrNil
+	case string:
+		return v, nil
+	}
+	return "", unexpected(reply)
+}
+
+func replyInt(reply any, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return 0, ErrNil
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	}
+	return 0, unexpected(reply)
+}
+
+func replyBool(reply any, err error) (bool, error) {
+	if b, ok := reply.(bool); ok && err == nil {
+		return b, nil
+	}
+	n, err := replyInt(reply, err)
+	return n != 0, err
+}
+
+func replyFloat(reply any, err error) (float64, error) {
+	if f, ok := reply.(float64); ok && err == nil {
+		return f, nil
+	}
+	s, err := replyString(reply, err)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// replyStrings converts an array of strings; nil elements become ""
+func replyStrings(reply any, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, unexpected(reply)
+	}
+	strs := make([]string, len(items))
+	for i, item := range items {
+		if item != nil {
+			strs[i] = fmt.Sprint(item)
+		}
+	}
+	return strs, nil
+}
+
+// replyMap converts a RESP3 map or the flat array of keys and values RESP2
+// has for it
+func replyMap(reply any, err error) (map[string]any, error) {
+	if err != nil {
+		return nil, err
+	}
+	switch v := reply.(type) {
+	case map[string]any:
+		return v, nil
+	case []any:
+		m := make(map[string]any, len(v)/2)
+		for i := 0; i+1 < len(v); i += 2 {
+			m[fmt.Sprint(v[i])] = v[i+1]
+		}
+		return m, nil
+	}
+	return nil, unexpected(reply)
+}
+
+func replyStringMap(reply any, err error) (map[string]string, error) {
+	m, err := replyMap(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	strs := make(map[string]string, len(m))
+	for key, value := range m {
+		strs[key] = fmt.Sprint(value)
+	}
+	return strs, nil
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
+
+// Ping checks the connection
+func (c *cmdable) Ping(ctx context.Context) error {
+	_, err := c.do(ctx, "PING")
+	return err
+}
+
+// Info returns the INFO text of the given sections, or of the default ones
+func (c *cmdable) Info(ctx context.Context, sections ...string) (string, error) {
+	return replyString(c.do(ctx, append([]string{"INFO"}, sections...)...))
+}
+
+// Get returns the value of key, or ErrNil when it does not exist
+func (c *cmdable) Get(ctx context.Context, key string) (string, error) {
+	return replyString(c.do(ctx, "GET", key))
+}
+
+// Set sets key to value, expiring after ttl unless it is zero
+func (c *cmdable) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	args := []string{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", milliseconds(ttl))
+	}
+	return replyOK(c.do(ctx, args...))
+}
+
+// SetArgs are the options of SET
+type SetArgs struct {
+	// TTL expires the key after it, ExpireAt at that time
+	TTL      time.Duration
+	ExpireAt time.Time
+	// KeepTTL keeps the time to live of the key
+	KeepTTL bool
+	// NX only sets a missing key and XX only an existing one
+	NX, XX bool
+	// Get returns the previous value
+	Get bool
+}
+
+// SetArgs runs SET with options. It returns the previous value when
+// args.Get is set, and ErrNil when NX or XX kept the key from being set or
+// there was no previous value.
+func (c *cmdable) SetArgs(ctx context.Context, key, value string, args SetArgs) (string, error) {
+	cmd := []string{"SET", key, value}
+	switch {
+	case args.TTL > 0:
+		cmd = append(cmd, "PX", milliseconds(args.TTL))
+	case !args.ExpireAt.IsZero():
+		cmd = append(cmd, "PXAT", strconv.FormatInt(args.ExpireAt.UnixMilli(), 10))
+	case args.KeepTTL:
+		cmd = append(cmd, "KEEPTTL")
+	}
+	if args.NX {
+		cmd = append(cmd, "NX")
+	}
+	if args.XX {
+		cmd = append(cmd, "XX")
+	}
+	if !args.Get {
+		return "", replyOK(c.do(ctx, cmd...))
+	}
+	return replyString(c.do(ctx, append(cmd, "GET")...))
+}
+
+// SetNX sets key unless it exists and reports whether it did
+func (c *cmdable) SetNX(ctx context.Context, key, value string) (bool, error) {
+	return replyBool(c.do(ctx, "SETNX", key, value))
+}
+
+// SetEx sets key to value expiring after ttl, in whole seconds
+func (c *cmdable) SetEx(ctx context.Context, key, value string, ttl time.Duration) error {
+	return replyOK(c.do(ctx, "SETEX", key, seconds(ttl), value))
+}
+
+// PSetEx sets key to value expiring after ttl, in milliseconds
+func (c *cmdable) PSetEx(ctx context.Context, key, value string, ttl time.Duration) error {
+	return replyOK(c.do(ctx, "PSETEX", key, milliseconds(ttl), value))
+}
+
+// GetSet sets key and returns its previous value, or ErrNil if it had none
+func (c *cmdable) GetSet(ctx context.Context, key, value string) (string, error) {
+	return replyString(c.do(ctx, "GETSET", key, value))
+}
+
+// GetDel deletes key and returns its value, or ErrNil if it did not exist
+func (c *cmdable) GetDel(ctx context.Context, key string) (string, error) {
+	return replyString(c.do(ctx, "GETDEL", key))
+}
+
+// GetExArgs are the options of GETEX; without any the time to live is left
+// as it is
+type GetExArgs struct {
+	TTL      time.Duration
+	ExpireAt time.Time
+	Persist  bool
+}
+
+// GetEx returns the value of key and changes its time to live
+func (c *cmdable) GetEx(ctx context.Context, key string, args GetExArgs) (string, error) {
+	cmd := []string{"GETEX", key}
+	switch {
+	case args.TTL > 0:
+		cmd = append(cmd, "PX", milliseconds(args.TTL))
+	case !args.ExpireAt.IsZero():
+		cmd = append(cmd, "PXAT", strconv.FormatInt(args.ExpireAt.UnixMilli(), 10))
+	case args.Persist:
+		cmd = append(cmd, "PERSIST")
+	}
+	return replyString(c.do(ctx, cmd...))
+}
+
+// MGet returns the values of keys, with found false for the missing ones
+func (c *cmdable) MGet(ctx context.Context, keys ...string) (values []string, found []bool, err error) {
+	reply, err := c.do(ctx, append([]string{"MGET"}, keys...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, nil, unexpected(reply)
+	}
+	values, found = make([]string, len(items)), make([]bool, len(items))
+	for i, item := range items {
+		values[i], found[i] = item.(string)
+	}
+	return values, found, nil
+}
+
+func pairArgs(name string, pairs map[string]string) []string {
+	args := make([]string, 0, 1+2*len(pairs))
+	args = append(args, name)
+	for key, value := range pairs {
+		args = append(args, key, value)
+	}
+	return args
+}
+
+// MSet sets several keys at once
+func (c *cmdable) MSet(ctx context.Context, pairs map[string]string) error {
+	return replyOK(c.do(ctx, pairArgs("MSET", pairs)...))
+}
+
+// MSetNX sets several keys at once unless any of them exists, and reports
+// whether it did
+func (c *cmdable) MSetNX(ctx context.Context, pairs map[string]string) (bool, error) {
+	return replyBool(c.do(ctx, pairArgs("MSETNX", pairs)...))
+}
+
+// Incr increments the integer value of key by one and returns it
+func (c *cmdable) Incr(ctx context.Context, key string) (int64, error) {
+	return replyInt(c.do(ctx, "INCR", key))
+}
+
+// Decr decrements the integer value of key by one and returns it
+func (c *cmdable) Decr(ctx context.Context, key string) (int64, error) {
+	return replyInt(c.do(ctx, "DECR", key))
+}
+
+// IncrBy increments the integer value of key and returns it
+func (c *cmdable) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return replyInt(c.do(ctx, "INCRBY", key, strconv.FormatInt(delta, 10)))
+}
+
+// DecrBy decrements the integer value of key and returns it
+func (c *cmdable) DecrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return replyInt(c.do(ctx, "DECRBY", key, strconv.FormatInt(delta, 10)))
+}
+
+// IncrByFloat increments the float value of key and returns it
+func (c *cmdable) IncrByFloat(ctx context.Context, key string, delta float64) (float64, error) {
+	return replyFloat(c.do(ctx, "INCRBYFLOAT", key, strconv.FormatFloat(delta, 'f', -1, 64)))
+}
+
+// Append appends value to key and returns the new length
+func (c *cmdable) Append(ctx context.Context, key, value string) (int64, error) {
+	return replyInt(c.do(ctx, "APPEND", key, value))
+}
+
+// GetRange returns the bytes start to end of the value of key; negative
+// offsets count from the end
+func (c *cmdable) GetRange(ctx context.Context, key string, start, end int64) (string, error) {
+	return replyString(c.do(ctx, "GETRANGE", key, strconv.FormatInt(start, 10), strconv.FormatInt(end, 10)))
+}
+
+// SetRange overwrites the value of key from offset and returns the new length
+func (c *cmdable) SetRange(ctx context.Context, key string, offset int64, value string) (int64, error) {
+	return replyInt(c.do(ctx, "SETRANGE", key, strconv.FormatInt(offset, 10), value))
+}
+
+// StrLen returns the length of the value of key
+func (c *cmdable) StrLen(ctx context.Context, key string) (int64, error) {
+	return replyInt(c.do(ctx, "STRLEN", key))
+}
+
+// Del deletes keys and returns how many existed
+func (c *cmdable) Del(ctx context.Context, keys ...string) (int64, error) {
+	return replyInt(c.do(ctx, append([]string{"DEL"}, keys...)...))
+}
+
+// Unlink deletes keys like Del
+func (c *cmdable) Unlink(ctx context.Context, keys ...string) (int64, error) {
+	return replyInt(c.do(ctx, append([]string{"UNLINK"}, keys...)...))
+}
+
+// Exists returns how many of keys exist
+func (c *cmdable) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return replyInt(c.do(ctx, append([]string{"EXISTS"}, keys...)...))
+}
+
+// Touch updates the access time of keys and returns how many exist
+func (c *cmdable) Touch(ctx context.Context, keys ...string) (int64, error) {
+	return replyInt(c.do(ctx, append([]string{"TOUCH"}, keys...)...))
+}
+
+// Keys returns the keys matching a glob pattern
+func (c *cmdable) Keys(ctx context.Context, pattern string) ([]string, error) {
+	return replyStrings(c.do(ctx, "KEYS", pattern))
+}
+
+// Scan returns a page of the keys matching a glob pattern, all keys when it
+// is empty, and the cursor of the next page, which is 0 after the last one
+func (c *cmdable) Scan(ctx context.Context, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	return c.ScanType(ctx, cursor, match, count, "")
+}
+
+// ScanType is Scan for the keys of one type
+func (c *cmdable) ScanType(ctx context.Context, cursor uint64, match string, count int64, keyType string) ([]string, uint64, error) {
+	cmd := []string{"SCAN", strconv.FormatUint(cursor, 10)}
+	if match != "" {
+		cmd = append(cmd, "MATCH", match)
+	}
+	if count > 0 {
+		cmd = append(cmd, "COUNT", strconv.FormatInt(count, 10))
+	}
+	if keyType != "" {
+		cmd = append(cmd, "TYPE", keyType)
+	}
+	reply, err := c.do(ctx, cmd...)
+	if err != nil {
+		return nil, 0, err
+	}
+	page, ok := reply.([]any)
+	if !ok || len(page) != 2 {
+		return nil, 0, unexpected(reply)
+	}
+	next, err := replyInt(page[0], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	keys, err := replyStrings(page[1], nil)
+	return keys, uint64(next), err
+}
+
+// Expire sets the time to live of key in whole seconds and reports whether
+// the key exists
+func (c *cmdable) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return replyBool(c.do(ctx, "EXPIRE", key, seconds(ttl)))
+}
+
+// PExpire sets the time to live of key in milliseconds and reports whether
+// the key exists
+func (c *cmdable) PExpire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return replyBool(c.do(ctx, "PEXPIRE", key, milliseconds(ttl)))
+}
+
+func replyTTL(unit time.Duration) func(any, error) (time.Duration, error) {
+	return func(reply any, err error) (time.Duration, error) {
+		n, err := replyInt(reply, err)
+		if err != nil {
+			return 0, err
+		}
+		switch ttl := time.Duration(n); ttl {
+		case TTLNoKey, TTLPersistent:
+			return ttl, nil
+		}
+		return time.Duration(n) * unit, nil
+	}
+}
+
+// TTL returns the time to live of key in seconds, TTLNoKey for a missing key
+// and TTLPersistent for a key without one
+func (c *cmdable) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return replyTTL(time.Second)(c.do(ctx, "TTL", key))
+}
+
+// PTTL is TTL in milliseconds
+func (c *cmdable) PTTL(ctx context.Context, key string) (time.Duration, error) {
+	return replyTTL(time.Millisecond)(c.do(ctx, "PTTL", key))
+}
+
+// Persist removes the time to live of key and reports whether it had one
+func (c *cmdable) Persist(ctx context.Context, key string) (bool, error) {
+	return replyBool(c.do(ctx, "PERSIST", key))
+}
+
+// Type returns the type of key, "none" when it does not exist
+func (c *cmdable) Type(ctx context.Context, key string) (string, error) {
+	return replyString(c.do(ctx, "TYPE", key))
+}
+
+// Rename renames key, replacing newKey
+func (c *cmdable) Rename(ctx context.Context, key, newKey string) error {
+	return replyOK(c.do(ctx, "RENAME", key, newKey))
+}
+
+// RenameNX renames key unless newKey exists and reports whether it did
+func (c *cmdable) RenameNX(ctx context.Context, key, newKey string) (bool, error) {
+	return replyBool(c.do(ctx, "RENAMENX", key, newKey))
+}
+
+// Copy copies source to destination, replacing it when replace is set, and
+// reports whether it did
+func (c *cmdable) Copy(ctx context.Context, source, destination string, replace bool) (bool, error) {
+	cmd := []string{"COPY", source, destination}
+	if replace {
+		cmd = append(cmd, "REPLACE")
+	}
+	return replyBool(c.do(ctx, cmd...))
+}
+
+// CopyToDB is Copy to another database
+func (c *cmdable) CopyToDB(ctx context.Context, source, destination string, db int, replace bool) (bool, error) {
+	cmd := []string{"COPY", source, destination, "DB", strconv.Itoa(db)}
+	if replace {
+		cmd = append(cmd, "REPLACE")
+	}
+	return replyBool(c.do(ctx, cmd...))
+}
+
+// Move moves key to another database and reports whether it did
+func (c *cmdable) Move(ctx context.Context, key string, db int) (bool, error) {
+	return replyBool(c.do(ctx, "MOVE", key, strconv.Itoa(db)))
+}
+
+// SwapDB swaps two databases for every client
+func (c *cmdable) SwapDB(ctx context.Context, first, second int) error {
+	return replyOK(c.do(ctx, "SWAPDB", strconv.Itoa(first), strconv.Itoa(second)))
+}
+
+// RandomKey returns a random key, or ErrNil when the database is empty
+func (c *cmdable) RandomKey(ctx context.Context) (string, error) {
+	return replyString(c.do(ctx, "RANDOMKEY"))
+}
+
+// DBSize returns the number of keys of the database
+func (c *cmdable) DBSize(ctx context.Context) (int64, error) {
+	return replyInt(c.do(ctx, "DBSIZE"))
+}
+
+func flushArgs(name string, async bool) []string {
+	if async {
+		return []string{name, "ASYNC"}
+	}
+	return []string{name, "SYNC"}
+}
+
+// FlushDB deletes the keys of the database, in the background when async
+// is set
+func (c *cmdable) FlushDB(ctx context.Context, async bool) error {
+	return replyOK(c.do(ctx, flushArgs("FLUSHDB", async)...))
+}
+
+// FlushAll deletes the keys of every database
+func (c *cmdable) FlushAll(ctx context.Context, async bool) error {
+	return replyOK(c.do(ctx, flushArgs("FLUSHALL", async)...))
+}
+
+// ObjectEncoding returns the internal representation of the value of key
+func (c *cmdable) ObjectEncoding(ctx context.Context, key string) (string, error) {
+	return replyString(c.do(ctx, "OBJECT", "ENCODING", key))
+}
+
+// ObjectFreq returns the access frequency of key under an LFU policy
+func (c *cmdable) ObjectFreq(ctx context.Context, key string) (int64, error) {
+	return replyInt(c.do(ctx, "OBJECT", "FREQ", key))
+}
+
+// ObjectIdleTime returns the time since key was last accessed, under an LRU
+// or LFU policy
+func (c *cmdable) ObjectIdleTime(ctx context.Context, key string) (time.Duration, error) {
+	n, err := replyInt(c.do(ctx, "OBJECT", "IDLETIME", key))
+	return time.Duration(n) * time.Second, err
+}
+
+// ObjectRefCount returns the reference count of the value of key
+func (c *cmdable) ObjectRefCount(ctx context.Context, key string) (int64, error) {
+	return replyInt(c.do(ctx, "OBJECT", "REFCOUNT", key))
+}
+
+// MemoryUsage returns the bytes key and its value take
+func (c *cmdable) MemoryUsage(ctx context.Context, key string) (int64, error) {
+	return replyInt(c.do(ctx, "MEMORY", "USAGE", key))
+}
+
+// SlowlogEntry is an entry of the slow log
+type SlowlogEntry struct {
+	ID         int64
+	Time       time.Time
+	Duration   time.Duration
+	Args       []string
+	ClientAddr string
+	ClientName string
+}
+
+// SlowlogGet returns the count latest entries of the slow log, all of them
+// for -1
+func (c *cmdable) SlowlogGet(ctx context.Context, count int64) ([]SlowlogEntry, error) {
+	reply, err := c.do(ctx, "SLOWLOG", "GET", strconv.FormatInt(count, 10))
+	if err != nil {
+		return nil, err
+	}
+	rows, ok := reply.([]any)
+	if !ok {
+		return nil, unexpected(reply)
+	}
+	entries := make([]SlowlogEntry, len(rows))
+	for i, row := range rows {
+		fields, ok := row.([]any)
+		if !ok || len(fields) < 6 {
+			return nil, unexpected(row)
+		}
+		id, _ := fields[0].(int64)
+		at, _ := fields[1].(int64)
+		micros, _ := fields[2].(int64)
+		args, err := replyStrings(fields[3], nil)
+		if err != nil {
+			return nil, err
+		}
+		addr, _ := fields[4].(string)
+		name, _ := fields[5].(string)
+		entries[i] = SlowlogEntry{ID: id, Time: time.Unix(at, 0), Duration: time.Duration(micros) * time.Microsecond,
+			Args: args, ClientAddr: addr, ClientName: name}
+	}
+	return entries, nil
+}
+
+// SlowlogLen returns the number of entries of the slow log
+func (c *cmdable) SlowlogLen(ctx context.Context) (int64, error) {
+	return replyInt(c.do(ctx, "SLOWLOG", "LEN"))
+}
+
+// SlowlogReset empties the slow log
+func (c *cmdable) SlowlogReset(ctx context.Context) error {
+	return replyOK(c.do(ctx, "SLOWLOG", "RESET"))
+}
+
+// LatencyEvent is the latest latency spike of an event
+type LatencyEvent struct {
+	Name   string
+	Time   time.Time
+	Latest time.Duration
+	Max    time.Duration
+}
+
+// LatencySample is a latency spike of an event
+type LatencySample struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+// LatencyLatest returns the latest spike of every event
+func (c *cmdable) LatencyLatest(ctx context.Context) ([]LatencyEvent, error) {
+	reply, err := c.do(ctx, "LATENCY", "LATEST")
+	if err != nil {
+		return nil, err
+	}
+	rows, ok := reply.([]any)
+	if !ok {
+		return nil, unexpected(reply)
+	}
+	events := make([]LatencyEvent, len(rows))
+	for i, row := range rows {
+		fields, ok := row.([]any)
+		if !ok || len(fields) < 4 {
+			return nil, unexpected(row)
+		}
+		name, _ := fields[0].(string)
+		at, _ := fields[1].(int64)
+		latest, _ := fields[2].(int64)
+		max, _ := fields[3].(int64)
+		events[i] = LatencyEvent{Name: name, Time: time.Unix(at, 0),
+			Latest: time.Duration(latest) * time.Millisecond, Max: time.Duration(max) * time.Millisecond}
+	}
+	return events, nil
+}
+
+// LatencyHistory returns the spikes of an event
+func (c *cmdable) LatencyHistory(ctx context.Context, event string) ([]LatencySample, error) {
+	reply, err := c.do(ctx, "LATENCY", "HISTORY", event)
+	if err != nil {
+		return nil, err
+	}
+	rows, ok := reply.([]any)
+	if !ok {
+		return nil, unexpected(reply)
+	}
+	samples := make([]LatencySample, len(rows))
+	for i, row := range rows {
+		fields, ok := row.([]any)
+		if !ok || len(fields) < 2 {
+			return nil, unexpected(row)
+		}
+		at, _ := fields[0].(int64)
+		latency, _ := fields[1].(int64)
+		samples[i] = LatencySample{Time: time.Unix(at, 0), Latency: time.Duration(latency) * time.Millisecond}
+	}
+	return samples, nil
+}
+
+// LatencyReset drops the spikes of events, of all of them when none are
+// given, and returns the number of events reset
+func (c *cmdable) LatencyReset(ctx context.Context, events ...string) (int64, error) {
+	return replyInt(c.do(ctx, append([]string{"LATENCY", "RESET"}, events...)...))
+}
+
+// LatencyDoctor returns the latency report
+func (c *cmdable) LatencyDoctor(ctx context.Context) (string, error) {
+	return replyString(c.do(ctx, "LATENCY", "DOCTOR"))
+}
+
+// ConfigGet returns the configuration parameters matching the patterns
+func (c *cmdable) ConfigGet(ctx context.Context, patterns ...string) (map[string]string, error) {
+	return replyStringMap(c.do(ctx, append([]string{"CONFIG", "GET"}, patterns...)...))
+}
+
+// ConfigSet changes all the given parameters, or none when one is invalid
+func (c *cmdable) ConfigSet(ctx context.Context, params map[string]string) error {
+	cmd := []string{"CONFIG", "SET"}
+	for name, value := range params {
+		cmd = append(cmd, name, value)
+	}
+	return replyOK(c.do(ctx, cmd...))
+}
+
+// ConfigRewrite writes the configuration back to its file
+func (c *cmdable) ConfigRewrite(ctx context.Context) error {
+	return replyOK(c.do(ctx, "CONFIG", "REWRITE"))
+}
+
+// ShutdownArgs are the options of SHUTDOWN
+type ShutdownArgs struct {
+	NoSave, Save, Now, Force bool
+}
+
+// Shutdown shuts the server down. The server closes the connection instead
+// of replying when it does.
+func (c *cmdable) Shutdown(ctx context.Context, args ShutdownArgs) error {
+	cmd := []string{"SHUTDOWN"}
+	for _, option := range []struct {
+		set  bool
+		name string
+	}{{args.NoSave, "NOSAVE"}, {args.Save, "SAVE"}, {args.Now, "NOW"}, {args.Force, "FORCE"}} {
+		if option.set {
+			cmd = append(cmd, option.name)
+		}
+	}
+	_, err := c.do(ctx, cmd...)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// ShutdownAbort cancels a shutdown in progress
+func (c *cmdable) ShutdownAbort(ctx context.Context) error {
+	return replyOK(c.do(ctx, "SHUTDOWN", "ABORT"))
+}
+
+// ClientList returns the CLIENT LIST text of the clients of a type, of all
+// of them when it is empty, restricted to ids when they are given
+func (c *cmdable) ClientList(ctx context.Context, clientType string, ids ...int64) (string, error) {
+	cmd := []string{"CLIENT", "LIST"}
+	if clientType != "" {
+		cmd = append(cmd, "TYPE", clientType)
+	}
+	if len(ids) > 0 {
+		cmd = append(cmd, "ID")
+		for _, id := range ids {
+			cmd = append(cmd, strconv.FormatInt(id, 10))
+		}
+	}
+	return replyString(c.do(ctx, cmd...))
+}
+
+// ClientKill closes the connection of the client at addr
+func (c *cmdable) ClientKill(ctx context.Context, addr string) error {
+	return replyOK(c.do(ctx, "CLIENT", "KILL", addr))
+}
+
+// ClientKillFilter closes the connections matching filters given as name
+// and value pairs, such as "TYPE", "pubsub", and returns how many it closed
+func (c *cmdable) ClientKillFilter(ctx context.Context, filters ...string) (int64, error) {
+	return replyInt(c.do(ctx, append([]string{"CLIENT", "KILL"}, filters...)...))
+}
+
+// ClientPause holds the commands of all clients, or only the writes, for d
+func (c *cmdable) ClientPause(ctx context.Context, d time.Duration, writesOnly bool) error {
+	mode := "ALL"
+	if writesOnly {
+		mode = "WRITE"
+	}
+	return replyOK(c.do(ctx, "CLIENT", "PAUSE", milliseconds(d), mode))
+}
+
+// ClientUnpause ends a CLIENT PAUSE
+func (c *cmdable) ClientUnpause(ctx context.Context) error {
+	return replyOK(c.do(ctx, "CLIENT", "UNPAUSE"))
+}
+
+// Publish sends a message to a channel and returns the number of
+// subscribers that received it
+func (c *cmdable) Publish(ctx context.Context, channel, message string) (int64, error) {
+	return replyInt(c.do(ctx, "PUBLISH", channel, message))
+}
+
+// PubSubChannels returns the channels with subscribers matching a glob
+// pattern, all of them when it is empty
+func (c *cmdable) PubSubChannels(ctx context.Context, pattern string) ([]string, error) {
+	cmd := []string{"PUBSUB", "CHANNELS"}
+	if pattern != "" {
+		cmd = append(cmd, pattern)
+	}
+	return replyStrings(c.do(ctx, cmd...))
+}
+
+// PubSubNumSub returns the number of subscribers of channels
+func (c *cmdable) PubSubNumSub(ctx context.Context, channels ...string) (map[string]int64, error) {
+	m, err := replyMap(c.do(ctx, append([]string{"PUBSUB", "NUMSUB"}, channels...)...))
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(m))
+	for channel, n := range m {
+		counts[channel], _ = n.(int64)
+	}
+	return counts, nil
+}
+
+// PubSubNumPat returns the number of pattern subscriptions
+func (c *cmdable) PubSubNumPat(ctx context.Context) (int64, error) {
+	return replyInt(c.do(ctx, "PUBSUB", "NUMPAT"))
+}
+
+// Select switches the connection to another database
+func (cn *Conn) Select(ctx context.Context, db int) error {
+	return replyOK(cn.do(ctx, "SELECT", strconv.Itoa(db)))
+}
+
+// Hello switches the connection to RESP2 or RESP3 and returns what the
+// server tells about itself
+func (cn *Conn) Hello(ctx context.Context, protocol int) (map[string]any, error) {
+	return replyMap(cn.do(ctx, "HELLO", strconv.Itoa(protocol)))
+}
+
+// ClientID returns the ID of the connection
+func (cn *Conn) ClientID(ctx context.Context) (int64, error) {
+	return replyInt(cn.do(ctx, "CLIENT", "ID"))
+}
+
+// ClientInfo returns the CLIENT LIST line of the connection
+func (cn *Conn) ClientInfo(ctx context.Context) (string, error) {
+	info, err := replyString(cn.do(ctx, "CLIENT", "INFO"))
+	return strings.TrimSuffix(info, "\n"), err
+}
+
+// ClientGetName returns the name of the connection, or ErrNil without one
+func (cn *Conn) ClientGetName(ctx context.Context) (string, error) {
+	return replyString(cn.do(ctx, "CLIENT", "GETNAME"))
+}
+
+// ClientSetName names the connection
+func (cn *Conn) ClientSetName(ctx context.Context, name string) error {
+	return replyOK(cn.do(ctx, "CLIENT", "SETNAME", name))
+}
+
+// ClientNoEvict exempts the connection from client eviction or not
+func (cn *Conn) ClientNoEvict(ctx context.Context, on bool) error {
+	return replyOK(cn.do(ctx, "CLIENT", "NO-EVICT", onOff(on)))
+}
+
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// TrackingArgs are the options of CLIENT TRACKING ON
+type TrackingArgs struct {
+	// Redirect sends the invalidations to the client with that ID
+	Redirect int64
+	// BCast tracks every key starting with one of Prefixes instead of the
+	// keys read
+	BCast    bool
+	Prefixes []string
+	// OptIn tracks the keys of the commands after CLIENT CACHING YES, OptOut
+	// all but those after CLIENT CACHING NO
+	OptIn, OptOut bool
+	// NoLoop skips the invalidations of the keys the connection changed itself
+	NoLoop bool
+}
+
+// ClientTracking turns client side caching on or off for the connection.
+// With RESP3 the invalidations arrive as pushes, see SetPushHandler.
+func (cn *Conn) ClientTracking(ctx context.Context, on bool, args TrackingArgs) error {
+	cmd := []string{"CLIENT", "TRACKING", onOff(on)}
+	if on {
+		if args.Redirect != 0 {
+			cmd = append(cmd, "REDIRECT", strconv.FormatInt(args.Redirect, 10))
+		}
+		for _, prefix := range args.Prefixes {
+			cmd = append(cmd, "PREFIX", prefix)
+		}
+		for _, option := range []struct {
+			set  bool
+			name string
+		}{{args.BCast, "BCAST"}, {args.OptIn, "OPTIN"}, {args.OptOut, "OPTOUT"}, {args.NoLoop, "NOLOOP"}} {
+			if option.set {
+				cmd = append(cmd, option.name)
+			}
+		}
+	}
+	return replyOK(cn.do(ctx, cmd...))
+}
+
+// ClientCaching decides whether the next command is tracked, in OPTIN or
+// OPTOUT mode
+func (cn *Conn) ClientCaching(ctx context.Context, yes bool) error {
+	arg := "NO"
+	if yes {
+		arg = "YES"
+	}
+	return replyOK(cn.do(ctx, "CLIENT", "CACHING", arg))
+}
+
+// ClientGetRedir returns the ID of the client invalidations are redirected
+// to, 0 without redirection and -1 without tracking
+func (cn *Conn) ClientGetRedir(ctx context.Context) (int64, error) {
+	return replyInt(cn.do(ctx, "CLIENT", "GETREDIR"))
+}
+
+// TrackingInfo describes the client side caching of a connection
+type TrackingInfo struct {
+	Flags    []string
+	Redirect int64
+	Prefixes []string
+}
+
+// ClientTrackingInfo describes the client side caching of the connection
+func (cn *Conn) ClientTrackingInfo(ctx context.Context) (TrackingInfo, error) {
+	m, err := replyMap(cn.do(ctx, "CLIENT", "TRACKINGINFO"))
+	if err != nil {
+		return TrackingInfo{}, err
+	}
+	var info TrackingInfo
+	info.Flags, _ = replyStrings(m["flags"], nil)
+	info.Redirect, _ = m["redirect"].(int64)
+	info.Prefixes, _ = replyStrings(m["prefixes"], nil)
+	return info, nil
+}
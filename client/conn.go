@@ -0,0 +1,276 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxPending is the number of requests a connection sends ahead of their
+// replies before it waits for some of them
+const maxPending = 4096
+
+var errConnClosed = errors.New("client: connection closed")
+
+// request is a command on its way through a connection
+type request struct {
+	ctx context.Context
+	cmd []byte
+	// asking sends ASKING first, whose reply is skipped, for an ASK redirect
+	asking bool
+	reply  any
+	err    error
+	done   chan struct{}
+}
+
+func (req *request) finish(reply any, err error) {
+	req.reply, req.err = reply, err
+	close(req.done)
+}
+
+// conn is a connection shared by concurrent callers. Their commands are
+// pipelined: a writer sends whatever is queued in one write, and a reader
+// hands the replies back in order.
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+	writer  *bufio.Writer
+
+	queue   chan *request
+	pending chan *request
+	// inflight counts the requests sent and not yet answered, for picking
+	// the least busy connection of a pool
+	inflight atomic.Int64
+	// push handles RESP3 pushes, such as invalidation messages
+	push atomic.Pointer[func([]any)]
+
+	closeOnce  sync.Once
+	closed     chan struct{}
+	writerDone chan struct{}
+	err        error
+}
+
+// dial connects to addr and prepares the connection as opts say: HELLO for
+// RESP3 and the client name, then SELECT
+func dial(ctx context.Context, opts *Options, addr string) (net.Conn, *bufio.Reader, error) {
+	dialer := net.Dialer{Timeout: opts.DialTimeout, KeepAlive: opts.KeepAlive}
+	netConn, err := dialer.DialContext(ctx, opts.Network, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+	reader := bufio.NewReader(netConn)
+	var setup [][]string
+	switch {
+	case opts.Protocol == 3:
+		hello := []string{"HELLO", "3"}
+		if opts.ClientName != "" {
+			hello = append(hello, "SETNAME", opts.ClientName)
+		}
+		setup = append(setup, hello)
+	case opts.ClientName != "":
+		setup = append(setup, []string{"CLIENT", "SETNAME", opts.ClientName})
+	}
+	if opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(opts.DB)})
+	}
+	var buf []byte
+	for _, args := range setup {
+		buf = appendCommand(buf, args)
+	}
+	if _, err := netConn.Write(buf); err != nil {
+		netConn.Close()
+		return nil, nil, err
+	}
+	for range setup {
+		reply, _, err := readReply(reader)
+		if e, ok := reply.(Error); ok && err == nil {
+			err = e
+		}
+		if err != nil {
+			netConn.Close()
+			return nil, nil, err
+		}
+	}
+	netConn.SetDeadline(time.Time{})
+	return netConn, reader, nil
+}
+
+// newConn starts serving commands on a prepared connection
+func newConn(netConn net.Conn, reader *bufio.Reader) *conn {
+	c := &conn{
+		netConn:    netConn,
+		reader:     reader,
+		writer:     bufio.NewWriter(netConn),
+		queue:      make(chan *request),
+		pending:    make(chan *request, maxPending),
+		closed:     make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	go c.writeLoop()
+	go c.readLoop()
+	return c
+}
+
+// do sends a command and waits for its reply. A cancelled context stops the
+// wait; a command that was not sent yet is then dropped, and the reply of
+// one that was is read and discarded.
+func (c *conn) do(ctx context.Context, args []string, asking bool) (any, error) {
+	req := &request{ctx: ctx, cmd: appendCommand(nil, args), asking: asking, done: make(chan struct{})}
+	select {
+	case c.queue <- req:
+	case <-c.closed:
+		return nil, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case <-req.done:
+		if e, ok := req.reply.(Error); ok && req.err == nil {
+			return nil, e
+		}
+		return req.reply, req.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *conn) writeLoop() {
+	defer close(c.writerDone)
+	for {
+		var req *request
+		select {
+		case req = <-c.queue:
+		case <-c.closed:
+			return
+		}
+		// Take whatever else is queued before flushing
+		for req != nil {
+			if err := req.ctx.Err(); err != nil {
+				req.finish(nil, err)
+			} else if !c.send(req) {
+				return
+			}
+			select {
+			case req = <-c.queue:
+			default:
+				req = nil
+			}
+		}
+		if err := c.writer.Flush(); err != nil {
+			c.close(err)
+			return
+		}
+	}
+}
+
+// send hands a request to the reader and writes it, flushing first when the
+// reader has too many replies to wait for. The request is pending before its
+// bytes are written: a large one goes out inside Write, and its reply may
+// arrive before Write returns.
+func (c *conn) send(req *request) bool {
+	c.inflight.Add(1)
+	select {
+	case c.pending <- req:
+	default:
+		if err := c.writer.Flush(); err != nil {
+			c.inflight.Add(-1)
+			req.finish(nil, err)
+			c.close(err)
+			return false
+		}
+		select {
+		case c.pending <- req:
+		case <-c.closed:
+			c.inflight.Add(-1)
+			req.finish(nil, c.err)
+			return false
+		}
+	}
+	if req.asking {
+		c.writer.Write(appendCommand(nil, []string{"ASKING"}))
+	}
+	if _, err := c.writer.Write(req.cmd); err != nil {
+		// The reader fails the pending requests once the writer is done
+		c.close(err)
+		return false
+	}
+	return true
+}
+
+func (c *conn) readLoop() {
+	for {
+		reply, err := c.next()
+		if err != nil {
+			c.close(err)
+			break
+		}
+		var req *request
+		select {
+		case req = <-c.pending:
+		default:
+			c.close(errors.New("client: reply without a command"))
+		}
+		if req == nil {
+			break
+		}
+		if req.asking {
+			// That was the reply of ASKING, the one of the command follows
+			if reply, err = c.next(); err != nil {
+				req.finish(nil, err)
+				c.close(err)
+				break
+			}
+		}
+		c.inflight.Add(-1)
+		req.finish(reply, nil)
+	}
+	// Fail the commands that will not get a reply anymore
+	<-c.writerDone
+	for {
+		select {
+		case req := <-c.pending:
+			req.finish(nil, c.err)
+		default:
+			return
+		}
+	}
+}
+
+// next reads the next reply, handing the pushes before it to the push handler
+func (c *conn) next() (any, error) {
+	for {
+		reply, isPush, err := readReply(c.reader)
+		if err != nil || !isPush {
+			return reply, err
+		}
+		if push := c.push.Load(); push != nil {
+			(*push)(reply.([]any))
+		}
+	}
+}
+
+// close closes the connection; commands fail with err from then on
+func (c *conn) close(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.closed)
+		c.netConn.Close()
+	})
+}
+
+func (c *conn) broken() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
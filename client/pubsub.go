@@ -0,0 +1,280 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+)
+
+// Message is a message published to a channel a PubSub listens to
+type Message struct {
+	Channel string
+	// Pattern is the pattern the channel matched, for PSubscribe
+	Pattern string
+	Payload string
+}
+
+// PubSub is a connection subscribed to channels and patterns. Its messages
+// arrive on Messages, which must be read continuously: a reader falling
+// behind holds back the connection until the output buffer limit of the
+// server closes it.
+type PubSub struct {
+	netConn net.Conn
+	// mu serializes the commands, whose confirmations come in order
+	mu       sync.Mutex
+	channels map[string]bool
+	patterns map[string]bool
+
+	acks     chan any
+	messages chan Message
+
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+// Subscribe opens a PubSub subscribed to channels
+func (c *Client) Subscribe(ctx context.Context, channels ...string) (*PubSub, error) {
+	ps, err := c.pubSub(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := ps.Subscribe(ctx, channels...); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+// PSubscribe opens a PubSub subscribed to glob patterns
+func (c *Client) PSubscribe(ctx context.Context, patterns ...string) (*PubSub, error) {
+	ps, err := c.pubSub(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := ps.PSubscribe(ctx, patterns...); err != nil {
+		ps.Close()
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (c *Client) pubSub(ctx context.Context) (*PubSub, error) {
+	netConn, reader, err := dial(ctx, &c.opts, c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	ps := &PubSub{
+		netConn:  netConn,
+		channels: map[string]bool{},
+		patterns: map[string]bool{},
+		acks:     make(chan any, 16),
+		messages: make(chan Message, 100),
+		done:     make(chan struct{}),
+	}
+	go ps.readLoop(reader)
+	return ps, nil
+}
+
+// Messages returns the channel of the messages. It is closed when the
+// PubSub is, see Err.
+func (ps *PubSub) Messages() <-chan Message {
+	return ps.messages
+}
+
+// Err returns why the PubSub ended once Messages is closed
+func (ps *PubSub) Err() error {
+	select {
+	case <-ps.done:
+		return ps.err
+	default:
+		return nil
+	}
+}
+
+// Subscribe subscribes to more channels
+func (ps *PubSub) Subscribe(ctx context.Context, channels ...string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.command(ctx, append([]string{"SUBSCRIBE"}, channels...), len(channels))
+}
+
+// PSubscribe subscribes to more patterns
+func (ps *PubSub) PSubscribe(ctx context.Context, patterns ...string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.command(ctx, append([]string{"PSUBSCRIBE"}, patterns...), len(patterns))
+}
+
+// Unsubscribe unsubscribes from channels, from all of them when none are given
+func (ps *PubSub) Unsubscribe(ctx context.Context, channels ...string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.command(ctx, append([]string{"UNSUBSCRIBE"}, channels...), unsubscribeAcks(channels, ps.channels))
+}
+
+// PUnsubscribe unsubscribes from patterns, from all of them when none are given
+func (ps *PubSub) PUnsubscribe(ctx context.Context, patterns ...string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.command(ctx, append([]string{"PUNSUBSCRIBE"}, patterns...), unsubscribeAcks(patterns, ps.patterns))
+}
+
+// unsubscribeAcks returns the number of confirmations of an unsubscribe:
+// one per name, or per subscription when there are no names, and at least one
+func unsubscribeAcks(names []string, subscribed map[string]bool) int {
+	if len(names) > 0 {
+		return len(names)
+	}
+	return max(len(subscribed), 1)
+}
+
+// Ping checks the connection
+func (ps *PubSub) Ping(ctx context.Context) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return ps.command(ctx, []string{"PING"}, 1)
+}
+
+// command sends a command and waits for its n confirmations. A cancelled
+// context closes the PubSub, as the confirmations would be out of step.
+func (ps *PubSub) command(ctx context.Context, args []string, n int) error {
+	if _, err := ps.netConn.Write(appendCommand(nil, args)); err != nil {
+		ps.close(err)
+		return err
+	}
+	for range n {
+		select {
+		case ack := <-ps.acks:
+			if e, ok := ack.(Error); ok {
+				return e
+			}
+			ps.track(ack)
+		case <-ps.done:
+			return ps.err
+		case <-ctx.Done():
+			ps.close(ctx.Err())
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// track keeps the subscriptions up to date with a confirmation
+func (ps *PubSub) track(ack any) {
+	frame, _ := ack.([]any)
+	if len(frame) != 3 {
+		return
+	}
+	kind, _ := frame[0].(string)
+	name, _ := frame[1].(string)
+	switch kind {
+	case "subscribe":
+		ps.channels[name] = true
+	case "unsubscribe":
+		delete(ps.channels, name)
+	case "psubscribe":
+		ps.patterns[name] = true
+	case "punsubscribe":
+		delete(ps.patterns, name)
+	}
+}
+
+func (ps *PubSub) readLoop(reader *bufio.Reader) {
+	defer close(ps.messages)
+	for {
+		reply, _, err := readReply(reader)
+		if err != nil {
+			ps.close(err)
+			return
+		}
+		frame, _ := reply.([]any)
+		kind := ""
+		if len(frame) > 0 {
+			kind, _ = frame[0].(string)
+		}
+		var msg Message
+		switch {
+		case kind == "message" && len(frame) == 3:
+			msg.Channel, _ = frame[1].(string)
+			msg.Payload, _ = frame[2].(string)
+		case kind == "pmessage" && len(frame) == 4:
+			msg.Pattern, _ = frame[1].(string)
+			msg.Channel, _ = frame[2].(string)
+			msg.Payload, _ = frame[3].(string)
+		default:
+			// Confirmations, pongs and errors answer a command
+			select {
+			case ps.acks <- reply:
+			case <-ps.done:
+				return
+			}
+			continue
+		}
+		select {
+		case ps.messages <- msg:
+		case <-ps.done:
+			return
+		}
+	}
+}
+
+func (ps *PubSub) close(err error) {
+	ps.closeOnce.Do(func() {
+		ps.err = err
+		close(ps.done)
+		ps.netConn.Close()
+	})
+}
+
+// Close closes the connection
+func (ps *PubSub) Close() error {
+	ps.close(errConnClosed)
+	return nil
+}
+
+// Monitor streams the commands the server runs, as the lines of MONITOR,
+// until ctx is done
+func (c *Client) Monitor(ctx context.Context) (<-chan string, error) {
+	netConn, reader, err := dial(ctx, &c.opts, c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netConn.Write(appendCommand(nil, []string{"MONITOR"})); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	reply, _, err := readReply(reader)
+	if e, ok := reply.(Error); ok && err == nil {
+		err = e
+	}
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	lines := make(chan string, 100)
+	stop := context.AfterFunc(ctx, func() { netConn.Close() })
+	go func() {
+		defer close(lines)
+		defer stop()
+		defer netConn.Close()
+		for {
+			reply, _, err := readReply(reader)
+			if err != nil {
+				return
+			}
+			line, ok := reply.(string)
+			if !ok {
+				continue
+			}
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return lines, nil
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrNil is returned for a nil reply, such as GET of a missing key
+var ErrNil = errors.New("client: nil reply")
+
+// Error is an error reply of the server, e.g. "ERR syntax error"
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Prefix returns the first word of the error, such as ERR, WRONGTYPE or MOVED
+func (e Error) Prefix() string {
+	prefix, _, _ := strings.Cut(string(e), " ")
+	return prefix
+}
+
+// appendCommand appends args encoded as a RESP array of bulk strings
+func appendCommand(buf []byte, args []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// readReply reads one RESP2 or RESP3 reply and reports whether it is a
+// RESP3 push. Simple, bulk and verbatim strings and big numbers become
+// strings, integers int64, doubles float64, booleans bool, nulls nil, arrays,
+// sets and pushes []any, maps map[string]any and error replies Error.
+// Attributes are skipped.
+func readReply(r *bufio.Reader) (any, bool, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, false, errors.New("client: invalid RESP: empty line")
+	}
+	kind, payload := line[0], line[1:]
+	switch kind {
+	case '+', '(':
+		return payload, false, nil
+	case '-':
+		return Error(payload), false, nil
+	case ':':
+		n, err := strconv.ParseInt(payload, 10, 64)
+		return n, false, err
+	case ',':
+		switch strings.ToLower(payload) {
+		case "inf":
+			return math.Inf(1), false, nil
+		case "-inf":
+			return math.Inf(-1), false, nil
+		}
+		f, err := strconv.ParseFloat(payload, 64)
+		return f, false, err
+	case '#':
+		return payload == "t", false, nil
+	case '_':
+		return nil, false, nil
+	case '$', '=', '!':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < 0 {
+			return nil, false, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, false, err
+		}
+		value := string(buf[:n])
+		switch kind {
+		case '=':
+			// Verbatim strings start with their format, e.g. "txt:"
+			if len(value) >= 4 {
+				value = value[4:]
+			}
+		case '!':
+			return Error(value), false, nil
+		}
+		return value, false, nil
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < 0 {
+			return nil, false, err
+		}
+		if kind == '%' || kind == '|' {
+			n *= 2
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], _, err = readReply(r); err != nil {
+				return nil, false, err
+			}
+		}
+		switch kind {
+		case '|':
+			return readReply(r)
+		case '%':
+			m := make(map[string]any, n/2)
+			for i := 0; i < n; i += 2 {
+				m[fmt.Sprint(items[i])] = items[i+1]
+			}
+			return m, false, nil
+		}
+		return items, kind == '>', nil
+	}
+	return nil, false, fmt.Errorf("client: invalid RESP: unexpected %q", kind)
+}
@@ -0,0 +1,186 @@
+package kvstore
+
+import "encoding/binary"
+
+const (
+	// arenaSegmentSize is the capacity of each arena segment; larger entries get a segment of their own
+	arenaSegmentSize = 4 << 20
+	// arenaHeaderSize holds the key and value lengths in front of every entry
+	arenaHeaderSize = 8
+)
+
+// arenaStorage keeps keys and values in large []byte segments and indexes them
+// with a map of hash -> location. Neither the segments nor the map contain
+// pointers, so the garbage collector does not have to scan millions of
+// strings on every cycle (the same idea as bigcache and freecache).
+//
+// An entry is laid out as [keyLen uint32][valueLen uint32][key][value] and its
+// location is encoded as segment<<32 | offset. Overwrites and deletes leave
+// dead bytes behind, which maybeCompact reclaims once they dominate the arena.
+type arenaStorage struct {
+	segments [][]byte
+	index    map[uint64]uint64
+	// overflow holds the rare keys whose 64-bit hash collides with a different live key
+	overflow map[string]string
+	used     int
+	freed    int
+}
+
+func newArenaStorage() *arenaStorage {
+	return &arenaStorage{
+		index:    make(map[uint64]uint64),
+		overflow: make(map[string]string),
+	}
+}
+
+func (a *arenaStorage) entry(loc uint64) (key, value []byte, size int) {
+	seg := a.segments[loc>>32]
+	off := int(uint32(loc))
+	keyLen := int(binary.LittleEndian.Uint32(seg[off:]))
+	valueLen := int(binary.LittleEndian.Uint32(seg[off+4:]))
+	start := off + arenaHeaderSize
+	return seg[start : start+keyLen], seg[start+keyLen : start+keyLen+valueLen], arenaHeaderSize + keyLen + valueLen
+}
+
+// appendEntry copies key and value to the end of the arena and returns their location
+func appendEntry[T string | []byte](a *arenaStorage, key, value T) uint64 {
+	size := arenaHeaderSize + len(key) + len(value)
+	last := len(a.segments) - 1
+	if last < 0 || cap(a.segments[last])-len(a.segments[last]) < size {
+		a.segments = append(a.segments, make([]byte, 0, max(arenaSegmentSize, size)))
+		last++
+	}
+
+	seg := a.segments[last]
+	off := len(seg)
+	seg = binary.LittleEndian.AppendUint32(seg, uint32(len(key)))
+	seg = binary.LittleEndian.AppendUint32(seg, uint32(len(value)))
+	seg = append(seg, key...)
+	seg = append(seg, value...)
+	a.segments[last] = seg
+	a.used += size
+	return uint64(last)<<32 | uint64(off)
+}
+
+func (a *arenaStorage) get(key string) (string, bool) {
+	if loc, ok := a.index[hashKey(key)]; ok {
+		if k, v, _ := a.entry(loc); string(k) == key {
+			return string(v), true
+		}
+	}
+	if len(a.overflow) > 0 {
+		value, ok := a.overflow[key]
+		return value, ok
+	}
+	return "", false
+}
+
+func (a *arenaStorage) has(key string) bool {
+	if loc, ok := a.index[hashKey(key)]; ok {
+		if k, _, _ := a.entry(loc); string(k) == key {
+			return true
+		}
+	}
+	if len(a.overflow) > 0 {
+		_, ok := a.overflow[key]
+		return ok
+	}
+	return false
+}
+
+func (a *arenaStorage) set(key, value string) {
+	h := hashKey(key)
+	if loc, ok := a.index[h]; ok {
+		k, v, size := a.entry(loc)
+		if string(k) != key {
+			a.overflow[key] = value
+			return
+		}
+		if len(v) == len(value) {
+			copy(v, value)
+			return
+		}
+		a.freed += size
+		a.index[h] = appendEntry(a, key, value)
+		a.maybeCompact()
+		return
+	}
+
+	if _, ok := a.overflow[key]; ok {
+		a.overflow[key] = value
+		return
+	}
+	a.index[h] = appendEntry(a, key, value)
+}
+
+func (a *arenaStorage) del(key string) bool {
+	if !a.unlink(key) {
+		return false
+	}
+	a.maybeCompact()
+	return true
+}
+
+// unlink leaves the dead bytes of the entry to the compaction of a later
+// write, as compacting copies the whole arena
+func (a *arenaStorage) unlink(key string) bool {
+	h := hashKey(key)
+	if loc, ok := a.index[h]; ok {
+		if k, _, size := a.entry(loc); string(k) == key {
+			delete(a.index, h)
+			a.freed += size
+			return true
+		}
+	}
+	if _, ok := a.overflow[key]; ok {
+		delete(a.overflow, key)
+		return true
+	}
+	return false
+}
+
+// overhead covers the entry header and the hash -> location slot in the index map
+func (a *arenaStorage) overhead() int {
+	return arenaHeaderSize + 24
+}
+
+func (a *arenaStorage) clear() {
+	a.segments = nil
+	clear(a.index)
+	clear(a.overflow)
+	a.used, a.freed = 0, 0
+}
+
+func (a *arenaStorage) len() int {
+	return len(a.index) + len(a.overflow)
+}
+
+func (a *arenaStorage) each(fn func(key string) bool) {
+	for _, loc := range a.index {
+		k, _, _ := a.entry(loc)
+		if !fn(string(k)) {
+			return
+		}
+	}
+	for k := range a.overflow {
+		if !fn(k) {
+			return
+		}
+	}
+}
+
+// maybeCompact rewrites the live entries into fresh segments once more than
+// half of the arena is dead space
+func (a *arenaStorage) maybeCompact() {
+	if a.used < arenaSegmentSize || a.freed*2 < a.used {
+		return
+	}
+
+	old := &arenaStorage{segments: a.segments}
+	a.segments = nil
+	a.used, a.freed = 0, 0
+	for h, loc := range a.index {
+		k, v, _ := old.entry(loc)
+		a.index[h] = appendEntry(a, k, v)
+	}
+}
@@ -0,0 +1,236 @@
+package kvstore
+
+import "sort"
+
+// btreeDegree is the minimum degree of the ordered key index. Nodes hold
+// between btreeDegree-1 and 2*btreeDegree-1 keys, so a million keys fit in
+// four levels.
+const (
+	btreeDegree   = 32
+	btreeMaxItems = 2*btreeDegree - 1
+	btreeMinItems = btreeDegree - 1
+)
+
+// btree is an in-memory B-tree of keys kept in lexicographic order. It follows
+// the single-pass insert and delete algorithms of google/btree: full children
+// are split on the way down during inserts and thin children are grown on the
+// way down during deletes, so no operation ever has to walk back up.
+type btree struct {
+	root   *btreeNode
+	length int
+}
+
+type btreeNode struct {
+	keys     []string
+	children []*btreeNode
+}
+
+type btreeRemove int
+
+const (
+	removeKey btreeRemove = iota
+	removeMax
+)
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeAt[T any](s []T, i int) ([]T, T) {
+	v := s[i]
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1], v
+}
+
+func (t *btree) len() int {
+	return t.length
+}
+
+// insert adds key and reports whether it was new
+func (t *btree) insert(key string) bool {
+	if t.root == nil {
+		t.root = &btreeNode{keys: []string{key}}
+		t.length++
+		return true
+	}
+	if len(t.root.keys) >= btreeMaxItems {
+		item, right := t.root.split(btreeMaxItems / 2)
+		t.root = &btreeNode{keys: []string{item}, children: []*btreeNode{t.root, right}}
+	}
+	if t.root.insert(key) {
+		t.length++
+		return true
+	}
+	return false
+}
+
+// delete removes key and reports whether it was present
+func (t *btree) delete(key string) bool {
+	if t.root == nil || len(t.root.keys) == 0 {
+		return false
+	}
+	_, ok := t.root.remove(key, removeKey)
+	if len(t.root.keys) == 0 && len(t.root.children) > 0 {
+		t.root = t.root.children[0]
+	}
+	if ok {
+		t.length--
+	}
+	return ok
+}
+
+// ascend calls fn for every key >= start in order until fn returns false
+func (t *btree) ascend(start string, fn func(key string) bool) {
+	if t.root != nil {
+		t.root.ascend(start, fn)
+	}
+}
+
+func (n *btreeNode) find(key string) (int, bool) {
+	i := sort.SearchStrings(n.keys, key)
+	return i, i < len(n.keys) && n.keys[i] == key
+}
+
+// split moves everything after index i into a new right sibling and returns
+// the key at i, which the parent takes over
+func (n *btreeNode) split(i int) (string, *btreeNode) {
+	item := n.keys[i]
+	right := &btreeNode{keys: append([]string(nil), n.keys[i+1:]...)}
+	clear(n.keys[i:])
+	n.keys = n.keys[:i]
+	if len(n.children) > 0 {
+		right.children = append([]*btreeNode(nil), n.children[i+1:]...)
+		clear(n.children[i+1:])
+		n.children = n.children[:i+1]
+	}
+	return item, right
+}
+
+func (n *btreeNode) maybeSplitChild(i int) bool {
+	if len(n.children[i].keys) < btreeMaxItems {
+		return false
+	}
+	item, right := n.children[i].split(btreeMaxItems / 2)
+	n.keys = insertAt(n.keys, i, item)
+	n.children = insertAt(n.children, i+1, right)
+	return true
+}
+
+// insert adds key below n, which is never full when called
+func (n *btreeNode) insert(key string) bool {
+	i, found := n.find(key)
+	if found {
+		return false
+	}
+	if len(n.children) == 0 {
+		n.keys = insertAt(n.keys, i, key)
+		return true
+	}
+	if n.maybeSplitChild(i) {
+		switch {
+		case key == n.keys[i]:
+			return false
+		case key > n.keys[i]:
+			i++
+		}
+	}
+	return n.children[i].insert(key)
+}
+
+func (n *btreeNode) remove(key string, typ btreeRemove) (string, bool) {
+	var i int
+	var found bool
+	switch typ {
+	case removeMax:
+		if len(n.children) == 0 {
+			var last string
+			n.keys, last = removeAt(n.keys, len(n.keys)-1)
+			return last, true
+		}
+		i = len(n.keys)
+	case removeKey:
+		i, found = n.find(key)
+		if len(n.children) == 0 {
+			if !found {
+				return "", false
+			}
+			n.keys, _ = removeAt(n.keys, i)
+			return key, true
+		}
+	}
+
+	if len(n.children[i].keys) <= btreeMinItems {
+		return n.growChildAndRemove(i, key, typ)
+	}
+	if found {
+		// Replace the key with its predecessor, which always lives in a leaf
+		out := n.keys[i]
+		n.keys[i], _ = n.children[i].remove("", removeMax)
+		return out, true
+	}
+	return n.children[i].remove(key, typ)
+}
+
+// growChildAndRemove gives child i an extra key, by stealing from a sibling or
+// merging with one, before retrying the removal
+func (n *btreeNode) growChildAndRemove(i int, key string, typ btreeRemove) (string, bool) {
+	switch {
+	case i > 0 && len(n.children[i-1].keys) > btreeMinItems:
+		child, left := n.children[i], n.children[i-1]
+		var stolen string
+		left.keys, stolen = removeAt(left.keys, len(left.keys)-1)
+		child.keys = insertAt(child.keys, 0, n.keys[i-1])
+		n.keys[i-1] = stolen
+		if len(left.children) > 0 {
+			var grandchild *btreeNode
+			left.children, grandchild = removeAt(left.children, len(left.children)-1)
+			child.children = insertAt(child.children, 0, grandchild)
+		}
+	case i < len(n.keys) && len(n.children[i+1].keys) > btreeMinItems:
+		child, right := n.children[i], n.children[i+1]
+		var stolen string
+		right.keys, stolen = removeAt(right.keys, 0)
+		child.keys = append(child.keys, n.keys[i])
+		n.keys[i] = stolen
+		if len(right.children) > 0 {
+			var grandchild *btreeNode
+			right.children, grandchild = removeAt(right.children, 0)
+			child.children = append(child.children, grandchild)
+		}
+	default:
+		if i >= len(n.keys) {
+			i--
+		}
+		child := n.children[i]
+		var separator string
+		var right *btreeNode
+		n.keys, separator = removeAt(n.keys, i)
+		n.children, right = removeAt(n.children, i+1)
+		child.keys = append(child.keys, separator)
+		child.keys = append(child.keys, right.keys...)
+		child.children = append(child.children, right.children...)
+	}
+	return n.remove(key, typ)
+}
+
+func (n *btreeNode) ascend(start string, fn func(key string) bool) bool {
+	i := sort.SearchStrings(n.keys, start)
+	for ; i < len(n.keys); i++ {
+		if len(n.children) > 0 && !n.children[i].ascend(start, fn) {
+			return false
+		}
+		if !fn(n.keys[i]) {
+			return false
+		}
+	}
+	if len(n.children) > 0 {
+		return n.children[len(n.children)-1].ascend(start, fn)
+	}
+	return true
+}
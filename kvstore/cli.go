@@ -0,0 +1,718 @@
+package kvstore
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// cliHistoryMax is the number of lines the history of the REPL keeps
+const cliHistoryMax = 1000
+
+// RunCLI runs the command line client of the cli subcommand, modelled on
+// redis-cli: without a command it starts a REPL, with one it prints its reply.
+// --pipe, --scan and --bigkeys select the other modes. It returns the exit
+// status.
+func RunCLI(args []string) int {
+	return runCLI(args, os.Stdin, os.Stdout, os.Stderr)
+}
+
+// cli is a session of the command line client
+type cli struct {
+	network, addr string
+	db            int
+	resp3         bool
+	// raw prints replies without type information, as they are
+	raw bool
+
+	conn    *cliConn
+	history *cliHistory
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+func runCLI(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("cli", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: go-mem-kv cli [options] [command [arg ...]]")
+		flags.PrintDefaults()
+	}
+	host := flags.String("h", "127.0.0.1", "Server hostname")
+	port := flags.Int("p", 6379, "Server port")
+	socket := flags.String("s", "", "Server Unix socket, used instead of the hostname and port")
+	db := flags.Int("n", 0, "Database number")
+	resp3 := flags.Bool("3", false, "Start the session in RESP3 mode")
+	raw := flags.Bool("raw", false, "Print replies as they are (the default when the output is not a terminal)")
+	noRaw := flags.Bool("no-raw", false, "Print replies with their types even when the output is not a terminal")
+	pipe := flags.Bool("pipe", false, "Transfer the RESP commands of stdin to the server (mass insertion)")
+	scan := flags.Bool("scan", false, "List the keys matching --pattern using SCAN")
+	pattern := flags.String("pattern", "*", "Pattern of the keys for --scan and --bigkeys")
+	count := flags.Int("count", 100, "COUNT of the SCAN commands of --scan and --bigkeys")
+	bigkeys := flags.Bool("bigkeys", false, "Sample the keys looking for the biggest ones of each type")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+
+	session := &cli{network: "tcp", addr: net.JoinHostPort(*host, strconv.Itoa(*port)), db: *db, resp3: *resp3,
+		raw: !isTerminal(stdout) && !*noRaw || *raw, stdout: stdout, stderr: stderr}
+	if *socket != "" {
+		session.network, session.addr = "unix", *socket
+	}
+	if err := session.connect(); err != nil {
+		// The REPL starts regardless and connects again on the next command
+		if flags.NArg() > 0 || *pipe || *scan || *bigkeys || !isTerminal(stdin) {
+			return 1
+		}
+	}
+	defer func() {
+		if session.conn != nil {
+			session.conn.Close()
+		}
+	}()
+
+	switch {
+	case *pipe:
+		return session.pipe(stdin)
+	case *scan:
+		err := session.scanKeys(*pattern, *count, func(key string) error {
+			_, err := fmt.Fprintln(stdout, key)
+			return err
+		})
+		if err != nil {
+			fmt.Fprintf(stderr, "Error scanning keys: %v\n", err)
+			return 1
+		}
+		return 0
+	case *bigkeys:
+		return session.bigkeys(*pattern, *count)
+	case flags.NArg() > 0:
+		reply, err := session.run(flags.Args())
+		if err != nil || reply.kind == '-' || reply.kind == '!' {
+			return 1
+		}
+		return 0
+	}
+	return session.repl(stdin)
+}
+
+// isTerminal reports whether f is a terminal rather than a file or a pipe
+func isTerminal(f any) bool {
+	file, ok := f.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// connect opens the connection of the session, switching to RESP3 and to
+// its database as needed
+func (cli *cli) connect() error {
+	conn, err := net.Dial(cli.network, cli.addr)
+	if err != nil {
+		fmt.Fprintf(cli.stderr, "Could not connect to go-mem-kv at %s: %v\n", cli.addr, err)
+		return err
+	}
+	cli.conn = &cliConn{Conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+	var setup [][]string
+	if cli.resp3 {
+		setup = append(setup, []string{"HELLO", "3"})
+	}
+	if cli.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(cli.db)})
+	}
+	for _, args := range setup {
+		reply, err := cli.conn.do(args...)
+		if err == nil && (reply.kind == '-' || reply.kind == '!') {
+			err = replyError(reply.str)
+		}
+		if err != nil {
+			fmt.Fprintf(cli.stderr, "%s failed: %v\n", args[0], err)
+			cli.conn.Close()
+			cli.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// run sends a command and prints its reply. Subscribing and MONITOR then
+// print what the server pushes until the connection ends.
+func (cli *cli) run(args []string) (respValue, error) {
+	if cli.conn == nil {
+		if err := cli.connect(); err != nil {
+			return respValue{}, err
+		}
+	}
+	name := strings.ToLower(args[0])
+	streaming := slices.Contains([]string{"subscribe", "psubscribe", "ssubscribe", "monitor"}, name)
+	if streaming && !cli.raw {
+		fmt.Fprintln(cli.stdout, "Reading messages... (press Ctrl-C to quit)")
+	}
+	reply, err := cli.conn.do(args...)
+	if err != nil {
+		fmt.Fprintf(cli.stderr, "Error: %v\n", err)
+		cli.conn.Close()
+		cli.conn = nil
+		return respValue{}, err
+	}
+	cli.print(reply, cli.raw || cliRawCommand(args))
+	if reply.kind == '-' || reply.kind == '!' {
+		return reply, nil
+	}
+	switch name {
+	case "select":
+		cli.db, _ = strconv.Atoi(args[1])
+	case "hello":
+		cli.resp3 = len(args) > 1 && args[1] == "3"
+	}
+	for streaming {
+		pushed, err := readRESP(cli.conn.reader)
+		if err != nil {
+			cli.conn.Close()
+			cli.conn = nil
+			return reply, nil
+		}
+		cli.print(pushed, cli.raw)
+	}
+	return reply, nil
+}
+
+// cliRawCommand reports whether a command returns text meant to be read as
+// it is, which is printed raw like redis-cli does
+func cliRawCommand(args []string) bool {
+	name := strings.ToLower(args[0])
+	if name == "info" || name == "lolwut" {
+		return true
+	}
+	if len(args) < 2 {
+		return false
+	}
+	switch name + " " + strings.ToLower(args[1]) {
+	case "client list", "client info", "cluster info", "cluster nodes", "memory doctor", "memory stats",
+		"latency doctor", "latency graph":
+		return true
+	}
+	return false
+}
+
+func (cli *cli) print(reply respValue, raw bool) {
+	if raw {
+		out := formatRaw(reply)
+		if !strings.HasSuffix(out, "\n") {
+			out += "\n"
+		}
+		fmt.Fprint(cli.stdout, out)
+		return
+	}
+	fmt.Fprint(cli.stdout, formatTTY(reply, ""))
+}
+
+func (cli *cli) prompt() string {
+	if cli.conn == nil {
+		return "not connected> "
+	}
+	if cli.db != 0 {
+		return fmt.Sprintf("%s[%d]> ", cli.addr, cli.db)
+	}
+	return cli.addr + "> "
+}
+
+// repl reads commands from stdin, one per line and tokenized like inline
+// commands. On a terminal it shows a prompt and keeps the history in a file;
+// "!!", "!<n>" and "!<prefix>" run a line of it again and "history" lists it.
+func (cli *cli) repl(stdin io.Reader) int {
+	interactive := isTerminal(stdin)
+	cli.history = &cliHistory{}
+	if interactive {
+		cli.history = loadCLIHistory(cliHistoryFile())
+	}
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 64*1024), maxStringSize)
+	for {
+		if interactive {
+			fmt.Fprint(cli.stdout, cli.prompt())
+		}
+		if !scanner.Scan() {
+			return 0
+		}
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" {
+			continue
+		}
+		line, ok := cli.history.expand(input)
+		if !ok {
+			fmt.Fprintln(cli.stderr, "(error) event not found")
+			continue
+		}
+		if line != input && interactive {
+			fmt.Fprintln(cli.stdout, line)
+		}
+		args := parseCommand(line)
+		if len(args) == 0 {
+			continue
+		}
+		if !cliSensitiveCommand(args) {
+			cli.history.add(line)
+		}
+		switch strings.ToLower(args[0]) {
+		case "quit", "exit":
+			return 0
+		case "history":
+			cli.history.print(cli.stdout)
+			continue
+		}
+		cli.run(args)
+	}
+}
+
+// cliSensitiveCommand reports whether a command may carry a password and is
+// kept out of the history
+func cliSensitiveCommand(args []string) bool {
+	switch strings.ToLower(args[0]) {
+	case "auth", "hello", "migrate":
+		return true
+	case "acl":
+		return len(args) > 1 && strings.EqualFold(args[1], "setuser")
+	case "config":
+		return len(args) > 2 && strings.EqualFold(args[1], "set") &&
+			(strings.EqualFold(args[2], "requirepass") || strings.EqualFold(args[2], "masterauth"))
+	}
+	return false
+}
+
+// pipe sends stdin, which holds commands in RESP, to the server while
+// counting the replies, like redis-cli --pipe. A PING with a random marker
+// follows the data, so its reply tells the last reply has been received.
+func (cli *cli) pipe(stdin io.Reader) int {
+	var token [20]byte
+	rand.Read(token[:])
+	marker := hex.EncodeToString(token[:])
+
+	type result struct {
+		replies, errors int
+		err             error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var res result
+		for {
+			reply, err := readRESP(cli.conn.reader)
+			if err != nil {
+				res.err = err
+				break
+			}
+			if reply.kind == '$' && reply.str == marker {
+				break
+			}
+			res.replies++
+			if reply.kind == '-' || reply.kind == '!' {
+				res.errors++
+				fmt.Fprintln(cli.stderr, reply.str)
+			}
+		}
+		done <- res
+	}()
+
+	_, err := io.Copy(cli.conn.writer, stdin)
+	if err == nil {
+		_, err = cli.conn.writer.WriteString(respArray([]string{"PING", marker}))
+	}
+	if err == nil {
+		err = cli.conn.writer.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(cli.stderr, "Error writing to the server: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(cli.stdout, "All data transferred. Waiting for the last reply...")
+	res := <-done
+	if res.err != nil {
+		fmt.Fprintf(cli.stderr, "Error reading replies from the server: %v\n", res.err)
+		return 1
+	}
+	fmt.Fprintln(cli.stdout, "Last reply received from server.")
+	fmt.Fprintf(cli.stdout, "errors: %d, replies: %d\n", res.errors, res.replies)
+	if res.errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// scanKeys calls fn with the keys matching pattern, iterating with SCAN
+func (cli *cli) scanKeys(pattern string, count int, fn func(key string) error) error {
+	cursor := "0"
+	for {
+		reply, err := cli.conn.do("SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(count))
+		if err != nil {
+			return err
+		}
+		if reply.kind == '-' || reply.kind == '!' {
+			return replyError(reply.str)
+		}
+		if len(reply.items) != 2 {
+			return errors.New("unexpected SCAN reply")
+		}
+		cursor = reply.items[0].str
+		for _, key := range reply.items[1].items {
+			if err := fn(key.str); err != nil {
+				return err
+			}
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// bigkeys scans the keyspace for the biggest key of each type and prints
+// the sizes per type, like redis-cli --bigkeys. Strings are measured in
+// bytes of their value and other types in bytes of memory.
+func (cli *cli) bigkeys(pattern string, count int) int {
+	type typeStats struct {
+		keys, size  int64
+		biggest     string
+		biggestSize int64
+	}
+	total, err := cli.conn.do("DBSIZE")
+	if err != nil {
+		fmt.Fprintf(cli.stderr, "Error: %v\n", err)
+		return 1
+	}
+	dbsize, _ := strconv.ParseInt(total.str, 10, 64)
+
+	fmt.Fprintln(cli.stdout, "\n# Scanning the entire keyspace to find biggest keys as well as")
+	fmt.Fprintln(cli.stdout, "# average sizes per key type.")
+	fmt.Fprintln(cli.stdout)
+	stats := map[string]*typeStats{"string": {}}
+	var sampled, keyLength int64
+	err = cli.scanKeys(pattern, count, func(key string) error {
+		reply, err := cli.conn.do("TYPE", key)
+		if err != nil {
+			return err
+		}
+		keyType := reply.str
+		if keyType == "none" {
+			return nil
+		}
+		if keyType == "string" {
+			reply, err = cli.conn.do("STRLEN", key)
+		} else {
+			reply, err = cli.conn.do("MEMORY", "USAGE", key)
+		}
+		if err != nil {
+			return err
+		}
+		size, _ := strconv.ParseInt(reply.str, 10, 64)
+		sampled++
+		keyLength += int64(len(key))
+		st := stats[keyType]
+		if st == nil {
+			st = &typeStats{}
+			stats[keyType] = st
+		}
+		st.keys++
+		st.size += size
+		if st.biggest == "" || size > st.biggestSize {
+			st.biggest, st.biggestSize = key, size
+			percent := 0.0
+			if dbsize > 0 {
+				percent = 100 * float64(sampled) / float64(dbsize)
+			}
+			fmt.Fprintf(cli.stdout, "[%05.2f%%] Biggest %-6s found so far '%s' with %d %s\n",
+				percent, keyType, cliQuote(key), size, cliSizeUnit(keyType))
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(cli.stderr, "Error scanning keys: %v\n", err)
+		return 1
+	}
+
+	average := func(n, d int64) float64 {
+		if d == 0 {
+			return 0
+		}
+		return float64(n) / float64(d)
+	}
+	types := make([]string, 0, len(stats))
+	for keyType := range stats {
+		types = append(types, keyType)
+	}
+	slices.Sort(types)
+	fmt.Fprintln(cli.stdout, "\n-------- summary -------")
+	fmt.Fprintf(cli.stdout, "\nSampled %d keys in the keyspace!\n", sampled)
+	fmt.Fprintf(cli.stdout, "Total key length in bytes is %d (avg len %.2f)\n\n", keyLength, average(keyLength, sampled))
+	for _, keyType := range types {
+		if st := stats[keyType]; st.biggest != "" {
+			fmt.Fprintf(cli.stdout, "Biggest %6s found '%s' has %d %s\n", keyType, cliQuote(st.biggest), st.biggestSize, cliSizeUnit(keyType))
+		}
+	}
+	fmt.Fprintln(cli.stdout)
+	for _, keyType := range types {
+		st := stats[keyType]
+		fmt.Fprintf(cli.stdout, "%d %ss with %d %s (%05.2f%% of keys, avg size %.2f)\n",
+			st.keys, keyType, st.size, cliSizeUnit(keyType), 100*average(st.keys, sampled), average(st.size, st.keys))
+	}
+	return 0
+}
+
+func cliSizeUnit(keyType string) string {
+	if keyType == "string" {
+		return "bytes"
+	}
+	return "bytes of memory"
+}
+
+// cliConn is the connection of the command line client
+type cliConn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// do sends a command and reads its reply
+func (c *cliConn) do(args ...string) (respValue, error) {
+	if _, err := c.writer.WriteString(respArray(args)); err != nil {
+		return respValue{}, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		return respValue{}, err
+	}
+	return readRESP(c.reader)
+}
+
+// formatTTY formats a reply for people, the way redis-cli does on a
+// terminal: types are spelled out, strings quoted and the elements of
+// aggregates numbered, with nested ones indented past prefix
+func formatTTY(v respValue, prefix string) string {
+	if v.null {
+		return "(nil)\n"
+	}
+	switch v.kind {
+	case '-', '!':
+		return "(error) " + v.str + "\n"
+	case '+':
+		return v.str + "\n"
+	case ':':
+		return "(integer) " + v.str + "\n"
+	case ',':
+		return "(double) " + v.str + "\n"
+	case '(':
+		return "(big number) " + v.str + "\n"
+	case '#':
+		if v.str == "t" {
+			return "(true)\n"
+		}
+		return "(false)\n"
+	case '=':
+		if len(v.str) >= 4 {
+			return v.str[4:] + "\n"
+		}
+		return v.str + "\n"
+	case '*', '~', '>', '%':
+	default:
+		return cliQuote(v.str) + "\n"
+	}
+
+	step, numbering := 1, byte(')')
+	switch v.kind {
+	case '~':
+		numbering = '~'
+	case '%':
+		step, numbering = 2, '#'
+	}
+	n := len(v.items) / step
+	if n == 0 {
+		switch v.kind {
+		case '~':
+			return "(empty set)\n"
+		case '%':
+			return "(empty hash)\n"
+		}
+		return "(empty array)\n"
+	}
+	width := len(strconv.Itoa(n))
+	nested := prefix + strings.Repeat(" ", width+2)
+	var b strings.Builder
+	for i := 0; i < len(v.items); i += step {
+		// The first element goes on the line of the number of its parent
+		if i > 0 {
+			b.WriteString(prefix)
+		}
+		fmt.Fprintf(&b, "%*d%c ", width, i/step+1, numbering)
+		if v.kind == '%' {
+			b.WriteString(strings.TrimSuffix(formatTTY(v.items[i], nested), "\n"))
+			b.WriteString(" => ")
+			b.WriteString(formatTTY(v.items[i+1], nested))
+			continue
+		}
+		b.WriteString(formatTTY(v.items[i], nested))
+	}
+	return b.String()
+}
+
+// formatRaw formats a reply for scripts: payloads as they are and the
+// elements of aggregates on lines of their own
+func formatRaw(v respValue) string {
+	if v.null {
+		return ""
+	}
+	switch v.kind {
+	case '#':
+		if v.str == "t" {
+			return "(true)"
+		}
+		return "(false)"
+	case '=':
+		if len(v.str) >= 4 {
+			return v.str[4:]
+		}
+	case '*', '~', '>', '%':
+		lines := make([]string, len(v.items))
+		for i, item := range v.items {
+			lines[i] = formatRaw(item)
+		}
+		return strings.Join(lines, "\n")
+	}
+	return v.str
+}
+
+// cliQuote quotes a string like redis-cli, escaping quotes, backslashes and
+// the bytes that are not printable ASCII
+func cliQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// cliHistory is the history of the REPL, appended to file when it is set
+type cliHistory struct {
+	lines []string
+	file  string
+}
+
+// cliHistoryFile returns the path of the history file, from
+// GOMEMKV_HISTFILE or in the home directory. "/dev/null" disables it.
+func cliHistoryFile() string {
+	if file := os.Getenv("GOMEMKV_HISTFILE"); file != "" {
+		return file
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gomemkv_history")
+}
+
+func loadCLIHistory(file string) *cliHistory {
+	h := &cliHistory{file: file}
+	if file == "" || file == os.DevNull {
+		h.file = ""
+		return h
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.lines = append(h.lines, line)
+		}
+	}
+	if len(h.lines) > cliHistoryMax {
+		h.lines = h.lines[len(h.lines)-cliHistoryMax:]
+		// Rewrite the file so it does not grow without bounds
+		os.WriteFile(file, []byte(strings.Join(h.lines, "\n")+"\n"), 0o600)
+	}
+	return h
+}
+
+func (h *cliHistory) add(line string) {
+	if len(h.lines) > 0 && h.lines[len(h.lines)-1] == line {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > cliHistoryMax {
+		h.lines = h.lines[1:]
+	}
+	if h.file == "" {
+		return
+	}
+	if f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600); err == nil {
+		fmt.Fprintln(f, line)
+		f.Close()
+	}
+}
+
+// expand replaces a line of "!!", "!<n>" or "!<prefix>" with the last line,
+// the line numbered n or the last line starting with prefix. It returns
+// false when there is no such line.
+func (h *cliHistory) expand(line string) (string, bool) {
+	if !strings.HasPrefix(line, "!") || len(line) == 1 {
+		return line, true
+	}
+	event := line[1:]
+	if event == "!" {
+		if len(h.lines) == 0 {
+			return "", false
+		}
+		return h.lines[len(h.lines)-1], true
+	}
+	if n, err := strconv.Atoi(event); err == nil {
+		if n < 1 || n > len(h.lines) {
+			return "", false
+		}
+		return h.lines[n-1], true
+	}
+	for i := len(h.lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(h.lines[i], event) {
+			return h.lines[i], true
+		}
+	}
+	return "", false
+}
+
+func (h *cliHistory) print(w io.Writer) {
+	for i, line := range h.lines {
+		fmt.Fprintf(w, "%5d  %s\n", i+1, line)
+	}
+}
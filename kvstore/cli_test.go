@@ -0,0 +1,141 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFormatReply(t *testing.T) {
+	for _, tc := range []struct {
+		reply, tty, raw string
+	}{
+		{"+OK\r\n", "OK\n", "OK"},
+		{"-ERR nope\r\n", "(error) ERR nope\n", "ERR nope"},
+		{":42\r\n", "(integer) 42\n", "42"},
+		{"$6\r\na \"b\"\n\r\n", `"a \"b\"\n"` + "\n", "a \"b\"\n"},
+		{"$-1\r\n", "(nil)\n", ""},
+		{"*0\r\n", "(empty array)\n", ""},
+		{",1.5\r\n", "(double) 1.5\n", "1.5"},
+		{"#t\r\n", "(true)\n", "(true)"},
+		{"=7\r\ntxt:a b\r\n", "a b\n", "a b"},
+		{"*2\r\n$1\r\na\r\n*2\r\n:1\r\n*1\r\n$1\r\nb\r\n", "1) \"a\"\n2) 1) (integer) 1\n   2) 1) \"b\"\n", "a\n1\nb"},
+		{"%2\r\n$1\r\nk\r\n$1\r\nv\r\n$1\r\nn\r\n:2\r\n", "1# \"k\" => \"v\"\n2# \"n\" => (integer) 2\n", "k\nv\nn\n2"},
+		{"~1\r\n$3\r\n\xff\x00a\r\n", "1~ \"\\xff\\x00a\"\n", "\xff\x00a"},
+	} {
+		v, err := readRESP(bufio.NewReader(strings.NewReader(tc.reply)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatTTY(v, ""); got != tc.tty {
+			t.Errorf("%q formatted as %q, want %q", tc.reply, got, tc.tty)
+		}
+		if got := formatRaw(v); got != tc.raw {
+			t.Errorf("%q formatted raw as %q, want %q", tc.reply, got, tc.raw)
+		}
+	}
+
+	// Ten elements or more align their numbers
+	items := make([]string, 10)
+	got := formatTTY(mustReadRESP(t, respArray(items)), "")
+	if !strings.HasPrefix(got, " 1) \"\"\n 2) ") || !strings.HasSuffix(got, "10) \"\"\n") {
+		t.Fatalf("long array formatted as %q", got)
+	}
+}
+
+func mustReadRESP(t *testing.T, reply string) respValue {
+	t.Helper()
+	v, err := readRESP(bufio.NewReader(strings.NewReader(reply)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestCLI(t *testing.T) {
+	server := NewRedisServer(nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.ServeListener(ctx, listener)
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	run := func(stdin string, args ...string) (int, string, string) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		status := runCLI(append([]string{"-h", "127.0.0.1", "-p", port}, args...), strings.NewReader(stdin), &stdout, &stderr)
+		return status, stdout.String(), stderr.String()
+	}
+
+	if status, out, _ := run("", "SET", "greeting", "hello world"); status != 0 || out != "OK\n" {
+		t.Fatalf("SET exited with %d and printed %q", status, out)
+	}
+	if status, out, _ := run("", "--no-raw", "INCR", "greeting"); status != 1 || out != "(error) ERR value is not an integer or out of range\n" {
+		t.Fatalf("INCR exited with %d and printed %q", status, out)
+	}
+
+	// Lines are tokenized like inline commands
+	status, out, _ := run("GET greeting\nSET \"two words\" \"a \\\"b\\\"\"\n!GET\nSET empty \"\"\nSTRLEN empty\nSELECT 2\nMGET greeting missing\nquit\nPING\n", "--no-raw")
+	want := "\"hello world\"\nOK\n\"hello world\"\nOK\n(integer) 0\nOK\n1) (nil)\n2) (nil)\n"
+	if status != 0 || out != want {
+		t.Fatalf("REPL exited with %d and printed %q, want %q", status, out, want)
+	}
+
+	var mass strings.Builder
+	for i := range 100 {
+		mass.WriteString(respArray([]string{"SET", "mass:" + strconv.Itoa(i), strconv.Itoa(i)}))
+	}
+	mass.WriteString(respArray([]string{"INCR", "greeting"}))
+	status, out, errOut := run(mass.String(), "--pipe")
+	if status != 1 || !strings.HasSuffix(out, "errors: 1, replies: 101\n") || !strings.Contains(errOut, "not an integer") {
+		t.Fatalf("--pipe exited with %d and printed %q %q", status, out, errOut)
+	}
+	if value, _ := server.databases()[0].Get("two words"); value != `a "b"` {
+		t.Fatalf("quoted SET stored %q", value)
+	}
+	if value, err := server.databases()[0].Get("empty"); err != nil || value != "" {
+		t.Fatalf("SET of an empty quoted value stored %q, %v", value, err)
+	}
+
+	status, out, _ = run("", "--scan", "--pattern", "mass:1?", "--count", "7")
+	if keys := strings.Fields(out); status != 0 || len(keys) != 10 {
+		t.Fatalf("--scan exited with %d and printed %q", status, out)
+	}
+	status, out, _ = run("", "--bigkeys")
+	if status != 0 || !strings.Contains(out, `Biggest string found '"greeting"' has 11 bytes`) ||
+		!strings.Contains(out, "Sampled 103 keys in the keyspace!") {
+		t.Fatalf("--bigkeys exited with %d and printed %q", status, out)
+	}
+}
+
+func TestCLIHistory(t *testing.T) {
+	h := &cliHistory{}
+	h.add("SET a 1")
+	h.add("GET a")
+	h.add("GET a")
+	for _, tc := range []struct {
+		line, want string
+		ok         bool
+	}{
+		{"!!", "GET a", true},
+		{"!1", "SET a 1", true},
+		{"!SET", "SET a 1", true},
+		{"!3", "", false},
+		{"!DEL", "", false},
+		{"GET b", "GET b", true},
+	} {
+		if got, ok := h.expand(tc.line); got != tc.want || ok != tc.ok {
+			t.Errorf("%q expanded to %q %v", tc.line, got, ok)
+		}
+	}
+	if len(h.lines) != 2 {
+		t.Fatalf("history kept %q", h.lines)
+	}
+}
@@ -0,0 +1,151 @@
+package kvstore
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// client is the state of one connection to a RedisServer
+type client struct {
+	server *RedisServer
+	conn   net.Conn
+	// id is unique per server and never reused; 0 for clients that are not
+	// registered, such as the ones tests build directly
+	id      int64
+	created time.Time
+	// dbIndex is the database selected with SELECT, written under mu. db is
+	// the store it maps to, looked up again before every command so SWAPDB
+	// applies right away.
+	dbIndex int
+	db      KVStoreInterface
+	// closing is set by QUIT to close the connection after the reply
+	closing bool
+
+	// mu guards the fields CLIENT LIST reads from other connections; the
+	// connection's own goroutine may read them without it
+	mu         sync.Mutex
+	name       string
+	lastCmd    string
+	lastActive time.Time
+	// qbuf is the number of bytes read but not yet parsed
+	qbuf    atomic.Int64
+	noEvict atomic.Bool
+	// omem is the output not written yet: queued pushes and the reply
+	// being written. softLimitSince is when it went over the soft output
+	// buffer limit, in Unix nanoseconds, 0 while it is not.
+	omem           atomic.Int64
+	softLimitSince atomic.Int64
+	outputLimited  atomic.Bool
+
+	// resp is the protocol version chosen with HELLO, 0 until then
+	resp atomic.Int32
+	// channels and patterns are the pub/sub subscriptions, changed under
+	// the server's pubsub lock; subs mirrors their total
+	channels map[string]bool
+	patterns map[string]bool
+	subs     atomic.Int64
+	tracking clientTracking
+
+	// writeMu serializes writes to conn, which the messages pushed by other
+	// connections share with the command replies
+	writeMu sync.Mutex
+	// pushes queues those messages for a writer goroutine started by the
+	// first push, so a slow client never holds up the one pushing
+	pushMu     sync.Mutex
+	pushes     chan string
+	pushClosed bool
+}
+
+// clientPushBacklog is how many pushed messages a client may fall behind
+// before it is disconnected, on top of the output buffer limits
+const clientPushBacklog = 4096
+
+// write sends a reply to the client
+func (c *client) write(reply string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write([]byte(reply))
+	return err
+}
+
+// protocol returns the RESP version of the connection, 2 or 3
+func (c *client) protocol() int {
+	if c.resp.Load() == 3 {
+		return 3
+	}
+	return 2
+}
+
+// frame encodes an out-of-band message such as a pub/sub message: a push
+// in RESP3, an array in RESP2
+func (c *client) frame(replies ...string) string {
+	if c.protocol() == 3 {
+		return respPush(replies)
+	}
+	return respReplies(replies)
+}
+
+// push queues a message for the client outside of the request/reply flow,
+// such as a MONITOR line, a pub/sub message or an invalidation. Clients that
+// fall too far behind are disconnected.
+func (c *client) push(msg string) {
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	if c.pushClosed || c.conn == nil {
+		return
+	}
+	if c.pushes == nil {
+		c.pushes = make(chan string, clientPushBacklog)
+		go func(pushes chan string) {
+			for msg := range pushes {
+				if c.write(msg) != nil {
+					c.conn.Close()
+				}
+				c.omem.Add(-int64(len(msg)))
+			}
+		}(c.pushes)
+	}
+	n := int64(len(msg))
+	c.omem.Add(n)
+	select {
+	case c.pushes <- msg:
+		c.checkOutputBuffer()
+	default:
+		c.omem.Add(-n)
+		c.conn.Close()
+	}
+}
+
+// pending returns how many pushed messages wait to be written
+func (c *client) pending() int {
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	return len(c.pushes)
+}
+
+// closePushes stops the writer goroutine once the connection is done
+func (c *client) closePushes() {
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	c.pushClosed = true
+	if c.pushes != nil {
+		close(c.pushes)
+	}
+}
+
+// addr is the client's remote address as shown by MONITOR and SLOWLOG
+func (c *client) addr() string {
+	if c.conn == nil {
+		return ""
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// getName returns the name set with CLIENT SETNAME
+func (c *client) getName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
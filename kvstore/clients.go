@@ -0,0 +1,519 @@
+package kvstore
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clientRegistry tracks the connected clients for the CLIENT command
+type clientRegistry struct {
+	nextID atomic.Int64
+
+	mu   sync.Mutex
+	byID map[int64]*client
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{byID: make(map[int64]*client)}
+}
+
+// add registers a client for a new connection
+func (r *clientRegistry) add(s *RedisServer, conn net.Conn) *client {
+	now := time.Now()
+	c := &client{server: s, conn: conn, id: r.nextID.Add(1), created: now, lastActive: now}
+	r.mu.Lock()
+	r.byID[c.id] = c
+	r.mu.Unlock()
+	return c
+}
+
+func (r *clientRegistry) remove(c *client) {
+	r.mu.Lock()
+	delete(r.byID, c.id)
+	r.mu.Unlock()
+}
+
+// get returns the client with the given ID, or nil
+func (r *clientRegistry) get(id int64) *client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.byID[id]
+}
+
+// closeClient releases everything a connection holds once it is closed
+func (s *RedisServer) closeClient(c *client) {
+	s.clients.remove(c)
+	s.monitors.remove(c)
+	s.pubsub.unsubscribeAll(c)
+	s.tracking.disable(c)
+	s.syncKeyEventHooks()
+	c.closePushes()
+}
+
+// list returns the registered clients ordered by ID
+func (r *clientRegistry) list() []*client {
+	r.mu.Lock()
+	all := make([]*client, 0, len(r.byID))
+	for _, c := range r.byID {
+		all = append(all, c)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(all, func(a, b *client) int { return int(a.id - b.id) })
+	return all
+}
+
+// describe renders c as a CLIENT LIST line. Fields for features the server
+// lacks, such as transactions, keep their Redis defaults so existing tooling
+// can parse the line.
+func (c *client) describe(now time.Time) string {
+	c.mu.Lock()
+	name, cmd, db, lastActive := c.name, c.lastCmd, c.dbIndex, c.lastActive
+	c.mu.Unlock()
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	laddr := ""
+	if c.conn != nil {
+		laddr = c.conn.LocalAddr().String()
+	}
+
+	flags := ""
+	if c.server.monitors.isMonitor(c) {
+		flags += "O"
+	}
+	sub, psub := c.server.pubsub.counts(c)
+	if sub+psub > 0 {
+		flags += "P"
+	}
+	trackingFlags, redirect := c.trackingFlags()
+	flags += trackingFlags
+	if c.noEvict.Load() {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	qbuf := c.qbuf.Load()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s fd=-1 name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d ssub=0 multi=-1 "+
+		"qbuf=%d qbuf-free=0 argv-mem=0 multi-mem=0 rbs=0 rbp=0 obl=0 oll=%d omem=%d tot-mem=%d events=r cmd=%s user=default redir=%d resp=%d",
+		c.id, c.addr(), laddr, name, int64(now.Sub(c.created)/time.Second), int64(now.Sub(lastActive)/time.Second),
+		flags, db, sub, psub, qbuf, c.pending(), c.omem.Load(), qbuf+c.omem.Load(), cmd, redirect, c.protocol())
+}
+
+// validClientName reports whether name may be set with CLIENT SETNAME; like
+// Redis, names are limited to printable characters without spaces
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// pauseState implements CLIENT PAUSE. active is checked on every command, so
+// commands only take the lock while a pause is in effect.
+type pauseState struct {
+	active atomic.Bool
+
+	mu    sync.Mutex
+	until time.Time
+	all   bool
+	timer *time.Timer
+	// generation counts the pauses, so that the timer of a pause that was
+	// extended cannot end the pause after Stop missed it
+	generation uint64
+	// done is closed when the pause ends
+	done chan struct{}
+}
+
+// pause stops the commands of all clients, or only writes, for d. Like Redis,
+// a pause during a pause keeps the later deadline and the stricter mode.
+func (p *pauseState) pause(d time.Duration, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	until := time.Now().Add(d)
+	if p.active.Load() {
+		p.timer.Stop()
+		if p.until.After(until) {
+			until = p.until
+		}
+		all = all || p.all
+	} else {
+		p.done = make(chan struct{})
+	}
+	p.until, p.all = until, all
+	p.generation++
+	generation := p.generation
+	p.timer = time.AfterFunc(time.Until(until), func() { p.expire(generation) })
+	p.active.Store(true)
+}
+
+// expire ends the pause when its timer fires, unless a later pause replaced it
+func (p *pauseState) expire(generation uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.generation == generation {
+		p.unpauseLocked()
+	}
+}
+
+// unpause ends the pause, if any, and wakes the waiting clients
+func (p *pauseState) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unpauseLocked()
+}
+
+func (p *pauseState) unpauseLocked() {
+	if !p.active.Load() {
+		return
+	}
+	p.timer.Stop()
+	p.active.Store(false)
+	close(p.done)
+}
+
+// wait blocks while the pause applies to a command that writes or not
+func (p *pauseState) wait(write bool) {
+	for p.active.Load() {
+		p.mu.Lock()
+		if !p.active.Load() || (!p.all && !write) {
+			p.mu.Unlock()
+			return
+		}
+		done := p.done
+		p.mu.Unlock()
+		<-done
+	}
+}
+
+var clientHelp = []string{
+	"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CACHING (YES|NO)",
+	"    Enable/disable tracking of the keys for next command in OPTIN/OPTOUT modes.",
+	"GETREDIR",
+	"    Return the client ID we are redirecting to when tracking is enabled.",
+	"GETNAME",
+	"    Return the name of the current connection.",
+	"ID",
+	"    Return the ID of the current connection.",
+	"INFO",
+	"    Return information about the current client connection.",
+	"KILL <ip:port>",
+	"    Kill connection made from <ip:port>.",
+	"KILL <option> <value> [<option> <value> [...]]",
+	"    Kill connections. Options are:",
+	"    * ADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made from the specified address",
+	"    * LADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made to specified local address",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Kill connections by type.",
+	"    * USER <username>",
+	"      Kill connections authenticated by <username>.",
+	"    * SKIPME (YES|NO)",
+	"      Skip killing current connection (default: yes).",
+	"    * ID <client-id>",
+	"      Kill connections by client id.",
+	"    * MAXAGE <maxage>",
+	"      Kill connections older than the specified age.",
+	"LIST [options ...]",
+	"    Return information about client connections. Options:",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Return clients of specified type.",
+	"    * ID <client-id> [<client-id> ...]",
+	"      Return clients of specified IDs only.",
+	"NO-EVICT (ON|OFF)",
+	"    Protect current client connection from eviction.",
+	"PAUSE <timeout> [WRITE|ALL]",
+	"    Suspend all, or just write, clients for <timeout> milliseconds.",
+	"UNPAUSE",
+	"    Stop the current client pause, resuming traffic.",
+	"SETNAME <name>",
+	"    Assign the name <name> to the current connection.",
+	"TRACKING (ON|OFF) [REDIRECT <id>] [BCAST] [PREFIX <prefix> [...]]",
+	"         [OPTIN] [OPTOUT] [NOLOOP]",
+	"    Control server assisted client side caching.",
+	"TRACKINGINFO",
+	"    Report tracking status for the current connection.",
+	"HELP",
+	"    Print this help.",
+}
+
+// clientTypes are the names accepted by the TYPE filters. There is no
+// replication, so no connection is a master or replica.
+var clientTypes = []string{"normal", "master", "replica", "slave", "pubsub"}
+
+// clientType returns "pubsub" for connections with subscriptions, like Redis,
+// and "normal" for the others
+func (c *client) clientType() string {
+	if c.subs.Load() > 0 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// cmdClient handles the CLIENT subcommands
+func (c *client) cmdClient(cmd []string) string {
+	s := c.server
+	switch sub := strings.ToLower(cmd[1]); {
+	case sub == "help" && len(cmd) == 2:
+		return respArray(clientHelp)
+	case sub == "id" && len(cmd) == 2:
+		return respInt(c.id)
+	case sub == "info" && len(cmd) == 2:
+		return respBulk(c.describe(time.Now()) + "\n")
+	case sub == "getname" && len(cmd) == 2:
+		if name := c.getName(); name != "" {
+			return respBulk(name)
+		}
+		return respNil
+	case sub == "setname" && len(cmd) == 3:
+		if !validClientName(cmd[2]) {
+			return respError("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		c.mu.Lock()
+		c.name = cmd[2]
+		c.mu.Unlock()
+		return respOK
+	case sub == "no-evict" && len(cmd) == 3:
+		switch strings.ToLower(cmd[2]) {
+		case "on":
+			c.noEvict.Store(true)
+		case "off":
+			c.noEvict.Store(false)
+		default:
+			return respError("ERR syntax error")
+		}
+		return respOK
+	case sub == "list":
+		return c.clientList(cmd[2:])
+	case sub == "kill" && len(cmd) >= 3:
+		return c.clientKill(cmd[2:])
+	case sub == "pause" && (len(cmd) == 3 || len(cmd) == 4):
+		ms, ok := parseInt(cmd[2])
+		if !ok || ms < 0 {
+			return respError("ERR timeout is not an integer or out of range")
+		}
+		all := true
+		if len(cmd) == 4 {
+			switch strings.ToLower(cmd[3]) {
+			case "all":
+			case "write":
+				all = false
+			default:
+				return respError("ERR CLIENT PAUSE mode must be WRITE or ALL")
+			}
+		}
+		s.pause.pause(time.Duration(ms)*time.Millisecond, all)
+		return respOK
+	case sub == "unpause" && len(cmd) == 2:
+		s.pause.unpause()
+		return respOK
+	case sub == "tracking" && len(cmd) >= 3:
+		return c.clientTrackingOn(cmd[2:])
+	case sub == "caching" && len(cmd) == 3:
+		return c.clientCaching(cmd[2])
+	case sub == "getredir" && len(cmd) == 2:
+		return c.clientGetRedir()
+	case sub == "trackinginfo" && len(cmd) == 2:
+		return c.clientTrackingInfo()
+	}
+	return respError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CLIENT HELP.", cmd[1]))
+}
+
+// clientList handles CLIENT LIST [TYPE type] [ID id [id ...]]
+func (c *client) clientList(args []string) string {
+	var ids map[int64]bool
+	typ := ""
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "type":
+			if i+1 == len(args) {
+				return respError("ERR syntax error")
+			}
+			i++
+			typ = strings.ToLower(args[i])
+			if !slices.Contains(clientTypes, typ) {
+				return respError(fmt.Sprintf("ERR Unknown client type '%s'", args[i]))
+			}
+		case "id":
+			if i+1 == len(args) {
+				return respError("ERR syntax error")
+			}
+			ids = map[int64]bool{}
+			for i+1 < len(args) {
+				id, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil {
+					break
+				}
+				if id <= 0 {
+					return respError("ERR Invalid client ID")
+				}
+				ids[id] = true
+				i++
+			}
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	for _, other := range c.server.clients.list() {
+		if (typ != "" && typ != other.clientType()) || (ids != nil && !ids[other.id]) {
+			continue
+		}
+		b.WriteString(other.describe(now))
+		b.WriteByte('\n')
+	}
+	return respBulk(b.String())
+}
+
+// clientKill handles both CLIENT KILL addr and the CLIENT KILL filter form
+func (c *client) clientKill(args []string) string {
+	if len(args) == 1 {
+		for _, other := range c.server.clients.list() {
+			if other.addr() == args[0] {
+				c.kill(other)
+				return respOK
+			}
+		}
+		return respError("ERR No such client")
+	}
+	if len(args)%2 != 0 {
+		return respError("ERR syntax error")
+	}
+
+	var matches []func(*client) bool
+	skipMe := true
+	now := time.Now()
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToLower(args[i]) {
+		case "id":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return respError("ERR client-id should be greater than 0")
+			}
+			matches = append(matches, func(o *client) bool { return o.id == id })
+		case "addr":
+			matches = append(matches, func(o *client) bool { return o.addr() == value })
+		case "laddr":
+			matches = append(matches, func(o *client) bool { return o.conn != nil && o.conn.LocalAddr().String() == value })
+		case "type":
+			typ := strings.ToLower(value)
+			if !slices.Contains(clientTypes, typ) {
+				return respError(fmt.Sprintf("ERR Unknown client type '%s'", value))
+			}
+			matches = append(matches, func(o *client) bool { return o.clientType() == typ })
+		case "user":
+			// Every connection runs as the default user until ACLs exist
+			if value != "default" {
+				return respError(fmt.Sprintf("ERR No such user '%s'", value))
+			}
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return respError("ERR syntax error")
+			}
+		case "maxage":
+			maxAge, ok := parseInt(value)
+			if !ok || maxAge < 0 {
+				return respError(ErrNotInteger.Error())
+			}
+			matches = append(matches, func(o *client) bool { return now.Sub(o.created) >= time.Duration(maxAge)*time.Second })
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+
+	killed := int64(0)
+next:
+	for _, other := range c.server.clients.list() {
+		if skipMe && other == c {
+			continue
+		}
+		for _, match := range matches {
+			if !match(other) {
+				continue next
+			}
+		}
+		c.kill(other)
+		killed++
+	}
+	return respInt(killed)
+}
+
+// kill closes the connection of other. A client killing itself still gets
+// the reply before its connection closes.
+func (c *client) kill(other *client) {
+	if other == c {
+		c.closing = true
+		return
+	}
+	other.conn.Close()
+}
+
+// cmdHello handles HELLO [protover [AUTH username password] [SETNAME name]],
+// which switches between RESP2 and RESP3 and describes the server
+func (c *client) cmdHello(cmd []string) string {
+	protocol := c.protocol()
+	if len(cmd) > 1 {
+		version, ok := parseInt(cmd[1])
+		if !ok {
+			return respError("ERR Protocol version is not an integer or out of range")
+		}
+		if version != 2 && version != 3 {
+			return respError("NOPROTO unsupported protocol version")
+		}
+		protocol = int(version)
+	}
+	name, setName := "", false
+	for i := 2; i < len(cmd); i++ {
+		switch {
+		case strings.EqualFold(cmd[i], "auth") && i+2 < len(cmd):
+			// Every connection runs as the default user, which takes any
+			// password until passwords can be configured
+			if cmd[i+1] != "default" {
+				return respError("WRONGPASS invalid username-password pair or user is disabled.")
+			}
+			i += 2
+		case strings.EqualFold(cmd[i], "setname") && i+1 < len(cmd):
+			if !validClientName(cmd[i+1]) {
+				return respError("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			name, setName = cmd[i+1], true
+			i++
+		default:
+			return respError(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", cmd[i]))
+		}
+	}
+
+	c.resp.Store(int32(protocol))
+	if setName {
+		c.mu.Lock()
+		c.name = name
+		c.mu.Unlock()
+	}
+	return respMap(protocol == 3, []string{
+		respBulk("server"), respBulk("redis"),
+		respBulk("version"), respBulk(redisVersion),
+		respBulk("proto"), respInt(int64(protocol)),
+		respBulk("id"), respInt(c.id),
+		respBulk("mode"), respBulk("standalone"),
+		respBulk("role"), respBulk("master"),
+		respBulk("modules"), respArray(nil),
+	})
+}
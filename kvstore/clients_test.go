@@ -0,0 +1,160 @@
+package kvstore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeClient connects to server over an in-memory pipe. send writes one
+// command and reads one reply, read reads the next reply or push. Simple
+// replies keep their type byte, bulk strings are returned as is, aggregates
+// as their type byte and their elements in brackets.
+func pipeClient(t *testing.T, server *RedisServer) (send func(args ...string) string, read func() string) {
+	local, remote := net.Pipe()
+	t.Cleanup(func() { local.Close() })
+	go server.handleConnection(remote)
+	r := bufio.NewReader(local)
+	read = func() string {
+		local.SetDeadline(time.Now().Add(5 * time.Second))
+		reply, err := readReply(r)
+		if err != nil {
+			return err.Error()
+		}
+		return reply
+	}
+	send = func(args ...string) string {
+		local.SetDeadline(time.Now().Add(5 * time.Second))
+		fmt.Fprintf(local, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(local, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+		return read()
+	}
+	return send, read
+}
+
+func readReply(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return line, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	case '*', '>', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		items := make([]string, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return "", err
+			}
+		}
+		return line[:1] + "[" + strings.Join(items, " ") + "]", nil
+	}
+	return line, nil
+}
+
+func TestClientListAndKill(t *testing.T) {
+	server := NewRedisServer(nil)
+	a, _ := pipeClient(t, server)
+	b, _ := pipeClient(t, server)
+
+	if got := a("CLIENT", "SETNAME", "alpha"); got != "+OK" {
+		t.Fatalf("SETNAME replied %q", got)
+	}
+	if got := a("CLIENT", "SETNAME", "has space"); !strings.HasPrefix(got, "-ERR") {
+		t.Fatalf("SETNAME with a space replied %q", got)
+	}
+	id := strings.TrimPrefix(a("CLIENT", "ID"), ":")
+	a("SELECT", "0")
+
+	list := b("CLIENT", "LIST")
+	if lines := strings.Split(strings.TrimSpace(list), "\n"); len(lines) != 2 {
+		t.Fatalf("CLIENT LIST returned %q", list)
+	}
+	if !strings.Contains(list, "id="+id+" ") || !strings.Contains(list, "name=alpha ") || !strings.Contains(list, "cmd=select ") {
+		t.Fatalf("CLIENT LIST returned %q", list)
+	}
+	if got := b("CLIENT", "LIST", "ID", id); strings.Count(got, "\n") != 1 || !strings.Contains(got, "name=alpha") {
+		t.Fatalf("CLIENT LIST ID returned %q", got)
+	}
+
+	if got := b("CLIENT", "KILL", "ID", id); got != ":1" {
+		t.Fatalf("CLIENT KILL ID replied %q", got)
+	}
+	if got := a("PING"); got != io.EOF.Error() && !strings.Contains(got, "closed") {
+		t.Fatalf("killed client got %q", got)
+	}
+	// The killed connection leaves the registry once its goroutine notices
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(b("CLIENT", "LIST"), "\n") != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("killed client is still listed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := b("CLIENT", "KILL", "SKIPME", "yes"); got != ":0" {
+		t.Fatalf("CLIENT KILL SKIPME yes replied %q", got)
+	}
+}
+
+func TestClientPauseWrite(t *testing.T) {
+	server := NewRedisServer(nil)
+	c := &client{server: server}
+	server.handleCommand(c, []string{"CLIENT", "PAUSE", "10000", "WRITE"})
+
+	done := make(chan string)
+	go func() { done <- server.handleCommand(&client{server: server}, []string{"SET", "k", "v"}) }()
+	if got := server.handleCommand(c, []string{"GET", "k"}); got != respNil {
+		t.Fatalf("GET during a write pause replied %q", got)
+	}
+	select {
+	case <-done:
+		t.Fatal("SET ran during a write pause")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	server.handleCommand(c, []string{"CLIENT", "UNPAUSE"})
+	select {
+	case got := <-done:
+		if got != respOK {
+			t.Fatalf("SET replied %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SET still blocked after CLIENT UNPAUSE")
+	}
+}
+
+func TestClientPauseStaleTimer(t *testing.T) {
+	var p pauseState
+	p.pause(time.Hour, false)
+	stale := p.generation
+	p.pause(time.Hour, false)
+	// The first timer fired just as the second pause stopped it
+	p.expire(stale)
+	if !p.active.Load() {
+		t.Fatal("the timer of an extended pause ended the pause")
+	}
+	p.expire(p.generation)
+	if p.active.Load() {
+		t.Fatal("the timer of the current pause did not end it")
+	}
+}
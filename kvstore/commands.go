@@ -0,0 +1,773 @@
+package kvstore
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// flagWrite marks commands that may modify the keyspace
+	flagWrite = 1 << iota
+	// flagReadOnly marks commands that only read the keyspace
+	flagReadOnly
+	// flagSkipMonitor keeps a command out of the MONITOR feed
+	flagSkipMonitor
+	// flagNoPause lets a command run during CLIENT PAUSE, so a paused
+	// server can still be unpaused
+	flagNoPause
+	// flagPubSub marks the commands a RESP2 client may send while subscribed
+	flagPubSub
+	// flagShutdown marks SHUTDOWN, which runs while a shutdown holds the
+	// other commands back and is not waited for, so ABORT gets through
+	flagShutdown
+)
+
+// command describes one entry of the command table
+type command struct {
+	name string
+	// arity follows the Redis convention: N means exactly N arguments
+	// including the command name, -N means at least N
+	arity   int
+	flags   int
+	handler func(c *client, cmd []string) string
+}
+
+// commands maps lower-case command names to their implementation
+var commands = map[string]*command{}
+
+func init() {
+	for _, c := range []*command{
+		{"ping", -1, flagPubSub, (*client).cmdPing},
+		{"quit", -1, flagNoPause | flagPubSub, (*client).cmdQuit},
+		{"hello", -1, 0, (*client).cmdHello},
+		{"info", -1, 0, (*client).cmdInfo},
+
+		{"get", 2, flagReadOnly, (*client).cmdGet},
+		{"set", -3, flagWrite, (*client).cmdSet},
+		{"setnx", 3, flagWrite, (*client).cmdSetNX},
+		{"setex", 4, flagWrite, (*client).cmdSetEx},
+		{"psetex", 4, flagWrite, (*client).cmdSetEx},
+		{"getset", 3, flagWrite, (*client).cmdGetSet},
+		{"getdel", 2, flagWrite, (*client).cmdGetDel},
+		{"getex", -2, flagWrite, (*client).cmdGetEx},
+		{"mget", -2, flagReadOnly, (*client).cmdMGet},
+		{"mset", -3, flagWrite, (*client).cmdMSet},
+		{"msetnx", -3, flagWrite, (*client).cmdMSetNX},
+		{"incr", 2, flagWrite, (*client).cmdIncrBy},
+		{"decr", 2, flagWrite, (*client).cmdIncrBy},
+		{"incrby", 3, flagWrite, (*client).cmdIncrBy},
+		{"decrby", 3, flagWrite, (*client).cmdIncrBy},
+		{"incrbyfloat", 3, flagWrite, (*client).cmdIncrByFloat},
+		{"append", 3, flagWrite, (*client).cmdAppend},
+		{"getrange", 4, flagReadOnly, (*client).cmdGetRange},
+		{"substr", 4, flagReadOnly, (*client).cmdGetRange},
+		{"setrange", 4, flagWrite, (*client).cmdSetRange},
+		{"strlen", 2, flagReadOnly, (*client).cmdStrLen},
+
+		{"del", -2, flagWrite, (*client).cmdDel},
+		{"unlink", -2, flagWrite, (*client).cmdDel},
+		{"exists", -2, flagReadOnly, (*client).cmdExists},
+		{"keys", 2, flagReadOnly, (*client).cmdKeys},
+		{"scan", -2, flagReadOnly, (*client).cmdScan},
+		{"expire", 3, flagWrite, (*client).cmdExpire},
+		{"pexpire", 3, flagWrite, (*client).cmdExpire},
+		{"ttl", 2, flagReadOnly, (*client).cmdTTL},
+		{"pttl", 2, flagReadOnly, (*client).cmdTTL},
+		{"persist", 2, flagWrite, (*client).cmdPersist},
+		{"type", 2, flagReadOnly, (*client).cmdType},
+		{"rename", 3, flagWrite, (*client).cmdRename},
+		{"renamenx", 3, flagWrite, (*client).cmdRename},
+		{"copy", -3, flagWrite, (*client).cmdCopy},
+		{"randomkey", 1, flagReadOnly, (*client).cmdRandomKey},
+		{"dbsize", 1, flagReadOnly, (*client).cmdDBSize},
+		{"flushdb", -1, flagWrite, (*client).cmdFlush},
+		{"flushall", -1, flagWrite, (*client).cmdFlush},
+		{"touch", -2, flagReadOnly, (*client).cmdTouch},
+		{"object", -2, flagReadOnly, (*client).cmdObject},
+		{"memory", -2, flagReadOnly, (*client).cmdMemory},
+		{"select", 2, 0, (*client).cmdSelect},
+		{"move", 3, flagWrite, (*client).cmdMove},
+		{"swapdb", 3, flagWrite, (*client).cmdSwapDB},
+		{"slowlog", -2, 0, (*client).cmdSlowlog},
+		{"latency", -2, 0, (*client).cmdLatency},
+		{"config", -2, 0, (*client).cmdConfig},
+		{"shutdown", -1, flagShutdown | flagNoPause, (*client).cmdShutdown},
+		{"monitor", 1, flagSkipMonitor, (*client).cmdMonitor},
+		{"client", -2, flagNoPause, (*client).cmdClient},
+		{"subscribe", -2, flagPubSub, (*client).cmdSubscribe},
+		{"psubscribe", -2, flagPubSub, (*client).cmdSubscribe},
+		{"unsubscribe", -1, flagPubSub, (*client).cmdUnsubscribe},
+		{"punsubscribe", -1, flagPubSub, (*client).cmdUnsubscribe},
+		{"publish", 3, 0, (*client).cmdPublish},
+		{"pubsub", -2, 0, (*client).cmdPubsub},
+	} {
+		commands[c.name] = c
+	}
+}
+
+// keySpec locates the keys among the arguments of a command, like the key
+// specs of Redis: first and last are argument indexes, a negative last
+// counting from the end, and step is the distance between two keys
+type keySpec struct {
+	first, last, step int
+}
+
+// commandKeySpecs has the key positions of the commands that take keys, for
+// client side caching
+var commandKeySpecs = map[string]keySpec{
+	"get": {1, 1, 1}, "set": {1, 1, 1}, "setnx": {1, 1, 1}, "setex": {1, 1, 1}, "psetex": {1, 1, 1},
+	"getset": {1, 1, 1}, "getdel": {1, 1, 1}, "getex": {1, 1, 1},
+	"mget": {1, -1, 1}, "mset": {1, -1, 2}, "msetnx": {1, -1, 2},
+	"incr": {1, 1, 1}, "decr": {1, 1, 1}, "incrby": {1, 1, 1}, "decrby": {1, 1, 1}, "incrbyfloat": {1, 1, 1},
+	"append": {1, 1, 1}, "getrange": {1, 1, 1}, "substr": {1, 1, 1}, "setrange": {1, 1, 1}, "strlen": {1, 1, 1},
+	"del": {1, -1, 1}, "unlink": {1, -1, 1}, "exists": {1, -1, 1}, "touch": {1, -1, 1},
+	"expire": {1, 1, 1}, "pexpire": {1, 1, 1}, "ttl": {1, 1, 1}, "pttl": {1, 1, 1}, "persist": {1, 1, 1},
+	"type": {1, 1, 1}, "rename": {1, 2, 1}, "renamenx": {1, 2, 1}, "copy": {1, 2, 1}, "move": {1, 1, 1},
+	"object": {2, 2, 1}, "memory": {2, 2, 1},
+}
+
+// commandKeys returns the keys cmd refers to
+func commandKeys(name string, cmd []string) []string {
+	spec, ok := commandKeySpecs[name]
+	if !ok || spec.first >= len(cmd) {
+		return nil
+	}
+	last := spec.last
+	if last < 0 {
+		last += len(cmd)
+	}
+	last = min(last, len(cmd)-1)
+	var keys []string
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, cmd[i])
+	}
+	return keys
+}
+
+func (c *client) cmdPing(cmd []string) string {
+	if c.protocol() == 2 && c.subscriptionCount() > 0 {
+		// A subscribed RESP2 connection can only receive arrays
+		message := ""
+		if len(cmd) == 2 {
+			message = cmd[1]
+		}
+		return respArray([]string{"pong", message})
+	}
+	switch len(cmd) {
+	case 1:
+		return "+PONG\r\n"
+	case 2:
+		return respBulk(cmd[1])
+	}
+	return respWrongArgs("ping")
+}
+
+// cmdQuit replies +OK and closes the connection
+func (c *client) cmdQuit(cmd []string) string {
+	c.closing = true
+	return respOK
+}
+
+// cmdInfo handles INFO [section [section ...]]
+func (c *client) cmdInfo(cmd []string) string {
+	return respBulk(c.server.info(cmd[1:]...))
+}
+
+func (c *client) cmdGet(cmd []string) string {
+	val, err := c.db.Get(cmd[1])
+	if err != nil {
+		return respNil
+	}
+	return respBulk(val)
+}
+
+// parseExpire turns the argument of EX, PX, EXAT or PXAT into a deadline. On
+// failure it returns the error reply instead.
+func parseExpire(unit, arg, name string) (time.Time, string) {
+	n, ok := parseInt(arg)
+	if !ok {
+		return time.Time{}, respError(ErrNotInteger.Error())
+	}
+	invalid := respError(fmt.Sprintf("ERR invalid expire time in '%s' command", name))
+	if n <= 0 {
+		return time.Time{}, invalid
+	}
+	switch unit {
+	case "ex", "exat":
+		if n > math.MaxInt64/1000 {
+			return time.Time{}, invalid
+		}
+		n *= 1000
+	}
+	if unit == "ex" || unit == "px" {
+		if n > math.MaxInt64/int64(time.Millisecond) {
+			return time.Time{}, invalid
+		}
+		return time.Now().Add(time.Duration(n) * time.Millisecond), ""
+	}
+	return time.UnixMilli(n), ""
+}
+
+func (c *client) cmdSet(cmd []string) string {
+	var opts SetOptions
+	get, hasExpire := false, false
+	for i := 3; i < len(cmd); i++ {
+		switch arg := strings.ToLower(cmd[i]); arg {
+		case "nx":
+			opts.NX = true
+		case "xx":
+			opts.XX = true
+		case "get":
+			get = true
+		case "keepttl":
+			opts.TTL.Keep = true
+		case "ex", "px", "exat", "pxat":
+			if hasExpire || i+1 >= len(cmd) {
+				return respError("ERR syntax error")
+			}
+			at, errReply := parseExpire(arg, cmd[i+1], "set")
+			if errReply != "" {
+				return errReply
+			}
+			opts.TTL.At = at
+			hasExpire = true
+			i++
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+	if (opts.NX && opts.XX) || (opts.TTL.Keep && hasExpire) {
+		return respError("ERR syntax error")
+	}
+
+	result, err := c.db.SetWithOptions(cmd[1], cmd[2], opts)
+	if err != nil {
+		return respErr(err)
+	}
+	if get {
+		if !result.Existed {
+			return respNil
+		}
+		return respBulk(result.Old)
+	}
+	if !result.Written {
+		return respNil
+	}
+	return respOK
+}
+
+func (c *client) cmdSetNX(cmd []string) string {
+	result, err := c.db.SetWithOptions(cmd[1], cmd[2], SetOptions{NX: true})
+	if err != nil {
+		return respErr(err)
+	}
+	return respBool(result.Written)
+}
+
+// cmdSetEx handles SETEX key seconds value and PSETEX key milliseconds value
+func (c *client) cmdSetEx(cmd []string) string {
+	name := strings.ToLower(cmd[0])
+	unit := "ex"
+	if name == "psetex" {
+		unit = "px"
+	}
+	at, errReply := parseExpire(unit, cmd[2], name)
+	if errReply != "" {
+		return errReply
+	}
+	if _, err := c.db.SetWithOptions(cmd[1], cmd[3], SetOptions{TTL: TTLOption{At: at}}); err != nil {
+		return respErr(err)
+	}
+	return respOK
+}
+
+func (c *client) cmdGetSet(cmd []string) string {
+	result, err := c.db.SetWithOptions(cmd[1], cmd[2], SetOptions{})
+	if err != nil {
+		return respErr(err)
+	}
+	if !result.Existed {
+		return respNil
+	}
+	return respBulk(result.Old)
+}
+
+func (c *client) cmdGetDel(cmd []string) string {
+	value, ok := c.db.GetDel(cmd[1])
+	if !ok {
+		return respNil
+	}
+	return respBulk(value)
+}
+
+// cmdGetEx handles GETEX key [EX seconds|PX ms|EXAT ts|PXAT ts-ms|PERSIST]
+func (c *client) cmdGetEx(cmd []string) string {
+	var ttl TTLOption
+	switch {
+	case len(cmd) == 2:
+	case len(cmd) == 3 && strings.ToLower(cmd[2]) == "persist":
+		ttl.Persist = true
+	case len(cmd) == 4:
+		unit := strings.ToLower(cmd[2])
+		if unit != "ex" && unit != "px" && unit != "exat" && unit != "pxat" {
+			return respError("ERR syntax error")
+		}
+		at, errReply := parseExpire(unit, cmd[3], "getex")
+		if errReply != "" {
+			return errReply
+		}
+		ttl.At = at
+	default:
+		return respError("ERR syntax error")
+	}
+
+	value, ok := c.db.GetEx(cmd[1], ttl)
+	if !ok {
+		return respNil
+	}
+	return respBulk(value)
+}
+
+func (c *client) cmdMGet(cmd []string) string {
+	return respNullableArray(c.db.MGet(cmd[1:]...))
+}
+
+// pairsFromArgs turns key value key value ... into a map, the last value of a repeated key winning
+func pairsFromArgs(args []string) map[string]string {
+	pairs := make(map[string]string, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		pairs[args[i]] = args[i+1]
+	}
+	return pairs
+}
+
+func (c *client) cmdMSet(cmd []string) string {
+	if len(cmd)%2 != 1 {
+		return respWrongArgs("mset")
+	}
+	if err := c.db.MSet(pairsFromArgs(cmd[1:])); err != nil {
+		return respErr(err)
+	}
+	return respOK
+}
+
+func (c *client) cmdMSetNX(cmd []string) string {
+	if len(cmd)%2 != 1 {
+		return respWrongArgs("msetnx")
+	}
+	ok, err := c.db.MSetNX(pairsFromArgs(cmd[1:]))
+	if err != nil {
+		return respErr(err)
+	}
+	return respBool(ok)
+}
+
+// cmdIncrBy handles INCR, DECR, INCRBY and DECRBY
+func (c *client) cmdIncrBy(cmd []string) string {
+	name := strings.ToLower(cmd[0])
+	delta := int64(1)
+	if len(cmd) == 3 {
+		var ok bool
+		if delta, ok = parseInt(cmd[2]); !ok {
+			return respError(ErrNotInteger.Error())
+		}
+	}
+	if name == "decr" || name == "decrby" {
+		if delta == math.MinInt64 {
+			return respError("ERR decrement would overflow")
+		}
+		delta = -delta
+	}
+
+	n, err := c.db.IncrBy(cmd[1], delta)
+	if err != nil {
+		return respErr(err)
+	}
+	return respInt(n)
+}
+
+func (c *client) cmdIncrByFloat(cmd []string) string {
+	delta, ok := parseFloat(cmd[2])
+	if !ok {
+		return respError(ErrNotFloat.Error())
+	}
+	f, err := c.db.IncrByFloat(cmd[1], delta)
+	if err != nil {
+		return respErr(err)
+	}
+	return respBulk(formatFloat(f))
+}
+
+func (c *client) cmdAppend(cmd []string) string {
+	n, err := c.db.Append(cmd[1], cmd[2])
+	if err != nil {
+		return respErr(err)
+	}
+	return respInt(int64(n))
+}
+
+func (c *client) cmdGetRange(cmd []string) string {
+	start, err1 := strconv.Atoi(cmd[2])
+	end, err2 := strconv.Atoi(cmd[3])
+	if err1 != nil || err2 != nil {
+		return respError(ErrNotInteger.Error())
+	}
+	return respBulk(c.db.GetRange(cmd[1], start, end))
+}
+
+func (c *client) cmdSetRange(cmd []string) string {
+	offset, err := strconv.Atoi(cmd[2])
+	if err != nil {
+		return respError(ErrNotInteger.Error())
+	}
+	if offset < 0 {
+		return respError("ERR offset is out of range")
+	}
+	// Compared this way round, a huge offset cannot overflow the sum
+	if offset > maxStringSize-len(cmd[3]) {
+		return respErr(ErrStringTooLong)
+	}
+	n, err := c.db.SetRange(cmd[1], offset, cmd[3])
+	if err != nil {
+		return respErr(err)
+	}
+	return respInt(int64(n))
+}
+
+func (c *client) cmdStrLen(cmd []string) string {
+	return respInt(int64(c.db.StrLen(cmd[1])))
+}
+
+// cmdDel handles DEL and UNLINK with any number of keys. With the map engine
+// removing a key only drops the store's reference to its value, which the
+// garbage collector then reclaims concurrently, so neither holds the lock for
+// O(size) teardown. The arena engine may compact the whole arena on a DEL;
+// UNLINK leaves that to a later write. Whole keyspaces are different, see
+// FLUSHALL ASYNC.
+func (c *client) cmdDel(cmd []string) string {
+	if strings.ToLower(cmd[0]) == "unlink" {
+		return respInt(int64(c.db.Unlink(cmd[1:]...)))
+	}
+	return respInt(int64(c.db.DelMulti(cmd[1:]...)))
+}
+
+// cmdExists counts how many of the given keys exist; repeated keys count repeatedly
+func (c *client) cmdExists(cmd []string) string {
+	n := 0
+	for _, key := range cmd[1:] {
+		if c.db.Exists(key) {
+			n++
+		}
+	}
+	return respInt(int64(n))
+}
+
+func (c *client) cmdKeys(cmd []string) string {
+	keys := c.db.Keys()
+	if cmd[1] != "*" {
+		matched := keys[:0]
+		for _, key := range keys {
+			if GlobMatch(cmd[1], key) {
+				matched = append(matched, key)
+			}
+		}
+		keys = matched
+	}
+	return respArray(keys)
+}
+
+func (c *client) cmdScan(cmd []string) string {
+	cursor, err := strconv.ParseUint(cmd[1], 10, 64)
+	if err != nil {
+		return "-ERR invalid cursor\r\n"
+	}
+
+	count := defaultScanCount
+	match := ""
+	keyType := ""
+
+	for i := 2; i < len(cmd); i += 2 {
+		if i+1 >= len(cmd) {
+			return "-ERR syntax error\r\n"
+		}
+		switch strings.ToLower(cmd[i]) {
+		case "count":
+			count, err = strconv.Atoi(cmd[i+1])
+			if err != nil {
+				return "-ERR invalid count\r\n"
+			}
+			if count < 1 {
+				return "-ERR syntax error\r\n"
+			}
+		case "match":
+			match = cmd[i+1]
+		case "type":
+			keyType = strings.ToLower(cmd[i+1])
+		default:
+			return "-ERR syntax error\r\n"
+		}
+	}
+
+	nextCursor, keys := c.db.Scan(cursor, match, count, keyType)
+	next := strconv.FormatUint(nextCursor, 10)
+	return fmt.Sprintf("*2\r\n$%d\r\n%s\r\n", len(next), next) + respArray(keys)
+}
+
+// cmdExpire handles EXPIRE and PEXPIRE
+func (c *client) cmdExpire(cmd []string) string {
+	n, err := strconv.ParseInt(cmd[2], 10, 64)
+	if err != nil {
+		return respError(ErrNotInteger.Error())
+	}
+	unit := time.Second
+	if strings.ToLower(cmd[0]) == "pexpire" {
+		unit = time.Millisecond
+	}
+	// A TTL has to fit a time.Duration, negative ones delete the key
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return respError(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(cmd[0])))
+	}
+	return respBool(c.db.Expire(cmd[1], time.Duration(n)*unit))
+}
+
+// cmdTTL handles TTL and PTTL
+func (c *client) cmdTTL(cmd []string) string {
+	ttl := c.db.TTL(cmd[1])
+	if ttl == TTLNoKey || ttl == TTLPersistent {
+		return respInt(int64(ttl))
+	}
+	if strings.ToLower(cmd[0]) == "pttl" {
+		return respInt(ttl.Milliseconds())
+	}
+	// Round to the nearest second like Redis does
+	return respInt((ttl.Milliseconds() + 500) / 1000)
+}
+
+func (c *client) cmdPersist(cmd []string) string {
+	return respBool(c.db.Persist(cmd[1]))
+}
+
+func (c *client) cmdType(cmd []string) string {
+	return respSimple(c.db.Type(cmd[1]))
+}
+
+// cmdRename handles RENAME and RENAMENX
+func (c *client) cmdRename(cmd []string) string {
+	if strings.ToLower(cmd[0]) == "renamenx" {
+		ok, err := c.db.RenameNX(cmd[1], cmd[2])
+		if err != nil {
+			return respErr(err)
+		}
+		return respBool(ok)
+	}
+	if err := c.db.Rename(cmd[1], cmd[2]); err != nil {
+		return respErr(err)
+	}
+	return respOK
+}
+
+// cmdCopy handles COPY source destination [DB destination-db] [REPLACE]
+func (c *client) cmdCopy(cmd []string) string {
+	replace := false
+	dst := c.db
+	for i := 3; i < len(cmd); i++ {
+		switch strings.ToLower(cmd[i]) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= len(cmd) {
+				return respError("ERR syntax error")
+			}
+			index, errReply := c.server.parseDB(cmd[i+1])
+			if errReply != "" {
+				return errReply
+			}
+			dst = c.server.databases()[index]
+			i++
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+	ok, err := CopyKey(c.db, dst, cmd[1], cmd[2], replace)
+	if err != nil {
+		return respErr(err)
+	}
+	return respBool(ok)
+}
+
+func (c *client) cmdRandomKey(cmd []string) string {
+	key, ok := c.db.RandomKey()
+	if !ok {
+		return respNil
+	}
+	return respBulk(key)
+}
+
+func (c *client) cmdDBSize(cmd []string) string {
+	return respInt(int64(c.db.DBSize()))
+}
+
+// cmdFlush handles FLUSHDB and FLUSHALL [ASYNC|SYNC]
+func (c *client) cmdFlush(cmd []string) string {
+	async := false
+	switch {
+	case len(cmd) == 1:
+	case len(cmd) == 2 && strings.ToLower(cmd[1]) == "async":
+		async = true
+	case len(cmd) == 2 && strings.ToLower(cmd[1]) == "sync":
+	default:
+		return respError("ERR syntax error")
+	}
+	if strings.ToLower(cmd[0]) == "flushall" {
+		for _, db := range c.server.databases() {
+			db.Flush(async)
+		}
+	} else {
+		c.db.Flush(async)
+	}
+	return respOK
+}
+
+func (c *client) cmdTouch(cmd []string) string {
+	return respInt(int64(c.db.Touch(cmd[1:]...)))
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// cmdObject handles OBJECT ENCODING|FREQ|IDLETIME|REFCOUNT key and OBJECT HELP
+func (c *client) cmdObject(cmd []string) string {
+	sub := strings.ToLower(cmd[1])
+	if sub == "help" && len(cmd) == 2 {
+		return respArray(objectHelp)
+	}
+	if len(cmd) != 3 || (sub != "encoding" && sub != "freq" && sub != "idletime" && sub != "refcount") {
+		return respError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", cmd[1]))
+	}
+
+	info, ok := c.db.Object(cmd[2])
+	if !ok {
+		return respNil
+	}
+	switch sub {
+	case "encoding":
+		return respBulk(info.Encoding)
+	case "refcount":
+		return respOne
+	case "idletime":
+		if !info.Policy.tracksAccess() {
+			return respError("ERR An LRU or LFU maxmemory policy is not selected, idle time not tracked.")
+		}
+		return respInt(int64(info.IdleTime / time.Second))
+	default:
+		if !info.Policy.lfu() {
+			return respError("ERR An LFU maxmemory policy is not selected, access frequency not tracked. " +
+				"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+		}
+		return respInt(int64(info.Freq))
+	}
+}
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+// cmdMemory handles MEMORY USAGE key [SAMPLES count] and MEMORY HELP
+func (c *client) cmdMemory(cmd []string) string {
+	switch sub := strings.ToLower(cmd[1]); {
+	case sub == "help" && len(cmd) == 2:
+		return respArray(memoryHelp)
+	case sub == "usage" && len(cmd) >= 3:
+		// SAMPLES only matters for nested types; strings are always measured exactly
+		switch {
+		case len(cmd) == 3:
+		case len(cmd) == 5 && strings.ToLower(cmd[3]) == "samples":
+			if _, ok := parseInt(cmd[4]); !ok {
+				return respError(ErrNotInteger.Error())
+			}
+		default:
+			return respError("ERR syntax error")
+		}
+		size, ok := c.db.MemoryUsage(cmd[2])
+		if !ok {
+			return respNil
+		}
+		return respInt(size)
+	}
+	return respError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try MEMORY HELP.", cmd[1]))
+}
+
+// parseDB validates a database index argument. On failure it returns the error reply instead.
+func (s *RedisServer) parseDB(arg string) (int, string) {
+	index, ok := parseInt(arg)
+	if !ok {
+		return 0, respError(ErrNotInteger.Error())
+	}
+	if index < 0 || index >= int64(len(s.databases())) {
+		return 0, respError("ERR DB index is out of range")
+	}
+	return int(index), ""
+}
+
+func (c *client) cmdSelect(cmd []string) string {
+	index, errReply := c.server.parseDB(cmd[1])
+	if errReply != "" {
+		return errReply
+	}
+	c.mu.Lock()
+	c.dbIndex = index
+	c.mu.Unlock()
+	return respOK
+}
+
+func (c *client) cmdMove(cmd []string) string {
+	index, errReply := c.server.parseDB(cmd[2])
+	if errReply != "" {
+		return errReply
+	}
+	ok, err := MoveKey(c.db, c.server.databases()[index], cmd[1])
+	if err != nil {
+		return respErr(err)
+	}
+	return respBool(ok)
+}
+
+// cmdSwapDB swaps two databases for every client at once, so a cache can be
+// rebuilt in a spare database and put in place atomically
+func (c *client) cmdSwapDB(cmd []string) string {
+	first, ok1 := parseInt(cmd[1])
+	second, ok2 := parseInt(cmd[2])
+	if !ok1 {
+		return respError("ERR invalid first DB index")
+	}
+	if !ok2 {
+		return respError("ERR invalid second DB index")
+	}
+	if !c.server.swapDB(first, second) {
+		return respError("ERR DB index is out of range")
+	}
+	return respOK
+}
@@ -0,0 +1,729 @@
+package kvstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of a RedisServer. The parameters use the
+// names and units of redis.conf in configuration files, CONFIG GET and
+// CONFIG SET.
+type Config struct {
+	// Bind lists the addresses to listen on, all interfaces when empty.
+	// Like in Redis, "*" is every IPv4 and "::*" every IPv6 address, and a
+	// "-" prefix skips an address that is not available.
+	Bind []string
+	// Port is the TCP port the server listens on, 0 for none
+	Port int
+	// UnixSocket is the path of a Unix domain socket to listen on as well,
+	// with the permissions UnixSocketPerm unless that is 0
+	UnixSocket     string
+	UnixSocketPerm os.FileMode
+	// ProtectedMode refuses clients that do not connect over the loopback
+	// interface or a Unix socket while the server listens on all interfaces
+	ProtectedMode bool
+	// Databases is the number of databases clients can SELECT
+	Databases int
+	// Engine is the storage engine of the databases the server creates
+	Engine Engine
+	// MaxMemory limits the stored data of all databases together, 0 means unlimited
+	MaxMemory       int64
+	MaxMemoryPolicy EvictionPolicy
+	// SlowlogLogSlowerThan is the slow log threshold, negative disables it
+	SlowlogLogSlowerThan time.Duration
+	SlowlogMaxLen        int
+	// LatencyMonitorThreshold is the latency monitor threshold, 0 disables it
+	LatencyMonitorThreshold time.Duration
+	TrackingTableMaxKeys    int64
+	// ShutdownTimeout bounds the wait for commands in flight on shutdown
+	ShutdownTimeout time.Duration
+	// MaxClients bounds the number of connections
+	MaxClients int
+	// Timeout closes connections idle for that long, 0 disables it
+	Timeout time.Duration
+	// TCPKeepAlive is the TCP keepalive period, 0 disables keepalives
+	TCPKeepAlive            time.Duration
+	ClientOutputBufferLimit OutputBufferLimits
+	// MemcachedPort is the TCP port of the memcached protocol listener on
+	// the bind addresses, 0 for none
+	MemcachedPort int
+	// NotifyKeyspaceEvents holds the notify-keyspace-events classes
+	NotifyKeyspaceEvents string
+	// MetricsAddr is the address of the HTTP listener for /metrics, empty
+	// when disabled. The server itself does not serve it.
+	MetricsAddr string
+	// HTTPAddr is the address of the HTTP/JSON gateway, empty when
+	// disabled. Like MetricsAddr it is served by the caller.
+	HTTPAddr string
+	// WebSocketAllowedOrigins are the origins of the pages, other than the
+	// gateway's own, that may open a WebSocket or call the other gateway
+	// endpoints; "*" allows any
+	WebSocketAllowedOrigins []string
+
+	// File is the configuration file the Config was loaded from, which
+	// CONFIG REWRITE writes back to
+	File string
+}
+
+// DefaultConfig returns the configuration of a server without a configuration file
+func DefaultConfig() *Config {
+	return &Config{
+		Port:                    6379,
+		ProtectedMode:           true,
+		Databases:               16,
+		Engine:                  EngineMap,
+		MaxMemoryPolicy:         PolicyNoEviction,
+		SlowlogLogSlowerThan:    defaultSlowlogThreshold,
+		SlowlogMaxLen:           defaultSlowlogMaxLen,
+		TrackingTableMaxKeys:    defaultTrackingTableMaxKeys,
+		ShutdownTimeout:         10 * time.Second,
+		MaxClients:              10000,
+		TCPKeepAlive:            300 * time.Second,
+		ClientOutputBufferLimit: defaultOutputBufferLimits,
+	}
+}
+
+// configParam is one parameter of the configuration. get and set convert
+// between the Config field and its redis.conf value; apply makes a changed
+// value take effect on a running server and is nil for parameters CONFIG SET
+// cannot change. The value of a multi parameter is a space separated list,
+// given as several arguments in configuration files.
+type configParam struct {
+	name  string
+	multi bool
+	get   func(cfg *Config) string
+	set   func(cfg *Config, value string) error
+	apply func(s *RedisServer, cfg *Config)
+}
+
+// configParams lists the parameters in the order CONFIG REWRITE appends them
+var configParams = []*configParam{
+	{
+		name:  "bind",
+		multi: true,
+		get:   func(cfg *Config) string { return strings.Join(cfg.Bind, " ") },
+		set: func(cfg *Config, value string) error {
+			cfg.Bind = strings.Fields(value)
+			return nil
+		},
+	},
+	{
+		name: "port",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.Port) },
+		set: func(cfg *Config, value string) error {
+			return parseConfigInt(value, 0, 65535, &cfg.Port)
+		},
+	},
+	{
+		name: "unixsocket",
+		get:  func(cfg *Config) string { return cfg.UnixSocket },
+		set: func(cfg *Config, value string) error {
+			cfg.UnixSocket = value
+			return nil
+		},
+	},
+	{
+		name: "unixsocketperm",
+		get:  func(cfg *Config) string { return strconv.FormatUint(uint64(cfg.UnixSocketPerm), 8) },
+		set: func(cfg *Config, value string) error {
+			perm, err := strconv.ParseUint(value, 8, 32)
+			if err != nil || perm > 0o777 {
+				return errors.New("argument must be an octal permission mode up to 777")
+			}
+			cfg.UnixSocketPerm = os.FileMode(perm)
+			return nil
+		},
+	},
+	{
+		name: "protected-mode",
+		get:  func(cfg *Config) string { return formatConfigBool(cfg.ProtectedMode) },
+		set: func(cfg *Config, value string) error {
+			return parseConfigBool(value, &cfg.ProtectedMode)
+		},
+		// Read for every new connection
+		apply: func(*RedisServer, *Config) {},
+	},
+	{
+		name: "databases",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.Databases) },
+		set: func(cfg *Config, value string) error {
+			return parseConfigInt(value, 1, math.MaxInt32, &cfg.Databases)
+		},
+	},
+	{
+		name: "engine",
+		get: func(cfg *Config) string {
+			if cfg.Engine == EngineArena {
+				return "arena"
+			}
+			return "map"
+		},
+		set: func(cfg *Config, value string) error {
+			switch strings.ToLower(value) {
+			case "map":
+				cfg.Engine = EngineMap
+			case "arena":
+				cfg.Engine = EngineArena
+			default:
+				return fmt.Errorf("unknown engine %q (expected map or arena)", value)
+			}
+			return nil
+		},
+	},
+	{
+		name: "maxmemory",
+		get:  func(cfg *Config) string { return strconv.FormatInt(cfg.MaxMemory, 10) },
+		set: func(cfg *Config, value string) (err error) {
+			cfg.MaxMemory, err = ParseMemory(value)
+			return err
+		},
+		apply: (*RedisServer).applyMaxMemory,
+	},
+	{
+		name: "maxmemory-policy",
+		get:  func(cfg *Config) string { return string(cfg.MaxMemoryPolicy) },
+		set: func(cfg *Config, value string) (err error) {
+			cfg.MaxMemoryPolicy, err = ParseEvictionPolicy(value)
+			return err
+		},
+		apply: (*RedisServer).applyMaxMemory,
+	},
+	{
+		name: "slowlog-log-slower-than",
+		get: func(cfg *Config) string {
+			return strconv.FormatInt(cfg.SlowlogLogSlowerThan.Microseconds(), 10)
+		},
+		set: func(cfg *Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			cfg.SlowlogLogSlowerThan = time.Duration(n) * time.Microsecond
+			return nil
+		},
+		apply: func(s *RedisServer, cfg *Config) { s.SetSlowlog(cfg.SlowlogLogSlowerThan, cfg.SlowlogMaxLen) },
+	},
+	{
+		name: "slowlog-max-len",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.SlowlogMaxLen) },
+		set: func(cfg *Config, value string) error {
+			return parseConfigInt(value, 0, math.MaxInt32, &cfg.SlowlogMaxLen)
+		},
+		apply: func(s *RedisServer, cfg *Config) { s.SetSlowlog(cfg.SlowlogLogSlowerThan, cfg.SlowlogMaxLen) },
+	},
+	{
+		name: "latency-monitor-threshold",
+		get: func(cfg *Config) string {
+			return strconv.FormatInt(cfg.LatencyMonitorThreshold.Milliseconds(), 10)
+		},
+		set: func(cfg *Config, value string) error {
+			var ms int
+			if err := parseConfigInt(value, 0, math.MaxInt32, &ms); err != nil {
+				return err
+			}
+			cfg.LatencyMonitorThreshold = time.Duration(ms) * time.Millisecond
+			return nil
+		},
+		apply: func(s *RedisServer, cfg *Config) { s.SetLatencyMonitorThreshold(cfg.LatencyMonitorThreshold) },
+	},
+	{
+		name: "tracking-table-max-keys",
+		get:  func(cfg *Config) string { return strconv.FormatInt(cfg.TrackingTableMaxKeys, 10) },
+		set: func(cfg *Config, value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return errors.New("argument must be a non-negative integer")
+			}
+			cfg.TrackingTableMaxKeys = n
+			return nil
+		},
+		apply: func(s *RedisServer, cfg *Config) { s.SetTrackingTableMaxKeys(cfg.TrackingTableMaxKeys) },
+	},
+	{
+		name: "notify-keyspace-events",
+		get:  func(cfg *Config) string { return cfg.NotifyKeyspaceEvents },
+		set: func(cfg *Config, value string) error {
+			flags, err := parseKeyspaceEvents(value)
+			if err != nil {
+				return err
+			}
+			cfg.NotifyKeyspaceEvents = keyspaceEventsString(flags)
+			return nil
+		},
+		apply: func(s *RedisServer, cfg *Config) { s.SetNotifyKeyspaceEvents(cfg.NotifyKeyspaceEvents) },
+	},
+	{
+		name: "shutdown-timeout",
+		get: func(cfg *Config) string {
+			return strconv.FormatInt(int64(cfg.ShutdownTimeout/time.Second), 10)
+		},
+		set: func(cfg *Config, value string) error {
+			var seconds int
+			if err := parseConfigInt(value, 0, math.MaxInt32, &seconds); err != nil {
+				return err
+			}
+			cfg.ShutdownTimeout = time.Duration(seconds) * time.Second
+			return nil
+		},
+		// Read whenever a shutdown starts
+		apply: func(*RedisServer, *Config) {},
+	},
+	{
+		name: "maxclients",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.MaxClients) },
+		set: func(cfg *Config, value string) error {
+			return parseConfigInt(value, 1, math.MaxInt32, &cfg.MaxClients)
+		},
+		apply: (*RedisServer).applyConnectionLimits,
+	},
+	{
+		name: "timeout",
+		get:  func(cfg *Config) string { return strconv.FormatInt(int64(cfg.Timeout/time.Second), 10) },
+		set: func(cfg *Config, value string) error {
+			var seconds int
+			if err := parseConfigInt(value, 0, math.MaxInt32, &seconds); err != nil {
+				return err
+			}
+			cfg.Timeout = time.Duration(seconds) * time.Second
+			return nil
+		},
+		apply: (*RedisServer).applyConnectionLimits,
+	},
+	{
+		name: "tcp-keepalive",
+		get:  func(cfg *Config) string { return strconv.FormatInt(int64(cfg.TCPKeepAlive/time.Second), 10) },
+		set: func(cfg *Config, value string) error {
+			var seconds int
+			if err := parseConfigInt(value, 0, math.MaxInt32, &seconds); err != nil {
+				return err
+			}
+			cfg.TCPKeepAlive = time.Duration(seconds) * time.Second
+			return nil
+		},
+		apply: (*RedisServer).applyConnectionLimits,
+	},
+	{
+		name:  "client-output-buffer-limit",
+		multi: true,
+		get:   func(cfg *Config) string { return cfg.ClientOutputBufferLimit.String() },
+		set: func(cfg *Config, value string) error {
+			return parseOutputBufferLimits(value, &cfg.ClientOutputBufferLimit)
+		},
+		apply: (*RedisServer).applyConnectionLimits,
+	},
+	{
+		name: "memcached-port",
+		get:  func(cfg *Config) string { return strconv.Itoa(cfg.MemcachedPort) },
+		set: func(cfg *Config, value string) error {
+			return parseConfigInt(value, 0, 65535, &cfg.MemcachedPort)
+		},
+	},
+	{
+		name: "metrics-addr",
+		get:  func(cfg *Config) string { return cfg.MetricsAddr },
+		set: func(cfg *Config, value string) error {
+			cfg.MetricsAddr = value
+			return nil
+		},
+	},
+	{
+		name: "http-addr",
+		get:  func(cfg *Config) string { return cfg.HTTPAddr },
+		set: func(cfg *Config, value string) error {
+			cfg.HTTPAddr = value
+			return nil
+		},
+	},
+	{
+		name:  "websocket-allowed-origins",
+		multi: true,
+		get:   func(cfg *Config) string { return strings.Join(cfg.WebSocketAllowedOrigins, " ") },
+		set: func(cfg *Config, value string) error {
+			cfg.WebSocketAllowedOrigins = strings.Fields(value)
+			return nil
+		},
+		// Read for every WebSocket handshake
+		apply: func(*RedisServer, *Config) {},
+	},
+}
+
+func parseConfigInt(value string, lo, hi int, dst *int) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return errors.New("argument couldn't be parsed into an integer")
+	}
+	if n < lo || n > hi {
+		return fmt.Errorf("argument must be between %d and %d inclusive", lo, hi)
+	}
+	*dst = n
+	return nil
+}
+
+func parseConfigBool(value string, dst *bool) error {
+	switch strings.ToLower(value) {
+	case "yes":
+		*dst = true
+	case "no":
+		*dst = false
+	default:
+		return errors.New("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+func formatConfigBool(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func lookupConfigParam(name string) *configParam {
+	name = strings.ToLower(name)
+	for _, p := range configParams {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Get returns the value of the parameter name as redis.conf spells it
+func (cfg *Config) Get(name string) (string, bool) {
+	p := lookupConfigParam(name)
+	if p == nil {
+		return "", false
+	}
+	return p.get(cfg), true
+}
+
+// Set changes the parameter name from its redis.conf value, e.g.
+// cfg.Set("maxmemory", "100mb")
+func (cfg *Config) Set(name, value string) error {
+	p := lookupConfigParam(name)
+	if p == nil {
+		return fmt.Errorf("unknown parameter %q", name)
+	}
+	if err := p.set(cfg, value); err != nil {
+		return fmt.Errorf("%s: %w", p.name, err)
+	}
+	return nil
+}
+
+// LoadConfig reads a redis.conf style file: one parameter and its value per
+// line, with # comments and optionally quoted values. Parameters missing
+// from the file keep their DefaultConfig value.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cfg := DefaultConfig()
+	if err := cfg.load(f); err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	if cfg.File, err = filepath.Abs(path); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		args, err := splitConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%d: %v", n, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+		p := lookupConfigParam(args[0])
+		if p == nil || len(args) < 2 || (len(args) > 2 && !p.multi) {
+			return fmt.Errorf("%d: Bad directive or wrong number of arguments", n)
+		}
+		if err := cfg.Set(args[0], strings.Join(args[1:], " ")); err != nil {
+			return fmt.Errorf("%d: %v", n, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// splitConfigLine splits a configuration line into its arguments like
+// sdssplitargs in Redis: blanks separate them, "double quotes" support
+// backslash escapes and 'single quotes' do not. Comment and empty lines have
+// no arguments.
+func splitConfigLine(line string) ([]string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil, nil
+	}
+	var args []string
+	for i := 0; i < len(line); {
+		switch line[i] {
+		case ' ', '\t':
+			i++
+			continue
+		}
+		var arg strings.Builder
+		switch quote := line[i]; quote {
+		case '"', '\'':
+			i++
+			for {
+				if i >= len(line) {
+					return nil, errors.New("unbalanced quotes in configuration line")
+				}
+				ch := line[i]
+				i++
+				if ch == quote {
+					break
+				}
+				if ch == '\\' && quote == '"' && i < len(line) {
+					ch = line[i]
+					i++
+					switch ch {
+					case 'n':
+						ch = '\n'
+					case 'r':
+						ch = '\r'
+					case 't':
+						ch = '\t'
+					case 'x':
+						if i+2 <= len(line) {
+							if b, err := strconv.ParseUint(line[i:i+2], 16, 8); err == nil {
+								ch = byte(b)
+								i += 2
+							}
+						}
+					}
+				}
+				arg.WriteByte(ch)
+			}
+			if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				return nil, errors.New("closing quote must be followed by a space")
+			}
+		default:
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				arg.WriteByte(line[i])
+				i++
+			}
+		}
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// quoteConfigValue quotes value for a configuration file when it is empty or
+// would not read back as a single argument
+func quoteConfigValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"'\\\r\n") {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// config returns a copy of the current configuration
+func (s *RedisServer) config() Config {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	return s.cfg
+}
+
+// updateConfig changes the current configuration under its lock
+func (s *RedisServer) updateConfig(update func(cfg *Config)) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	update(&s.cfg)
+}
+
+// applyMaxMemory sets the memory limit shared by the databases, and the
+// policy of every database that supports one. Limits the stores have of their
+// own stay in place.
+func (s *RedisServer) applyMaxMemory(cfg *Config) {
+	s.memory.limit.Store(cfg.MaxMemory)
+	for _, db := range s.databases() {
+		if store, ok := db.(interface{ SetEvictionPolicy(policy EvictionPolicy) }); ok {
+			store.SetEvictionPolicy(cfg.MaxMemoryPolicy)
+		}
+		shareMemory(db, s.memory)
+	}
+}
+
+// shareMemory counts the memory of db against b, when db supports it
+func shareMemory(db KVStoreInterface, b *memoryBudget) {
+	if store, ok := db.(interface{ shareMemory(b *memoryBudget) }); ok {
+		store.shareMemory(b)
+	}
+}
+
+var configHelp = []string{
+	"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET <pattern>",
+	"    Return parameters matching the glob-like <pattern> and their values.",
+	"SET <directive> <value>",
+	"    Set the configuration <directive> to <value>.",
+	"REWRITE",
+	"    Rewrite the configuration file.",
+	"HELP",
+	"    Print this help.",
+}
+
+// cmdConfig handles CONFIG GET pattern [pattern ...], SET name value
+// [name value ...], REWRITE and HELP
+func (c *client) cmdConfig(cmd []string) string {
+	switch sub := strings.ToLower(cmd[1]); {
+	case sub == "help" && len(cmd) == 2:
+		return respArray(configHelp)
+	case sub == "get" && len(cmd) >= 3:
+		return c.configGet(cmd[2:])
+	case sub == "set" && len(cmd) >= 4 && len(cmd)%2 == 0:
+		return c.configSet(cmd[2:])
+	case sub == "rewrite" && len(cmd) == 2:
+		if err := c.server.rewriteConfig(); err != nil {
+			return respError("ERR Rewriting config file: " + err.Error())
+		}
+		return respOK
+	}
+	return respError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CONFIG HELP.", cmd[1]))
+}
+
+func (c *client) configGet(patterns []string) string {
+	cfg := c.server.config()
+	var pairs []string
+	for _, p := range configParams {
+		for _, pattern := range patterns {
+			if GlobMatch(strings.ToLower(pattern), p.name) {
+				pairs = append(pairs, respBulk(p.name), respBulk(p.get(&cfg)))
+				break
+			}
+		}
+	}
+	return respMap(c.protocol() == 3, pairs)
+}
+
+// configSet changes every given parameter or, when one of them is unknown,
+// immutable or invalid, none of them
+func (c *client) configSet(args []string) string {
+	s := c.server
+	s.configSetMu.Lock()
+	defer s.configSetMu.Unlock()
+	cfg := s.config()
+	changed := map[*configParam]bool{}
+	for i := 0; i < len(args); i += 2 {
+		p := lookupConfigParam(args[i])
+		if p == nil {
+			return respError(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", args[i]))
+		}
+		if changed[p] {
+			return respError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", args[i]))
+		}
+		if p.apply == nil {
+			return respError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", args[i]))
+		}
+		if err := p.set(&cfg, args[i+1]); err != nil {
+			return respError(fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", args[i], err))
+		}
+		changed[p] = true
+	}
+	for _, p := range configParams {
+		if changed[p] {
+			p.apply(s, &cfg)
+		}
+	}
+	s.updateConfig(func(current *Config) { *current = cfg })
+	return respOK
+}
+
+// configRewriteMarker precedes the parameters CONFIG REWRITE appends
+const configRewriteMarker = "# Generated by CONFIG REWRITE"
+
+// rewriteConfig writes the current configuration back to its file like
+// CONFIG REWRITE in Redis: comments and unknown lines stay as they are, the
+// first line of each parameter gets its current value and later duplicates
+// are dropped, and parameters that differ from their default and are not in
+// the file yet are appended.
+func (s *RedisServer) rewriteConfig() error {
+	s.configSetMu.Lock()
+	defer s.configSetMu.Unlock()
+	cfg := s.config()
+	if cfg.File == "" {
+		return errors.New("the server is running without a config file")
+	}
+	data, err := os.ReadFile(cfg.File)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	line := func(p *configParam) string {
+		value := p.get(&cfg)
+		if !p.multi || value == "" {
+			return p.name + " " + quoteConfigValue(value)
+		}
+		fields := strings.Fields(value)
+		for i, field := range fields {
+			fields[i] = quoteConfigValue(field)
+		}
+		return p.name + " " + strings.Join(fields, " ")
+	}
+	var lines []string
+	seen := map[*configParam]bool{}
+	hasMarker := false
+	if len(data) > 0 {
+		for _, text := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if text == configRewriteMarker {
+				hasMarker = true
+			}
+			args, err := splitConfigLine(text)
+			var p *configParam
+			if err == nil && len(args) > 0 {
+				p = lookupConfigParam(args[0])
+			}
+			switch {
+			case p == nil:
+				lines = append(lines, text)
+			case !seen[p]:
+				seen[p] = true
+				lines = append(lines, line(p))
+			}
+		}
+	}
+	defaults := DefaultConfig()
+	for _, p := range configParams {
+		if seen[p] || p.get(&cfg) == p.get(defaults) {
+			continue
+		}
+		if !hasMarker {
+			lines = append(lines, configRewriteMarker)
+			hasMarker = true
+		}
+		lines = append(lines, line(p))
+	}
+
+	// Write a temporary file and rename it, so a crash never leaves a
+	// truncated configuration behind
+	tmp, err := os.CreateTemp(filepath.Dir(cfg.File), "."+filepath.Base(cfg.File)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(cfg.File); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	return os.Rename(tmp.Name(), cfg.File)
+}
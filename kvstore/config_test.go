@@ -0,0 +1,115 @@
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFileAndRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	original := `# Test server
+port 7000
+
+# Limit memory
+maxmemory 1mb
+slowlog-max-len 10
+slowlog-max-len 20
+notify-keyspace-events ""
+`
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 7000 || cfg.MaxMemory != 1<<20 || cfg.SlowlogMaxLen != 20 || cfg.Databases != 16 {
+		t.Fatalf("loaded %+v", cfg)
+	}
+
+	server := NewRedisServer(cfg)
+	c := &client{server: server}
+	if got := server.handleCommand(c, []string{"CONFIG", "GET", "slowlog-*"}); got != respArray([]string{"slowlog-log-slower-than", "10000", "slowlog-max-len", "20"}) {
+		t.Fatalf("CONFIG GET slowlog-* replied %q", got)
+	}
+	for _, tc := range []struct {
+		cmd  []string
+		want string
+	}{
+		{[]string{"CONFIG", "SET", "port", "7001"}, "ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config"},
+		{[]string{"CONFIG", "SET", "nope", "1"}, "ERR Unknown option or number of arguments for CONFIG SET - 'nope'"},
+		// A bad value leaves the other parameters unchanged as well
+		{[]string{"CONFIG", "SET", "maxmemory", "2mb", "maxmemory-policy", "sometimes"}, "ERR CONFIG SET failed (possibly related to argument 'maxmemory-policy') - unknown maxmemory policy \"sometimes\""},
+	} {
+		if got := server.handleCommand(c, tc.cmd); got != respError(tc.want) {
+			t.Fatalf("%v replied %q", tc.cmd, got)
+		}
+	}
+	if got := server.config().MaxMemory; got != 1<<20 {
+		t.Fatalf("maxmemory is %d after a failed CONFIG SET", got)
+	}
+
+	if got := server.handleCommand(c, []string{"CONFIG", "SET", "slowlog-max-len", "5", "latency-monitor-threshold", "100", "notify-keyspace-events", "xKE"}); got != respOK {
+		t.Fatalf("CONFIG SET replied %q", got)
+	}
+	if server.slowlog.maxLen != 5 || time.Duration(server.latency.threshold.Load()) != 100*time.Millisecond {
+		t.Fatal("CONFIG SET did not take effect")
+	}
+	if got := server.handleCommand(c, []string{"CONFIG", "REWRITE"}); got != respOK {
+		t.Fatalf("CONFIG REWRITE replied %q", got)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Test server
+port 7000
+
+# Limit memory
+maxmemory 1048576
+slowlog-max-len 5
+notify-keyspace-events xKE
+# Generated by CONFIG REWRITE
+latency-monitor-threshold 100
+`
+	if string(data) != want {
+		t.Fatalf("rewritten file:\n%s", data)
+	}
+	if _, err := LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigSetKeepsStoreLimits(t *testing.T) {
+	store := New(WithMaxMemory(1000))
+	server := NewRedisServer(nil, store)
+	c := &client{server: server}
+	if got := server.handleCommand(c, []string{"CONFIG", "SET", "maxmemory", "2mb", "maxmemory-policy", "allkeys-lru"}); got != respOK {
+		t.Fatalf("CONFIG SET replied %q", got)
+	}
+	if store.maxMemory != 1000 || store.policy != PolicyAllKeysLRU {
+		t.Fatalf("store has maxmemory %d and policy %s after CONFIG SET", store.maxMemory, store.policy)
+	}
+	if got := server.memory.limit.Load(); got != 2<<20 {
+		t.Fatalf("the shared maxmemory is %d", got)
+	}
+}
+
+func TestSplitConfigLine(t *testing.T) {
+	for line, want := range map[string][]string{
+		"  # comment":              nil,
+		`save ""`:                  {"save", ""},
+		`name "a \"b\"\x41" 'c d'`: {"name", `a "b"A`, "c d"},
+	} {
+		got, err := splitConfigLine(line)
+		if err != nil || strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+			t.Fatalf("splitConfigLine(%q) = %q, %v", line, got, err)
+		}
+	}
+	if _, err := splitConfigLine(`name "open`); err == nil {
+		t.Fatal("unbalanced quotes were accepted")
+	}
+}
@@ -0,0 +1,101 @@
+package kvstore
+
+import (
+	"errors"
+	"slices"
+)
+
+// ErrCrossDB is returned when moving or copying keys between stores that are
+// not implemented by this package
+var ErrCrossDB = errors.New("ERR the store does not support operations across databases")
+
+// keyOwner is implemented by KVStore and ShardedKVStore. It returns the
+// KVStore that holds key, so operations spanning two databases can lock
+// exactly the two stores involved.
+type keyOwner interface {
+	owner(key string) *KVStore
+}
+
+func (kv *KVStore) owner(string) *KVStore {
+	return kv
+}
+
+func (s *ShardedKVStore) owner(key string) *KVStore {
+	return s.shard(key)
+}
+
+// lockPair locks a and b, which may be the same store, in id order so that
+// concurrent cross-database operations cannot deadlock
+func lockPair(a, b *KVStore) (unlock func()) {
+	if a == b {
+		a.mu.Lock()
+		return a.unlock
+	}
+	if a.id > b.id {
+		a, b = b, a
+	}
+	a.mu.Lock()
+	b.mu.Lock()
+	return func() {
+		b.mu.Unlock()
+		a.mu.Unlock()
+		a.drainEvents()
+		b.drainEvents()
+	}
+}
+
+// owners returns the stores holding srcKey in src and dstKey in dst
+func owners(src, dst KVStoreInterface, srcKey, dstKey string) (from, to *KVStore, err error) {
+	srcOwner, ok1 := src.(keyOwner)
+	dstOwner, ok2 := dst.(keyOwner)
+	if !ok1 || !ok2 {
+		return nil, nil, ErrCrossDB
+	}
+	return srcOwner.owner(srcKey), dstOwner.owner(dstKey), nil
+}
+
+// MoveKey moves key and its TTL from the database src to dst atomically, like
+// MOVE. It returns false when key does not exist in src or already exists in dst.
+func MoveKey(src, dst KVStoreInterface, key string) (bool, error) {
+	from, to, err := owners(src, dst, key, key)
+	if err != nil {
+		return false, err
+	}
+	if from == to {
+		return false, ErrSameKey
+	}
+	unlock := lockPair(from, to)
+	defer unlock()
+	moved, err := renameLocked(from, to, key, key, true, EventMoveFrom, EventMoveTo)
+	if errors.Is(err, ErrNoSuchKey) {
+		return false, nil
+	}
+	return moved, err
+}
+
+// CopyKey copies srcKey in the database src to dstKey in dst, like COPY with
+// the DB option
+func CopyKey(src, dst KVStoreInterface, srcKey, dstKey string, replace bool) (bool, error) {
+	from, to, err := owners(src, dst, srcKey, dstKey)
+	if err != nil {
+		return false, err
+	}
+	unlock := lockPair(from, to)
+	defer unlock()
+	return copyLocked(from, to, srcKey, dstKey, replace)
+}
+
+// swapDB exchanges databases a and b. It reports false when an index is out of range.
+func (s *RedisServer) swapDB(a, b int64) bool {
+	for {
+		current := s.dbs.Load()
+		dbs := slices.Clone(*current)
+		if a < 0 || b < 0 || a >= int64(len(dbs)) || b >= int64(len(dbs)) {
+			return false
+		}
+		dbs[a], dbs[b] = dbs[b], dbs[a]
+		if s.dbs.CompareAndSwap(current, &dbs) {
+			return true
+		}
+	}
+}
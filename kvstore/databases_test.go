@@ -0,0 +1,110 @@
+package kvstore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMoveKeyAcrossStoreKinds(t *testing.T) {
+	src, dst := New(), NewSharded(4)
+	src.Set("k", "v")
+	src.Expire("k", time.Hour)
+
+	if ok, err := MoveKey(src, dst, "k"); !ok || err != nil {
+		t.Fatalf("MoveKey = %v, %v", ok, err)
+	}
+	if src.Exists("k") || dst.TTL("k") <= 0 {
+		t.Fatal("MoveKey did not move the key with its TTL")
+	}
+
+	src.Set("k", "other")
+	if ok, _ := MoveKey(src, dst, "k"); ok {
+		t.Fatal("MoveKey overwrote an existing key")
+	}
+	if ok, _ := MoveKey(src, dst, "missing"); ok {
+		t.Fatal("MoveKey moved a missing key")
+	}
+	if src.Stats().Keys != 1 || dst.Stats().Keys != 1 {
+		t.Fatalf("unexpected key counts %+v %+v", src.Stats(), dst.Stats())
+	}
+}
+
+// foreignStore hides the package's own store behind KVStoreInterface, like a
+// store implemented outside the package
+type foreignStore struct {
+	KVStoreInterface
+}
+
+func TestMoveWithForeignStore(t *testing.T) {
+	server := NewRedisServer(nil, foreignStore{New()}, New())
+	c := &client{server: server}
+	server.handleCommand(c, []string{"SET", "key", "v"})
+	for _, cmd := range [][]string{{"MOVE", "key", "1"}, {"COPY", "key", "key", "DB", "1"}} {
+		if got := server.handleCommand(c, cmd); got != respError(ErrCrossDB.Error()) {
+			t.Fatalf("%v = %q", cmd, got)
+		}
+	}
+}
+
+func TestSelectAndSwapDB(t *testing.T) {
+	server := NewRedisServer(nil, New(), New(), New())
+	a, b := &client{server: server}, &client{server: server}
+
+	for _, step := range []struct {
+		c    *client
+		cmd  []string
+		want string
+	}{
+		{a, []string{"SELECT", "1"}, respOK},
+		{a, []string{"SET", "key", "fresh"}, respOK},
+		{b, []string{"SET", "key", "stale"}, respOK},
+		{b, []string{"SWAPDB", "0", "1"}, respOK},
+		// Both clients keep their index and see the swapped data right away
+		{b, []string{"GET", "key"}, respBulk("fresh")},
+		{a, []string{"GET", "key"}, respBulk("stale")},
+		{a, []string{"SELECT", "3"}, respError("ERR DB index is out of range")},
+		{a, []string{"MOVE", "key", "2"}, respOne},
+		{b, []string{"MOVE", "key", "0"}, respError(ErrSameKey.Error())},
+	} {
+		if got := server.handleCommand(step.c, step.cmd); got != step.want {
+			t.Fatalf("%v = %q, want %q", step.cmd, got, step.want)
+		}
+	}
+}
+
+func TestMaxMemoryIsSharedByDatabases(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxMemory = 8 * 1024
+	server := NewRedisServer(cfg)
+	send, _ := pipeClient(t, server)
+	value := strings.Repeat("v", 1024)
+
+	// Half of the limit in db 0 leaves the other half to db 1
+	for i := 0; i < 4; i++ {
+		if got := send("SET", fmt.Sprintf("a%d", i), value); got != "+OK" {
+			t.Fatalf("SET in db 0 replied %q", got)
+		}
+	}
+	send("SELECT", "1")
+	var got string
+	for i := 0; i < 5 && got != "-"+ErrOOM.Error(); i++ {
+		got = send("SET", fmt.Sprintf("b%d", i), value)
+	}
+	if got != "-"+ErrOOM.Error() {
+		t.Fatal("db 1 got the whole maxmemory to itself")
+	}
+	total, _ := server.keyspaceStats()
+	if total.UsedMemory > cfg.MaxMemory || total.MaxMemory != cfg.MaxMemory {
+		t.Fatalf("used %d of maxmemory %d", total.UsedMemory, total.MaxMemory)
+	}
+
+	// Freeing db 0 makes room in db 1
+	send("SELECT", "0")
+	send("FLUSHDB")
+	send("SELECT", "1")
+	if got := send("SET", "after", value); got != "+OK" {
+		t.Fatalf("SET after FLUSHDB of the other database replied %q", got)
+	}
+}
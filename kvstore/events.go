@@ -0,0 +1,134 @@
+package kvstore
+
+import "sync"
+
+// Key events reported by a store, named like the keyspace notifications of
+// Redis
+const (
+	EventNew         = "new"
+	EventSet         = "set"
+	EventDel         = "del"
+	EventExpire      = "expire"
+	EventPersist     = "persist"
+	EventExpired     = "expired"
+	EventEvicted     = "evicted"
+	EventIncrBy      = "incrby"
+	EventIncrByFloat = "incrbyfloat"
+	EventAppend      = "append"
+	EventSetRange    = "setrange"
+	EventRenameFrom  = "rename_from"
+	EventRenameTo    = "rename_to"
+	EventCopyTo      = "copy_to"
+	EventMoveFrom    = "move_from"
+	EventMoveTo      = "move_to"
+)
+
+// KeyEvent is a change to one key of a store
+type KeyEvent struct {
+	Event string
+	Key   string
+}
+
+// keyEvents queues the events of a store and delivers them to its listeners.
+// Events are queued while the store lock is held, so the queue is in the
+// order the changes happened, and delivered after it is released, so that
+// listeners may use the store. Whichever goroutine finds the queue idle
+// delivers until it is empty; the others only queue.
+type keyEvents struct {
+	mu        sync.Mutex
+	listeners map[int]func(KeyEvent)
+	nextID    int
+	queue     []KeyEvent
+	draining  bool
+}
+
+// OnKeyEvent calls fn for every change to a key: writes, deletions, expired
+// and evicted keys, renames and so on, see the Event constants. Events arrive
+// in order, after the change, on the goroutine of some store operation, so fn
+// should be quick. The returned function stops the calls.
+func (kv *KVStore) OnKeyEvent(fn func(KeyEvent)) (cancel func()) {
+	e := &kv.events
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.listeners == nil {
+		e.listeners = make(map[int]func(KeyEvent))
+	}
+	id := e.nextID
+	e.nextID++
+	e.listeners[id] = fn
+	kv.listening.Store(true)
+	return func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.listeners, id)
+		if len(e.listeners) == 0 {
+			kv.listening.Store(false)
+			e.queue = nil
+		}
+	}
+}
+
+// OnKeyEvent calls fn for the key events of every shard. Events of one shard
+// arrive in order, events of different shards may interleave.
+func (s *ShardedKVStore) OnKeyEvent(fn func(KeyEvent)) (cancel func()) {
+	cancels := make([]func(), len(s.shards))
+	for i, shard := range s.shards {
+		cancels[i] = shard.OnKeyEvent(fn)
+	}
+	return func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+// notify queues an event. It costs an atomic load while nobody listens.
+// Callers hold kv.mu.
+func (kv *KVStore) notify(event, key string) {
+	if !kv.listening.Load() {
+		return
+	}
+	kv.events.mu.Lock()
+	kv.events.queue = append(kv.events.queue, KeyEvent{event, key})
+	kv.events.mu.Unlock()
+}
+
+// unlock releases the write lock and delivers the events queued under it
+func (kv *KVStore) unlock() {
+	kv.mu.Unlock()
+	kv.drainEvents()
+}
+
+// drainEvents delivers the queued events. Code holding the locks of several
+// stores releases all of them first, as listeners may use any of them.
+func (kv *KVStore) drainEvents() {
+	if kv.listening.Load() {
+		kv.events.drain()
+	}
+}
+
+func (e *keyEvents) drain() {
+	e.mu.Lock()
+	if e.draining {
+		e.mu.Unlock()
+		return
+	}
+	e.draining = true
+	for len(e.queue) > 0 {
+		batch := e.queue
+		e.queue = nil
+		listeners := make([]func(KeyEvent), 0, len(e.listeners))
+		for _, fn := range e.listeners {
+			listeners = append(listeners, fn)
+		}
+		e.mu.Unlock()
+		for _, ev := range batch {
+			for _, fn := range listeners {
+				fn(ev)
+			}
+		}
+		e.mu.Lock()
+	}
+	e.draining = false
+	e.mu.Unlock()
+}
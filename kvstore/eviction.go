@@ -0,0 +1,324 @@
+package kvstore
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// EvictionPolicy decides which keys are removed once maxmemory is reached
+type EvictionPolicy string
+
+const (
+	PolicyNoEviction    EvictionPolicy = "noeviction"
+	PolicyAllKeysLRU    EvictionPolicy = "allkeys-lru"
+	PolicyAllKeysLFU    EvictionPolicy = "allkeys-lfu"
+	PolicyAllKeysRandom EvictionPolicy = "allkeys-random"
+	PolicyVolatileLRU   EvictionPolicy = "volatile-lru"
+	PolicyVolatileLFU   EvictionPolicy = "volatile-lfu"
+	PolicyVolatileTTL   EvictionPolicy = "volatile-ttl"
+	PolicyVolatileRand  EvictionPolicy = "volatile-random"
+)
+
+const (
+	// defaultEvictionSamples matches the Redis maxmemory-samples default
+	defaultEvictionSamples = 5
+	// lfuInitVal is the counter new keys start with so they are not evicted right away
+	lfuInitVal = 5
+	// lfuLogFactor and lfuDecayTime match the Redis lfu-log-factor and lfu-decay-time defaults
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+)
+
+// ParseEvictionPolicy validates a maxmemory-policy name
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	policy := EvictionPolicy(strings.ToLower(name))
+	switch policy {
+	case PolicyNoEviction, PolicyAllKeysLRU, PolicyAllKeysLFU, PolicyAllKeysRandom,
+		PolicyVolatileLRU, PolicyVolatileLFU, PolicyVolatileTTL, PolicyVolatileRand:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown maxmemory policy %q", name)
+}
+
+// ParseMemory parses a redis.conf style memory size such as 100mb, 1gb or 4096
+func ParseMemory(size string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSuffix(s, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid memory size %q", size)
+	}
+	return n * multiplier, nil
+}
+
+func (p EvictionPolicy) tracksAccess() bool {
+	switch p {
+	case PolicyAllKeysLRU, PolicyAllKeysLFU, PolicyVolatileLRU, PolicyVolatileLFU:
+		return true
+	}
+	return false
+}
+
+func (p EvictionPolicy) lfu() bool {
+	return p == PolicyAllKeysLFU || p == PolicyVolatileLFU
+}
+
+func (p EvictionPolicy) volatile() bool {
+	return strings.HasPrefix(string(p), "volatile-")
+}
+
+// entryMeta is the per-key access information used by the LRU and LFU
+// policies. Its fields are atomic so reads can update it under the read lock.
+type entryMeta struct {
+	access atomic.Int64
+	freq   atomic.Uint32
+}
+
+func newEntryMeta() *entryMeta {
+	m := &entryMeta{}
+	m.access.Store(time.Now().UnixNano())
+	m.freq.Store(lfuInitVal)
+	return m
+}
+
+func (m *entryMeta) touch(lfu bool) {
+	now := time.Now().UnixNano()
+	if lfu {
+		counter := m.decayedFreq(now)
+		// Logarithmic increment: the higher the counter, the less likely it grows
+		if counter < 255 {
+			base := float64(max(int(counter)-lfuInitVal, 0))
+			if rand.Float64() < 1/(base*lfuLogFactor+1) {
+				counter++
+			}
+		}
+		m.freq.Store(counter)
+	}
+	m.access.Store(now)
+}
+
+// decayedFreq is the LFU counter minus one for every lfuDecayTime since the last access
+func (m *entryMeta) decayedFreq(now int64) uint32 {
+	counter := m.freq.Load()
+	periods := (now - m.access.Load()) / int64(lfuDecayTime)
+	if periods <= 0 {
+		return counter
+	}
+	if int64(counter) <= periods {
+		return 0
+	}
+	return counter - uint32(periods)
+}
+
+// touch records an access to key for the LRU and LFU policies. It only needs the read lock.
+func (kv *KVStore) touch(key string) {
+	if !kv.policy.tracksAccess() {
+		return
+	}
+	if m := kv.meta[key]; m != nil {
+		m.touch(kv.policy.lfu())
+	}
+}
+
+// memoryBudget is a maxmemory shared by several stores, such as the
+// databases of a server. Writers to different stores check it without a
+// common lock, so concurrent writes may overshoot it by the size of a write.
+type memoryBudget struct {
+	// limit is 0 when unlimited
+	limit atomic.Int64
+	used  atomic.Int64
+}
+
+// shareMemory makes the store count its memory against b as well as its own
+// limit. Writes evict keys of this store only, so a write to a store without
+// keys to evict fails with ErrOOM even when other stores have some.
+func (kv *KVStore) shareMemory(b *memoryBudget) {
+	kv.mu.Lock()
+	defer kv.unlock()
+	if kv.budget == b {
+		return
+	}
+	if kv.budget != nil {
+		kv.budget.used.Add(-kv.used)
+	}
+	kv.budget = b
+	b.used.Add(kv.used)
+}
+
+// addUsed changes the memory used by the store and its budget. Callers hold kv.mu.
+func (kv *KVStore) addUsed(delta int64) {
+	kv.used += delta
+	if kv.budget != nil {
+		kv.budget.used.Add(delta)
+	}
+}
+
+// overLimit reports whether delta more bytes exceed the limit of the store
+// or its budget. Callers hold kv.mu.
+func (kv *KVStore) overLimit(delta int64) bool {
+	if kv.maxMemory > 0 && kv.used+delta > kv.maxMemory {
+		return true
+	}
+	if kv.budget == nil {
+		return false
+	}
+	limit := kv.budget.limit.Load()
+	return limit > 0 && kv.budget.used.Load()+delta > limit
+}
+
+// reserve makes room for delta more bytes under maxmemory by evicting keys
+// that protected reports false for. Callers hold kv.mu.
+func (kv *KVStore) reserve(delta int64, protected func(key string) bool) error {
+	if delta <= 0 {
+		return nil
+	}
+	for kv.overLimit(delta) {
+		if kv.policy == PolicyNoEviction {
+			return ErrOOM
+		}
+		key, ok := kv.evictionCandidate(protected)
+		if !ok {
+			return ErrOOM
+		}
+		kv.delLocked(key)
+		kv.evicted.Add(1)
+		kv.notify(EventEvicted, key)
+	}
+	return nil
+}
+
+// evictionCandidate samples up to kv.samples keys, like Redis' approximated
+// LRU/LFU, and returns the best one to evict under the current policy
+func (kv *KVStore) evictionCandidate(protected func(key string) bool) (string, bool) {
+	var best string
+	var bestScore int64
+	found := false
+	sampled := 0
+	now := time.Now().UnixNano()
+
+	consider := func(key string) bool {
+		if protected(key) {
+			return true
+		}
+		score := kv.evictionScore(key, now)
+		if !found || score > bestScore {
+			best, bestScore, found = key, score, true
+		}
+		sampled++
+		return sampled < kv.samples
+	}
+
+	if kv.policy.volatile() {
+		for key := range kv.expires {
+			if !consider(key) {
+				break
+			}
+		}
+	} else {
+		kv.data.each(consider)
+	}
+	return best, found
+}
+
+// evictionScore ranks key for eviction, higher scores are evicted first
+func (kv *KVStore) evictionScore(key string, now int64) int64 {
+	switch kv.policy {
+	case PolicyAllKeysLRU, PolicyVolatileLRU:
+		m := kv.meta[key]
+		if m == nil {
+			return math.MaxInt64
+		}
+		return now - m.access.Load()
+	case PolicyAllKeysLFU, PolicyVolatileLFU:
+		m := kv.meta[key]
+		if m == nil {
+			return math.MaxInt64
+		}
+		return 255 - int64(m.decayedFreq(now))
+	case PolicyVolatileTTL:
+		return -kv.expires[key]
+	}
+	return 0
+}
+
+// Stats is a point in time view of a store's keyspace and memory counters
+type Stats struct {
+	Keys        int
+	Expires     int
+	UsedMemory  int64
+	MaxMemory   int64
+	Policy      EvictionPolicy
+	EvictedKeys int64
+	ExpiredKeys int64
+	// KeyspaceHits and KeyspaceMisses count lookups of existing and missing keys by reads
+	KeyspaceHits   int64
+	KeyspaceMisses int64
+	// LazyFreePending is the number of flushed keys still being torn down in
+	// the background, LazyFreedObjects how many have been so far
+	LazyFreePending  int64
+	LazyFreedObjects int64
+}
+
+func (kv *KVStore) Stats() Stats {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return Stats{
+		Keys:        kv.data.len(),
+		Expires:     len(kv.expires),
+		UsedMemory:  kv.used,
+		MaxMemory:   kv.maxMemory,
+		Policy:      kv.policy,
+		EvictedKeys: kv.evicted.Load(),
+		ExpiredKeys: kv.expired.Load(),
+
+		KeyspaceHits:   kv.hits.Load(),
+		KeyspaceMisses: kv.misses.Load(),
+
+		LazyFreePending:  kv.lazyfreePending.Load(),
+		LazyFreedObjects: kv.lazyfreed.Load(),
+	}
+}
+
+// SetMaxMemory changes the memory limit and eviction policy at runtime. A
+// limit of 0 disables eviction.
+func (kv *KVStore) SetMaxMemory(bytes int64, policy EvictionPolicy) {
+	kv.mu.Lock()
+	defer kv.unlock()
+	kv.setPolicyLocked(policy)
+	kv.maxMemory = bytes
+}
+
+// SetEvictionPolicy changes the eviction policy at runtime and keeps the
+// memory limit
+func (kv *KVStore) SetEvictionPolicy(policy EvictionPolicy) {
+	kv.mu.Lock()
+	defer kv.unlock()
+	kv.setPolicyLocked(policy)
+}
+
+func (kv *KVStore) setPolicyLocked(policy EvictionPolicy) {
+	if policy != kv.policy {
+		kv.meta = make(map[string]*entryMeta)
+	}
+	kv.policy = policy
+}
@@ -0,0 +1,138 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNoEvictionReturnsOOM(t *testing.T) {
+	store := New(WithMaxMemory(1024))
+	value := strings.Repeat("x", 100)
+
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = store.Set(fmt.Sprintf("key:%d", i), value)
+	}
+	if !errors.Is(err, ErrOOM) {
+		t.Fatalf("expected ErrOOM, got %v", err)
+	}
+	if stats := store.Stats(); stats.UsedMemory > stats.MaxMemory || stats.EvictedKeys != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestAllKeysLRUEvictsIdleKeys(t *testing.T) {
+	store := New(WithMaxMemory(20*1024), WithEvictionPolicy(PolicyAllKeysLRU))
+	value := strings.Repeat("x", 100)
+
+	store.Set("hot", value)
+	for i := 0; i < 1000; i++ {
+		store.Get("hot")
+		if err := store.Set(fmt.Sprintf("key:%d", i), value); err != nil {
+			t.Fatalf("set failed: %v", err)
+		}
+	}
+
+	stats := store.Stats()
+	if stats.EvictedKeys == 0 || stats.UsedMemory > stats.MaxMemory {
+		t.Fatalf("expected evictions within the limit, got %+v", stats)
+	}
+	if !store.Exists("hot") {
+		t.Fatal("the most recently used key was evicted")
+	}
+}
+
+func TestVolatilePoliciesOnlyEvictKeysWithTTL(t *testing.T) {
+	store := New(WithMaxMemory(4*1024), WithEvictionPolicy(PolicyVolatileTTL))
+	value := strings.Repeat("x", 100)
+
+	store.Set("soon", value)
+	store.Expire("soon", time.Minute)
+	store.Set("later", value)
+	store.Expire("later", time.Hour)
+
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = store.Set(fmt.Sprintf("key:%d", i), value)
+	}
+	if !errors.Is(err, ErrOOM) {
+		t.Fatalf("expected ErrOOM once no volatile keys are left, got %v", err)
+	}
+	if store.Exists("soon") || store.Exists("later") {
+		t.Fatal("expected both volatile keys to be evicted")
+	}
+	if evicted := store.Stats().EvictedKeys; evicted != 2 {
+		t.Fatalf("expected 2 evictions, got %d", evicted)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	store := New()
+	store.Set("a", "1")
+	store.Set("b", "2")
+
+	if ttl := store.TTL("a"); ttl != TTLPersistent {
+		t.Fatalf("expected persistent key, got %v", ttl)
+	}
+	if !store.Expire("a", 20*time.Millisecond) || !store.Expire("b", 20*time.Millisecond) {
+		t.Fatal("expire on existing keys failed")
+	}
+	if ttl := store.TTL("a"); ttl <= 0 || ttl > 20*time.Millisecond {
+		t.Fatalf("unexpected ttl %v", ttl)
+	}
+	if !store.Persist("b") || store.TTL("b") != TTLPersistent {
+		t.Fatal("persist failed")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if store.Exists("a") || store.TTL("a") != TTLNoKey {
+		t.Fatal("expected a to be expired")
+	}
+	if removed := store.ActiveExpire(); removed != 1 {
+		t.Fatalf("expected active expire to remove 1 key, removed %d", removed)
+	}
+	if stats := store.Stats(); stats.Keys != 1 || stats.Expires != 0 || stats.ExpiredKeys != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// SET clears the TTL
+	store.Expire("b", time.Hour)
+	store.Set("b", "3")
+	if store.TTL("b") != TTLPersistent {
+		t.Fatal("expected set to clear the ttl")
+	}
+}
+
+func TestExpireOutOfRange(t *testing.T) {
+	send, _ := pipeClient(t, NewRedisServer(nil))
+	send("SET", "k", "v")
+	for _, cmd := range [][]string{
+		{"EXPIRE", "k", "9223372036854775807"},
+		{"PEXPIRE", "k", "9223372036854775807"},
+		{"EXPIRE", "k", "-9223372036854775808"},
+		{"SET", "k", "v", "PX", "9223372036854775807"},
+	} {
+		if got := send(cmd...); !strings.HasPrefix(got, "-ERR invalid expire time") {
+			t.Fatalf("%q replied %q", cmd, got)
+		}
+	}
+	if got := send("EXISTS", "k"); got != ":1" {
+		t.Fatal("an out of range expire deleted the key")
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	for size, want := range map[string]int64{"100": 100, "1kb": 1024, "2mb": 2 << 20, "1g": 1e9, "8589934591gb": 8589934591 << 30} {
+		if got, err := ParseMemory(size); got != want || err != nil {
+			t.Fatalf("ParseMemory(%q) = %d, %v", size, got, err)
+		}
+	}
+	for _, size := range []string{"-1", "lots", "99999999999gb", "9223372036854775807k"} {
+		if _, err := ParseMemory(size); err == nil {
+			t.Fatalf("ParseMemory(%q) accepted an invalid size", size)
+		}
+	}
+}
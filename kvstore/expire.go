@@ -0,0 +1,150 @@
+package kvstore
+
+import "time"
+
+// expireOverhead estimates the memory held by one entry of the expires map
+const expireOverhead = 32
+
+// TTL results for keys without a deadline and for missing keys, mirroring
+// the -1 and -2 replies of the Redis TTL command
+const (
+	TTLPersistent time.Duration = -1
+	TTLNoKey      time.Duration = -2
+)
+
+// activeExpireSamples is how many keys with a TTL each active expire round inspects
+const activeExpireSamples = 20
+
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+// isExpired reports whether key has a deadline in the past. Callers hold kv.mu.
+func (kv *KVStore) isExpired(key string) bool {
+	if len(kv.expires) == 0 {
+		return false
+	}
+	deadline, ok := kv.expires[key]
+	return ok && deadline <= nowMs()
+}
+
+// deleteExpired removes key if it is still expired once the write lock is held
+func (kv *KVStore) deleteExpired(key string) {
+	kv.mu.Lock()
+	defer kv.unlock()
+	if kv.isExpired(key) {
+		kv.expireLocked(key)
+	}
+}
+
+// expireLocked deletes a key whose TTL has passed. Callers hold kv.mu.
+func (kv *KVStore) expireLocked(key string) {
+	if kv.delLocked(key) {
+		kv.expired.Add(1)
+		kv.notify(EventExpired, key)
+	}
+}
+
+func (kv *KVStore) setExpireLocked(key string, deadline int64) {
+	if _, ok := kv.expires[key]; !ok {
+		kv.addUsed(expireOverhead)
+	}
+	kv.expires[key] = deadline
+}
+
+func (kv *KVStore) persistLocked(key string) bool {
+	if _, ok := kv.expires[key]; ok {
+		delete(kv.expires, key)
+		kv.addUsed(-expireOverhead)
+		return true
+	}
+	return false
+}
+
+// Expire sets a time to live on key. A ttl of zero or less deletes the key
+// right away. It returns false if the key does not exist.
+func (kv *KVStore) Expire(key string, ttl time.Duration) bool {
+	return kv.ExpireAt(key, time.Now().Add(ttl))
+}
+
+// ExpireAt sets the absolute deadline of key. A deadline in the past deletes
+// the key right away. It returns false if the key does not exist.
+func (kv *KVStore) ExpireAt(key string, at time.Time) bool {
+	kv.mu.Lock()
+	defer kv.unlock()
+	if !kv.data.has(key) {
+		return false
+	}
+	if kv.isExpired(key) {
+		kv.expireLocked(key)
+		return false
+	}
+	if !at.After(time.Now()) {
+		kv.delLocked(key)
+		kv.notify(EventDel, key)
+		return true
+	}
+	kv.setExpireLocked(key, at.UnixMilli())
+	kv.notify(EventExpire, key)
+	return true
+}
+
+// Persist removes the TTL of key and reports whether it had one
+func (kv *KVStore) Persist(key string) bool {
+	kv.mu.Lock()
+	defer kv.unlock()
+	if kv.isExpired(key) {
+		kv.expireLocked(key)
+		return false
+	}
+	if !kv.persistLocked(key) {
+		return false
+	}
+	kv.notify(EventPersist, key)
+	return true
+}
+
+// TTL returns the remaining time to live of key, TTLPersistent if it has no
+// deadline or TTLNoKey if it does not exist
+func (kv *KVStore) TTL(key string) time.Duration {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	if !kv.data.has(key) || kv.isExpired(key) {
+		return TTLNoKey
+	}
+	deadline, ok := kv.expires[key]
+	if !ok {
+		return TTLPersistent
+	}
+	return time.Duration(deadline-nowMs()) * time.Millisecond
+}
+
+// ActiveExpire deletes keys whose TTL has passed without waiting for them to
+// be accessed, using the Redis approach: sample keys with a TTL and repeat
+// while more than a quarter of the sample was expired, bounded to about a
+// millisecond. It returns the number of keys removed.
+func (kv *KVStore) ActiveExpire() int {
+	stop := time.Now().Add(time.Millisecond)
+	total := 0
+	for {
+		kv.mu.Lock()
+		sampled, removed := 0, 0
+		now := nowMs()
+		for key, deadline := range kv.expires {
+			if sampled == activeExpireSamples {
+				break
+			}
+			sampled++
+			if deadline <= now {
+				kv.expireLocked(key)
+				removed++
+			}
+		}
+		kv.unlock()
+
+		total += removed
+		if sampled == 0 || removed*4 <= sampled || time.Now().After(stop) {
+			return total
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package kvstore
+
+// globMaxNesting bounds the recursion of a pattern with many '*', like Redis
+const globMaxNesting = 1000
+
+// GlobMatch reports whether s matches the Redis glob pattern, with the same
+// semantics KEYS, SCAN MATCH and PSUBSCRIBE have in Redis:
+//
+//	?       exactly one byte
+//	*       any sequence of bytes, including none
+//	[abc]   one of the listed bytes, [a-z] a range, [^a] anything but a
+//	\x      the byte x literally, also inside brackets
+//
+// It is a port of stringmatchlen from Redis' util.c, including its quirks: a
+// reversed range such as [z-a] is swapped and an unterminated [ extends to
+// the end of the pattern, and an empty string only matches the empty pattern
+// (Redis callers skip matching altogether for "*"). It never panics on
+// malformed patterns.
+func GlobMatch(pattern, s string) bool {
+	skipLongerMatches := false
+	return globMatch(pattern, s, &skipLongerMatches, 0)
+}
+
+func globMatch(pattern, s string, skipLongerMatches *bool, nesting int) bool {
+	if nesting > globMaxNesting {
+		return false
+	}
+
+	p, i := 0, 0
+	for p < len(pattern) && i < len(s) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p == len(pattern)-1 {
+				return true
+			}
+			for ; i < len(s); i++ {
+				if globMatch(pattern[p+1:], s[i:], skipLongerMatches, nesting+1) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+			}
+			// The rest of the pattern matches nowhere in the rest of the string,
+			// so letting an earlier '*' swallow more bytes cannot help either
+			*skipLongerMatches = true
+			return false
+		case '?':
+			i++
+		case '[':
+			p++
+			negate := p < len(pattern) && pattern[p] == '^'
+			if negate {
+				p++
+			}
+			match := false
+			for {
+				if p >= len(pattern) {
+					// Unterminated class: step back so the p++ below ends the pattern
+					p--
+					break
+				}
+				if pattern[p] == '\\' && len(pattern)-p >= 2 {
+					p++
+					if pattern[p] == s[i] {
+						match = true
+					}
+				} else if pattern[p] == ']' {
+					break
+				} else if len(pattern)-p >= 3 && pattern[p+1] == '-' {
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					p += 2
+					if s[i] >= start && s[i] <= end {
+						match = true
+					}
+				} else if pattern[p] == s[i] {
+					match = true
+				}
+				p++
+			}
+			if negate {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			i++
+		case '\\':
+			if len(pattern)-p >= 2 {
+				p++
+			}
+			fallthrough
+		default:
+			if pattern[p] != s[i] {
+				return false
+			}
+			i++
+		}
+		p++
+		if i == len(s) {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			break
+		}
+	}
+	return p == len(pattern) && i == len(s)
+}
+
+// globLiteralPrefix returns the literal text every string matching pattern
+// starts with, resolving escapes, up to the first wildcard
+func globLiteralPrefix(pattern string) string {
+	prefix := make([]byte, 0, len(pattern))
+	for p := 0; p < len(pattern); p++ {
+		switch pattern[p] {
+		case '*', '?', '[':
+			return string(prefix)
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+		}
+		prefix = append(prefix, pattern[p])
+	}
+	return string(prefix)
+}
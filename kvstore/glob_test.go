@@ -0,0 +1,140 @@
+package kvstore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", false},
+		{"", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:123", true},
+		{"user:*", "users:123", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h[\]]llo`, "h]llo", true},
+		{"h[ello", "he", true},
+		{"h[", "h", false},
+		{"[]", "a", false},
+		{"[^]", "a", true},
+		{`a\`, `a\`, true},
+		{"a*b*c*d*e*f*g*h*i*j*k", strings.Repeat("a", 64), false},
+	}
+	for _, c := range cases {
+		if got := GlobMatch(c.pattern, c.s); got != c.want {
+			t.Errorf("GlobMatch(%q, %q) = %v, want %v", c.pattern, c.s, got, c.want)
+		}
+		if got := globReferenceMatch(c.pattern, c.s); got != c.want {
+			t.Errorf("reference(%q, %q) = %v, want %v", c.pattern, c.s, got, c.want)
+		}
+	}
+}
+
+// globReference translates a Redis glob into an anchored regular expression,
+// following the stringmatchlen rules independently of the matcher
+func globReference(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString(`(?s)^`)
+	for p := 0; p < len(pattern); p++ {
+		switch c := pattern[p]; c {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[p : p+1]))
+		case '[':
+			p++
+			negate := p < len(pattern) && pattern[p] == '^'
+			if negate {
+				p++
+			}
+			var set [128]bool
+			for ; p < len(pattern) && pattern[p] != ']'; p++ {
+				switch {
+				case pattern[p] == '\\' && p+1 < len(pattern):
+					p++
+					set[pattern[p]] = true
+				case p+2 < len(pattern) && pattern[p+1] == '-':
+					lo, hi := min(pattern[p], pattern[p+2]), max(pattern[p], pattern[p+2])
+					for x := int(lo); x <= int(hi); x++ {
+						set[x] = true
+					}
+					p += 2
+				default:
+					set[pattern[p]] = true
+				}
+			}
+			b.WriteString(`[`)
+			if negate {
+				b.WriteString(`^`)
+			}
+			// Keeps [] and [^] valid: \x{10FFFF} never appears in the ASCII strings compared
+			b.WriteString(`\x{10FFFF}`)
+			for x, ok := range set {
+				if ok {
+					fmt.Fprintf(&b, `\x{%x}`, x)
+				}
+			}
+			b.WriteString(`]`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(`$`)
+	return regexp.MustCompile(b.String())
+}
+
+// globReferenceMatch adds the one rule a regexp cannot express: like
+// stringmatchlen, an empty string only matches the empty pattern
+func globReferenceMatch(pattern, s string) bool {
+	if s == "" {
+		return pattern == ""
+	}
+	return globReference(pattern).MatchString(s)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func FuzzGlobMatch(f *testing.F) {
+	seeds := []string{"*", "user:*", "h?llo", "h[ae]llo", "h[^e]llo", "h[a-z]*", `\*`, "[", "[]", "[^]", `[\]]`, "a*b?c[d-f]", "[a-]b"}
+	for _, seed := range seeds {
+		f.Add(seed, "hello")
+		f.Add(seed, "user:42")
+	}
+	f.Fuzz(func(t *testing.T, pattern, s string) {
+		if !isASCII(pattern) || !isASCII(s) || len(pattern) > 64 {
+			t.Skip()
+		}
+		want := globReferenceMatch(pattern, s)
+		if got := GlobMatch(pattern, s); got != want {
+			t.Fatalf("GlobMatch(%q, %q) = %v, reference says %v", pattern, s, got, want)
+		}
+	})
+}
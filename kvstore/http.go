@@ -0,0 +1,408 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseHeartbeat is how often an idle event stream gets a comment, so proxies
+// do not time it out
+const sseHeartbeat = 30 * time.Second
+
+// HTTPHandler returns the HTTP/JSON gateway, for clients that cannot open a
+// TCP connection, such as serverless functions and browsers:
+//
+//	GET    /keys/{key}  the value of key
+//	PUT    /keys/{key}  sets key to the request body; the ex, px, exat, pxat,
+//	                    nx, xx and keepttl query parameters are the SET options
+//	DELETE /keys/{key}  deletes key
+//	GET    /keys        a page of keys, with the cursor, match, count and type
+//	                    query parameters of SCAN
+//	POST   /command     runs a command given as a JSON array, e.g.
+//	                    ["INCRBY", "n", 2], and returns its reply as JSON
+//	GET    /subscribe   Server-Sent Events for the channel and pattern query
+//	                    parameters
+//	GET    /ws          a WebSocket carrying RESP, served like a connection
+//
+// Commands run through the same dispatcher as those of connections. The db
+// query parameter selects another database than 0, and resp=3 makes
+// /command return RESP3 replies such as maps.
+//
+// Requests are refused like connections by protected mode and maxclients,
+// and like WebSockets when a browser sends them from a page of another
+// origin. /command also wants a JSON Content-Type, which a page cannot send
+// to another origin without the browser asking first.
+func (s *RedisServer) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys/{key...}", s.httpGetKey)
+	mux.HandleFunc("PUT /keys/{key...}", s.httpSetKey)
+	mux.HandleFunc("DELETE /keys/{key...}", s.httpDelKey)
+	mux.HandleFunc("GET /keys", s.httpScan)
+	mux.HandleFunc("POST /command", s.httpCommand)
+	mux.HandleFunc("GET /subscribe", s.httpSubscribe)
+	mux.HandleFunc("GET /ws", s.httpWebSocket)
+	return s.httpAdmit(mux)
+}
+
+// httpAdmit refuses the requests protected mode, maxclients or their origin
+// do not allow
+func (s *RedisServer) httpAdmit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var addr *net.TCPAddr
+		if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+			addr = net.TCPAddrFromAddrPort(addrPort)
+		}
+		switch err := s.admitClient(addr); {
+		case errors.Is(err, errProtectedMode):
+			writeJSONError(w, http.StatusForbidden, err.Error())
+			return
+		case err != nil:
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		if !s.originAllowed(r) {
+			writeJSONError(w, http.StatusForbidden, "origin not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes v as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// jsonReply turns a decoded reply into a value JSON can encode: error
+// replies become {"error": ...} and doubles JSON has no number for strings
+func jsonReply(reply any) any {
+	switch v := reply.(type) {
+	case replyError:
+		return map[string]string{"error": string(v)}
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	case []any:
+		for i := range v {
+			v[i] = jsonReply(v[i])
+		}
+	case map[string]any:
+		for key := range v {
+			v[key] = jsonReply(v[key])
+		}
+	}
+	return reply
+}
+
+// httpClient returns a client for the commands of an HTTP request, using the
+// database of the db query parameter
+func (s *RedisServer) httpClient(w http.ResponseWriter, r *http.Request) (*client, bool) {
+	now := time.Now()
+	c := &client{server: s, created: now, lastActive: now}
+	if db := r.URL.Query().Get("db"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil || n < 0 || n >= len(s.databases()) {
+			writeJSONError(w, http.StatusBadRequest, "ERR DB index is out of range")
+			return nil, false
+		}
+		c.dbIndex = n
+	}
+	return c, true
+}
+
+// run executes cmd for an HTTP request and decodes its reply. It writes the
+// response and returns false when the command failed or was dropped by a
+// shutdown.
+func (s *RedisServer) run(w http.ResponseWriter, c *client, cmd []string) (any, bool) {
+	defer s.closeClient(c)
+	reply := s.handleCommand(c, cmd)
+	if reply == "" {
+		writeJSONError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return nil, false
+	}
+	decoded, err := decodeReply(bufio.NewReader(strings.NewReader(reply)))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	if e, ok := decoded.(replyError); ok {
+		writeJSONError(w, http.StatusBadRequest, string(e))
+		return nil, false
+	}
+	return decoded, true
+}
+
+func (s *RedisServer) httpGetKey(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.httpClient(w, r)
+	if !ok {
+		return
+	}
+	key := r.PathValue("key")
+	reply, ok := s.run(w, c, []string{"GET", key})
+	if !ok {
+		return
+	}
+	if reply == nil {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"key": key, "value": reply})
+}
+
+func (s *RedisServer) httpSetKey(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.httpClient(w, r)
+	if !ok {
+		return
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxStringSize))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	cmd := []string{"SET", r.PathValue("key"), string(value)}
+	query := r.URL.Query()
+	for _, option := range []string{"nx", "xx", "keepttl"} {
+		if query.Has(option) {
+			cmd = append(cmd, option)
+		}
+	}
+	for _, option := range []string{"ex", "px", "exat", "pxat"} {
+		if query.Has(option) {
+			cmd = append(cmd, option, query.Get(option))
+		}
+	}
+	reply, ok := s.run(w, c, cmd)
+	if !ok {
+		return
+	}
+	if reply == nil {
+		writeJSONError(w, http.StatusPreconditionFailed, "not stored")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *RedisServer) httpDelKey(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.httpClient(w, r)
+	if !ok {
+		return
+	}
+	reply, ok := s.run(w, c, []string{"DEL", r.PathValue("key")})
+	if !ok {
+		return
+	}
+	if reply == int64(0) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// httpScan returns a page of keys as {"cursor": ..., "keys": [...]}. The
+// cursor is a string, like in Redis, as it may not fit a JSON number.
+func (s *RedisServer) httpScan(w http.ResponseWriter, r *http.Request) {
+	c, ok := s.httpClient(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	cmd := []string{"SCAN", "0"}
+	if cursor := query.Get("cursor"); cursor != "" {
+		cmd[1] = cursor
+	}
+	for _, option := range []string{"match", "count", "type"} {
+		if query.Has(option) {
+			cmd = append(cmd, option, query.Get(option))
+		}
+	}
+	reply, ok := s.run(w, c, cmd)
+	if !ok {
+		return
+	}
+	page, _ := reply.([]any)
+	if len(page) != 2 {
+		writeJSONError(w, http.StatusInternalServerError, "unexpected SCAN reply")
+		return
+	}
+	keys, _ := page[1].([]any)
+	if keys == nil {
+		keys = []any{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"cursor": page[0], "keys": keys})
+}
+
+// httpCommand runs the command of a JSON array of strings and numbers and
+// returns {"result": reply}, or {"error": message} for an error reply
+func (s *RedisServer) httpCommand(w http.ResponseWriter, r *http.Request) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "ERR expected Content-Type: application/json")
+		return
+	}
+	c, ok := s.httpClient(w, r)
+	if !ok {
+		return
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxStringSize))
+	decoder.UseNumber()
+	var args []any
+	if err := decoder.Decode(&args); err != nil || len(args) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "ERR expected a JSON array with the command and its arguments")
+		return
+	}
+	cmd := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			cmd[i] = v
+		case json.Number:
+			cmd[i] = v.String()
+		default:
+			writeJSONError(w, http.StatusBadRequest, "ERR command arguments must be strings or numbers")
+			return
+		}
+	}
+	switch strings.ToLower(cmd[0]) {
+	case "subscribe", "psubscribe", "ssubscribe":
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("ERR '%s' needs a stream, use GET /subscribe", cmd[0]))
+		return
+	}
+	if r.URL.Query().Get("resp") == "3" {
+		c.resp.Store(3)
+	}
+	reply, ok := s.run(w, c, cmd)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"result": jsonReply(reply)})
+}
+
+// httpSubscribe streams the messages of the channel and pattern query
+// parameters as Server-Sent Events. The subscriber is a regular client whose
+// messages go through a pipe, so CLIENT LIST and the pub/sub output buffer
+// limits apply as for a connection.
+func (s *RedisServer) httpSubscribe(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	channels, patterns := query["channel"], query["pattern"]
+	if len(channels)+len(patterns) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "ERR no channel or pattern to subscribe to")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	conn, pushes := net.Pipe()
+	defer conn.Close()
+	c := s.clients.add(s, conn)
+	defer s.closeClient(c)
+	var replies bytes.Buffer
+	if len(channels) > 0 {
+		replies.WriteString(s.handleCommand(c, append([]string{"SUBSCRIBE"}, channels...)))
+	}
+	if len(patterns) > 0 {
+		replies.WriteString(s.handleCommand(c, append([]string{"PSUBSCRIBE"}, patterns...)))
+	}
+	if c.closing {
+		writeJSONError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	confirmations := bufio.NewReader(&replies)
+	for {
+		frame, err := decodeReply(confirmations)
+		if err != nil {
+			break
+		}
+		writeEvent(w, frame)
+	}
+	flusher.Flush()
+
+	frames := make(chan any)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(frames)
+		reader := bufio.NewReader(pushes)
+		for {
+			frame, err := decodeReply(reader)
+			if err != nil {
+				return
+			}
+			select {
+			case frames <- frame:
+			case <-done:
+				return
+			}
+		}
+	}()
+	// Closing the pipe ends the reader once the request is gone
+	stop := context.AfterFunc(r.Context(), func() { pushes.Close() })
+	defer stop()
+	defer pushes.Close()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case frame, ok := <-frames:
+			if !ok || writeEvent(w, frame) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes a pub/sub frame as a Server-Sent Event named after its
+// kind, such as message, pmessage or subscribe
+func writeEvent(w io.Writer, frame any) error {
+	items, _ := frame.([]any)
+	if len(items) < 3 {
+		return nil
+	}
+	kind, _ := items[0].(string)
+	data := map[string]any{"channel": items[1], "message": items[2]}
+	switch {
+	case kind == "pmessage" && len(items) == 4:
+		data = map[string]any{"pattern": items[1], "channel": items[2], "message": items[3]}
+	case kind == "psubscribe" || kind == "punsubscribe":
+		data = map[string]any{"pattern": items[1], "count": items[2]}
+	case strings.HasSuffix(kind, "subscribe"):
+		data = map[string]any{"channel": items[1], "count": items[2]}
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", kind, payload)
+	return err
+}
@@ -0,0 +1,171 @@
+package kvstore
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPGateway(t *testing.T) {
+	server := NewRedisServer(nil)
+	ts := httptest.NewServer(server.HTTPHandler())
+	defer ts.Close()
+
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if method == "POST" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		status             int
+		want               string
+	}{
+		{"PUT", "/keys/user/1", "ada", http.StatusNoContent, ""},
+		{"PUT", "/keys/user/1?nx", "bob", http.StatusPreconditionFailed, `{"error":"not stored"}`},
+		{"GET", "/keys/user/1", "", http.StatusOK, `{"key":"user/1","value":"ada"}`},
+		{"GET", "/keys/missing", "", http.StatusNotFound, `{"error":"not found"}`},
+		{"POST", "/command", `["INCRBY", "n", 5]`, http.StatusOK, `{"result":5}`},
+		{"POST", "/command", `["MGET", "n", "missing"]`, http.StatusOK, `{"result":["5",null]}`},
+		{"POST", "/command?resp=3", `["CONFIG", "GET", "maxclients"]`, http.StatusOK, `{"result":{"maxclients":"10000"}}`},
+		{"POST", "/command", `["INCR", "user/1"]`, http.StatusBadRequest, `{"error":"ERR value is not an integer or out of range"}`},
+		{"POST", "/command", `["SUBSCRIBE", "news"]`, http.StatusBadRequest, `{"error":"ERR 'SUBSCRIBE' needs a stream, use GET /subscribe"}`},
+		{"POST", "/command", `{"cmd": "PING"}`, http.StatusBadRequest, `{"error":"ERR expected a JSON array with the command and its arguments"}`},
+		{"GET", "/keys?match=user/*", "", http.StatusOK, `{"cursor":"0","keys":["user/1"]}`},
+		{"PUT", "/keys/other?db=1", "x", http.StatusNoContent, ""},
+		{"GET", "/keys/other", "", http.StatusNotFound, `{"error":"not found"}`},
+		{"GET", "/keys/other?db=16", "", http.StatusBadRequest, `{"error":"ERR DB index is out of range"}`},
+		{"DELETE", "/keys/user/1", "", http.StatusNoContent, ""},
+		{"DELETE", "/keys/user/1", "", http.StatusNotFound, `{"error":"not found"}`},
+	} {
+		status, body := do(tc.method, tc.path, tc.body)
+		if status != tc.status || body != tc.want {
+			t.Fatalf("%s %s returned %d %s, want %d %s", tc.method, tc.path, status, body, tc.status, tc.want)
+		}
+	}
+}
+
+func TestHTTPSubscribe(t *testing.T) {
+	server := NewRedisServer(nil)
+	ts := httptest.NewServer(server.HTTPHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/subscribe?channel=news&pattern=user.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type is %q", got)
+	}
+	events := bufio.NewReader(resp.Body)
+	next := func() string {
+		t.Helper()
+		var event []string
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return strings.Join(event, " ")
+			}
+			event = append(event, strings.TrimSuffix(line, "\n"))
+		}
+	}
+	for _, want := range []string{
+		`event: subscribe data: {"channel":"news","count":1}`,
+		`event: psubscribe data: {"count":2,"pattern":"user.*"}`,
+	} {
+		if got := next(); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	c := &client{server: server}
+	server.handleCommand(c, []string{"PUBLISH", "news", "hello"})
+	server.handleCommand(c, []string{"PUBLISH", "user.1", "joined"})
+	for _, want := range []string{
+		`event: message data: {"channel":"news","message":"hello"}`,
+		`event: pmessage data: {"channel":"user.1","message":"joined","pattern":"user.*"}`,
+	} {
+		if got := next(); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	// The subscriber goes away with the request
+	resp.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for server.pubsub.publish("news", "bye") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber still registered after the request ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestHTTPAdmission checks that pages of other sites and remote clients in
+// protected mode cannot run commands
+func TestHTTPAdmission(t *testing.T) {
+	server := NewRedisServer(nil)
+	handler := server.HTTPHandler()
+	server.databases()[0].Set("k", "v")
+
+	for _, tc := range []struct {
+		method, path, contentType, origin, remote string
+		status                                    int
+	}{
+		// A form post needs no preflight, so the origin and the type must stop it
+		{"POST", "/command", "text/plain", "https://elsewhere.example", "127.0.0.1:4000", http.StatusForbidden},
+		{"POST", "/command", "text/plain", "", "127.0.0.1:4000", http.StatusUnsupportedMediaType},
+		{"GET", "/keys/k", "", "https://elsewhere.example", "127.0.0.1:4000", http.StatusForbidden},
+		{"GET", "/subscribe?channel=news", "", "https://elsewhere.example", "127.0.0.1:4000", http.StatusForbidden},
+		{"POST", "/command", "application/json", "", "192.0.2.1:4000", http.StatusForbidden},
+		{"GET", "/keys/k", "", "", "192.0.2.1:4000", http.StatusForbidden},
+		{"GET", "/keys/k", "", "http://gateway.example", "127.0.0.1:4000", http.StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, "http://gateway.example"+tc.path, strings.NewReader(`["FLUSHALL"]`))
+		req.RemoteAddr = tc.remote
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Fatalf("%s %s from %s with origin %q returned %d, want %d", tc.method, tc.path, tc.remote, tc.origin, rec.Code, tc.status)
+		}
+	}
+	if !server.databases()[0].Exists("k") {
+		t.Fatal("a refused request flushed the database")
+	}
+
+	server.limits.maxClients.Store(0)
+	req := httptest.NewRequest("GET", "/keys/k", nil)
+	req.RemoteAddr = "127.0.0.1:4000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("a request over maxclients returned %d", rec.Code)
+	}
+}
@@ -0,0 +1,247 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisVersion is the Redis version clients should assume when they check
+// INFO server for feature detection
+const redisVersion = "7.2.0"
+
+// infoSection renders one section of the INFO reply
+type infoSection struct {
+	name string
+	// extra sections are only included when asked for by name, "all" or "everything"
+	extra  bool
+	render func(s *RedisServer, snap *infoSnapshot) []string
+}
+
+// infoSnapshot is the store state shared by the sections of one INFO reply
+type infoSnapshot struct {
+	total Stats
+	perDB []Stats
+}
+
+var infoSections = []infoSection{
+	{"server", false, (*RedisServer).infoServer},
+	{"clients", false, (*RedisServer).infoClients},
+	{"memory", false, (*RedisServer).infoMemory},
+	{"persistence", false, (*RedisServer).infoPersistence},
+	{"stats", false, (*RedisServer).infoStats},
+	{"replication", false, (*RedisServer).infoReplication},
+	{"commandstats", true, (*RedisServer).infoCommandStats},
+	{"keyspace", false, (*RedisServer).infoKeyspace},
+}
+
+// info renders the INFO reply for the requested sections: none or "default"
+// for the default ones, "all" or "everything" for all of them, or any list of
+// section names
+func (s *RedisServer) info(requested ...string) string {
+	want := map[string]bool{}
+	for _, name := range requested {
+		want[strings.ToLower(name)] = true
+	}
+	all := want["all"] || want["everything"]
+	def := len(requested) == 0 || want["default"]
+
+	snap := &infoSnapshot{}
+	snap.total, snap.perDB = s.keyspaceStats()
+
+	var b strings.Builder
+	for _, sec := range infoSections {
+		if !all && !want[sec.name] && (!def || sec.extra) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(sec.name[:1])+sec.name[1:])
+		for _, line := range sec.render(s, snap) {
+			b.WriteString(line + "\r\n")
+		}
+	}
+	return b.String()
+}
+
+func (s *RedisServer) infoServer(*infoSnapshot) []string {
+	uptime := time.Since(s.metrics.start)
+	cfg := s.config()
+	return []string{
+		"redis_version:" + redisVersion,
+		"redis_mode:standalone",
+		fmt.Sprintf("os:%s %s", runtime.GOOS, runtime.GOARCH),
+		fmt.Sprintf("arch_bits:%d", strconv.IntSize),
+		"go_version:" + runtime.Version(),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		"run_id:" + s.metrics.runID,
+		fmt.Sprintf("tcp_port:%d", cfg.Port),
+		"config_file:" + cfg.File,
+		fmt.Sprintf("server_time_usec:%d", time.Now().UnixMicro()),
+		fmt.Sprintf("uptime_in_seconds:%d", int64(uptime/time.Second)),
+		fmt.Sprintf("uptime_in_days:%d", int64(uptime/(24*time.Hour))),
+		fmt.Sprintf("hz:%d", time.Second/activeExpireInterval),
+	}
+}
+
+func (s *RedisServer) infoClients(*infoSnapshot) []string {
+	pubsubClients, trackingClients := 0, 0
+	for _, c := range s.clients.list() {
+		if c.subs.Load() > 0 {
+			pubsubClients++
+		}
+		if c.tracking.on.Load() {
+			trackingClients++
+		}
+	}
+	return []string{
+		fmt.Sprintf("connected_clients:%d", s.metrics.connectedClients.Load()),
+		fmt.Sprintf("maxclients:%d", s.limits.maxClients.Load()),
+		fmt.Sprintf("pubsub_clients:%d", pubsubClients),
+		fmt.Sprintf("tracking_clients:%d", trackingClients),
+	}
+}
+
+// infoMemory reports the dataset estimate maxmemory is enforced against as
+// used_memory, and the Go runtime's view of the process next to it
+func (s *RedisServer) infoMemory(snap *infoSnapshot) []string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	rss := int64(ms.Sys - ms.HeapReleased)
+	fragmentation := 0.0
+	if snap.total.UsedMemory > 0 {
+		fragmentation = float64(rss) / float64(snap.total.UsedMemory)
+	}
+	return []string{
+		fmt.Sprintf("used_memory:%d", snap.total.UsedMemory),
+		"used_memory_human:" + bytesToHuman(snap.total.UsedMemory),
+		fmt.Sprintf("used_memory_rss:%d", rss),
+		"used_memory_rss_human:" + bytesToHuman(rss),
+		fmt.Sprintf("maxmemory:%d", snap.total.MaxMemory),
+		"maxmemory_human:" + bytesToHuman(snap.total.MaxMemory),
+		fmt.Sprintf("maxmemory_policy:%s", snap.total.Policy),
+		fmt.Sprintf("mem_fragmentation_ratio:%.2f", fragmentation),
+		"mem_allocator:go",
+		fmt.Sprintf("lazyfree_pending_objects:%d", snap.total.LazyFreePending),
+		fmt.Sprintf("go_heap_alloc:%d", ms.HeapAlloc),
+		fmt.Sprintf("go_heap_sys:%d", ms.HeapSys),
+		fmt.Sprintf("go_heap_objects:%d", ms.HeapObjects),
+		fmt.Sprintf("go_num_gc:%d", ms.NumGC),
+		fmt.Sprintf("go_gc_pause_total_ns:%d", ms.PauseTotalNs),
+	}
+}
+
+func (s *RedisServer) infoPersistence(*infoSnapshot) []string {
+	return []string{
+		"loading:0",
+		"async_loading:0",
+		fmt.Sprintf("rdb_changes_since_last_save:%d", s.metrics.dirty.Load()),
+		"rdb_bgsave_in_progress:0",
+		fmt.Sprintf("rdb_last_save_time:%d", s.metrics.start.Unix()),
+		"aof_enabled:0",
+	}
+}
+
+func (s *RedisServer) infoStats(snap *infoSnapshot) []string {
+	m := s.metrics
+	s.pubsub.mu.RLock()
+	channels, patterns := len(s.pubsub.channels), len(s.pubsub.patterns)
+	s.pubsub.mu.RUnlock()
+	trackedKeys, trackedPrefixes := s.tracking.stats()
+	return []string{
+		fmt.Sprintf("total_connections_received:%d", m.totalConnections.Load()),
+		fmt.Sprintf("total_commands_processed:%d", m.totalCommands.Load()),
+		fmt.Sprintf("total_net_input_bytes:%d", m.netInput.Load()),
+		fmt.Sprintf("total_net_output_bytes:%d", m.netOutput.Load()),
+		fmt.Sprintf("rejected_connections:%d", m.rejectedConnections.Load()),
+		fmt.Sprintf("expired_keys:%d", snap.total.ExpiredKeys),
+		fmt.Sprintf("evicted_keys:%d", snap.total.EvictedKeys),
+		fmt.Sprintf("keyspace_hits:%d", snap.total.KeyspaceHits),
+		fmt.Sprintf("keyspace_misses:%d", snap.total.KeyspaceMisses),
+		fmt.Sprintf("lazyfreed_objects:%d", snap.total.LazyFreedObjects),
+		fmt.Sprintf("pubsub_channels:%d", channels),
+		fmt.Sprintf("pubsub_patterns:%d", patterns),
+		fmt.Sprintf("tracking_total_keys:%d", trackedKeys),
+		fmt.Sprintf("tracking_total_prefixes:%d", trackedPrefixes),
+		fmt.Sprintf("total_error_replies:%d", m.errorReplies.Load()),
+		fmt.Sprintf("client_output_buffer_limit_disconnections:%d", m.outputBufferDisconnections.Load()),
+		fmt.Sprintf("client_idle_timeout_disconnections:%d", m.idleDisconnections.Load()),
+		fmt.Sprintf("client_query_buffer_limit_disconnections:%d", m.queryBufferDisconnections.Load()),
+	}
+}
+
+func (s *RedisServer) infoReplication(*infoSnapshot) []string {
+	return []string{"role:master", "connected_slaves:0"}
+}
+
+// infoCommandStats has a line for every command called at least once, sorted by name
+func (s *RedisServer) infoCommandStats(*infoSnapshot) []string {
+	lines := []string{}
+	for c, st := range s.metrics.commands {
+		calls, rejected := st.calls.Load(), st.rejected.Load()
+		if calls == 0 && rejected == 0 {
+			continue
+		}
+		usec := st.nanos.Load() / int64(time.Microsecond)
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(st.nanos.Load()) / float64(calls) / float64(time.Microsecond)
+		}
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d",
+			c.name, calls, usec, perCall, rejected, st.failed.Load()))
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+// infoKeyspace has a line per database; like Redis, empty ones are left out
+func (s *RedisServer) infoKeyspace(snap *infoSnapshot) []string {
+	lines := []string{}
+	for i, st := range snap.perDB {
+		if st.Keys > 0 {
+			lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d", i, st.Keys, st.Expires))
+		}
+	}
+	return lines
+}
+
+// keyspaceStats returns the Stats of every database and their sum
+func (s *RedisServer) keyspaceStats() (total Stats, perDB []Stats) {
+	for _, db := range s.databases() {
+		st := db.Stats()
+		perDB = append(perDB, st)
+		total.Keys += st.Keys
+		total.Expires += st.Expires
+		total.UsedMemory += st.UsedMemory
+		total.Policy = st.Policy
+		total.EvictedKeys += st.EvictedKeys
+		total.ExpiredKeys += st.ExpiredKeys
+		total.KeyspaceHits += st.KeyspaceHits
+		total.KeyspaceMisses += st.KeyspaceMisses
+		total.LazyFreePending += st.LazyFreePending
+		total.LazyFreedObjects += st.LazyFreedObjects
+	}
+	// The limit is the one the databases share
+	total.MaxMemory = s.memory.limit.Load()
+	return total, perDB
+}
+
+// bytesToHuman formats a byte count the way INFO does, e.g. 1.50M
+func bytesToHuman(n int64) string {
+	f := float64(n)
+	for _, unit := range []string{"B", "K", "M", "G", "T"} {
+		if f < 1024 || unit == "T" {
+			if unit == "B" {
+				return fmt.Sprintf("%dB", n)
+			}
+			return fmt.Sprintf("%.2f%s", f, unit)
+		}
+		f /= 1024
+	}
+	return ""
+}
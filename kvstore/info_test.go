@@ -0,0 +1,46 @@
+package kvstore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInfoSections(t *testing.T) {
+	server := NewRedisServer(nil, New(), New())
+	c := &client{server: server}
+	for _, cmd := range [][]string{
+		{"SET", "a", "1"},
+		{"GET", "a"},
+		{"GET", "missing"},
+		{"GET"},
+		{"INCR", "a", "extra"},
+		{"SELECT", "1"},
+		{"SET", "b", "2"},
+	} {
+		server.handleCommand(c, cmd)
+	}
+
+	for _, tc := range []struct {
+		args        []string
+		want, avoid []string
+	}{
+		{nil, []string{"# Server", "# Keyspace", "db0:keys=1", "db1:keys=1"}, []string{"# Commandstats"}},
+		{[]string{"stats"}, []string{"keyspace_hits:1", "keyspace_misses:1", "total_commands_processed:5"}, []string{"# Server"}},
+		{[]string{"commandstats"}, []string{"cmdstat_get:calls=2,", "rejected_calls=1,failed_calls=0", "cmdstat_set:calls=2,", "cmdstat_incr:calls=0,"}, []string{"cmdstat_select:calls=0"}},
+		{[]string{"SERVER", "clients"}, []string{"# Server", "# Clients"}, []string{"# Memory"}},
+		{[]string{"all"}, []string{"# Memory", "# Commandstats", "# Keyspace"}, nil},
+		{[]string{"nonexistent"}, nil, []string{"#"}},
+	} {
+		info := server.info(tc.args...)
+		for _, want := range tc.want {
+			if !strings.Contains(info, want) {
+				t.Errorf("INFO %v lacks %q:\n%s", tc.args, want, info)
+			}
+		}
+		for _, avoid := range tc.avoid {
+			if strings.Contains(info, avoid) {
+				t.Errorf("INFO %v contains %q:\n%s", tc.args, avoid, info)
+			}
+		}
+	}
+}
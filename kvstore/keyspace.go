@@ -0,0 +1,333 @@
+package kvstore
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Errors returned by the keyspace operations, worded as the Redis error replies
+var (
+	ErrNoSuchKey = errors.New("ERR no such key")
+	ErrSameKey   = errors.New("ERR source and destination objects are the same")
+)
+
+// embstrMaxLen is the longest string Redis stores with the embstr encoding
+const embstrMaxLen = 44
+
+// ObjectInfo is what OBJECT reports about a key
+type ObjectInfo struct {
+	// Encoding is the encoding Redis would pick for the value: int, embstr or raw
+	Encoding string
+	// IdleTime is the time since the last access, only tracked by the LRU and LFU policies
+	IdleTime time.Duration
+	// Freq is the logarithmic access counter, only tracked by the LFU policies
+	Freq int
+	// Policy is the eviction policy in effect, which decides which of the above are known
+	Policy EvictionPolicy
+}
+
+// Type returns the type of the value at key: "string", or "none" when it does not exist
+func (kv *KVStore) Type(key string) string {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	if _, ok := kv.liveValue(key); ok {
+		return "string"
+	}
+	return "none"
+}
+
+// Rename moves the value and TTL of src to dst, overwriting dst. It fails
+// with ErrNoSuchKey when src does not exist.
+func (kv *KVStore) Rename(src, dst string) error {
+	kv.mu.Lock()
+	defer kv.unlock()
+	_, err := renameLocked(kv, kv, src, dst, false, EventRenameFrom, EventRenameTo)
+	return err
+}
+
+// RenameNX is Rename that only happens when dst does not exist
+func (kv *KVStore) RenameNX(src, dst string) (bool, error) {
+	kv.mu.Lock()
+	defer kv.unlock()
+	return renameLocked(kv, kv, src, dst, true, EventRenameFrom, EventRenameTo)
+}
+
+// renameLocked moves src in from to dst in to, which are the same store
+// unless the keys live on different shards or databases, and reports it with
+// fromEvent and toEvent. Callers hold both locks.
+func renameLocked(from, to *KVStore, src, dst string, nx bool, fromEvent, toEvent string) (bool, error) {
+	value, ok := from.writableValue(src)
+	if !ok {
+		return false, ErrNoSuchKey
+	}
+	old, exists := to.writableValue(dst)
+	if nx && exists {
+		return false, nil
+	}
+	if from == to && src == dst {
+		from.notify(fromEvent, src)
+		to.notify(toEvent, dst)
+		return true, nil
+	}
+
+	delta := to.entrySize(dst, value)
+	if exists {
+		delta -= to.entrySize(dst, old)
+	}
+	if from == to {
+		delta -= from.entrySize(src, value)
+	}
+	if err := to.reserve(delta, func(k string) bool { return k == src || k == dst }); err != nil {
+		return false, err
+	}
+
+	deadline, hadTTL := from.expires[src]
+	meta := from.meta[src]
+	from.delLocked(src)
+	to.storeLocked(dst, value)
+	if hadTTL {
+		to.setExpireLocked(dst, deadline)
+	}
+	// The value keeps its access history, like the object Redis moves
+	if meta != nil && to.policy.tracksAccess() {
+		to.meta[dst] = meta
+	}
+	from.notify(fromEvent, src)
+	to.notify(toEvent, dst)
+	return true, nil
+}
+
+// Copy copies the value and TTL of src to dst. It returns false when src does
+// not exist, or when dst exists and replace is false.
+func (kv *KVStore) Copy(src, dst string, replace bool) (bool, error) {
+	kv.mu.Lock()
+	defer kv.unlock()
+	return copyLocked(kv, kv, src, dst, replace)
+}
+
+// copyLocked copies src in from to dst in to. Callers hold both locks.
+func copyLocked(from, to *KVStore, src, dst string, replace bool) (bool, error) {
+	if from == to && src == dst {
+		return false, ErrSameKey
+	}
+	value, ok := from.writableValue(src)
+	if !ok {
+		return false, nil
+	}
+	if _, exists := to.writableValue(dst); exists && !replace {
+		return false, nil
+	}
+
+	deadline, hadTTL := from.expires[src]
+	if err := to.setLocked(dst, value); err != nil {
+		return false, err
+	}
+	if hadTTL {
+		to.setExpireLocked(dst, deadline)
+	}
+	to.notify(EventCopyTo, dst)
+	return true, nil
+}
+
+// randomKeyAttempts bounds how many expired keys RandomKey skips before giving up
+const randomKeyAttempts = 100
+
+// RandomKey returns a random live key. The starting point of Go map
+// iteration is randomized, which is enough for RANDOMKEY.
+func (kv *KVStore) RandomKey() (string, bool) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	key, found, attempts := "", false, 0
+	kv.data.each(func(k string) bool {
+		if !kv.isExpired(k) {
+			key, found = k, true
+			return false
+		}
+		attempts++
+		return attempts < randomKeyAttempts
+	})
+	return key, found
+}
+
+// DBSize returns the number of keys, including expired keys not removed yet like Redis does
+func (kv *KVStore) DBSize() int {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	return kv.data.len()
+}
+
+// Flush removes every key. The keyspace is swapped for an empty one under the
+// lock in constant time; tearing the old one down happens after the lock is
+// released, inline or on a background goroutine when async is true.
+func (kv *KVStore) Flush(async bool) {
+	kv.mu.Lock()
+	data, expires, meta := kv.data, kv.expires, kv.meta
+	objects := int64(data.len())
+	kv.data = newStorage(kv.engine)
+	if kv.index != nil {
+		kv.index = &btree{}
+	}
+	kv.expires = make(map[string]int64)
+	kv.meta = make(map[string]*entryMeta)
+	if kv.versions != nil {
+		kv.versions = make(map[string]uint64)
+	}
+	kv.addUsed(-kv.used)
+	kv.unlock()
+
+	free := func() {
+		data.clear()
+		clear(expires)
+		clear(meta)
+	}
+	if !async {
+		free()
+		return
+	}
+	kv.lazyfreePending.Add(objects)
+	go func() {
+		free()
+		kv.lazyfreePending.Add(-objects)
+		kv.lazyfreed.Add(objects)
+	}()
+}
+
+// Touch records an access to every existing key and returns how many existed
+func (kv *KVStore) Touch(keys ...string) int {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	n := 0
+	for _, key := range keys {
+		if _, ok := kv.liveValue(key); ok {
+			kv.touch(key)
+			n++
+		}
+	}
+	return n
+}
+
+// Object returns the encoding and access statistics of key without counting
+// as an access
+func (kv *KVStore) Object(key string) (ObjectInfo, bool) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	value, ok := kv.liveValue(key)
+	if !ok {
+		return ObjectInfo{}, false
+	}
+
+	info := ObjectInfo{Encoding: "raw", Policy: kv.policy}
+	if _, isInt := parseInt(value); isInt {
+		info.Encoding = "int"
+	} else if len(value) <= embstrMaxLen {
+		info.Encoding = "embstr"
+	}
+	if m := kv.meta[key]; m != nil {
+		now := time.Now().UnixNano()
+		info.IdleTime = time.Duration(now - m.access.Load())
+		info.Freq = int(m.decayedFreq(now))
+	}
+	return info, true
+}
+
+// MemoryUsage returns the bytes key accounts for against maxmemory: its key,
+// value, engine overhead and TTL entry
+func (kv *KVStore) MemoryUsage(key string) (int64, bool) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	value, ok := kv.liveValue(key)
+	if !ok {
+		return 0, false
+	}
+	size := kv.entrySize(key, value)
+	if _, ok := kv.expires[key]; ok {
+		size += expireOverhead
+	}
+	return size, true
+}
+
+// RandomKey picks a shard weighted by its number of keys and returns one of its keys
+func (s *ShardedKVStore) RandomKey() (string, bool) {
+	sizes := make([]int, len(s.shards))
+	total := 0
+	for i, shard := range s.shards {
+		sizes[i] = shard.DBSize()
+		total += sizes[i]
+	}
+	if total == 0 {
+		return "", false
+	}
+	r := rand.Intn(total)
+	start := 0
+	for i, size := range sizes {
+		if r < size {
+			start = i
+			break
+		}
+		r -= size
+	}
+	// Fall through to the next shards when the chosen one only had expired keys
+	for i := range s.shards {
+		if key, ok := s.shards[(start+i)%len(s.shards)].RandomKey(); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+func (s *ShardedKVStore) Type(key string) string {
+	return s.shard(key).Type(key)
+}
+
+// Rename locks the shards of both keys, so the move is atomic even across shards
+func (s *ShardedKVStore) Rename(src, dst string) error {
+	unlock := s.lockShards([]string{src, dst})
+	defer unlock()
+	_, err := renameLocked(s.shard(src), s.shard(dst), src, dst, false, EventRenameFrom, EventRenameTo)
+	return err
+}
+
+func (s *ShardedKVStore) RenameNX(src, dst string) (bool, error) {
+	unlock := s.lockShards([]string{src, dst})
+	defer unlock()
+	return renameLocked(s.shard(src), s.shard(dst), src, dst, true, EventRenameFrom, EventRenameTo)
+}
+
+func (s *ShardedKVStore) Copy(src, dst string, replace bool) (bool, error) {
+	unlock := s.lockShards([]string{src, dst})
+	defer unlock()
+	return copyLocked(s.shard(src), s.shard(dst), src, dst, replace)
+}
+
+func (s *ShardedKVStore) DBSize() int {
+	total := 0
+	for _, shard := range s.shards {
+		total += shard.DBSize()
+	}
+	return total
+}
+
+// Flush empties the shards one after the other; it is not atomic across shards
+func (s *ShardedKVStore) Flush(async bool) {
+	for _, shard := range s.shards {
+		shard.Flush(async)
+	}
+}
+
+func (s *ShardedKVStore) Touch(keys ...string) int {
+	n := 0
+	for _, key := range keys {
+		n += s.shard(key).Touch(key)
+	}
+	return n
+}
+
+func (s *ShardedKVStore) Object(key string) (ObjectInfo, bool) {
+	return s.shard(key).Object(key)
+}
+
+func (s *ShardedKVStore) MemoryUsage(key string) (int64, bool) {
+	return s.shard(key).MemoryUsage(key)
+}
@@ -0,0 +1,105 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func keyspaceStores() map[string]KVStoreInterface {
+	return map[string]KVStoreInterface{
+		"map":     New(),
+		"arena":   New(WithEngine(EngineArena)),
+		"sharded": NewSharded(8),
+	}
+}
+
+func TestRenameAndCopy(t *testing.T) {
+	for name, store := range keyspaceStores() {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Rename("missing", "x"); !errors.Is(err, ErrNoSuchKey) {
+				t.Fatalf("Rename of a missing key: %v", err)
+			}
+
+			// Enough keys that some pairs land on different shards
+			for i := 0; i < 20; i++ {
+				src, dst := fmt.Sprintf("src:%d", i), fmt.Sprintf("dst:%d", i)
+				store.Set(src, "v")
+				store.Expire(src, time.Hour)
+				if err := store.Rename(src, dst); err != nil {
+					t.Fatalf("Rename: %v", err)
+				}
+				if store.Exists(src) || store.TTL(dst) <= 0 {
+					t.Fatalf("Rename %s -> %s did not move the key and its TTL", src, dst)
+				}
+				if ok, _ := store.Copy(dst, src, false); !ok || store.TTL(src) <= 0 {
+					t.Fatalf("Copy %s -> %s did not copy the key and its TTL", dst, src)
+				}
+				if ok, _ := store.RenameNX(src, dst); ok {
+					t.Fatal("RenameNX overwrote an existing key")
+				}
+			}
+			if stats := store.Stats(); stats.Keys != 40 || stats.Expires != 40 {
+				t.Fatalf("unexpected stats after renames %+v", stats)
+			}
+
+			if _, err := store.Copy("dst:0", "dst:0", true); !errors.Is(err, ErrSameKey) {
+				t.Fatalf("Copy onto itself: %v", err)
+			}
+		})
+	}
+}
+
+func TestFlushAsync(t *testing.T) {
+	for name, store := range keyspaceStores() {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				store.Set(fmt.Sprintf("key:%d", i), "value")
+			}
+			if _, ok := store.RandomKey(); !ok {
+				t.Fatal("RandomKey found nothing")
+			}
+
+			store.Flush(true)
+			if store.DBSize() != 0 || store.Stats().UsedMemory != 0 {
+				t.Fatalf("Flush left keys behind: %+v", store.Stats())
+			}
+			deadline := time.Now().Add(5 * time.Second)
+			for store.Stats().LazyFreedObjects != 1000 {
+				if time.Now().After(deadline) {
+					t.Fatalf("background free did not finish: %+v", store.Stats())
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			store.Set("after", "flush")
+			if _, ok := store.RandomKey(); !ok || store.DBSize() != 1 {
+				t.Fatal("store unusable after Flush")
+			}
+		})
+	}
+}
+
+func TestUnlinkLeavesArenaCompactionToWrites(t *testing.T) {
+	store := New(WithEngine(EngineArena))
+	arena := store.data.(*arenaStorage)
+	value := strings.Repeat("v", 1024)
+	keys := make([]string, 2*arenaSegmentSize/len(value))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+		store.Set(keys[i], value)
+	}
+
+	if n := store.Unlink(keys[1:]...); n != len(keys)-1 {
+		t.Fatalf("Unlink removed %d keys", n)
+	}
+	if arena.freed == 0 {
+		t.Fatal("Unlink compacted the arena")
+	}
+	store.Del(keys[0])
+	if arena.freed != 0 || arena.used != 0 {
+		t.Fatalf("Del did not compact the arena: used %d, freed %d", arena.used, arena.freed)
+	}
+}
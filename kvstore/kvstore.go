@@ -2,32 +2,168 @@ package kvstore
 
 import (
 	"errors"
-	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type KVStore struct {
-	data map[string]string
-	mu   sync.RWMutex
+	// id orders locks taken on two stores at once, see lockPair
+	id     uint64
+	engine Engine
+	data   storage
+	// index keeps the keys in lexicographic order for Range and Prefix, nil when disabled
+	index *btree
+	// expires maps keys with a TTL to their deadline in unix milliseconds
+	expires map[string]int64
+	// meta holds LRU/LFU bookkeeping, only maintained while the eviction policy needs it
+	meta map[string]*entryMeta
+	// used is the estimated memory held by all entries, see entrySize
+	used      int64
+	maxMemory int64
+	// versions numbers the writes of every key once trackVersions is called,
+	// see versions.go; lastVersion is the latest number handed out
+	versions    map[string]uint64
+	lastVersion uint64
+	// budget is the maxmemory this store shares with the other databases of
+	// a server, nil when it has none
+	budget  *memoryBudget
+	policy  EvictionPolicy
+	samples int
+	evicted atomic.Int64
+	expired atomic.Int64
+	// hits and misses count key lookups by read operations, for keyspace_hits and keyspace_misses
+	hits   atomic.Int64
+	misses atomic.Int64
+	// lazyfreePending and lazyfreed count the keys of flushed keyspaces torn down in the background
+	lazyfreePending atomic.Int64
+	lazyfreed       atomic.Int64
+	mu              sync.RWMutex
+	// events queues the key events for OnKeyEvent listeners; listening is
+	// set while there are any
+	events    keyEvents
+	listening atomic.Bool
 }
 
-func New() *KVStore {
+// Engine selects how a KVStore keeps its keys and values in memory
+type Engine int
+
+const (
+	// EngineMap stores entries in a plain map[string]string
+	EngineMap Engine = iota
+	// EngineArena stores entries in pointer-free byte arenas the GC does not scan
+	EngineArena
+)
+
+// ErrOOM is returned by writes that would exceed maxmemory when nothing can be evicted
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+type options struct {
+	engine    Engine
+	index     *bool
+	maxMemory int64
+	policy    EvictionPolicy
+	samples   int
+}
+
+// Option configures a KVStore created by New
+type Option func(*options)
+
+// WithEngine selects the storage engine, EngineMap by default
+func WithEngine(engine Engine) Option {
+	return func(o *options) {
+		o.engine = engine
+	}
+}
+
+// WithOrderedIndex enables or disables the ordered key index behind Range,
+// Prefix and prefix SCANs. It is on by default for EngineMap and off for
+// EngineArena, whose point is to keep pointers away from the GC.
+func WithOrderedIndex(enabled bool) Option {
+	return func(o *options) {
+		o.index = &enabled
+	}
+}
+
+// WithMaxMemory limits the estimated memory used by entries; 0 means unlimited
+func WithMaxMemory(bytes int64) Option {
+	return func(o *options) {
+		o.maxMemory = bytes
+	}
+}
+
+// WithEvictionPolicy selects what happens when maxmemory is reached, PolicyNoEviction by default
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+func New(opts ...Option) *KVStore {
+	return newKVStore(applyOptions(opts))
+}
+
+func applyOptions(opts []Option) options {
+	o := options{policy: PolicyNoEviction, samples: defaultEvictionSamples}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// storeIDs hands out KVStore ids
+var storeIDs atomic.Uint64
+
+func newStorage(engine Engine) storage {
+	if engine == EngineArena {
+		return newArenaStorage()
+	}
+	return mapStorage{}
+}
+
+func newKVStore(o options) *KVStore {
+	var index *btree
+	if o.index == nil && o.engine == EngineMap || o.index != nil && *o.index {
+		index = &btree{}
+	}
 	return &KVStore{
-		data: make(map[string]string),
+		id:        storeIDs.Add(1),
+		engine:    o.engine,
+		data:      newStorage(o.engine),
+		index:     index,
+		expires:   make(map[string]int64),
+		meta:      make(map[string]*entryMeta),
+		maxMemory: o.maxMemory,
+		policy:    o.policy,
+		samples:   o.samples,
 	}
 }
 
 func (kv *KVStore) Set(key, value string) error {
 	kv.mu.Lock()
-	defer kv.mu.Unlock()
-	kv.data[key] = value
+	defer kv.unlock()
+	if err := kv.setLocked(key, value); err != nil {
+		return err
+	}
+	kv.notify(EventSet, key)
 	return nil
 }
 
 func (kv *KVStore) Get(key string) (string, error) {
 	kv.mu.RLock()
-	defer kv.mu.RUnlock()
-	if value, ok := kv.data[key]; ok {
+	value, ok := kv.data.get(key)
+	if ok && kv.isExpired(key) {
+		kv.mu.RUnlock()
+		kv.deleteExpired(key)
+		kv.lookup(false)
+		return "", errors.New("key not found")
+	}
+	if ok {
+		kv.touch(key)
+	}
+	kv.mu.RUnlock()
+	kv.lookup(ok)
+	if ok {
 		return value, nil
 	}
 	return "", errors.New("key not found")
@@ -35,66 +171,193 @@ func (kv *KVStore) Get(key string) (string, error) {
 
 func (kv *KVStore) Del(key string) bool {
 	kv.mu.Lock()
-	defer kv.mu.Unlock()
-	if _, ok := kv.data[key]; ok {
-		delete(kv.data, key)
-		return true
+	defer kv.unlock()
+	if kv.isExpired(key) {
+		kv.expireLocked(key)
+		return false
+	}
+	if !kv.delLocked(key) {
+		return false
+	}
+	kv.notify(EventDel, key)
+	return true
+}
+
+// MSet stores all pairs atomically
+func (kv *KVStore) MSet(pairs map[string]string) error {
+	kv.mu.Lock()
+	defer kv.unlock()
+	if err := kv.reserve(kv.pairsDelta(pairs), func(key string) bool { _, ok := pairs[key]; return ok }); err != nil {
+		return err
+	}
+	for key, value := range pairs {
+		kv.storeLocked(key, value)
+		kv.notify(EventSet, key)
+	}
+	return nil
+}
+
+// DelMulti removes the given keys atomically and returns how many existed
+func (kv *KVStore) DelMulti(keys ...string) int {
+	return kv.delMulti(keys, false)
+}
+
+// Unlink is DelMulti for UNLINK: it never compacts the arena engine while
+// holding the lock, and leaves that to later writes
+func (kv *KVStore) Unlink(keys ...string) int {
+	return kv.delMulti(keys, true)
+}
+
+func (kv *KVStore) delMulti(keys []string, lazy bool) int {
+	kv.mu.Lock()
+	defer kv.unlock()
+	deleted := 0
+	for _, key := range keys {
+		if kv.isExpired(key) {
+			kv.expireLocked(key)
+			continue
+		}
+		if kv.removeLocked(key, lazy) {
+			kv.notify(EventDel, key)
+			deleted++
+		}
 	}
-	return false
+	return deleted
 }
 
 func (kv *KVStore) Keys() []string {
 	kv.mu.RLock()
 	defer kv.mu.RUnlock()
-	keys := make([]string, 0, len(kv.data))
-	for k := range kv.data {
-		keys = append(keys, k)
-	}
-	return keys
+	return kv.liveKeys()
 }
 
 func (kv *KVStore) Exists(key string) bool {
 	kv.mu.RLock()
 	defer kv.mu.RUnlock()
-	_, ok := kv.data[key]
+	ok := kv.data.has(key) && !kv.isExpired(key)
+	kv.lookup(ok)
 	return ok
 }
 
-func (kv *KVStore) Scan(cursor int, match string, count int, keyType string) (int, []string) {
+// lookup counts a key lookup by a read operation as a hit or a miss
+func (kv *KVStore) lookup(hit bool) {
+	if hit {
+		kv.hits.Add(1)
+	} else {
+		kv.misses.Add(1)
+	}
+}
+
+func (kv *KVStore) Scan(cursor uint64, match string, count int, keyType string) (uint64, []string) {
 	kv.mu.RLock()
 	defer kv.mu.RUnlock()
+	return scanKeys(kv.scanCandidates(match), cursor, match, count, keyType)
+}
 
-	keys := make([]string, 0, len(kv.data))
-	for k := range kv.data {
-		keys = append(keys, k)
+// scanCandidates returns the live keys a SCAN with match has to look at. When
+// the pattern has a literal prefix only that range of the index is visited.
+// Callers hold kv.mu.
+func (kv *KVStore) scanCandidates(match string) []string {
+	prefix := globLiteralPrefix(match)
+	if prefix == "" || kv.index == nil {
+		return kv.liveKeys()
 	}
+	keys := []string{}
+	kv.index.ascend(prefix, func(k string) bool {
+		if !strings.HasPrefix(k, prefix) {
+			return false
+		}
+		if !kv.isExpired(k) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	return keys
+}
 
-	if cursor >= len(keys) {
-		return 0, []string{}
-	}
+// liveKeys snapshots every key that has not expired yet; callers hold kv.mu
+func (kv *KVStore) liveKeys() []string {
+	keys := make([]string, 0, kv.data.len())
+	kv.data.each(func(k string) bool {
+		if !kv.isExpired(k) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	return keys
+}
 
-	var matchRegex *regexp.Regexp
-	if match != "" {
-		matchRegex = regexp.MustCompile(match)
+// entrySize estimates the memory held by one entry including engine overhead
+func (kv *KVStore) entrySize(key, value string) int64 {
+	return int64(len(key) + len(value) + kv.data.overhead())
+}
+
+// setLocked stores value under key, evicting other keys first if maxmemory
+// requires it. Like Redis SET it clears any TTL. Callers hold kv.mu.
+func (kv *KVStore) setLocked(key, value string) error {
+	delta := kv.entrySize(key, value)
+	if old, ok := kv.data.get(key); ok {
+		delta -= kv.entrySize(key, old)
+	}
+	if err := kv.reserve(delta, func(k string) bool { return k == key }); err != nil {
+		return err
 	}
+	kv.storeLocked(key, value)
+	return nil
+}
 
-	result := []string{}
-	for i := cursor; i < len(keys) && (count == 0 || len(result) < count); i++ {
-		key := keys[i]
-		if matchRegex != nil && !matchRegex.MatchString(key) {
-			continue
-		}
-		// In this simple KV store, all values are strings, so we'll always match "string" type
-		if keyType != "" && keyType != "string" {
-			continue
+// storeLocked writes an entry whose memory has already been reserved
+func (kv *KVStore) storeLocked(key, value string) {
+	if old, ok := kv.data.get(key); ok {
+		kv.addUsed(-kv.entrySize(key, old))
+	} else {
+		if kv.index != nil {
+			kv.index.insert(key)
 		}
-		result = append(result, key)
+		kv.notify(EventNew, key)
 	}
+	kv.data.set(key, value)
+	kv.bumpVersion(key)
+	kv.addUsed(kv.entrySize(key, value))
+	kv.persistLocked(key)
+	if kv.policy.tracksAccess() {
+		kv.meta[key] = newEntryMeta()
+	}
+}
 
-	nextCursor := cursor + len(result)
-	if nextCursor >= len(keys) {
-		nextCursor = 0
+// delLocked removes key and its bookkeeping. Callers hold kv.mu.
+func (kv *KVStore) delLocked(key string) bool {
+	return kv.removeLocked(key, false)
+}
+
+// removeLocked deletes key, with storage.unlink when lazy
+func (kv *KVStore) removeLocked(key string, lazy bool) bool {
+	value, ok := kv.data.get(key)
+	if !ok {
+		return false
+	}
+	if lazy {
+		kv.data.unlink(key)
+	} else {
+		kv.data.del(key)
 	}
+	if kv.index != nil {
+		kv.index.delete(key)
+	}
+	kv.addUsed(-kv.entrySize(key, value))
+	kv.persistLocked(key)
+	delete(kv.meta, key)
+	delete(kv.versions, key)
+	return true
+}
 
-	return nextCursor, result
-}
\ No newline at end of file
+func (kv *KVStore) pairsDelta(pairs map[string]string) int64 {
+	var delta int64
+	for key, value := range pairs {
+		delta += kv.entrySize(key, value)
+		if old, ok := kv.data.get(key); ok {
+			delta -= kv.entrySize(key, old)
+		}
+	}
+	return delta
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math/rand"
 	"runtime"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -29,9 +30,8 @@ type BenchmarkResult struct {
 }
 
 
-func runBenchmark(b *testing.B, numRecords int, timeout time.Duration) BenchmarkResult {
+func runBenchmark(b *testing.B, store KVStoreInterface, numRecords int, timeout time.Duration) BenchmarkResult {
 	b.StopTimer()
-	store := New()
 	keys := make([]string, numRecords)
 	values := make([]string, numRecords)
 
@@ -159,13 +159,94 @@ func runBenchmark(b *testing.B, numRecords int, timeout time.Duration) Benchmark
 }
 
 func BenchmarkKVStore(b *testing.B) {
+	runBenchmarkSizes(b, func() KVStoreInterface { return New() })
+}
+
+func BenchmarkShardedKVStore(b *testing.B) {
+	runBenchmarkSizes(b, func() KVStoreInterface { return NewSharded(0) })
+}
+
+func BenchmarkArenaKVStore(b *testing.B) {
+	runBenchmarkSizes(b, func() KVStoreInterface { return New(WithEngine(EngineArena)) })
+}
+
+// BenchmarkGCPause fills each engine with the largest runRedisBenchmark size
+// and reports the average stop-the-world pause of a forced GC cycle
+func BenchmarkGCPause(b *testing.B) {
+	engines := []struct {
+		name   string
+		engine Engine
+	}{
+		{"Map", EngineMap},
+		{"Arena", EngineArena},
+	}
+
+	for _, e := range engines {
+		b.Run(e.name, func(b *testing.B) {
+			store := New(WithEngine(e.engine))
+			for i := 0; i < 500000; i++ {
+				store.Set(fmt.Sprintf("key:%d:%s", i, randString(5, 10)), randString(50, 1000))
+			}
+			runtime.GC()
+
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			start := time.Now()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+			b.StopTimer()
+			elapsed := time.Since(start)
+			runtime.ReadMemStats(&after)
+
+			cycles := after.NumGC - before.NumGC
+			if cycles > 0 {
+				b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(cycles), "pause-ns/gc")
+				b.ReportMetric(float64(elapsed.Nanoseconds())/float64(cycles), "ns/gc")
+			}
+			runtime.KeepAlive(store)
+		})
+	}
+}
+
+// BenchmarkSetParallel measures raw write throughput as goroutines increase;
+// run with -cpu 1,4,8,16 to compare the single-lock and sharded stores
+func BenchmarkSetParallel(b *testing.B) {
+	stores := []struct {
+		name  string
+		store KVStoreInterface
+	}{
+		{"KVStore", New()},
+		{"Sharded", NewSharded(0)},
+	}
+
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.Intn(len(keys))
+				for pb.Next() {
+					s.store.Set(keys[i%len(keys)], "value")
+					i++
+				}
+			})
+		})
+	}
+}
+
+func runBenchmarkSizes(b *testing.B, newStore func() KVStoreInterface) {
 	sizes := []int{1000, 10000, 500000}//, 100000, 1000000, 1000000000}
 	results := make([]BenchmarkResult, len(sizes))
 	timeout := time.Duration(*timeoutSeconds) * time.Second // Set timeout to 10 minutes
 
 	for i, size := range sizes {
 		b.Run(fmt.Sprintf("Size%d", size), func(b *testing.B) {
-			results[i] = runBenchmark(b, size, timeout)
+			results[i] = runBenchmark(b, newStore(), size, timeout)
 		})
 	}
 
@@ -192,4 +273,85 @@ func BenchmarkKVStore(b *testing.B) {
 			int64(result.Duration / time.Millisecond),
 			status)
 	}
-}
\ No newline at end of file
+}
+
+func TestShardedKVStoreMultiKey(t *testing.T) {
+	store := NewSharded(8)
+	if store.ShardCount() != 8 {
+		t.Fatalf("expected 8 shards, got %d", store.ShardCount())
+	}
+
+	pairs := map[string]string{}
+	keys := []string{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		pairs[key] = fmt.Sprintf("value:%d", i)
+		keys = append(keys, key)
+	}
+
+	// Overlapping multi-key writes from many goroutines must not deadlock
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				store.MSet(pairs)
+				store.DelMulti(keys[j%10:]...)
+			}
+		}()
+	}
+	wg.Wait()
+
+	store.MSet(pairs)
+	if got := len(store.Keys()); got != len(pairs) {
+		t.Fatalf("expected %d keys, got %d", len(pairs), got)
+	}
+	if value, err := store.Get("key:42"); err != nil || value != "value:42" {
+		t.Fatalf("unexpected value %q, %v", value, err)
+	}
+	if deleted := store.DelMulti("key:1", "key:2", "missing"); deleted != 2 {
+		t.Fatalf("expected 2 deletions, got %d", deleted)
+	}
+}
+
+func TestArenaStorage(t *testing.T) {
+	store := New(WithEngine(EngineArena))
+
+	// Overwrite with growing values so dead space builds up and forces compaction
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 1000; i++ {
+			store.Set(fmt.Sprintf("key:%d", i), fmt.Sprintf("%d:%s", round, randString(300, 600)))
+		}
+	}
+	for i := 0; i < 1000; i += 2 {
+		if !store.Del(fmt.Sprintf("key:%d", i)) {
+			t.Fatalf("expected key:%d to be deleted", i)
+		}
+	}
+
+	arena := store.data.(*arenaStorage)
+	if arena.freed*2 >= arena.used && arena.used >= arenaSegmentSize {
+		t.Fatalf("expected compaction, %d of %d bytes are dead", arena.freed, arena.used)
+	}
+	if got := len(store.Keys()); got != 500 {
+		t.Fatalf("expected 500 keys, got %d", got)
+	}
+	for i := 1; i < 1000; i += 2 {
+		value, err := store.Get(fmt.Sprintf("key:%d", i))
+		if err != nil || !strings.HasPrefix(value, "19:") {
+			t.Fatalf("unexpected value for key:%d: %q, %v", i, value, err)
+		}
+	}
+	if store.Exists("key:0") {
+		t.Fatal("key:0 should be gone")
+	}
+
+	// Same-length overwrites happen in place
+	store.Set("fixed", "aaaa")
+	used := arena.used
+	store.Set("fixed", "bbbb")
+	if value, _ := store.Get("fixed"); value != "bbbb" || arena.used != used {
+		t.Fatalf("expected in-place overwrite, got %q", value)
+	}
+}
@@ -0,0 +1,243 @@
+package kvstore
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyHistoryLen is how many samples each event keeps, like Redis
+const latencyHistoryLen = 160
+
+// Latency events recorded by the server
+const (
+	latencyEventCommand     = "command"
+	latencyEventExpireCycle = "expire-cycle"
+)
+
+type latencySample struct {
+	time    time.Time
+	latency time.Duration
+}
+
+// latencyEvent is the history of one event type; history is a ring buffer
+// like the slow log's, next being the index of the next sample
+type latencyEvent struct {
+	history []latencySample
+	next    int
+	max     time.Duration
+}
+
+func (e *latencyEvent) latest() *latencySample {
+	return &e.history[(e.next-1+len(e.history))%len(e.history)]
+}
+
+// latencyMonitor records spikes above a threshold per event type
+type latencyMonitor struct {
+	// threshold is a time.Duration; 0 disables the monitor as in Redis
+	threshold atomic.Int64
+
+	mu     sync.Mutex
+	events map[string]*latencyEvent
+}
+
+func newLatencyMonitor() *latencyMonitor {
+	return &latencyMonitor{events: make(map[string]*latencyEvent)}
+}
+
+// SetLatencyMonitorThreshold makes the latency monitor record events that
+// take at least threshold; 0 disables it
+func (s *RedisServer) SetLatencyMonitorThreshold(threshold time.Duration) {
+	s.latency.threshold.Store(int64(threshold))
+	s.updateConfig(func(cfg *Config) { cfg.LatencyMonitorThreshold = threshold })
+}
+
+// add records a spike of event. Like Redis, samples falling in the same
+// second are merged, keeping the highest latency.
+func (m *latencyMonitor) add(event string, latency time.Duration) {
+	threshold := time.Duration(m.threshold.Load())
+	if threshold <= 0 || latency < threshold {
+		return
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.events[event]
+	if e == nil {
+		e = &latencyEvent{}
+		m.events[event] = e
+	}
+	e.max = max(e.max, latency)
+	if len(e.history) > 0 {
+		if last := e.latest(); last.time.Unix() == now.Unix() {
+			last.latency = max(last.latency, latency)
+			return
+		}
+	}
+	sample := latencySample{now, latency}
+	if len(e.history) < latencyHistoryLen {
+		e.history = append(e.history, sample)
+	} else {
+		e.history[e.next] = sample
+	}
+	e.next = (e.next + 1) % latencyHistoryLen
+}
+
+// eventNames returns the names of the recorded events, sorted. Callers hold m.mu.
+func (m *latencyMonitor) eventNames() []string {
+	names := make([]string, 0, len(m.events))
+	for name := range m.events {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// latestSample is a LATENCY LATEST row
+type latestSample struct {
+	event string
+	latencySample
+	max time.Duration
+}
+
+// latestSamples returns the newest sample and all time maximum of every event
+func (m *latencyMonitor) latestSamples() []latestSample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rows := make([]latestSample, 0, len(m.events))
+	for _, name := range m.eventNames() {
+		e := m.events[name]
+		rows = append(rows, latestSample{name, *e.latest(), e.max})
+	}
+	return rows
+}
+
+// history returns the samples of event, oldest first
+func (m *latencyMonitor) history(event string) []latencySample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.events[event]
+	if e == nil {
+		return nil
+	}
+	samples := make([]latencySample, 0, len(e.history))
+	for i := range e.history {
+		samples = append(samples, e.history[(e.next+i)%len(e.history)])
+	}
+	return samples
+}
+
+// reset forgets the given events, or all of them when none are given, and
+// returns how many were forgotten
+func (m *latencyMonitor) reset(events ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(events) == 0 {
+		n := len(m.events)
+		clear(m.events)
+		return n
+	}
+	n := 0
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+// doctor writes a human readable analysis of the recorded events, in the
+// spirit of LATENCY DOCTOR
+func (m *latencyMonitor) doctor() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	threshold := time.Duration(m.threshold.Load())
+	var b strings.Builder
+	if threshold <= 0 {
+		b.WriteString("The latency monitor is disabled. Enable it with CONFIG SET latency-monitor-threshold <milliseconds>.\n")
+		return b.String()
+	}
+	if len(m.events) == 0 {
+		fmt.Fprintf(&b, "No latency spikes above %d milliseconds were observed. Nothing to report.\n", threshold.Milliseconds())
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "Latency spikes above %d milliseconds were observed for these events:\n\n", threshold.Milliseconds())
+	for i, name := range m.eventNames() {
+		e := m.events[name]
+		var total time.Duration
+		for _, sample := range e.history {
+			total += sample.latency
+		}
+		fmt.Fprintf(&b, "%d. %s: %d latency spikes (average %dms, max %dms). Worst all time event %dms.\n",
+			i+1, name, len(e.history), (total / time.Duration(len(e.history))).Milliseconds(),
+			slices.MaxFunc(e.history, func(a, b latencySample) int { return cmp.Compare(a.latency, b.latency) }).latency.Milliseconds(),
+			e.max.Milliseconds())
+	}
+
+	b.WriteString("\nAdvice:\n\n")
+	if _, ok := m.events[latencyEventCommand]; ok {
+		b.WriteString("- Slow commands were observed. Check SLOWLOG GET for the commands and their arguments; " +
+			"KEYS and large MGET/MSET calls are the usual suspects, SCAN is the incremental alternative to KEYS.\n")
+	}
+	if _, ok := m.events[latencyEventExpireCycle]; ok {
+		b.WriteString("- The active expire cycle was slow. Many keys expiring at the same second cause this; " +
+			"spreading TTLs with some jitter avoids it.\n")
+	}
+	return b.String()
+}
+
+var latencyHelp = []string{
+	"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return a human readable latency analysis report.",
+	"HISTORY <event>",
+	"    Return time-latency samples for the <event> class.",
+	"LATEST",
+	"    Return the latest latency samples for all events.",
+	"RESET [<event> ...]",
+	"    Reset latency data of one or more <event> classes.",
+	"    (default: reset all data for all event classes)",
+	"HELP",
+	"    Print this help.",
+}
+
+// cmdLatency handles LATENCY LATEST, HISTORY event, RESET [event ...], DOCTOR and HELP
+func (c *client) cmdLatency(cmd []string) string {
+	m := c.server.latency
+	switch sub := strings.ToLower(cmd[1]); {
+	case sub == "help" && len(cmd) == 2:
+		return respArray(latencyHelp)
+	case sub == "latest" && len(cmd) == 2:
+		rows := m.latestSamples()
+		replies := make([]string, len(rows))
+		for i, row := range rows {
+			replies[i] = respReplies([]string{
+				respBulk(row.event),
+				respInt(row.time.Unix()),
+				respInt(row.latency.Milliseconds()),
+				respInt(row.max.Milliseconds()),
+			})
+		}
+		return respReplies(replies)
+	case sub == "history" && len(cmd) == 3:
+		samples := m.history(cmd[2])
+		replies := make([]string, len(samples))
+		for i, sample := range samples {
+			replies[i] = respReplies([]string{respInt(sample.time.Unix()), respInt(sample.latency.Milliseconds())})
+		}
+		return respReplies(replies)
+	case sub == "reset":
+		return respInt(int64(m.reset(cmd[2:]...)))
+	case sub == "doctor" && len(cmd) == 2:
+		return respBulk(m.doctor())
+	}
+	return respError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try LATENCY HELP.", cmd[1]))
+}
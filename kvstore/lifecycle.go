@@ -0,0 +1,377 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrServerClosed is returned by Serve and Start once the server shut down
+var ErrServerClosed = errors.New("kvstore: server closed")
+
+var (
+	errShutdownInProgress = errors.New("shutdown already in progress")
+	errShutdownAborted    = errors.New("shutdown aborted")
+)
+
+// shutdownPollInterval is how often a shutdown checks for in-flight commands
+const shutdownPollInterval = time.Millisecond
+
+// lifecycle tracks the listeners, the commands in flight and the shutdown of
+// a server. While a shutdown is in progress commands and new connections are
+// held back until it completes, when they are dropped, or is aborted, when
+// they go on.
+type lifecycle struct {
+	// inflight counts the commands being executed
+	inflight atomic.Int64
+	// stopping is set while a shutdown is in progress, closed once the
+	// server shut down
+	stopping atomic.Bool
+	closed   atomic.Bool
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	// conns holds the connections of other protocols than RESP, which are
+	// not in the client registry
+	conns map[net.Conn]bool
+	// resume is closed when the shutdown in progress ends, abort to abort it
+	resume  chan struct{}
+	abort   chan struct{}
+	aborted bool
+	// done is closed once the server shut down
+	done       chan struct{}
+	expireOnce sync.Once
+	save       func() error
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{listeners: make(map[net.Listener]bool), conns: make(map[net.Conn]bool), done: make(chan struct{})}
+}
+
+// SetSaveFunc sets how the data is persisted when the server shuts down.
+// Shutdown, a cancelled Serve context and SHUTDOWN call it unless NOSAVE is
+// given; when it fails, the shutdown is aborted, unless SHUTDOWN FORCE
+// ignores the error.
+func (s *RedisServer) SetSaveFunc(save func() error) {
+	s.lifecycle.mu.Lock()
+	defer s.lifecycle.mu.Unlock()
+	s.lifecycle.save = save
+}
+
+// Serve listens on the configured addresses and Unix socket, and on the
+// memcached port if there is one, and serves connections until ctx is
+// cancelled, Shutdown is called or a client sends SHUTDOWN. A cancelled ctx
+// shuts the server down gracefully within shutdown-timeout. Serve returns
+// ErrServerClosed once the server shut down.
+func (s *RedisServer) Serve(ctx context.Context) error {
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+	var memcached []net.Listener
+	if cfg := s.config(); cfg.MemcachedPort != 0 {
+		if memcached, err = listenTCP(cfg.Bind, cfg.MemcachedPort); err != nil {
+			for _, listener := range append(listeners, memcached...) {
+				listener.Close()
+			}
+			return err
+		}
+	}
+	errs := make(chan error, len(listeners)+len(memcached))
+	for _, listener := range listeners {
+		fmt.Printf("Redis-compatible server listening on %s\n", listener.Addr())
+		go func() { errs <- s.ServeListener(ctx, listener) }()
+	}
+	for _, listener := range memcached {
+		fmt.Printf("Memcached server listening on %s\n", listener.Addr())
+		go func() { errs <- s.ServeMemcached(ctx, listener) }()
+	}
+	err = ErrServerClosed
+	for range len(listeners) + len(memcached) {
+		if lerr := <-errs; !errors.Is(lerr, ErrServerClosed) {
+			err = lerr
+		}
+	}
+	return err
+}
+
+// ServeListener serves the connections of an existing listener, e.g. one
+// passed by socket activation, like Serve does with its own. It may be called
+// for several listeners at once and takes ownership of listener.
+func (s *RedisServer) ServeListener(ctx context.Context, listener net.Listener) error {
+	return s.serveListener(ctx, listener, func(conn net.Conn) {
+		if !s.admitConn(conn) {
+			conn.Close()
+			return
+		}
+		s.handleConnection(conn)
+	})
+}
+
+// serveListener accepts the connections of listener until the server shuts
+// down and runs serve for each of them in its own goroutine
+func (s *RedisServer) serveListener(ctx context.Context, listener net.Listener, serve func(net.Conn)) error {
+	l := s.lifecycle
+	l.mu.Lock()
+	if l.closed.Load() {
+		l.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	l.listeners[listener] = true
+	l.mu.Unlock()
+	s.ready.Store(true)
+	l.expireOnce.Do(func() { go s.activeExpireCycle() })
+
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config().ShutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(shutdownCtx); err != nil && !errors.Is(err, errShutdownInProgress) {
+			fmt.Println("Error shutting down:", err)
+		}
+	})
+	defer stop()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if l.closed.Load() {
+				<-l.done
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			fmt.Println("Error accepting connection:", err)
+			continue
+		}
+		if !l.admit() {
+			conn.Close()
+			continue
+		}
+		go serve(conn)
+	}
+}
+
+// admit waits while a shutdown is in progress and reports whether the caller
+// may go on, which is false once the server shut down
+func (l *lifecycle) admit() bool {
+	if !l.stopping.Load() {
+		return !l.closed.Load()
+	}
+	l.mu.Lock()
+	resume := l.resume
+	l.mu.Unlock()
+	if resume != nil {
+		<-resume
+	}
+	return !l.closed.Load()
+}
+
+// enter counts a command as in flight, holding it back while a shutdown is in
+// progress. It returns false when the shutdown completed and the command is
+// dropped; otherwise the caller calls exit once the command is done.
+func (l *lifecycle) enter() bool {
+	l.inflight.Add(1)
+	for l.stopping.Load() {
+		l.inflight.Add(-1)
+		if !l.admit() {
+			return false
+		}
+		l.inflight.Add(1)
+	}
+	return true
+}
+
+func (l *lifecycle) exit() {
+	l.inflight.Add(-1)
+}
+
+// track registers a connection that is not a RESP client so that shutting
+// down closes it; it returns false once the server shut down
+func (l *lifecycle) track(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed.Load() {
+		return false
+	}
+	l.conns[conn] = true
+	return true
+}
+
+func (l *lifecycle) untrack(conn net.Conn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.conns, conn)
+}
+
+// Shutdown gracefully shuts the server down: it stops taking new connections
+// and commands, waits for the commands in flight, saves the data with the
+// SetSaveFunc function and closes every connection. When ctx ends first, the
+// connections are closed right away and its error is returned.
+func (s *RedisServer) Shutdown(ctx context.Context) error {
+	return s.shutdown(ctx, shutdownOptions{save: true})
+}
+
+// shutdownOptions are the modifiers of SHUTDOWN
+type shutdownOptions struct {
+	// save runs the save function if there is one
+	save bool
+	// now skips waiting for the commands in flight
+	now bool
+	// force shuts down even when saving fails
+	force bool
+}
+
+func (s *RedisServer) shutdown(ctx context.Context, opts shutdownOptions) error {
+	l := s.lifecycle
+	l.mu.Lock()
+	if l.closed.Load() {
+		l.mu.Unlock()
+		return ErrServerClosed
+	}
+	if l.stopping.Load() {
+		l.mu.Unlock()
+		return errShutdownInProgress
+	}
+	l.resume, l.abort, l.aborted = make(chan struct{}), make(chan struct{}), false
+	abort, save := l.abort, l.save
+	l.stopping.Store(true)
+	l.mu.Unlock()
+	wasReady := s.ready.Swap(false)
+
+	var err error
+	if !opts.now {
+		err = l.drain(ctx, abort)
+	}
+	if errors.Is(err, errShutdownAborted) {
+		s.resume(wasReady)
+		return err
+	}
+	if opts.save && save != nil {
+		if serr := save(); serr != nil {
+			if !opts.force {
+				s.resume(wasReady)
+				return fmt.Errorf("saving: %w", serr)
+			}
+			fmt.Println("Error saving on shutdown:", serr)
+		}
+	}
+
+	l.mu.Lock()
+	l.closed.Store(true)
+	for listener := range l.listeners {
+		listener.Close()
+	}
+	clear(l.listeners)
+	for conn := range l.conns {
+		conn.Close()
+	}
+	clear(l.conns)
+	l.mu.Unlock()
+	for _, c := range s.clients.list() {
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	}
+	l.mu.Lock()
+	l.stopping.Store(false)
+	close(l.resume)
+	close(l.done)
+	l.mu.Unlock()
+	return err
+}
+
+// drain waits until no command is in flight, ctx ends or the shutdown is aborted
+func (l *lifecycle) drain(ctx context.Context, abort chan struct{}) error {
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for l.inflight.Load() > 0 {
+		select {
+		case <-abort:
+			return errShutdownAborted
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	select {
+	case <-abort:
+		return errShutdownAborted
+	default:
+		return nil
+	}
+}
+
+// resume lets the held back connections and commands go on after an aborted
+// or failed shutdown
+func (s *RedisServer) resume(ready bool) {
+	l := s.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopping.Store(false)
+	close(l.resume)
+	s.ready.Store(ready)
+}
+
+// abortShutdown aborts the shutdown in progress; it returns false when there
+// is none
+func (l *lifecycle) abortShutdown() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.stopping.Load() || l.aborted {
+		return false
+	}
+	l.aborted = true
+	close(l.abort)
+	return true
+}
+
+// cmdShutdown handles SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] and SHUTDOWN
+// ABORT. Like Redis, a successful shutdown closes the connection without a
+// reply.
+func (c *client) cmdShutdown(cmd []string) string {
+	s := c.server
+	var opts shutdownOptions
+	var nosave, save, abort bool
+	for _, arg := range cmd[1:] {
+		switch strings.ToLower(arg) {
+		case "nosave":
+			nosave = true
+		case "save":
+			save = true
+		case "now":
+			opts.now = true
+		case "force":
+			opts.force = true
+		case "abort":
+			abort = true
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+	if (nosave && save) || (abort && len(cmd) > 2) {
+		return respError("ERR syntax error")
+	}
+	if abort {
+		if !s.lifecycle.abortShutdown() {
+			return respError("ERR No shutdown in progress.")
+		}
+		return respOK
+	}
+	opts.save = !nosave
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config().ShutdownTimeout)
+	defer cancel()
+	if err := s.shutdown(ctx, opts); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		fmt.Println("Error trying to shut down:", err)
+		return respError("ERR Errors trying to SHUTDOWN. Check logs.")
+	}
+	c.closing = true
+	return ""
+}
@@ -0,0 +1,99 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownCommand(t *testing.T) {
+	server := NewRedisServer(nil)
+	a, _ := pipeClient(t, server)
+	b, _ := pipeClient(t, server)
+
+	if got := b("SHUTDOWN", "ABORT"); got != "-ERR No shutdown in progress." {
+		t.Fatalf("SHUTDOWN ABORT replied %q", got)
+	}
+	saved := 0
+	server.SetSaveFunc(func() error {
+		saved++
+		if saved == 1 {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+	// A failing save aborts the shutdown
+	if got := b("SHUTDOWN"); got != "-ERR Errors trying to SHUTDOWN. Check logs." {
+		t.Fatalf("SHUTDOWN with a failing save replied %q", got)
+	}
+	if got := a("PING"); got != "+PONG" {
+		t.Fatalf("PING after an aborted shutdown replied %q", got)
+	}
+
+	if got := b("SHUTDOWN", "SAVE"); got == "+OK" || strings.HasPrefix(got, "-") {
+		t.Fatalf("SHUTDOWN SAVE replied %q", got)
+	}
+	if got := a("PING"); got == "+PONG" {
+		t.Fatal("connection still served after SHUTDOWN")
+	}
+	if saved != 2 {
+		t.Fatalf("save ran %d times", saved)
+	}
+	if err := server.Shutdown(context.Background()); !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("second Shutdown returned %v", err)
+	}
+}
+
+func TestShutdownWaitsAndAborts(t *testing.T) {
+	server := NewRedisServer(nil)
+	c := &client{server: server}
+	server.handleCommand(c, []string{"CLIENT", "PAUSE", "10000", "WRITE"})
+	// The SET is in flight until the pause ends
+	done := make(chan string)
+	go func() { done <- server.handleCommand(&client{server: server}, []string{"SET", "k", "v"}) }()
+	for server.lifecycle.inflight.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdown := make(chan error)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	for !server.lifecycle.stopping.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	if got := server.handleCommand(c, []string{"SHUTDOWN", "ABORT"}); got != respOK {
+		t.Fatalf("SHUTDOWN ABORT replied %q", got)
+	}
+	if err := <-shutdown; !errors.Is(err, errShutdownAborted) {
+		t.Fatalf("aborted Shutdown returned %v", err)
+	}
+	server.handleCommand(c, []string{"CLIENT", "UNPAUSE"})
+	if got := <-done; got != respOK {
+		t.Fatalf("SET replied %q", got)
+	}
+}
+
+func TestServeContext(t *testing.T) {
+	server := NewRedisServer(nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- server.ServeListener(ctx, listener) }()
+	for !server.ready.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case err := <-served:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Fatalf("Serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after its context was cancelled")
+	}
+}
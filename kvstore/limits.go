@@ -0,0 +1,227 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// OutputBufferLimit bounds the output a client has not read yet. The client
+// is disconnected once it is over Hard bytes, or over Soft bytes for longer
+// than SoftSeconds. 0 disables a limit.
+type OutputBufferLimit struct {
+	Hard, Soft  int64
+	SoftSeconds time.Duration
+}
+
+// OutputBufferLimits holds the limit of each client class of Redis. There is
+// no replication, so the replica limit never applies.
+type OutputBufferLimits struct {
+	Normal, Replica, PubSub OutputBufferLimit
+}
+
+// maxMultibulkLength bounds the number of arguments of a command, so that a
+// client cannot make the server allocate for arguments it never sends. Bulk
+// strings are bounded by maxStringSize, Redis' proto-max-bulk-len.
+const maxMultibulkLength = 1024 * 1024
+
+// defaultOutputBufferLimits are the client-output-buffer-limit defaults of Redis
+var defaultOutputBufferLimits = OutputBufferLimits{
+	Replica: OutputBufferLimit{Hard: 256 << 20, Soft: 64 << 20, SoftSeconds: 60 * time.Second},
+	PubSub:  OutputBufferLimit{Hard: 32 << 20, Soft: 8 << 20, SoftSeconds: 60 * time.Second},
+}
+
+// class returns the limit of a client class as named by CLIENT LIST TYPE
+func (l *OutputBufferLimits) class(name string) *OutputBufferLimit {
+	switch name {
+	case "normal":
+		return &l.Normal
+	case "replica", "slave":
+		return &l.Replica
+	case "pubsub":
+		return &l.PubSub
+	}
+	return nil
+}
+
+// String formats the limits as the value of client-output-buffer-limit
+func (l OutputBufferLimits) String() string {
+	var b strings.Builder
+	for i, class := range []string{"normal", "slave", "pubsub"} {
+		limit := l.class(class)
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s %d %d %d", class, limit.Hard, limit.Soft, int64(limit.SoftSeconds/time.Second))
+	}
+	return b.String()
+}
+
+// parseOutputBufferLimits applies a client-output-buffer-limit value, groups
+// of <class> <hard> <soft> <soft seconds>, to the classes it names
+func parseOutputBufferLimits(value string, limits *OutputBufferLimits) error {
+	args := strings.Fields(value)
+	if len(args) == 0 || len(args)%4 != 0 {
+		return errors.New("wrong number of arguments in buffer limit configuration")
+	}
+	updated := *limits
+	for i := 0; i < len(args); i += 4 {
+		limit := updated.class(strings.ToLower(args[i]))
+		if limit == nil {
+			return fmt.Errorf("invalid client class specified in buffer limit configuration: %s", args[i])
+		}
+		hard, err := ParseMemory(args[i+1])
+		if err != nil {
+			return errors.New("error in hard, soft or soft_seconds setting in buffer limit configuration")
+		}
+		soft, err := ParseMemory(args[i+2])
+		if err != nil {
+			return errors.New("error in hard, soft or soft_seconds setting in buffer limit configuration")
+		}
+		seconds, err := strconv.ParseInt(args[i+3], 10, 64)
+		if err != nil || seconds < 0 {
+			return errors.New("error in hard, soft or soft_seconds setting in buffer limit configuration")
+		}
+		*limit = OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: time.Duration(seconds) * time.Second}
+	}
+	*limits = updated
+	return nil
+}
+
+// connectionLimits holds the limits checked on the hot paths, copied from
+// the configuration whenever it changes
+type connectionLimits struct {
+	maxClients atomic.Int64
+	// timeout and keepAlive are time.Durations, 0 when disabled
+	timeout   atomic.Int64
+	keepAlive atomic.Int64
+	output    atomic.Pointer[OutputBufferLimits]
+}
+
+// applyConnectionLimits makes the connection limits of cfg take effect
+func (s *RedisServer) applyConnectionLimits(cfg *Config) {
+	s.limits.maxClients.Store(int64(cfg.MaxClients))
+	s.limits.timeout.Store(int64(cfg.Timeout))
+	s.limits.keepAlive.Store(int64(cfg.TCPKeepAlive))
+	output := cfg.ClientOutputBufferLimit
+	s.limits.output.Store(&output)
+}
+
+// errMaxClients refuses the connections over maxclients
+var errMaxClients = errors.New("ERR max number of clients reached")
+
+// admitConn sets up a new connection, or refuses it with an error reply
+// because of protected mode or maxclients
+func (s *RedisServer) admitConn(conn net.Conn) bool {
+	if err := s.acceptConn(conn); err != nil {
+		conn.Write([]byte(respError(err.Error())))
+		return false
+	}
+	return true
+}
+
+// acceptConn sets up a new connection of any protocol, or returns why it is
+// refused
+func (s *RedisServer) acceptConn(conn net.Conn) error {
+	addr, _ := conn.RemoteAddr().(*net.TCPAddr)
+	if err := s.admitClient(addr); err != nil {
+		return err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if period := time.Duration(s.limits.keepAlive.Load()); period > 0 {
+			tcp.SetKeepAlive(true)
+			tcp.SetKeepAlivePeriod(period)
+		} else {
+			tcp.SetKeepAlive(false)
+		}
+	}
+	return nil
+}
+
+// admitClient returns why protected mode or maxclients refuse a client from
+// addr, nil for a Unix socket
+func (s *RedisServer) admitClient(addr *net.TCPAddr) error {
+	if s.protectedModeDenies(addr) {
+		return errProtectedMode
+	}
+	if s.metrics.connectedClients.Load() >= s.limits.maxClients.Load() {
+		s.metrics.rejectedConnections.Add(1)
+		return errMaxClients
+	}
+	return nil
+}
+
+// readDeadline returns when an idle client times out, or the zero time when
+// it does not. Like in Redis, pub/sub and MONITOR connections never do.
+func (c *client) readDeadline() time.Time {
+	timeout := time.Duration(c.server.limits.timeout.Load())
+	if timeout <= 0 || c.subs.Load() > 0 || c.server.monitors.isMonitor(c) {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// errOutputBufferLimit is returned for a reply that pushed a client over its
+// output buffer limit
+var errOutputBufferLimit = errors.New("output buffer limit reached")
+
+// reply writes a command reply, accounting for it as pending output while
+// it is written
+func (c *client) reply(reply string) error {
+	n := int64(len(reply))
+	c.omem.Add(n)
+	defer c.omem.Add(-n)
+	if !c.checkOutputBuffer() {
+		return errOutputBufferLimit
+	}
+	return c.write(reply)
+}
+
+// checkOutputBuffer disconnects the client when its pending output is over
+// the hard limit of its class, or has been over the soft limit for too long.
+// It reports whether the client may stay.
+func (c *client) checkOutputBuffer() bool {
+	limits := c.server.limits.output.Load()
+	if limits == nil {
+		return true
+	}
+	limit := limits.class(c.clientType())
+	if limit.Hard == 0 && limit.Soft == 0 {
+		return true
+	}
+	omem := c.omem.Load()
+	if limit.Hard > 0 && omem > limit.Hard {
+		c.closeForOutputBuffer()
+		return false
+	}
+	if limit.Soft == 0 || omem <= limit.Soft {
+		c.softLimitSince.Store(0)
+		return true
+	}
+	now := time.Now().UnixNano()
+	since := c.softLimitSince.Load()
+	if since == 0 {
+		c.softLimitSince.CompareAndSwap(0, now)
+		return true
+	}
+	if time.Duration(now-since) > limit.SoftSeconds {
+		c.closeForOutputBuffer()
+		return false
+	}
+	return true
+}
+
+func (c *client) closeForOutputBuffer() {
+	if c.outputLimited.Swap(true) {
+		return
+	}
+	c.server.metrics.outputBufferDisconnections.Add(1)
+	fmt.Printf("Client id=%d addr=%s closed for overcoming of output buffer limits.\n", c.id, c.addr())
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
@@ -0,0 +1,108 @@
+package kvstore
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutputBufferLimit(t *testing.T) {
+	server := NewRedisServer(nil)
+	c := &client{server: server}
+	if got := server.handleCommand(c, []string{"CONFIG", "SET", "client-output-buffer-limit", "pubsub 1kb 0 0"}); got != respOK {
+		t.Fatalf("CONFIG SET replied %q", got)
+	}
+	want := "normal 0 0 0 slave 268435456 67108864 60 pubsub 1024 0 0"
+	if got := server.config().ClientOutputBufferLimit.String(); got != want {
+		t.Fatalf("client-output-buffer-limit is %q, want %q", got, want)
+	}
+
+	// The subscriber never reads, so the messages pile up in its output
+	sub, _ := pipeClient(t, server)
+	sub("SUBSCRIBE", "news")
+	message := strings.Repeat("x", 300)
+	deadline := time.Now().Add(5 * time.Second)
+	for server.metrics.outputBufferDisconnections.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber over its output buffer limit was not disconnected")
+		}
+		server.handleCommand(c, []string{"PUBLISH", "news", message})
+	}
+	if info := server.info("stats"); !strings.Contains(info, "client_output_buffer_limit_disconnections:1\r\n") {
+		t.Fatalf("INFO stats is missing the disconnection:\n%s", info)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Timeout = 50 * time.Millisecond
+	server := NewRedisServer(cfg)
+	idle, _ := pipeClient(t, server)
+	sub, _ := pipeClient(t, server)
+	idle("PING")
+	sub("SUBSCRIBE", "news")
+	time.Sleep(200 * time.Millisecond)
+	if got := idle("PING"); got == "+PONG" {
+		t.Fatal("idle client was not disconnected")
+	}
+	if got := sub("PING"); got != "*[pong ]" {
+		t.Fatalf("subscribed client got %q after being idle", got)
+	}
+	if got := server.metrics.idleDisconnections.Load(); got != 1 {
+		t.Fatalf("counted %d idle disconnections", got)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxClients = 1
+	server := NewRedisServer(cfg)
+	first, _ := pipeClient(t, server)
+	first("PING")
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	replies := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(local).ReadString('\n')
+		replies <- line
+	}()
+	if server.admitConn(remote) {
+		t.Fatal("connection over maxclients was admitted")
+	}
+	if got := <-replies; got != respError(errMaxClients.Error()) {
+		t.Fatalf("refused connection got %q", got)
+	}
+	if got := server.metrics.rejectedConnections.Load(); got != 1 {
+		t.Fatalf("counted %d rejected connections", got)
+	}
+}
+
+func TestRequestLengthLimits(t *testing.T) {
+	server := NewRedisServer(nil)
+	for request, want := range map[string]string{
+		"*2147483647\r\n":       "-ERR Protocol error: invalid multibulk length\r\n",
+		"*1\r\n$2000000000\r\n": "-ERR Protocol error: invalid bulk length\r\n",
+	} {
+		local, remote := net.Pipe()
+		go server.handleConnection(remote)
+		local.SetDeadline(time.Now().Add(5 * time.Second))
+		go local.Write([]byte(request))
+		reader := bufio.NewReader(local)
+		if reply, _ := reader.ReadString('\n'); reply != want {
+			t.Fatalf("%q replied %q, want %q", request, reply, want)
+		}
+		if _, err := reader.ReadByte(); err == nil {
+			t.Fatalf("%q did not disconnect the client", request)
+		}
+		local.Close()
+	}
+	if got := server.metrics.queryBufferDisconnections.Load(); got != 2 {
+		t.Fatalf("counted %d query buffer disconnections", got)
+	}
+	if info := server.info("stats"); !strings.Contains(info, "client_query_buffer_limit_disconnections:2\r\n") {
+		t.Fatalf("INFO stats is missing the disconnections:\n%s", info)
+	}
+}
@@ -0,0 +1,116 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errProtectedMode is what Redis tells refused clients, minus the advice
+// about passwords, which this server does not have
+var errProtectedMode = errors.New("DENIED Redis is running in protected mode because protected mode is enabled and no bind address is configured. " +
+	"In this mode connections are only accepted from the loopback interface and Unix sockets. " +
+	"If you want to connect from external computers you may adopt one of the following solutions: " +
+	"1) Just disable protected mode sending the command 'CONFIG SET protected-mode no' from the loopback interface by connecting from the same host the server is running, " +
+	"however MAKE SURE the server is not publicly accessible from internet if you do so. Use CONFIG REWRITE to make this change permanent. " +
+	"2) Alternatively you can just disable the protected mode by editing the configuration file, and setting the protected mode option to 'no', and then restarting the server. " +
+	"3) If you started the server manually just for testing, restart it with the '-protected-mode no' option. " +
+	"4) Bind the server to the addresses it should be reachable on with the 'bind' option. " +
+	"NOTE: You only need to do one of the above things in order for the server to start accepting connections from the outside.")
+
+// listen opens a TCP listener for every bind address and one for the Unix
+// socket, as configured
+func (s *RedisServer) listen() (listeners []net.Listener, err error) {
+	cfg := s.config()
+	defer func() {
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+		}
+	}()
+	if cfg.Port != 0 {
+		if listeners, err = listenTCP(cfg.Bind, cfg.Port); err != nil {
+			return listeners, err
+		}
+	}
+	if cfg.UnixSocket != "" {
+		// Like Redis, replace the socket a previous run left behind
+		os.Remove(cfg.UnixSocket)
+		listener, err := net.Listen("unix", cfg.UnixSocket)
+		if err != nil {
+			return listeners, err
+		}
+		listeners = append(listeners, listener)
+		if cfg.UnixSocketPerm != 0 {
+			if err := os.Chmod(cfg.UnixSocket, cfg.UnixSocketPerm); err != nil {
+				return listeners, err
+			}
+		}
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("no address to listen on: set a port, bind address or unixsocket")
+	}
+	return listeners, nil
+}
+
+// listenTCP opens a listener on port for every bind address, or for all
+// interfaces when there are none
+func listenTCP(binds []string, port int) (listeners []net.Listener, err error) {
+	if len(binds) == 0 {
+		binds = []string{""}
+	}
+	for _, bind := range binds {
+		optional := strings.HasPrefix(bind, "-")
+		network, host := bindAddress(strings.TrimPrefix(bind, "-"))
+		listener, err := net.Listen(network, net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			if optional {
+				continue
+			}
+			return listeners, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// bindAddress maps a bind address to the network and host to listen on,
+// keeping IPv4 and IPv6 addresses to their own stack as Redis does
+func bindAddress(bind string) (network, host string) {
+	switch bind {
+	case "":
+		return "tcp", ""
+	case "*":
+		return "tcp4", ""
+	case "::*":
+		return "tcp6", ""
+	}
+	ip := net.ParseIP(bind)
+	switch {
+	case ip == nil:
+		return "tcp", bind
+	case ip.To4() != nil:
+		return "tcp4", bind
+	default:
+		return "tcp6", bind
+	}
+}
+
+// protectedModeDenies reports whether protected mode refuses a client from
+// addr, nil for a Unix socket: it is on, no bind address restricts the
+// listeners and the client is not local
+func (s *RedisServer) protectedModeDenies(addr *net.TCPAddr) bool {
+	cfg := s.config()
+	if !cfg.ProtectedMode || len(cfg.Bind) > 0 {
+		return false
+	}
+	if addr != nil && !addr.IP.IsLoopback() {
+		fmt.Printf("Refused connection from %s in protected mode\n", addr)
+		return true
+	}
+	return false
+}
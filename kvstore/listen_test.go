@@ -0,0 +1,78 @@
+package kvstore
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeUnixSocket(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Port = 0
+	cfg.UnixSocket = filepath.Join(t.TempDir(), "kv.sock")
+	cfg.UnixSocketPerm = 0o700
+	server := NewRedisServer(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Serve(ctx)
+
+	var conn net.Conn
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if conn, err = net.Dial("unix", cfg.UnixSocket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	defer conn.Close()
+	conn.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	if got, err := readReply(bufio.NewReader(conn)); err != nil || got != "+PONG" {
+		t.Fatalf("PING over the Unix socket replied %q, %v", got, err)
+	}
+	info, err := os.Stat(cfg.UnixSocket)
+	if err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("socket mode is %v, %v", info.Mode(), err)
+	}
+}
+
+func TestProtectedMode(t *testing.T) {
+	server := NewRedisServer(nil)
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000}
+	local := &net.TCPAddr{IP: net.IPv6loopback, Port: 4000}
+	if !server.protectedModeDenies(remote) || server.protectedModeDenies(local) || server.protectedModeDenies(nil) {
+		t.Fatal("protected mode must refuse remote clients only")
+	}
+	c := &client{server: server}
+	server.handleCommand(c, []string{"CONFIG", "SET", "protected-mode", "no"})
+	if server.protectedModeDenies(remote) {
+		t.Fatal("protected mode refused a client after it was disabled")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Bind = []string{"192.0.2.10"}
+	if NewRedisServer(cfg).protectedModeDenies(remote) {
+		t.Fatal("protected mode refused a client of an explicitly bound server")
+	}
+}
+
+func TestBindAddress(t *testing.T) {
+	for bind, want := range map[string][2]string{
+		"":          {"tcp", ""},
+		"*":         {"tcp4", ""},
+		"::*":       {"tcp6", ""},
+		"127.0.0.1": {"tcp4", "127.0.0.1"},
+		"::1":       {"tcp6", "::1"},
+		"localhost": {"tcp", "localhost"},
+	} {
+		if network, host := bindAddress(bind); network != want[0] || host != want[1] {
+			t.Fatalf("bindAddress(%q) = %s %s", bind, network, host)
+		}
+	}
+}
@@ -0,0 +1,1143 @@
+package kvstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memcachedVersion is the memcached release whose protocol is served; clients
+// look at it to find out whether the meta commands are available
+const memcachedVersion = "1.6.21"
+
+const (
+	// maxMemcachedKeyLen is the longest key memcached accepts
+	maxMemcachedKeyLen = 250
+	// maxRelativeExpiry is the longest exptime taken as seconds from now,
+	// larger values are Unix timestamps
+	maxRelativeExpiry = 60 * 60 * 24 * 30
+)
+
+const (
+	memcachedError    = "ERROR\r\n"
+	memcachedBadLine  = "CLIENT_ERROR bad command line format\r\n"
+	memcachedBadChunk = "CLIENT_ERROR bad data chunk\r\n"
+	memcachedBadFlag  = "CLIENT_ERROR invalid flag\r\n"
+	memcachedOOM      = "SERVER_ERROR out of memory storing object\r\n"
+	memcachedNaN      = "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+	memcachedBadDelta = "CLIENT_ERROR invalid numeric delta argument\r\n"
+)
+
+var errBadDataChunk = errors.New("bad data chunk")
+
+// memcached is the state of the memcached front-end. The store only holds
+// values, so the client flags memcached clients attach to items are kept
+// here, along with the version of the write that stored them: once the value
+// is changed another way, e.g. over RESP, the flags read as 0.
+type memcached struct {
+	watch sync.Once
+	mu    sync.Mutex
+	flags map[string]itemFlags
+
+	stats memcachedStats
+}
+
+type itemFlags struct {
+	flags   uint32
+	version uint64
+}
+
+// memcachedStats counts what the memcached stats command reports
+type memcachedStats struct {
+	cmdGet, cmdSet, cmdFlush, cmdTouch                    atomic.Int64
+	getHits, getMisses, deleteHits, deleteMisses          atomic.Int64
+	incrHits, incrMisses, decrHits, decrMisses            atomic.Int64
+	casHits, casMisses, casBadval, touchHits, touchMisses atomic.Int64
+}
+
+// versionedStore is a store that numbers the writes of its keys, see
+// versions.go. The versions are the CAS tokens of the memcached front-end.
+type versionedStore interface {
+	trackVersions()
+	getVersion(key string, ttl TTLOption) (string, uint64, bool)
+	updateVersion(key string, ttl TTLOption, fn func(value string, version uint64, exists bool) (string, bool)) (uint64, bool, error)
+	deleteVersion(key string, version uint64) bool
+}
+
+// hashVersions versions the values of stores of other packages by their
+// hash. A write that stores a value the key held before restores its version,
+// so CAS cannot tell it apart from no write at all.
+type hashVersions struct {
+	KVStoreInterface
+}
+
+func hashVersion(value string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	return max(h.Sum64(), 1)
+}
+
+func (h hashVersions) trackVersions() {}
+
+func (h hashVersions) getVersion(key string, ttl TTLOption) (string, uint64, bool) {
+	value, ok := h.GetEx(key, ttl)
+	return value, hashVersion(value), ok
+}
+
+func (h hashVersions) updateVersion(key string, ttl TTLOption, fn func(value string, version uint64, exists bool) (string, bool)) (uint64, bool, error) {
+	var version uint64
+	written, err := h.Update(key, ttl, func(value string, exists bool) (string, bool) {
+		value, ok := fn(value, hashVersion(value), exists)
+		version = hashVersion(value)
+		return value, ok
+	})
+	if !written {
+		version = 0
+	}
+	return version, written, err
+}
+
+func (h hashVersions) deleteVersion(key string, version uint64) bool {
+	values, found := h.MGet(key)
+	return found[0] && hashVersion(values[0]) == version && h.CompareAndDelete(key, values[0])
+}
+
+// versioned returns db as a versionedStore
+func versioned(db KVStoreInterface) versionedStore {
+	if store, ok := db.(versionedStore); ok {
+		return store
+	}
+	return hashVersions{db}
+}
+
+// flagsOf returns the client flags of key while it holds the value of version
+func (m *memcached) flagsOf(key string, version uint64) uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if item, ok := m.flags[key]; ok && item.version == version {
+		return item.flags
+	}
+	return 0
+}
+
+// setFlags records the client flags of the value just stored at key
+func (m *memcached) setFlags(key string, version uint64, flags uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if flags == 0 {
+		delete(m.flags, key)
+		return
+	}
+	if m.flags == nil {
+		m.flags = make(map[string]itemFlags)
+	}
+	m.flags[key] = itemFlags{flags: flags, version: version}
+}
+
+// carryFlags keeps the client flags of key when its value changes from the
+// one of version old to the one of version in place, as append and incr do
+func (m *memcached) carryFlags(key string, old, version uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if item, ok := m.flags[key]; ok && item.version == old {
+		item.version = version
+		m.flags[key] = item
+	}
+}
+
+// watchMemcachedKeys starts numbering the writes of every database, as SWAPDB
+// may make any of them database 0, and drops the flags of keys that leave
+// database 0. Store
+// writes are never made while holding m.mu, so checking that the key is gone
+// under it cannot race with a memcached write recording new flags.
+func (s *RedisServer) watchMemcachedKeys() {
+	m := &s.memcached
+	for _, db := range s.databases() {
+		versioned(db).trackVersions()
+		db.OnKeyEvent(func(ev KeyEvent) {
+			switch ev.Event {
+			case EventDel, EventExpired, EventEvicted, EventRenameFrom, EventMoveFrom:
+			default:
+				return
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if _, ok := m.flags[ev.Key]; ok && s.databases()[0].TTL(ev.Key) == TTLNoKey {
+				delete(m.flags, ev.Key)
+			}
+		})
+	}
+}
+
+// ServeMemcached serves the memcached text and meta protocols on the
+// connections of listener, against the data of database 0, until the server
+// shuts down. Like ServeListener it takes ownership of listener.
+func (s *RedisServer) ServeMemcached(ctx context.Context, listener net.Listener) error {
+	return s.serveListener(ctx, listener, func(conn net.Conn) {
+		defer conn.Close()
+		if err := s.acceptConn(conn); err != nil {
+			conn.Write([]byte("SERVER_ERROR " + err.Error() + "\r\n"))
+			return
+		}
+		s.handleMemcachedConnection(conn)
+	})
+}
+
+// memcachedConn is a connection speaking the memcached protocol
+type memcachedConn struct {
+	server *RedisServer
+	reader *bufio.Reader
+}
+
+type memcachedCommand func(mc *memcachedConn, args []string) string
+
+var memcachedCommands = map[string]memcachedCommand{
+	"get":       (*memcachedConn).cmdGet,
+	"gets":      (*memcachedConn).cmdGet,
+	"set":       (*memcachedConn).cmdStore,
+	"add":       (*memcachedConn).cmdStore,
+	"replace":   (*memcachedConn).cmdStore,
+	"append":    (*memcachedConn).cmdStore,
+	"prepend":   (*memcachedConn).cmdStore,
+	"cas":       (*memcachedConn).cmdStore,
+	"incr":      (*memcachedConn).cmdArith,
+	"decr":      (*memcachedConn).cmdArith,
+	"delete":    (*memcachedConn).cmdDelete,
+	"touch":     (*memcachedConn).cmdTouch,
+	"flush_all": (*memcachedConn).cmdFlushAll,
+	"stats":     (*memcachedConn).cmdStats,
+	"version":   (*memcachedConn).cmdVersion,
+	"verbosity": (*memcachedConn).cmdVerbosity,
+	"mg":        (*memcachedConn).cmdMetaGet,
+	"ms":        (*memcachedConn).cmdMetaSet,
+	"md":        (*memcachedConn).cmdMetaDelete,
+	"ma":        (*memcachedConn).cmdMetaArith,
+	"mn":        (*memcachedConn).cmdMetaNoop,
+}
+
+func (s *RedisServer) handleMemcachedConnection(conn net.Conn) {
+	if !s.lifecycle.track(conn) {
+		return
+	}
+	defer s.lifecycle.untrack(conn)
+	s.memcached.watch.Do(s.watchMemcachedKeys)
+	s.metrics.totalConnections.Add(1)
+	s.metrics.connectedClients.Add(1)
+	defer s.metrics.connectedClients.Add(-1)
+
+	conn = &countingConn{Conn: conn, in: &s.metrics.netInput, out: &s.metrics.netOutput}
+	mc := &memcachedConn{server: s, reader: bufio.NewReader(conn)}
+	writer := bufio.NewWriter(conn)
+	for {
+		// Pipelined commands are answered together
+		if mc.reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+		var deadline time.Time
+		if timeout := time.Duration(s.limits.timeout.Load()); timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		conn.SetReadDeadline(deadline)
+		line, err := mc.reader.ReadSlice('\n')
+		if err != nil {
+			switch {
+			case errors.Is(err, bufio.ErrBufferFull):
+				conn.Write([]byte("CLIENT_ERROR line too long\r\n"))
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				s.metrics.idleDisconnections.Add(1)
+			case err != io.EOF:
+				fmt.Printf("Error reading memcached command: %v\n", err)
+			}
+			return
+		}
+		args := strings.Fields(string(line))
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" {
+			writer.Flush()
+			return
+		}
+
+		if !s.lifecycle.enter() {
+			return
+		}
+		reply := memcachedError
+		if command, ok := memcachedCommands[args[0]]; ok {
+			reply = command(mc, args)
+		}
+		s.lifecycle.exit()
+		writer.WriteString(reply)
+	}
+}
+
+func (mc *memcachedConn) db() KVStoreInterface {
+	return mc.server.databases()[0]
+}
+
+// readData reads the data block of a storage command
+func (mc *memcachedConn) readData(size int) (string, error) {
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(mc.reader, data); err != nil {
+		return "", err
+	}
+	if string(data[size:]) != "\r\n" {
+		return "", errBadDataChunk
+	}
+	return string(data[:size]), nil
+}
+
+func validMemcachedKey(key string) bool {
+	if len(key) == 0 || len(key) > maxMemcachedKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// memcachedTTL converts an exptime to a TTL option: 0 never expires, up to 30
+// days is relative, anything larger a Unix time and negative already expired
+func memcachedTTL(exptime int64) TTLOption {
+	switch {
+	case exptime == 0:
+		return TTLOption{Persist: true}
+	case exptime < 0:
+		return TTLOption{At: time.Now()}
+	case exptime > maxRelativeExpiry:
+		return TTLOption{At: time.Unix(exptime, 0)}
+	default:
+		return TTLOption{At: time.Now().Add(time.Duration(exptime) * time.Second)}
+	}
+}
+
+// memcachedTTLLeft is the remaining TTL in seconds as mg and ma report it,
+// -1 without one
+func memcachedTTLLeft(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return -1
+	}
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+// storeMode is how a storage command writes
+type storeMode int
+
+const (
+	storeSet storeMode = iota
+	storeAdd
+	storeReplace
+	storeAppend
+	storePrepend
+)
+
+var storeModes = map[string]storeMode{
+	"set": storeSet, "cas": storeSet, "add": storeAdd, "replace": storeReplace,
+	"append": storeAppend, "prepend": storePrepend,
+}
+
+// storeResult is the outcome of a storage or arithmetic command
+type storeResult int
+
+const (
+	stored storeResult = iota
+	notStored
+	exists
+	notFound
+)
+
+// store writes data to key as mode says, only while the value has version
+// cas unless that is 0. Append and prepend keep the TTL and flags. It returns
+// the version of the value stored.
+func (mc *memcachedConn) store(mode storeMode, key, data string, flags uint32, ttl TTLOption, cas uint64) (uint64, storeResult, error) {
+	result := stored
+	var old uint64
+	inPlace := mode == storeAppend || mode == storePrepend
+	if inPlace {
+		ttl = TTLOption{Keep: true}
+	}
+	version, _, err := versioned(mc.db()).updateVersion(key, ttl, func(current string, currentVersion uint64, ok bool) (string, bool) {
+		old = currentVersion
+		var value string
+		switch {
+		case cas != 0 && !ok:
+			result = notFound
+		case cas != 0 && currentVersion != cas:
+			result = exists
+		case mode == storeAdd && ok, mode != storeSet && mode != storeAdd && !ok:
+			result = notStored
+		case inPlace && len(current)+len(data) > maxStringSize:
+			result = notStored
+		case mode == storeAppend:
+			value = current + data
+		case mode == storePrepend:
+			value = data + current
+		default:
+			value = data
+		}
+		return value, result == stored
+	})
+	if err != nil || result != stored {
+		return 0, result, err
+	}
+	if inPlace {
+		mc.server.memcached.carryFlags(key, old, version)
+	} else {
+		mc.server.memcached.setFlags(key, version, flags)
+	}
+	mc.modified(key)
+	return version, stored, nil
+}
+
+// modified tells the RESP clients tracking key that a memcached command
+// changed it, since those writes do not go through trackCommand
+func (mc *memcachedConn) modified(key string) {
+	if s := mc.server; s.tracking.users.Load() > 0 {
+		s.invalidate(nil, []string{key})
+	}
+}
+
+// arithOptions are the parameters of incr, decr and ma
+type arithOptions struct {
+	incr  bool
+	delta uint64
+	// cas makes the change conditional on the CAS token when not 0
+	cas uint64
+	// vivify creates a missing key with the value initial and TTL vivifyTTL
+	vivify    bool
+	initial   uint64
+	vivifyTTL TTLOption
+}
+
+// arith adds delta to or subtracts it from the number at key like memcached
+// does: incr wraps around at 2^64 and decr stops at 0. The TTL is kept. It
+// returns the new number and its version.
+func (mc *memcachedConn) arith(key string, opts arithOptions) (uint64, uint64, storeResult, error) {
+	result := stored
+	var old uint64
+	var n uint64
+	var nan, created bool
+	version, _, err := versioned(mc.db()).updateVersion(key, TTLOption{Keep: true}, func(current string, currentVersion uint64, ok bool) (string, bool) {
+		old = currentVersion
+		switch {
+		case !ok && opts.vivify:
+			n, created = opts.initial, true
+		case !ok:
+			result = notFound
+			return "", false
+		case opts.cas != 0 && currentVersion != opts.cas:
+			result = exists
+			return "", false
+		default:
+			var err error
+			if n, err = strconv.ParseUint(strings.TrimRight(current, " "), 10, 64); err != nil {
+				nan = true
+				return "", false
+			}
+			switch {
+			case opts.incr:
+				n += opts.delta
+			case n < opts.delta:
+				n = 0
+			default:
+				n -= opts.delta
+			}
+		}
+		return strconv.FormatUint(n, 10), true
+	})
+	switch {
+	case err != nil:
+		return 0, 0, result, err
+	case nan:
+		return 0, 0, result, errNotNumeric
+	case result != stored:
+		return 0, 0, result, nil
+	}
+	if created {
+		if !opts.vivifyTTL.At.IsZero() {
+			mc.db().ExpireAt(key, opts.vivifyTTL.At)
+		}
+	} else {
+		mc.server.memcached.carryFlags(key, old, version)
+	}
+	mc.modified(key)
+	return n, version, stored, nil
+}
+
+var errNotNumeric = errors.New("non-numeric value")
+
+// storeError is the reply to a failed write
+func storeError(err error) string {
+	if errors.Is(err, errNotNumeric) {
+		return memcachedNaN
+	}
+	return memcachedOOM
+}
+
+// noreply strips a trailing noreply from args and reports whether it was there
+func noreply(args []string) ([]string, bool) {
+	if len(args) > 0 && args[len(args)-1] == "noreply" {
+		return args[:len(args)-1], true
+	}
+	return args, false
+}
+
+// cmdGet handles get and gets <key>*
+func (mc *memcachedConn) cmdGet(args []string) string {
+	keys := args[1:]
+	if len(keys) == 0 {
+		return memcachedError
+	}
+	for _, key := range keys {
+		if !validMemcachedKey(key) {
+			return memcachedBadLine
+		}
+	}
+	stats := &mc.server.memcached.stats
+	stats.cmdGet.Add(int64(len(keys)))
+	db := versioned(mc.db())
+	var b strings.Builder
+	for _, key := range keys {
+		value, version, ok := db.getVersion(key, TTLOption{})
+		if !ok {
+			stats.getMisses.Add(1)
+			continue
+		}
+		stats.getHits.Add(1)
+		fmt.Fprintf(&b, "VALUE %s %d %d", key, mc.server.memcached.flagsOf(key, version), len(value))
+		if args[0] == "gets" {
+			fmt.Fprintf(&b, " %d", version)
+		}
+		b.WriteString("\r\n" + value + "\r\n")
+	}
+	b.WriteString("END\r\n")
+	return b.String()
+}
+
+// cmdStore handles set, add, replace, append, prepend and cas:
+// <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+func (mc *memcachedConn) cmdStore(args []string) string {
+	args, quiet := noreply(args)
+	n := 5
+	if args[0] == "cas" {
+		n = 6
+	}
+	if len(args) != n {
+		return memcachedBadLine
+	}
+	size, err := strconv.Atoi(args[4])
+	if err != nil || size < 0 {
+		return memcachedBadLine
+	}
+	if size > maxStringSize {
+		mc.reader.Discard(size + 2)
+		return "SERVER_ERROR object too large for cache\r\n"
+	}
+	data, err := mc.readData(size)
+	if err != nil {
+		return memcachedBadChunk
+	}
+	flags, ferr := strconv.ParseUint(args[2], 10, 32)
+	exptime, eerr := strconv.ParseInt(args[3], 10, 64)
+	var cas uint64
+	var cerr error
+	if args[0] == "cas" {
+		cas, cerr = strconv.ParseUint(args[5], 10, 64)
+	}
+	if ferr != nil || eerr != nil || cerr != nil || !validMemcachedKey(args[1]) {
+		return memcachedBadLine
+	}
+
+	stats := &mc.server.memcached.stats
+	stats.cmdSet.Add(1)
+	_, result, err := mc.store(storeModes[args[0]], args[1], data, uint32(flags), memcachedTTL(exptime), cas)
+	if err != nil {
+		return storeError(err)
+	}
+	if args[0] == "cas" {
+		switch result {
+		case stored:
+			stats.casHits.Add(1)
+		case exists:
+			stats.casBadval.Add(1)
+		case notFound:
+			stats.casMisses.Add(1)
+		}
+	}
+	if quiet {
+		return ""
+	}
+	return [...]string{"STORED\r\n", "NOT_STORED\r\n", "EXISTS\r\n", "NOT_FOUND\r\n"}[result]
+}
+
+// cmdArith handles incr and decr <key> <value> [noreply]
+func (mc *memcachedConn) cmdArith(args []string) string {
+	args, quiet := noreply(args)
+	if len(args) != 3 || !validMemcachedKey(args[1]) {
+		return memcachedBadLine
+	}
+	delta, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return memcachedBadDelta
+	}
+	incr := args[0] == "incr"
+	n, _, result, err := mc.arith(args[1], arithOptions{incr: incr, delta: delta})
+	if err != nil {
+		return storeError(err)
+	}
+	mc.server.memcached.stats.countArith(incr, result == stored)
+	switch {
+	case quiet:
+		return ""
+	case result == notFound:
+		return "NOT_FOUND\r\n"
+	}
+	return strconv.FormatUint(n, 10) + "\r\n"
+}
+
+func (stats *memcachedStats) countArith(incr, hit bool) {
+	switch {
+	case incr && hit:
+		stats.incrHits.Add(1)
+	case incr:
+		stats.incrMisses.Add(1)
+	case hit:
+		stats.decrHits.Add(1)
+	default:
+		stats.decrMisses.Add(1)
+	}
+}
+
+// cmdDelete handles delete <key> [noreply]. Like memcached, a legacy 0 time
+// argument is accepted as well.
+func (mc *memcachedConn) cmdDelete(args []string) string {
+	args, quiet := noreply(args)
+	if len(args) == 3 && args[2] == "0" {
+		args = args[:2]
+	}
+	if len(args) != 2 || !validMemcachedKey(args[1]) {
+		return "CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]\r\n"
+	}
+	stats := &mc.server.memcached.stats
+	deleted := mc.db().Del(args[1])
+	if deleted {
+		mc.modified(args[1])
+		stats.deleteHits.Add(1)
+	} else {
+		stats.deleteMisses.Add(1)
+	}
+	switch {
+	case quiet:
+		return ""
+	case deleted:
+		return "DELETED\r\n"
+	}
+	return "NOT_FOUND\r\n"
+}
+
+// cmdTouch handles touch <key> <exptime> [noreply]
+func (mc *memcachedConn) cmdTouch(args []string) string {
+	args, quiet := noreply(args)
+	if len(args) != 3 || !validMemcachedKey(args[1]) {
+		return memcachedBadLine
+	}
+	exptime, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR invalid exptime argument\r\n"
+	}
+	stats := &mc.server.memcached.stats
+	stats.cmdTouch.Add(1)
+	_, ok := mc.db().GetEx(args[1], memcachedTTL(exptime))
+	if ok {
+		stats.touchHits.Add(1)
+	} else {
+		stats.touchMisses.Add(1)
+	}
+	switch {
+	case quiet:
+		return ""
+	case ok:
+		return "TOUCHED\r\n"
+	}
+	return "NOT_FOUND\r\n"
+}
+
+// cmdFlushAll handles flush_all [delay] [noreply], which empties database 0
+// now or after delay seconds
+func (mc *memcachedConn) cmdFlushAll(args []string) string {
+	args, quiet := noreply(args)
+	var delay int64
+	switch len(args) {
+	case 1:
+	case 2:
+		var err error
+		if delay, err = strconv.ParseInt(args[1], 10, 64); err != nil || delay < 0 {
+			return memcachedBadLine
+		}
+	default:
+		return memcachedBadLine
+	}
+	s := mc.server
+	s.memcached.stats.cmdFlush.Add(1)
+	flush := func() {
+		s.databases()[0].Flush(false)
+		s.memcached.mu.Lock()
+		clear(s.memcached.flags)
+		s.memcached.mu.Unlock()
+		// Like FLUSHALL, trackers drop their whole cache
+		if s.tracking.users.Load() > 0 {
+			s.invalidateAll()
+		}
+	}
+	if delay > 0 {
+		time.AfterFunc(time.Duration(delay)*time.Second, flush)
+	} else {
+		flush()
+	}
+	if quiet {
+		return ""
+	}
+	return "OK\r\n"
+}
+
+// cmdStats handles stats, reporting the general statistics
+func (mc *memcachedConn) cmdStats(args []string) string {
+	if len(args) > 1 {
+		return memcachedError
+	}
+	s := mc.server
+	stats := &s.memcached.stats
+	dbStats := mc.db().Stats()
+	now := time.Now()
+	lines := []struct {
+		name  string
+		value any
+	}{
+		{"pid", os.Getpid()},
+		{"uptime", int64(now.Sub(s.metrics.start) / time.Second)},
+		{"time", now.Unix()},
+		{"version", memcachedVersion},
+		{"pointer_size", strconv.IntSize},
+		{"curr_connections", s.metrics.connectedClients.Load()},
+		{"total_connections", s.metrics.totalConnections.Load()},
+		{"rejected_connections", s.metrics.rejectedConnections.Load()},
+		{"cmd_get", stats.cmdGet.Load()},
+		{"cmd_set", stats.cmdSet.Load()},
+		{"cmd_flush", stats.cmdFlush.Load()},
+		{"cmd_touch", stats.cmdTouch.Load()},
+		{"get_hits", stats.getHits.Load()},
+		{"get_misses", stats.getMisses.Load()},
+		{"delete_misses", stats.deleteMisses.Load()},
+		{"delete_hits", stats.deleteHits.Load()},
+		{"incr_misses", stats.incrMisses.Load()},
+		{"incr_hits", stats.incrHits.Load()},
+		{"decr_misses", stats.decrMisses.Load()},
+		{"decr_hits", stats.decrHits.Load()},
+		{"cas_misses", stats.casMisses.Load()},
+		{"cas_hits", stats.casHits.Load()},
+		{"cas_badval", stats.casBadval.Load()},
+		{"touch_hits", stats.touchHits.Load()},
+		{"touch_misses", stats.touchMisses.Load()},
+		{"bytes_read", s.metrics.netInput.Load()},
+		{"bytes_written", s.metrics.netOutput.Load()},
+		{"limit_maxbytes", s.memory.limit.Load()},
+		{"bytes", dbStats.UsedMemory},
+		{"curr_items", dbStats.Keys},
+		{"evictions", dbStats.EvictedKeys},
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		fmt.Fprintf(&b, "STAT %s %v\r\n", line.name, line.value)
+	}
+	b.WriteString("END\r\n")
+	return b.String()
+}
+
+func (mc *memcachedConn) cmdVersion(args []string) string {
+	return "VERSION " + memcachedVersion + "\r\n"
+}
+
+// cmdVerbosity handles verbosity <level> [noreply], which has nothing to set
+func (mc *memcachedConn) cmdVerbosity(args []string) string {
+	args, quiet := noreply(args)
+	if len(args) != 2 {
+		return memcachedError
+	}
+	if quiet {
+		return ""
+	}
+	return "OK\r\n"
+}
+
+// metaFlag is a flag of a meta command: a letter and an optional token
+type metaFlag struct {
+	flag  byte
+	token string
+}
+
+// metaRequest is a parsed meta command
+type metaRequest struct {
+	key string
+	// encodedKey is the key as the client sent it, base64 with the b flag
+	encodedKey string
+	flags      []metaFlag
+}
+
+// parseMetaRequest parses the key and flags of a meta command, accepting the
+// flags in allowed
+func parseMetaRequest(key string, args []string, allowed string) (*metaRequest, string) {
+	req := &metaRequest{key: key, encodedKey: key}
+	for _, arg := range args {
+		if !strings.Contains(allowed, arg[:1]) {
+			return nil, memcachedBadFlag
+		}
+		req.flags = append(req.flags, metaFlag{flag: arg[0], token: arg[1:]})
+	}
+	if req.has('b') {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, "CLIENT_ERROR error decoding key\r\n"
+		}
+		req.key = string(decoded)
+	}
+	if len(req.key) == 0 || len(req.key) > maxMemcachedKeyLen || (!req.has('b') && !validMemcachedKey(key)) {
+		return nil, memcachedBadLine
+	}
+	return req, ""
+}
+
+func (req *metaRequest) has(flag byte) bool {
+	_, ok := req.token(flag)
+	return ok
+}
+
+// token returns the token of the last occurrence of flag
+func (req *metaRequest) token(flag byte) (string, bool) {
+	for i := len(req.flags) - 1; i >= 0; i-- {
+		if req.flags[i].flag == flag {
+			return req.flags[i].token, true
+		}
+	}
+	return "", false
+}
+
+// uint parses the numeric token of flag, def when the flag is not given
+func (req *metaRequest) uint(flag byte, def uint64) (uint64, bool) {
+	token, ok := req.token(flag)
+	if !ok {
+		return def, true
+	}
+	n, err := strconv.ParseUint(token, 10, 64)
+	return n, err == nil
+}
+
+// ttl parses the exptime token of flag; ok is false when it is malformed
+func (req *metaRequest) ttl(flag byte) (ttl TTLOption, given, ok bool) {
+	token, given := req.token(flag)
+	if !given {
+		return TTLOption{}, false, true
+	}
+	exptime, err := strconv.ParseInt(token, 10, 64)
+	if err != nil {
+		return TTLOption{}, true, false
+	}
+	return memcachedTTL(exptime), true, true
+}
+
+// reply formats a meta reply: the status code, the return flags for the
+// requested flags that have one, and the value if there is one
+func (req *metaRequest) reply(code string, ret func(flag byte) (string, bool), value *string) string {
+	var b strings.Builder
+	b.WriteString(code)
+	if value != nil {
+		b.WriteString(" " + strconv.Itoa(len(*value)))
+	}
+	for _, f := range req.flags {
+		switch f.flag {
+		case 'O':
+			b.WriteString(" O" + f.token)
+		case 'k':
+			b.WriteString(" k" + req.encodedKey)
+		case 'b':
+			if req.has('k') {
+				b.WriteString(" b")
+			}
+		default:
+			if ret == nil {
+				continue
+			}
+			if s, ok := ret(f.flag); ok {
+				b.WriteString(" " + string(f.flag) + s)
+			}
+		}
+	}
+	b.WriteString("\r\n")
+	if value != nil {
+		b.WriteString(*value + "\r\n")
+	}
+	return b.String()
+}
+
+// ttlLeft returns the remaining TTL of key for the t return flag
+func (mc *memcachedConn) ttlLeft(key string) string {
+	return strconv.FormatInt(memcachedTTLLeft(mc.db().TTL(key)), 10)
+}
+
+// cmdMetaGet handles mg <key> <flags>*
+func (mc *memcachedConn) cmdMetaGet(args []string) string {
+	if len(args) < 2 {
+		return memcachedBadLine
+	}
+	req, errReply := parseMetaRequest(args[1], args[2:], "bcfkOqstTv")
+	if req == nil {
+		return errReply
+	}
+	ttl, touch, ok := req.ttl('T')
+	if !ok {
+		return memcachedBadLine
+	}
+	stats := &mc.server.memcached.stats
+	stats.cmdGet.Add(1)
+	if !touch {
+		ttl = TTLOption{}
+	}
+	value, version, ok := versioned(mc.db()).getVersion(req.key, ttl)
+	if touch {
+		stats.cmdTouch.Add(1)
+		if ok {
+			stats.touchHits.Add(1)
+		} else {
+			stats.touchMisses.Add(1)
+		}
+	}
+	if !ok {
+		stats.getMisses.Add(1)
+		if req.has('q') {
+			return ""
+		}
+		return "EN\r\n"
+	}
+	stats.getHits.Add(1)
+
+	ret := func(flag byte) (string, bool) {
+		switch flag {
+		case 'c':
+			return strconv.FormatUint(version, 10), true
+		case 'f':
+			return strconv.FormatUint(uint64(mc.server.memcached.flagsOf(req.key, version)), 10), true
+		case 's':
+			return strconv.Itoa(len(value)), true
+		case 't':
+			return mc.ttlLeft(req.key), true
+		}
+		return "", false
+	}
+	if req.has('v') {
+		return req.reply("VA", ret, &value)
+	}
+	return req.reply("HD", ret, nil)
+}
+
+// cmdMetaSet handles ms <key> <datalen> <flags>*
+func (mc *memcachedConn) cmdMetaSet(args []string) string {
+	if len(args) < 3 {
+		return memcachedBadLine
+	}
+	size, err := strconv.Atoi(args[2])
+	if err != nil || size < 0 {
+		return memcachedBadLine
+	}
+	if size > maxStringSize {
+		mc.reader.Discard(size + 2)
+		return "SERVER_ERROR object too large for cache\r\n"
+	}
+	data, err := mc.readData(size)
+	if err != nil {
+		return memcachedBadChunk
+	}
+	req, errReply := parseMetaRequest(args[1], args[3:], "bcCFkOqTM")
+	if req == nil {
+		return errReply
+	}
+	ttl, _, ttlOK := req.ttl('T')
+	flags, flagsOK := req.uint('F', 0)
+	cas, casOK := req.uint('C', 0)
+	mode := storeSet
+	if token, ok := req.token('M'); ok {
+		modes := map[string]storeMode{"S": storeSet, "E": storeAdd, "R": storeReplace, "A": storeAppend, "P": storePrepend}
+		if mode, ok = modes[strings.ToUpper(token)]; !ok {
+			return "CLIENT_ERROR invalid mode for ms\r\n"
+		}
+	}
+	if !ttlOK || !flagsOK || !casOK || flags > 1<<32-1 {
+		return memcachedBadLine
+	}
+
+	stats := &mc.server.memcached.stats
+	stats.cmdSet.Add(1)
+	version, result, err := mc.store(mode, req.key, data, uint32(flags), ttl, cas)
+	if err != nil {
+		return storeError(err)
+	}
+	if cas != 0 {
+		switch result {
+		case stored:
+			stats.casHits.Add(1)
+		case exists:
+			stats.casBadval.Add(1)
+		case notFound:
+			stats.casMisses.Add(1)
+		}
+	}
+	switch result {
+	case notStored:
+		return req.reply("NS", nil, nil)
+	case exists:
+		return req.reply("EX", nil, nil)
+	case notFound:
+		return req.reply("NF", nil, nil)
+	}
+	if req.has('q') {
+		return ""
+	}
+	return req.reply("HD", func(flag byte) (string, bool) {
+		if flag == 'c' {
+			return strconv.FormatUint(version, 10), true
+		}
+		return "", false
+	}, nil)
+}
+
+// cmdMetaDelete handles md <key> <flags>*
+func (mc *memcachedConn) cmdMetaDelete(args []string) string {
+	if len(args) < 2 {
+		return memcachedBadLine
+	}
+	req, errReply := parseMetaRequest(args[1], args[2:], "bCkOq")
+	if req == nil {
+		return errReply
+	}
+	cas, ok := req.uint('C', 0)
+	if !ok {
+		return memcachedBadLine
+	}
+	db := mc.db()
+	result := stored
+	if cas == 0 {
+		if !db.Del(req.key) {
+			result = notFound
+		}
+	} else if !versioned(db).deleteVersion(req.key, cas) {
+		result = exists
+		if !db.Exists(req.key) {
+			result = notFound
+		}
+	}
+	stats := &mc.server.memcached.stats
+	if result == stored {
+		mc.modified(req.key)
+		stats.deleteHits.Add(1)
+	} else {
+		stats.deleteMisses.Add(1)
+	}
+	switch {
+	case result == exists:
+		return req.reply("EX", nil, nil)
+	case req.has('q'):
+		return ""
+	case result == notFound:
+		return req.reply("NF", nil, nil)
+	}
+	return req.reply("HD", nil, nil)
+}
+
+// cmdMetaArith handles ma <key> <flags>*
+func (mc *memcachedConn) cmdMetaArith(args []string) string {
+	if len(args) < 2 {
+		return memcachedBadLine
+	}
+	req, errReply := parseMetaRequest(args[1], args[2:], "bCNJDTMqOktcv")
+	if req == nil {
+		return errReply
+	}
+	opts := arithOptions{incr: true}
+	var deltaOK, initialOK, casOK, vivifyOK, ttlOK, touch bool
+	opts.delta, deltaOK = req.uint('D', 1)
+	opts.initial, initialOK = req.uint('J', 0)
+	opts.cas, casOK = req.uint('C', 0)
+	opts.vivifyTTL, opts.vivify, vivifyOK = req.ttl('N')
+	ttl, touch, ttlOK := req.ttl('T')
+	if token, ok := req.token('M'); ok {
+		switch token {
+		case "I", "i", "+":
+		case "D", "d", "-":
+			opts.incr = false
+		default:
+			return "CLIENT_ERROR invalid mode for ma\r\n"
+		}
+	}
+	if !deltaOK {
+		return memcachedBadDelta
+	}
+	if !initialOK || !casOK || !vivifyOK || !ttlOK {
+		return memcachedBadLine
+	}
+
+	n, version, result, err := mc.arith(req.key, opts)
+	if err != nil {
+		return storeError(err)
+	}
+	mc.server.memcached.stats.countArith(opts.incr, result == stored)
+	switch result {
+	case notFound:
+		return req.reply("NF", nil, nil)
+	case exists:
+		return req.reply("EX", nil, nil)
+	}
+	if touch {
+		if ttl.Persist {
+			mc.db().Persist(req.key)
+		} else {
+			mc.db().ExpireAt(req.key, ttl.At)
+		}
+	}
+	value := strconv.FormatUint(n, 10)
+	ret := func(flag byte) (string, bool) {
+		switch flag {
+		case 'c':
+			return strconv.FormatUint(version, 10), true
+		case 't':
+			return mc.ttlLeft(req.key), true
+		}
+		return "", false
+	}
+	switch {
+	case req.has('v'):
+		return req.reply("VA", ret, &value)
+	case req.has('q'):
+		return ""
+	}
+	return req.reply("HD", ret, nil)
+}
+
+// cmdMetaNoop handles mn, which clients send after quiet commands to learn
+// that they are done
+func (mc *memcachedConn) cmdMetaNoop(args []string) string {
+	return "MN\r\n"
+}
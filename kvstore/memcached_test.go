@@ -0,0 +1,168 @@
+package kvstore
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// memcachedClient connects to the memcached front-end of server and returns a
+// function sending a request and reading the given number of reply lines
+func memcachedClient(t *testing.T, server *RedisServer) func(request string, lines int) string {
+	local, remote := net.Pipe()
+	t.Cleanup(func() { local.Close() })
+	go server.handleMemcachedConnection(remote)
+	reader := bufio.NewReader(local)
+	return func(request string, lines int) string {
+		t.Helper()
+		if _, err := local.Write([]byte(request)); err != nil {
+			t.Fatal(err)
+		}
+		var reply strings.Builder
+		for range lines {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply.WriteString(line)
+		}
+		return reply.String()
+	}
+}
+
+func TestMemcachedText(t *testing.T) {
+	server := NewRedisServer(nil)
+	send := memcachedClient(t, server)
+	// The CAS token is the version of the write, the first one of database 0
+	cas := "1"
+
+	for _, tc := range []struct {
+		request string
+		lines   int
+		want    string
+	}{
+		{"set greeting 42 0 5\r\nhello\r\n", 1, "STORED\r\n"},
+		{"get greeting missing\r\n", 3, "VALUE greeting 42 5\r\nhello\r\nEND\r\n"},
+		{"gets greeting\r\n", 3, "VALUE greeting 42 5 " + cas + "\r\nhello\r\nEND\r\n"},
+		{"add greeting 0 0 1\r\nx\r\n", 1, "NOT_STORED\r\n"},
+		{"replace missing 0 0 1\r\nx\r\n", 1, "NOT_STORED\r\n"},
+		{"cas greeting 7 0 2 99\r\nhi\r\n", 1, "EXISTS\r\n"},
+		{"cas missing 7 0 2 99\r\nhi\r\n", 1, "NOT_FOUND\r\n"},
+		{"cas greeting 7 0 2 " + cas + "\r\nhi\r\n", 1, "STORED\r\n"},
+		// Appending keeps the flags
+		{"append greeting 0 0 1\r\n!\r\n", 1, "STORED\r\n"},
+		{"prepend greeting 0 0 1 noreply\r\n>\r\nget greeting\r\n", 3, "VALUE greeting 7 4\r\n>hi!\r\nEND\r\n"},
+		{"set n 0 0 2\r\n10\r\nincr n 5\r\n", 2, "STORED\r\n15\r\n"},
+		{"decr n 20\r\n", 1, "0\r\n"},
+		{"incr greeting 1\r\n", 1, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{"incr missing 1\r\n", 1, "NOT_FOUND\r\n"},
+		{"touch n 100\r\n", 1, "TOUCHED\r\n"},
+		{"delete n\r\ndelete n\r\n", 2, "DELETED\r\nNOT_FOUND\r\n"},
+		{"set bad 0 0 2\r\nabc\r\n", 1, "CLIENT_ERROR bad data chunk\r\n"},
+		{"bogus\r\n", 1, "ERROR\r\n"},
+	} {
+		if got := send(tc.request, tc.lines); got != tc.want {
+			t.Fatalf("%q replied %q, want %q", tc.request, got, tc.want)
+		}
+	}
+
+	// The items are plain keys of database 0
+	db := server.databases()[0]
+	if value, _ := db.Get("greeting"); value != ">hi!" {
+		t.Fatalf("RESP sees greeting = %q", value)
+	}
+	if db.TTL("n") != TTLNoKey {
+		t.Fatal("deleted key still exists")
+	}
+	// A value written another way drops the flags
+	db.Set("greeting", "hey")
+	if got := send("get greeting\r\n", 3); got != "VALUE greeting 0 3\r\nhey\r\nEND\r\n" {
+		t.Fatalf("get after SET replied %q", got)
+	}
+	if got := send("flush_all\r\nget greeting\r\n", 2); got != "OK\r\nEND\r\n" {
+		t.Fatalf("flush_all replied %q", got)
+	}
+}
+
+func TestMemcachedMeta(t *testing.T) {
+	server := NewRedisServer(nil)
+	send := memcachedClient(t, server)
+	// The CAS token is the version of the write, the first one of database 0
+	cas := "1"
+
+	for _, tc := range []struct {
+		request string
+		lines   int
+		want    string
+	}{
+		{"ms greeting 5 F3 T100 c\r\nhello\r\n", 1, "HD c" + cas + "\r\n"},
+		{"mg greeting v f t k Oabc\r\n", 2, "VA 5 f3 t100 kgreeting Oabc\r\nhello\r\n"},
+		{"mg missing v\r\nmg missing v q\r\nmn\r\n", 2, "EN\r\nMN\r\n"},
+		{"ms greeting 2 C99\r\nhi\r\n", 1, "EX\r\n"},
+		{"ms greeting 1 MA\r\n!\r\nmg greeting v f\r\n", 3, "HD\r\nVA 6 f3\r\nhello!\r\n"},
+		{"ms missing 1 MR\r\nx\r\n", 1, "NS\r\n"},
+		{"ms Z3JlZXRpbmc= 2 b k\r\nhi\r\n", 1, "HD b kZ3JlZXRpbmc=\r\n"},
+		{"ma counter\r\nma counter N0 J10 v\r\n", 3, "NF\r\nVA 2\r\n10\r\n"},
+		{"ma counter MD D4 v\r\n", 2, "VA 1\r\n6\r\n"},
+		{"md greeting C99\r\nmd greeting q\r\nmd greeting\r\n", 2, "EX\r\nNF\r\n"},
+		{"mg greeting x\r\n", 1, "CLIENT_ERROR invalid flag\r\n"},
+	} {
+		if got := send(tc.request, tc.lines); got != tc.want {
+			t.Fatalf("%q replied %q, want %q", tc.request, got, tc.want)
+		}
+	}
+}
+
+// TestMemcachedCASVersions checks that CAS tokens change with every write,
+// even one restoring an earlier value
+func TestMemcachedCASVersions(t *testing.T) {
+	server := NewRedisServer(nil)
+	send := memcachedClient(t, server)
+
+	send("set n 5 0 1\r\n1\r\n", 1)
+	reply := send("gets n\r\n", 3)
+	fields := strings.Fields(strings.SplitN(reply, "\r\n", 2)[0])
+	if len(fields) != 5 {
+		t.Fatalf("gets replied %q", reply)
+	}
+	cas := fields[4]
+	if got := send("incr n 1\r\ndecr n 1\r\n", 2); got != "2\r\n1\r\n" {
+		t.Fatalf("incr and decr replied %q", got)
+	}
+	if got := send("cas n 0 0 1 "+cas+"\r\n9\r\n", 1); got != "EXISTS\r\n" {
+		t.Fatalf("a stale cas replied %q", got)
+	}
+	if got := send("mg n c\r\n", 1); got == "HD c"+cas+"\r\n" {
+		t.Fatal("incr and decr kept the CAS token")
+	}
+	if got := send("md n C"+cas+"\r\n", 1); got != "EX\r\n" {
+		t.Fatalf("a stale md replied %q", got)
+	}
+
+	// Flags are those of the write, not of the value
+	db := server.databases()[0]
+	db.Set("n", "2")
+	db.Set("n", "1")
+	if got := send("get n\r\n", 3); got != "VALUE n 0 1\r\n1\r\nEND\r\n" {
+		t.Fatalf("get after SETs restoring the value replied %q", got)
+	}
+}
+
+// TestMemcachedInvalidatesTracking checks that memcached writes reach the RESP
+// clients tracking the keys
+func TestMemcachedInvalidatesTracking(t *testing.T) {
+	server := NewRedisServer(nil)
+	send := memcachedClient(t, server)
+	a, readA := pipeClient(t, server)
+	a("HELLO", "3")
+	a("CLIENT", "TRACKING", "ON")
+
+	for _, write := range []string{"set k 0 0 1\r\n1\r\n", "incr k 1\r\n", "delete k\r\n"} {
+		a("GET", "k")
+		send(write, 1)
+		if got := readA(); got != ">[invalidate *[k]]" {
+			t.Fatalf("%q sent %q instead of the invalidation", write, got)
+		}
+	}
+}
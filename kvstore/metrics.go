@@ -0,0 +1,118 @@
+package kvstore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the command duration histogram
+var latencyBuckets = [...]time.Duration{
+	10 * time.Microsecond, 25 * time.Microsecond, 50 * time.Microsecond,
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second,
+}
+
+// commandStats are the INFO commandstats counters of one command
+type commandStats struct {
+	calls atomic.Int64
+	nanos atomic.Int64
+	// rejected counts calls refused before running, failed calls that replied with an error
+	rejected atomic.Int64
+	failed   atomic.Int64
+	// buckets counts calls per latencyBuckets bound, the last one those slower than all bounds
+	buckets [len(latencyBuckets) + 1]atomic.Int64
+}
+
+func (st *commandStats) observe(elapsed time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && elapsed > latencyBuckets[i] {
+		i++
+	}
+	st.buckets[i].Add(1)
+}
+
+// metrics holds the server counters INFO reports. Key level counters such as
+// hits, misses and evictions live in the stores and are read through Stats.
+type metrics struct {
+	start time.Time
+	runID string
+
+	connectedClients atomic.Int64
+	totalConnections atomic.Int64
+	totalCommands    atomic.Int64
+	errorReplies     atomic.Int64
+	netInput         atomic.Int64
+	netOutput        atomic.Int64
+	// dirty counts the write commands since the last save
+	dirty atomic.Int64
+	// rejectedConnections counts the connections refused by maxclients, the
+	// disconnections the clients closed for their output buffer, idleness or
+	// a request over the bulk and multibulk length limits
+	rejectedConnections        atomic.Int64
+	outputBufferDisconnections atomic.Int64
+	idleDisconnections         atomic.Int64
+	queryBufferDisconnections  atomic.Int64
+
+	// commands is filled when the server is created and never modified
+	// afterwards, so it is read without a lock
+	commands map[*command]*commandStats
+}
+
+func newMetrics() *metrics {
+	id := make([]byte, 20)
+	rand.Read(id)
+	m := &metrics{
+		start:    time.Now(),
+		runID:    hex.EncodeToString(id),
+		commands: make(map[*command]*commandStats, len(commands)),
+	}
+	for _, c := range commands {
+		m.commands[c] = &commandStats{}
+	}
+	return m
+}
+
+// called records one executed command
+func (m *metrics) called(c *command, elapsed time.Duration, reply string) {
+	stats := m.commands[c]
+	stats.calls.Add(1)
+	stats.nanos.Add(int64(elapsed))
+	stats.observe(elapsed)
+	m.totalCommands.Add(1)
+	if len(reply) > 0 && reply[0] == '-' {
+		stats.failed.Add(1)
+		m.errorReplies.Add(1)
+	} else if c.flags&flagWrite != 0 {
+		m.dirty.Add(1)
+	}
+}
+
+// rejected records a command refused before it ran, such as a wrong number of arguments
+func (m *metrics) rejected(c *command) {
+	m.commands[c].rejected.Add(1)
+	m.errorReplies.Add(1)
+}
+
+// countingConn counts the bytes read from and written to a connection
+type countingConn struct {
+	net.Conn
+	in, out *atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,153 @@
+package kvstore
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// monitorFeed fans the executed commands out to the MONITOR clients through
+// their push queues, so a slow monitor is disconnected rather than holding up
+// the clients it watches
+type monitorFeed struct {
+	// count mirrors len(monitors) so handleCommand can skip the feed with a
+	// single atomic load when nobody is watching
+	count atomic.Int32
+
+	mu       sync.RWMutex
+	monitors map[*client]bool
+}
+
+func newMonitorFeed() *monitorFeed {
+	return &monitorFeed{monitors: make(map[*client]bool)}
+}
+
+// add turns c into a monitor
+func (f *monitorFeed) add(c *client) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.monitors[c] = true
+	f.count.Store(int32(len(f.monitors)))
+}
+
+// remove stops feeding c, if it is a monitor
+func (f *monitorFeed) remove(c *client) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.monitors, c)
+	f.count.Store(int32(len(f.monitors)))
+}
+
+// isMonitor reports whether c receives the feed
+func (f *monitorFeed) isMonitor(c *client) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.monitors[c]
+}
+
+// feed sends cmd, executed by c at start against database db, to every monitor
+func (f *monitorFeed) feed(c *client, db int, start time.Time, cmd []string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "+%d.%06d [%d %s]", start.Unix(), start.Nanosecond()/1000, db, c.addr())
+	for _, arg := range redactArgs(cmd) {
+		b.WriteByte(' ')
+		writeRepr(&b, arg)
+	}
+	b.WriteString("\r\n")
+	line := b.String()
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for m := range f.monitors {
+		m.push(line)
+	}
+}
+
+// redacted replaces secrets in MONITOR and SLOWLOG output, as in Redis
+const redacted = "(redacted)"
+
+// redactArgs returns cmd with passwords replaced by redacted. It returns cmd
+// itself when there is nothing to hide.
+func redactArgs(cmd []string) []string {
+	var hide []int
+	switch strings.ToLower(cmd[0]) {
+	case "auth":
+		for i := 1; i < len(cmd); i++ {
+			hide = append(hide, i)
+		}
+	case "hello":
+		// HELLO [protover [AUTH username password] [SETNAME clientname]]
+		for i := 2; i+2 < len(cmd); i++ {
+			if strings.EqualFold(cmd[i], "auth") {
+				hide = append(hide, i+1, i+2)
+				break
+			}
+		}
+	case "config":
+		// CONFIG SET requirepass and masterauth carry passwords as values
+		if len(cmd) > 2 && strings.EqualFold(cmd[1], "set") {
+			for i := 2; i+1 < len(cmd); i += 2 {
+				if name := strings.ToLower(cmd[i]); name == "requirepass" || name == "masterauth" {
+					hide = append(hide, i+1)
+				}
+			}
+		}
+	}
+	if len(hide) == 0 {
+		return cmd
+	}
+	args := append([]string(nil), cmd...)
+	for _, i := range hide {
+		args[i] = redacted
+	}
+	return args
+}
+
+// writeRepr writes s as a quoted string with escapes for quotes, backslashes
+// and non printable bytes, like Redis' sdscatrepr
+func writeRepr(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; ch {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			if ch < ' ' || ch > '~' {
+				fmt.Fprintf(b, `\x%02x`, ch)
+			} else {
+				b.WriteByte(ch)
+			}
+		}
+	}
+	b.WriteByte('"')
+}
+
+// cmdMonitor handles MONITOR: after the +OK, the connection receives every
+// command the server executes until it closes
+func (c *client) cmdMonitor(cmd []string) string {
+	if c.conn == nil {
+		return respError("ERR MONITOR needs a network connection")
+	}
+	// Hold the writer while registering, so the +OK goes out before the
+	// first fed command and no command after it is missed
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.server.monitors.add(c)
+	if _, err := c.conn.Write([]byte(respOK)); err != nil {
+		c.conn.Close()
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package kvstore
+
+import (
+	"bufio"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMonitorFeed(t *testing.T) {
+	server := NewRedisServer(nil, New(), New())
+	local, remote := net.Pipe()
+	defer local.Close()
+	go server.handleConnection(remote)
+
+	local.SetDeadline(time.Now().Add(5 * time.Second))
+	local.Write([]byte("*1\r\n$7\r\nMONITOR\r\n"))
+	r := bufio.NewReader(local)
+	if line, _ := r.ReadString('\n'); line != "+OK\r\n" {
+		t.Fatalf("MONITOR replied %q", line)
+	}
+
+	c := &client{server: server}
+	server.handleCommand(c, []string{"SELECT", "1"})
+	server.handleCommand(c, []string{"set", "k", "a \"b\"\n\x01"})
+
+	want := []string{
+		`[0 ] "SELECT" "1"`,
+		`[1 ] "set" "k" "a \"b\"\n\x01"`,
+	}
+	stamp := regexp.MustCompile(`^\+\d+\.\d{6} `)
+	for _, w := range want {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stamp.MatchString(line) || strings.TrimSuffix(stamp.ReplaceAllString(line, ""), "\r\n") != w {
+			t.Fatalf("got %q, want %q", line, w)
+		}
+	}
+
+	for _, cmd := range [][]string{
+		{"AUTH", "user", "secret"},
+		{"HELLO", "3", "AUTH", "user", "secret", "SETNAME", "app"},
+		{"CONFIG", "SET", "maxmemory", "1mb", "requirepass", "secret"},
+	} {
+		if got := strings.Join(redactArgs(cmd), " "); strings.Contains(got, "secret") || !strings.Contains(got, redacted) {
+			t.Fatalf("%v was redacted to %q", cmd, got)
+		}
+	}
+
+	local.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for server.monitors.count.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("closed monitor is still registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
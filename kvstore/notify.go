@@ -0,0 +1,165 @@
+package kvstore
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Classes of notify-keyspace-events, with the bits Redis uses
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+	notifyStream               // t
+	notifyKeyMiss              // m
+	notifyModule               // d
+	notifyNew                  // n
+
+	// notifyAll is what A stands for: every class but m and n
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZset |
+		notifyExpired | notifyEvicted | notifyStream | notifyModule
+)
+
+var notifyClassChars = map[rune]int{
+	'K': notifyKeyspace, 'E': notifyKeyevent, 'g': notifyGeneric, '$': notifyString,
+	'l': notifyList, 's': notifySet, 'h': notifyHash, 'z': notifyZset, 'x': notifyExpired,
+	'e': notifyEvicted, 't': notifyStream, 'm': notifyKeyMiss, 'd': notifyModule,
+	'n': notifyNew, 'A': notifyAll,
+}
+
+// eventClasses maps the events of the store to their notification class
+var eventClasses = map[string]int{
+	EventNew:         notifyNew,
+	EventSet:         notifyString,
+	EventSetRange:    notifyString,
+	EventIncrBy:      notifyString,
+	EventIncrByFloat: notifyString,
+	EventAppend:      notifyString,
+	EventDel:         notifyGeneric,
+	EventExpire:      notifyGeneric,
+	EventPersist:     notifyGeneric,
+	EventRenameFrom:  notifyGeneric,
+	EventRenameTo:    notifyGeneric,
+	EventCopyTo:      notifyGeneric,
+	EventMoveFrom:    notifyGeneric,
+	EventMoveTo:      notifyGeneric,
+	EventExpired:     notifyExpired,
+	EventEvicted:     notifyEvicted,
+}
+
+// parseKeyspaceEvents parses a notify-keyspace-events value like "Ex" or
+// "KA". Like Redis, classes without K or E select nothing.
+func parseKeyspaceEvents(value string) (int, error) {
+	flags := 0
+	for _, ch := range value {
+		class, ok := notifyClassChars[ch]
+		if !ok {
+			return 0, fmt.Errorf("invalid notify-keyspace-events class %q", ch)
+		}
+		flags |= class
+	}
+	if flags&(notifyKeyspace|notifyKeyevent) == 0 {
+		return 0, nil
+	}
+	return flags, nil
+}
+
+// keyspaceEvents holds the notify-keyspace-events setting and the listeners
+// the server keeps on its databases while anything needs their key events
+type keyspaceEvents struct {
+	flags atomic.Int64
+
+	mu      sync.Mutex
+	cancels []func()
+}
+
+// SetNotifyKeyspaceEvents selects the keyspace notifications published to
+// __keyspace@<db>__:<key> and __keyevent@<db>__:<event>, with the classes of
+// notify-keyspace-events in Redis, e.g. "Ex" for expired keys. The empty
+// string turns them off.
+func (s *RedisServer) SetNotifyKeyspaceEvents(value string) error {
+	flags, err := parseKeyspaceEvents(value)
+	if err != nil {
+		return err
+	}
+	s.keyspaceEvents.flags.Store(int64(flags))
+	s.updateConfig(func(cfg *Config) { cfg.NotifyKeyspaceEvents = keyspaceEventsString(flags) })
+	s.syncKeyEventHooks()
+	return nil
+}
+
+// syncKeyEventHooks listens to the key events of every database while
+// notifications are on or clients track keys, since expired and evicted keys
+// invalidate them too, and stops listening otherwise
+func (s *RedisServer) syncKeyEventHooks() {
+	e := &s.keyspaceEvents
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	wanted := e.flags.Load() != 0 || s.tracking.users.Load() > 0
+	switch {
+	case wanted && e.cancels == nil:
+		for _, db := range s.databases() {
+			e.cancels = append(e.cancels, db.OnKeyEvent(func(ev KeyEvent) { s.keyEvent(db, ev) }))
+		}
+	case !wanted && e.cancels != nil:
+		for _, cancel := range e.cancels {
+			cancel()
+		}
+		e.cancels = nil
+	}
+}
+
+// keyEvent publishes the notifications of an event in db. The database
+// number is looked up per event since SWAPDB moves stores around.
+func (s *RedisServer) keyEvent(db KVStoreInterface, ev KeyEvent) {
+	if ev.Event == EventExpired || ev.Event == EventEvicted {
+		if s.tracking.users.Load() > 0 {
+			s.invalidate(nil, []string{ev.Key})
+		}
+	}
+	flags := int(s.keyspaceEvents.flags.Load())
+	if flags&eventClasses[ev.Event] == 0 {
+		return
+	}
+	index := slices.Index(s.databases(), db)
+	if index < 0 {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		s.pubsub.publish(fmt.Sprintf("__keyspace@%d__:%s", index, ev.Key), ev.Event)
+	}
+	if flags&notifyKeyevent != 0 {
+		s.pubsub.publish(fmt.Sprintf("__keyevent@%d__:%s", index, ev.Event), ev.Key)
+	}
+}
+
+// keyspaceEventsString formats flags the way CONFIG GET shows them
+func keyspaceEventsString(flags int) string {
+	var b strings.Builder
+	if flags&notifyAll == notifyAll {
+		b.WriteByte('A')
+	}
+	for _, ch := range "g$lshzxetmdn" {
+		class := notifyClassChars[ch]
+		if flags&class != 0 && (class&notifyAll == 0 || flags&notifyAll != notifyAll) {
+			b.WriteRune(ch)
+		}
+	}
+	if flags&notifyKeyspace != 0 {
+		b.WriteByte('K')
+	}
+	if flags&notifyKeyevent != 0 {
+		b.WriteByte('E')
+	}
+	return b.String()
+}
@@ -0,0 +1,111 @@
+package kvstore
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestOnKeyEvent(t *testing.T) {
+	kv := New()
+	var events []KeyEvent
+	cancel := kv.OnKeyEvent(func(ev KeyEvent) {
+		// Listeners run after the lock is released and may use the store
+		kv.Exists(ev.Key)
+		events = append(events, ev)
+	})
+
+	kv.Set("a", "1")
+	kv.IncrBy("a", 2)
+	kv.Rename("a", "b")
+	kv.Expire("b", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	kv.Get("b")
+	kv.Del("missing")
+	cancel()
+	kv.Set("c", "1")
+
+	want := []KeyEvent{
+		{EventNew, "a"}, {EventSet, "a"}, {EventIncrBy, "a"},
+		{EventNew, "b"}, {EventRenameFrom, "a"}, {EventRenameTo, "b"},
+		{EventExpire, "b"}, {EventExpired, "b"},
+	}
+	if !slices.Equal(events, want) {
+		t.Fatalf("got events %v, want %v", events, want)
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	server := NewRedisServer(nil, New(), New())
+	if err := server.SetNotifyKeyspaceEvents("Kq"); err == nil {
+		t.Fatal("SetNotifyKeyspaceEvents accepted an unknown class")
+	}
+	if err := server.SetNotifyKeyspaceEvents("KEg$"); err != nil {
+		t.Fatal(err)
+	}
+	sub, read := pipeClient(t, server)
+	send, _ := pipeClient(t, server)
+	sub("PSUBSCRIBE", "__key*@1__:*")
+
+	send("SELECT", "1")
+	send("SET", "k", "v")
+	send("DEL", "k")
+	want := []string{
+		"*[pmessage __key*@1__:* __keyspace@1__:k set]",
+		"*[pmessage __key*@1__:* __keyevent@1__:set k]",
+		"*[pmessage __key*@1__:* __keyspace@1__:k del]",
+		"*[pmessage __key*@1__:* __keyevent@1__:del k]",
+	}
+	for _, w := range want {
+		if got := read(); got != w {
+			t.Fatalf("got %q, want %q", got, w)
+		}
+	}
+
+	// Without K or E nothing is published
+	server.SetNotifyKeyspaceEvents("g$")
+	send("SET", "k", "v")
+	if got := send("PUBLISH", "__keyspace@1__:k", "done"); got != ":1" {
+		t.Fatalf("PUBLISH replied %q", got)
+	}
+	if got := read(); got != "*[pmessage __key*@1__:* __keyspace@1__:k done]" {
+		t.Fatalf("got %q after notifications were turned off", got)
+	}
+}
+
+func TestShardedOnKeyEvent(t *testing.T) {
+	s := NewSharded(8)
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	var events []KeyEvent
+	s.OnKeyEvent(func(ev KeyEvent) {
+		// Every shard is unlocked by the time listeners run
+		for _, key := range keys {
+			s.Exists(key)
+		}
+		events = append(events, ev)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pairs := map[string]string{}
+		for _, key := range keys {
+			pairs[key] = "v"
+		}
+		s.MSet(pairs)
+		s.DelMulti(keys...)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a listener using the store deadlocked a multi-shard write")
+	}
+
+	counts := map[string]int{}
+	for _, ev := range events {
+		counts[ev.Event]++
+	}
+	if counts[EventNew] != len(keys) || counts[EventSet] != len(keys) || counts[EventDel] != len(keys) {
+		t.Fatalf("got events %v", events)
+	}
+}
@@ -0,0 +1,145 @@
+package kvstore
+
+import (
+	"iter"
+	"sort"
+)
+
+// rangeBatchSize is how many entries Range copies per lock acquisition, so
+// the loop body can run, and even write to the store, without holding the lock
+const rangeBatchSize = 256
+
+type pair struct {
+	key, value string
+}
+
+// Range iterates the keys in [start, end) and their values in lexicographic
+// order. An empty end means no upper bound. Entries are read in batches, so
+// writes made during the iteration may or may not be observed.
+func (kv *KVStore) Range(start, end string) iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		from := start
+		for {
+			batch := kv.rangeBatch(from, end)
+			for _, p := range batch {
+				if !yield(p.key, p.value) {
+					return
+				}
+			}
+			if len(batch) < rangeBatchSize {
+				return
+			}
+			// The smallest key greater than the last one returned
+			from = batch[len(batch)-1].key + "\x00"
+		}
+	}
+}
+
+// Prefix iterates the keys starting with prefix and their values in lexicographic order
+func (kv *KVStore) Prefix(prefix string) iter.Seq2[string, string] {
+	return kv.Range(prefix, prefixEnd(prefix))
+}
+
+// prefixEnd is the smallest string greater than every string starting with
+// prefix, or "" when there is none (a prefix of only 0xff bytes)
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// rangeBatch copies up to rangeBatchSize live entries in [from, end)
+func (kv *KVStore) rangeBatch(from, end string) []pair {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	inRange := func(k string) bool {
+		return k >= from && (end == "" || k < end)
+	}
+
+	batch := make([]pair, 0, rangeBatchSize)
+	if kv.index != nil {
+		kv.index.ascend(from, func(k string) bool {
+			if !inRange(k) || len(batch) == rangeBatchSize {
+				return false
+			}
+			if value, ok := kv.data.get(k); ok && !kv.isExpired(k) {
+				batch = append(batch, pair{k, value})
+			}
+			return true
+		})
+		return batch
+	}
+
+	// Without an index every call has to look at all keys
+	keys := []string{}
+	kv.data.each(func(k string) bool {
+		if inRange(k) && !kv.isExpired(k) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	sort.Strings(keys)
+	for _, k := range keys[:min(len(keys), rangeBatchSize)] {
+		value, _ := kv.data.get(k)
+		batch = append(batch, pair{k, value})
+	}
+	return batch
+}
+
+// Range merges the ordered ranges of all shards
+func (s *ShardedKVStore) Range(start, end string) iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		type cursor struct {
+			next func() (string, string, bool)
+			stop func()
+			key  string
+			val  string
+		}
+
+		heads := make([]*cursor, 0, len(s.shards))
+		defer func() {
+			for _, c := range heads {
+				c.stop()
+			}
+		}()
+		for _, shard := range s.shards {
+			next, stop := iter.Pull2(shard.Range(start, end))
+			c := &cursor{next: next, stop: stop}
+			var ok bool
+			if c.key, c.val, ok = next(); ok {
+				heads = append(heads, c)
+			} else {
+				stop()
+			}
+		}
+
+		for len(heads) > 0 {
+			lowest := 0
+			for i, c := range heads {
+				if c.key < heads[lowest].key {
+					lowest = i
+				}
+			}
+			c := heads[lowest]
+			if !yield(c.key, c.val) {
+				return
+			}
+			var ok bool
+			if c.key, c.val, ok = c.next(); !ok {
+				c.stop()
+				heads = append(heads[:lowest], heads[lowest+1:]...)
+			}
+		}
+	}
+}
+
+// Prefix iterates the keys starting with prefix across all shards in lexicographic order
+func (s *ShardedKVStore) Prefix(prefix string) iter.Seq2[string, string] {
+	return s.Range(prefix, prefixEnd(prefix))
+}
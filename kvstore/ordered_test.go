@@ -0,0 +1,114 @@
+package kvstore
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestBtreeMatchesSortedSet(t *testing.T) {
+	tree := &btree{}
+	reference := map[string]bool{}
+
+	for i := 0; i < 50000; i++ {
+		key := fmt.Sprintf("k%05d", rand.Intn(5000))
+		if rand.Intn(3) == 0 {
+			if tree.delete(key) != reference[key] {
+				t.Fatalf("delete(%q) disagreed with the reference", key)
+			}
+			delete(reference, key)
+		} else {
+			if tree.insert(key) == reference[key] {
+				t.Fatalf("insert(%q) disagreed with the reference", key)
+			}
+			reference[key] = true
+		}
+	}
+
+	want := []string{}
+	for key := range reference {
+		want = append(want, key)
+	}
+	sort.Strings(want)
+
+	got := []string{}
+	tree.ascend("", func(key string) bool {
+		got = append(got, key)
+		return true
+	})
+	if tree.len() != len(want) || !slices.Equal(got, want) {
+		t.Fatalf("tree holds %d keys, expected %d in order", tree.len(), len(want))
+	}
+}
+
+func TestRangeAndPrefix(t *testing.T) {
+	for name, store := range map[string]*KVStore{
+		"indexed":   New(),
+		"unindexed": New(WithEngine(EngineArena)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				store.Set(fmt.Sprintf("user:%d:name", i), fmt.Sprintf("name%d", i))
+				store.Set(fmt.Sprintf("order:%d", i), "x")
+			}
+
+			got := []string{}
+			for key, value := range store.Prefix("user:12") {
+				if !strings.HasPrefix(value, "name12") {
+					t.Fatalf("unexpected value %q for %q", value, key)
+				}
+				got = append(got, key)
+				// Writing while iterating must not deadlock
+				store.Set("order:new", "y")
+			}
+			if len(got) != 11 || !slices.IsSorted(got) {
+				t.Fatalf("unexpected prefix result %v", got)
+			}
+
+			count := 0
+			for range store.Range("order:", "order:5") {
+				count++
+			}
+			// order:0-4, order:10-49 and order:100-499 sort below order:5
+			if count != 445 {
+				t.Fatalf("expected 445 keys in range, got %d", count)
+			}
+		})
+	}
+}
+
+func TestShardedPrefixIsOrdered(t *testing.T) {
+	store := NewSharded(16)
+	for i := 0; i < 500; i++ {
+		store.Set(fmt.Sprintf("key:%03d", i), "v")
+	}
+	got := []string{}
+	for key := range store.Prefix("key:1") {
+		got = append(got, key)
+	}
+	if len(got) != 100 || !slices.IsSorted(got) {
+		t.Fatalf("unexpected merged prefix result of %d keys", len(got))
+	}
+}
+
+func TestScanWithPrefixOnlyVisitsMatchingKeys(t *testing.T) {
+	if prefix := globLiteralPrefix("user:123:*"); prefix != "user:123:" {
+		t.Fatalf("unexpected prefix %q", prefix)
+	}
+
+	store := New()
+	for i := 0; i < 100; i++ {
+		store.Set(fmt.Sprintf("user:123:%d", i), "v")
+		store.Set(fmt.Sprintf("other:%d", i), "v")
+	}
+	if candidates := store.scanCandidates("user:123:*"); len(candidates) != 100 {
+		t.Fatalf("expected only the 100 matching keys to be visited, got %d", len(candidates))
+	}
+	_, keys := store.Scan(0, "user:123:*", 1000, "")
+	if len(keys) != 100 {
+		t.Fatalf("expected 100 keys, got %d", len(keys))
+	}
+}
@@ -0,0 +1,165 @@
+package kvstore
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricsPrefix namespaces every metric the server exports
+const metricsPrefix = "gomemkv_"
+
+// MetricsHandler serves the Prometheus endpoint and the Kubernetes probes:
+//
+//	/metrics  metrics in the Prometheus text exposition format
+//	/healthz  200 while the process is able to serve HTTP
+//	/readyz   200 once Start accepts connections, 503 before and after
+func (s *RedisServer) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", s.serveMetrics)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			http.Error(w, "not accepting connections", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// promWriter writes metric families in the text exposition format
+type promWriter struct {
+	w *bufio.Writer
+}
+
+// family writes the HELP and TYPE lines of a metric
+func (p promWriter) family(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+}
+
+// sample writes one value; labels alternate between names and values
+func (p promWriter) sample(name string, value float64, labels ...string) {
+	p.w.WriteString(metricsPrefix + name)
+	if len(labels) > 0 {
+		p.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				p.w.WriteByte(',')
+			}
+			fmt.Fprintf(p.w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		p.w.WriteByte('}')
+	}
+	p.w.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// single writes a metric family with one unlabeled sample
+func (p promWriter) single(name, kind, help string, value float64) {
+	p.family(name, kind, help)
+	p.sample(name, value)
+}
+
+// labelEscaper escapes label values as the exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (s *RedisServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p := promWriter{bufio.NewWriter(w)}
+	defer p.w.Flush()
+	m := s.metrics
+
+	p.single("uptime_seconds", "gauge", "Seconds since the server started.", time.Since(m.start).Seconds())
+	p.single("connected_clients", "gauge", "Number of client connections.", float64(m.connectedClients.Load()))
+	p.single("connections_received_total", "counter", "Connections accepted by the server.", float64(m.totalConnections.Load()))
+	p.single("rejected_connections_total", "counter", "Connections refused because of maxclients.", float64(m.rejectedConnections.Load()))
+	p.family("client_disconnections_total", "counter", "Clients the server disconnected, by reason.")
+	p.sample("client_disconnections_total", float64(m.outputBufferDisconnections.Load()), "reason", "output_buffer_limit")
+	p.sample("client_disconnections_total", float64(m.idleDisconnections.Load()), "reason", "idle_timeout")
+	p.sample("client_disconnections_total", float64(m.queryBufferDisconnections.Load()), "reason", "query_buffer_limit")
+	p.single("commands_processed_total", "counter", "Commands executed by the server.", float64(m.totalCommands.Load()))
+	p.single("error_replies_total", "counter", "Error replies sent, including unknown and rejected commands.", float64(m.errorReplies.Load()))
+	p.single("net_input_bytes_total", "counter", "Bytes read from clients.", float64(m.netInput.Load()))
+	p.single("net_output_bytes_total", "counter", "Bytes written to clients.", float64(m.netOutput.Load()))
+
+	s.writeCommandMetrics(p)
+	s.writeKeyspaceMetrics(p)
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	p.single("go_goroutines", "gauge", "Number of goroutines.", float64(runtime.NumGoroutine()))
+	p.single("go_heap_alloc_bytes", "gauge", "Bytes of allocated heap objects.", float64(ms.HeapAlloc))
+	p.single("go_sys_bytes", "gauge", "Bytes obtained from the operating system.", float64(ms.Sys))
+	p.single("go_gc_cycles_total", "counter", "Completed garbage collection cycles.", float64(ms.NumGC))
+	p.single("go_gc_pause_seconds_total", "counter", "Total time the garbage collector stopped the world.", float64(ms.PauseTotalNs)/1e9)
+}
+
+// writeCommandMetrics writes the per-command counters and latency histograms
+// for every command called at least once
+func (s *RedisServer) writeCommandMetrics(p promWriter) {
+	type entry struct {
+		name  string
+		stats *commandStats
+	}
+	var used []entry
+	for c, st := range s.metrics.commands {
+		if st.calls.Load() > 0 || st.rejected.Load() > 0 {
+			used = append(used, entry{c.name, st})
+		}
+	}
+	slices.SortFunc(used, func(a, b entry) int { return strings.Compare(a.name, b.name) })
+
+	p.family("command_calls_total", "counter", "Calls per command.")
+	for _, e := range used {
+		p.sample("command_calls_total", float64(e.stats.calls.Load()), "cmd", e.name)
+	}
+	p.family("command_failed_calls_total", "counter", "Calls per command that replied with an error.")
+	for _, e := range used {
+		p.sample("command_failed_calls_total", float64(e.stats.failed.Load()), "cmd", e.name)
+	}
+	p.family("command_rejected_calls_total", "counter", "Calls per command refused before running.")
+	for _, e := range used {
+		p.sample("command_rejected_calls_total", float64(e.stats.rejected.Load()), "cmd", e.name)
+	}
+
+	p.family("command_duration_seconds", "histogram", "Time spent executing each command.")
+	for _, e := range used {
+		var cumulative int64
+		for i, bound := range latencyBuckets {
+			cumulative += e.stats.buckets[i].Load()
+			p.sample("command_duration_seconds_bucket", float64(cumulative), "cmd", e.name, "le", strconv.FormatFloat(bound.Seconds(), 'g', -1, 64))
+		}
+		cumulative += e.stats.buckets[len(latencyBuckets)].Load()
+		p.sample("command_duration_seconds_bucket", float64(cumulative), "cmd", e.name, "le", "+Inf")
+		p.sample("command_duration_seconds_sum", float64(e.stats.nanos.Load())/1e9, "cmd", e.name)
+		p.sample("command_duration_seconds_count", float64(cumulative), "cmd", e.name)
+	}
+}
+
+// writeKeyspaceMetrics writes the key counts per database and the memory and
+// eviction counters summed over all databases
+func (s *RedisServer) writeKeyspaceMetrics(p promWriter) {
+	total, perDB := s.keyspaceStats()
+
+	p.family("db_keys", "gauge", "Keys per database.")
+	for i, st := range perDB {
+		p.sample("db_keys", float64(st.Keys), "db", "db"+strconv.Itoa(i))
+	}
+	p.family("db_keys_expiring", "gauge", "Keys with a TTL per database.")
+	for i, st := range perDB {
+		p.sample("db_keys_expiring", float64(st.Expires), "db", "db"+strconv.Itoa(i))
+	}
+	p.single("memory_used_bytes", "gauge", "Estimated memory held by keys and values, as enforced by maxmemory.", float64(total.UsedMemory))
+	p.single("memory_max_bytes", "gauge", "The maxmemory limit, 0 when unlimited.", float64(total.MaxMemory))
+	p.single("evicted_keys_total", "counter", "Keys evicted because of maxmemory.", float64(total.EvictedKeys))
+	p.single("expired_keys_total", "counter", "Keys removed because their TTL passed.", float64(total.ExpiredKeys))
+	p.single("keyspace_hits_total", "counter", "Successful key lookups by read commands.", float64(total.KeyspaceHits))
+	p.single("keyspace_misses_total", "counter", "Failed key lookups by read commands.", float64(total.KeyspaceMisses))
+}
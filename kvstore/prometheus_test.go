@@ -0,0 +1,55 @@
+package kvstore
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	server := NewRedisServer(nil, New(), New())
+	c := &client{server: server}
+	server.handleCommand(c, []string{"SET", "a", "1"})
+	server.handleCommand(c, []string{"GET", "a"})
+	server.handleCommand(c, []string{"GET", "b"})
+
+	handler := server.MetricsHandler()
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		body, _ := io.ReadAll(rec.Body)
+		return rec.Code, string(body)
+	}
+
+	code, body := get("/metrics")
+	if code != http.StatusOK {
+		t.Fatalf("/metrics returned %d", code)
+	}
+	for _, want := range []string{
+		"# TYPE gomemkv_command_duration_seconds histogram\n",
+		`gomemkv_command_calls_total{cmd="get"} 2` + "\n",
+		`gomemkv_command_duration_seconds_bucket{cmd="set",le="+Inf"} 1` + "\n",
+		`gomemkv_command_duration_seconds_count{cmd="get"} 2` + "\n",
+		`gomemkv_db_keys{db="db0"} 1` + "\n",
+		`gomemkv_db_keys{db="db1"} 0` + "\n",
+		"gomemkv_keyspace_hits_total 1\n",
+		"gomemkv_keyspace_misses_total 1\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics lacks %q", want)
+		}
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz returned %d", code)
+	}
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz returned %d before Start", code)
+	}
+	server.ready.Store(true)
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz returned %d once ready", code)
+	}
+}
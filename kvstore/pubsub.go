@@ -0,0 +1,219 @@
+package kvstore
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// pubsub routes PUBLISH messages to the clients subscribed to a channel or to
+// a pattern matching it. Messages go through the push queue of each client.
+type pubsub struct {
+	mu       sync.RWMutex
+	channels map[string]map[*client]bool
+	patterns map[string]map[*client]bool
+}
+
+func newPubsub() *pubsub {
+	return &pubsub{channels: make(map[string]map[*client]bool), patterns: make(map[string]map[*client]bool)}
+}
+
+// subscribe adds c to channel or, with pattern set, to the pattern; it
+// returns false when c was already subscribed
+func (p *pubsub) subscribe(c *client, name string, pattern bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	registry, own := p.channels, &c.channels
+	if pattern {
+		registry, own = p.patterns, &c.patterns
+	}
+	if (*own)[name] {
+		return false
+	}
+	if *own == nil {
+		*own = map[string]bool{}
+	}
+	(*own)[name] = true
+	if registry[name] == nil {
+		registry[name] = map[*client]bool{}
+	}
+	registry[name][c] = true
+	c.subs.Add(1)
+	return true
+}
+
+// unsubscribe removes c from the channel or pattern; it returns false when c
+// was not subscribed
+func (p *pubsub) unsubscribe(c *client, name string, pattern bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	registry, own := p.channels, c.channels
+	if pattern {
+		registry, own = p.patterns, c.patterns
+	}
+	if !own[name] {
+		return false
+	}
+	delete(own, name)
+	delete(registry[name], c)
+	if len(registry[name]) == 0 {
+		delete(registry, name)
+	}
+	c.subs.Add(-1)
+	return true
+}
+
+// subscriptions returns the channels or patterns c is subscribed to, sorted
+func (p *pubsub) subscriptions(c *client, pattern bool) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	own := c.channels
+	if pattern {
+		own = c.patterns
+	}
+	names := make([]string, 0, len(own))
+	for name := range own {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// counts returns how many channels and patterns c is subscribed to
+func (p *pubsub) counts(c *client) (channels, patterns int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(c.channels), len(c.patterns)
+}
+
+// subscribed reports whether c listens to channel, by name or pattern
+func (p *pubsub) subscribed(c *client, channel string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if c.channels[channel] {
+		return true
+	}
+	for pattern := range c.patterns {
+		if GlobMatch(pattern, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish sends message to the subscribers of channel and returns how many
+// clients received it
+func (p *pubsub) publish(channel, message string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	n := 0
+	for c := range p.channels[channel] {
+		c.push(c.frame(respBulk("message"), respBulk(channel), respBulk(message)))
+		n++
+	}
+	for pattern, clients := range p.patterns {
+		if !GlobMatch(pattern, channel) {
+			continue
+		}
+		for c := range clients {
+			c.push(c.frame(respBulk("pmessage"), respBulk(pattern), respBulk(channel), respBulk(message)))
+			n++
+		}
+	}
+	return n
+}
+
+// unsubscribeAll drops every subscription of a closing connection
+func (p *pubsub) unsubscribeAll(c *client) {
+	for _, channel := range p.subscriptions(c, false) {
+		p.unsubscribe(c, channel, false)
+	}
+	for _, pattern := range p.subscriptions(c, true) {
+		p.unsubscribe(c, pattern, true)
+	}
+}
+
+// subscriptionCount is the count sent with (un)subscribe confirmations
+func (c *client) subscriptionCount() int64 {
+	return c.subs.Load()
+}
+
+// cmdSubscribe handles SUBSCRIBE and PSUBSCRIBE. Like Redis, it replies with
+// one confirmation per channel.
+func (c *client) cmdSubscribe(cmd []string) string {
+	pattern := strings.EqualFold(cmd[0], "psubscribe")
+	kind := strings.ToLower(cmd[0])
+	var b strings.Builder
+	for _, name := range cmd[1:] {
+		c.server.pubsub.subscribe(c, name, pattern)
+		b.WriteString(c.frame(respBulk(kind), respBulk(name), respInt(c.subscriptionCount())))
+	}
+	return b.String()
+}
+
+// cmdUnsubscribe handles UNSUBSCRIBE and PUNSUBSCRIBE; without arguments
+// they drop every channel or pattern
+func (c *client) cmdUnsubscribe(cmd []string) string {
+	pattern := strings.EqualFold(cmd[0], "punsubscribe")
+	kind := strings.ToLower(cmd[0])
+	names := cmd[1:]
+	if len(names) == 0 {
+		names = c.server.pubsub.subscriptions(c, pattern)
+		if len(names) == 0 {
+			return c.frame(respBulk(kind), respNil, respInt(c.subscriptionCount()))
+		}
+	}
+	var b strings.Builder
+	for _, name := range names {
+		c.server.pubsub.unsubscribe(c, name, pattern)
+		b.WriteString(c.frame(respBulk(kind), respBulk(name), respInt(c.subscriptionCount())))
+	}
+	return b.String()
+}
+
+func (c *client) cmdPublish(cmd []string) string {
+	return respInt(int64(c.server.pubsub.publish(cmd[1], cmd[2])))
+}
+
+var pubsubHelp = []string{
+	"PUBSUB <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CHANNELS [<pattern>]",
+	"    Return the currently active channels matching a <pattern> (default: '*').",
+	"NUMPAT",
+	"    Return number of subscriptions to patterns.",
+	"NUMSUB [<channel> ...]",
+	"    Return the number of subscribers for the specified channels, excluding",
+	"    pattern subscriptions(default: no channels).",
+	"HELP",
+	"    Print this help.",
+}
+
+// cmdPubsub handles PUBSUB CHANNELS [pattern], NUMSUB [channel ...], NUMPAT and HELP
+func (c *client) cmdPubsub(cmd []string) string {
+	p := c.server.pubsub
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	switch sub := strings.ToLower(cmd[1]); {
+	case sub == "help" && len(cmd) == 2:
+		return respArray(pubsubHelp)
+	case sub == "channels" && len(cmd) <= 3:
+		channels := []string{}
+		for channel := range p.channels {
+			if len(cmd) == 2 || GlobMatch(cmd[2], channel) {
+				channels = append(channels, channel)
+			}
+		}
+		slices.Sort(channels)
+		return respArray(channels)
+	case sub == "numsub":
+		replies := make([]string, 0, 2*(len(cmd)-2))
+		for _, channel := range cmd[2:] {
+			replies = append(replies, respBulk(channel), respInt(int64(len(p.channels[channel]))))
+		}
+		return respReplies(replies)
+	case sub == "numpat" && len(cmd) == 2:
+		return respInt(int64(len(p.patterns)))
+	}
+	return respError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try PUBSUB HELP.", cmd[1]))
+}
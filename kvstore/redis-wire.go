@@ -2,12 +2,18 @@ package kvstore
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 )
 
@@ -18,78 +24,227 @@ type KVStoreInterface interface {
 	Del(key string) bool
 	Keys() []string
 	Exists(key string) bool
-	Scan(cursor int, match string, count int, keyType string) (int, []string) 
-
+	Scan(cursor uint64, match string, count int, keyType string) (uint64, []string)
+	Expire(key string, ttl time.Duration) bool
+	ExpireAt(key string, at time.Time) bool
+	Persist(key string) bool
+	TTL(key string) time.Duration
+	ActiveExpire() int
+	Stats() Stats
+	SetWithOptions(key, value string, opts SetOptions) (SetResult, error)
+	IncrBy(key string, delta int64) (int64, error)
+	IncrByFloat(key string, delta float64) (float64, error)
+	Append(key, value string) (int, error)
+	GetRange(key string, start, end int) string
+	SetRange(key string, offset int, value string) (int, error)
+	StrLen(key string) int
+	GetDel(key string) (string, bool)
+	GetEx(key string, ttl TTLOption) (string, bool)
+	Update(key string, ttl TTLOption, fn func(value string, exists bool) (string, bool)) (bool, error)
+	CompareAndDelete(key, value string) bool
+	MGet(keys ...string) (values []string, found []bool)
+	MSet(pairs map[string]string) error
+	MSetNX(pairs map[string]string) (bool, error)
+	DelMulti(keys ...string) int
+	Unlink(keys ...string) int
+	Type(key string) string
+	Rename(src, dst string) error
+	RenameNX(src, dst string) (bool, error)
+	Copy(src, dst string, replace bool) (bool, error)
+	RandomKey() (string, bool)
+	DBSize() int
+	Flush(async bool)
+	Touch(keys ...string) int
+	Object(key string) (ObjectInfo, bool)
+	MemoryUsage(key string) (int64, bool)
+	OnKeyEvent(fn func(KeyEvent)) (cancel func())
 }
 
+// activeExpireInterval is how often the server removes expired keys nobody accesses
+const activeExpireInterval = 100 * time.Millisecond
+
 // RedisServer represents our Redis-compatible server
 type RedisServer struct {
-	store KVStoreInterface
-	port  int
+	// dbs holds the numbered databases. SWAPDB replaces the slice as a whole,
+	// so a command always sees a consistent mapping without taking a lock.
+	dbs atomic.Pointer[[]KVStoreInterface]
+	// cfg is the current configuration; configSetMu serializes CONFIG SET
+	// and CONFIG REWRITE, whose setters take configMu one at a time
+	cfg         Config
+	configMu    sync.Mutex
+	configSetMu sync.Mutex
+	metrics     *metrics
+	slowlog *slowlog
+	latency *latencyMonitor
+	// monitors receives every executed command for MONITOR
+	monitors *monitorFeed
+	clients  *clientRegistry
+	pause    pauseState
+	pubsub   *pubsub
+	tracking *trackingTable
+	// lifecycle tracks listeners, commands in flight and shutdown
+	lifecycle *lifecycle
+	limits    connectionLimits
+	// keyspaceEvents publishes key changes for notify-keyspace-events
+	keyspaceEvents keyspaceEvents
+	memcached      memcached
+	// memory is the maxmemory the databases share
+	memory *memoryBudget
+	// ready is set once the server accepts connections, for /readyz
+	ready atomic.Bool
 }
 
-// NewRedisServer creates a new RedisServer instance
-func NewRedisServer(store KVStoreInterface) *RedisServer {
-	port := 6379 // Default Redis port
-	if envPort := os.Getenv("REDIS_PORT"); envPort != "" {
-		if p, err := strconv.Atoi(envPort); err == nil {
-			port = p
+// NewRedisServer creates a new RedisServer instance with the configuration
+// cfg, or DefaultConfig() when it is nil, serving dbs as databases 0, 1, ...
+// in order. Without any store it creates cfg.Databases empty ones with the
+// configured engine and eviction policy; stores that are passed keep their
+// own, although CONFIG SET maxmemory-policy changes the policy of all of them.
+// cfg.MaxMemory is a single limit for all the databases together, on top of
+// the limits stores may have of their own.
+func NewRedisServer(cfg *Config, dbs ...KVStoreInterface) *RedisServer {
+	if cfg == nil {
+		cfg = DefaultConfig()
+	}
+	if len(dbs) == 0 {
+		opts := []Option{WithEngine(cfg.Engine), WithEvictionPolicy(cfg.MaxMemoryPolicy)}
+		dbs = make([]KVStoreInterface, max(cfg.Databases, 1))
+		for i := range dbs {
+			dbs[i] = New(opts...)
 		}
 	}
-	return &RedisServer{store: store, port: port}
+	s := &RedisServer{cfg: *cfg, metrics: newMetrics(), slowlog: newSlowlog(), latency: newLatencyMonitor(), monitors: newMonitorFeed(),
+		clients: newClientRegistry(), pubsub: newPubsub(), tracking: newTrackingTable(), lifecycle: newLifecycle(),
+		memory: &memoryBudget{}}
+	s.cfg.Databases = len(dbs)
+	dbs = slices.Clone(dbs)
+	s.dbs.Store(&dbs)
+	s.memory.limit.Store(cfg.MaxMemory)
+	for _, db := range dbs {
+		shareMemory(db, s.memory)
+	}
+
+	s.SetSlowlog(cfg.SlowlogLogSlowerThan, cfg.SlowlogMaxLen)
+	s.SetLatencyMonitorThreshold(cfg.LatencyMonitorThreshold)
+	s.SetTrackingTableMaxKeys(cfg.TrackingTableMaxKeys)
+	s.applyConnectionLimits(cfg)
+	// An invalid value leaves notifications off; Config.Set rejects it
+	s.SetNotifyKeyspaceEvents(cfg.NotifyKeyspaceEvents)
+	return s
 }
 
-// Start begins listening for connections
-func (s *RedisServer) Start() error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
-	if err != nil {
-		return err
-	}
-	defer listener.Close()
+// databases returns the current database mapping; callers must not modify it
+func (s *RedisServer) databases() []KVStoreInterface {
+	return *s.dbs.Load()
+}
 
-	fmt.Printf("Redis-compatible server listening on port %d\n", s.port)
+// Start serves connections on the configured port until the server shuts
+// down, see Serve
+func (s *RedisServer) Start() error {
+	return s.Serve(context.Background())
+}
 
+// activeExpireCycle periodically deletes expired keys that are never read again
+func (s *RedisServer) activeExpireCycle() {
+	ticker := time.NewTicker(activeExpireInterval)
+	defer ticker.Stop()
 	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Println("Error accepting connection:", err)
+		select {
+		case <-ticker.C:
+		case <-s.lifecycle.done:
+			return
+		}
+		// Keys must not expire while CLIENT PAUSE holds writes back
+		if s.pause.active.Load() {
 			continue
 		}
-		go s.handleConnection(conn)
+		start := time.Now()
+		for _, db := range s.databases() {
+			db.ActiveExpire()
+		}
+		s.latency.add(latencyEventExpireCycle, time.Since(start))
 	}
 }
+
 func (s *RedisServer) handleConnection(conn net.Conn) {
 	defer conn.Close()
+	s.metrics.totalConnections.Add(1)
+	s.metrics.connectedClients.Add(1)
+	defer s.metrics.connectedClients.Add(-1)
+
+	conn = &countingConn{Conn: conn, in: &s.metrics.netInput, out: &s.metrics.netOutput}
 	reader := bufio.NewReader(conn)
+	c := s.clients.add(s, conn)
+	defer s.closeClient(c)
+	if s.lifecycle.closed.Load() {
+		return
+	}
 
 	for {
+		conn.SetReadDeadline(c.readDeadline())
 		cmd, err := s.readCommand(reader)
 		if err != nil {
-			if err != io.EOF {
+			switch {
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				s.metrics.idleDisconnections.Add(1)
+			case err != io.EOF:
 				fmt.Printf("Error reading command: %v\n", err)
 			}
+			var perr protocolError
+			if errors.As(err, &perr) {
+				c.reply("-" + perr.Error() + "\r\n")
+			}
 			return
 		}
+		c.qbuf.Store(int64(reader.Buffered()))
 
-		response := s.handleCommand(cmd)
-		conn.Write([]byte(response))
+		response := s.handleCommand(c, cmd)
+		// MONITOR writes its own reply and leaves nothing to send
+		if response != "" {
+			if err := c.reply(response); err != nil {
+				return
+			}
+		}
+		if c.closing {
+			return
+		}
 	}
 }
 
+// protocolError is a malformed request the client is told about before the
+// connection is closed, like Redis' "Protocol error" replies
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "ERR Protocol error: " + string(e)
+}
+
 func (s *RedisServer) readCommand(reader *bufio.Reader) ([]string, error) {
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		return nil, err
-	}
+	var count int
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
 
-	line = strings.TrimSpace(line)
-	if len(line) == 0 || line[0] != '*' {
-		return nil, fmt.Errorf("invalid RESP: expected '*', got %q", line)
-	}
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] != '*' {
+			return nil, fmt.Errorf("invalid RESP: expected '*', got %q", line)
+		}
 
-	count, err := strconv.Atoi(line[1:])
-	if err != nil {
-		return nil, fmt.Errorf("invalid RESP: cannot parse array length: %v", err)
+		count, err = strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid RESP: cannot parse array length: %v", err)
+		}
+		// A null array carries no command, Redis skips it
+		if count != -1 {
+			break
+		}
+	}
+	if count < 0 || count > maxMultibulkLength {
+		if count > 0 {
+			s.metrics.queryBufferDisconnections.Add(1)
+		}
+		return nil, protocolError("invalid multibulk length")
 	}
 
 	cmd := make([]string, count)
@@ -108,6 +263,12 @@ func (s *RedisServer) readCommand(reader *bufio.Reader) ([]string, error) {
 		if err != nil {
 			return nil, fmt.Errorf("invalid RESP: cannot parse string length: %v", err)
 		}
+		if length < 0 || length > maxStringSize {
+			if length > 0 {
+				s.metrics.queryBufferDisconnections.Add(1)
+			}
+			return nil, protocolError("invalid bulk length")
+		}
 
 		value := make([]byte, length)
 		_, err = io.ReadFull(reader, value)
@@ -148,6 +309,8 @@ func parseCommand(cmd string) []string {
 	var parts []string
 	var current string
 	inQuotes := false
+	// quoted keeps a token that was written as "" although it is empty
+	quoted := false
 	escapeNext := false
 
 	for _, char := range cmd {
@@ -158,117 +321,71 @@ func parseCommand(cmd string) []string {
 			escapeNext = true
 		} else if char == '"' {
 			inQuotes = !inQuotes
+			quoted = true
 		} else if unicode.IsSpace(char) && !inQuotes {
-			if current != "" {
+			if current != "" || quoted {
 				parts = append(parts, current)
 				current = ""
+				quoted = false
 			}
 		} else {
 			current += string(char)
 		}
 	}
 
-	if current != "" {
+	if current != "" || quoted {
 		parts = append(parts, current)
 	}
 
 	return parts
 }
-func (s *RedisServer) handleCommand(cmd []string) string {
+
+func (s *RedisServer) handleCommand(c *client, cmd []string) string {
 	if len(cmd) == 0 {
 		return "-ERR empty command\r\n"
 	}
 
-	switch strings.ToLower(cmd[0]) {
-	case "ping":
-		return "+PONG\r\n"
-	case "get":
-		if len(cmd) != 2 {
-			return "-ERR wrong number of arguments for 'get' command\r\n"
-		}
-		val, err := s.store.Get(cmd[1])
-		if err != nil {
-			return "$-1\r\n"
-		}
-		return fmt.Sprintf("$%d\r\n%s\r\n", len(val), val)
-	case "set":
-		if len(cmd) != 3 {
-			return "-ERR wrong number of arguments for 'set' command\r\n"
-		}
-		err := s.store.Set(cmd[1], cmd[2])
-		if err != nil {
-			return "-ERR internal error\r\n"
-		}
-		return "+OK\r\n"
-	case "del":
-		if len(cmd) != 2 {
-			return "-ERR wrong number of arguments for 'del' command\r\n"
-		}
-		if s.store.Del(cmd[1]) {
-			return ":1\r\n"
-		}
-		return ":0\r\n"
-	case "keys":
-		if len(cmd) != 2 || cmd[1] != "*" {
-			return "-ERR wrong number of arguments for 'keys' command\r\n"
-		}
-		keys := s.store.Keys()
-		response := fmt.Sprintf("*%d\r\n", len(keys))
-		for _, key := range keys {
-			response += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
-		}
-		return response
-	case "exists":
-		if len(cmd) != 2 {
-			return "-ERR wrong number of arguments for 'exists' command\r\n"
-		}
-		if s.store.Exists(cmd[1]) {
-			return ":1\r\n"
-		}
-		return ":0\r\n"
-	case "scan":
-		if len(cmd) < 2 {
-			return "-ERR wrong number of arguments for 'scan' command\r\n"
-		}
-		cursor, err := strconv.Atoi(cmd[1])
-		if err != nil {
-			return "-ERR invalid cursor\r\n"
-		}
-		
-		count := 10 // default count
-		match := ""
-		keyType := ""
-		
-		for i := 2; i < len(cmd); i += 2 {
-			if i+1 >= len(cmd) {
-				return "-ERR syntax error\r\n"
-			}
-			switch strings.ToLower(cmd[i]) {
-			case "count":
-				count, err = strconv.Atoi(cmd[i+1])
-				if err != nil {
-					return "-ERR invalid count\r\n"
-				}
-			case "match":
-				match = cmd[i+1]
-			case "type":
-				keyType = strings.ToLower(cmd[i+1])
-			default:
-				return "-ERR syntax error\r\n"
-			}
-		}
-		
-		nextCursor, keys := s.store.Scan(cursor, match, count, keyType)
-		response := fmt.Sprintf("*2\r\n$%d\r\n%d\r\n", len(strconv.Itoa(nextCursor)), nextCursor)
-		response += fmt.Sprintf("*%d\r\n", len(keys))
-		for _, key := range keys {
-			response += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
-		}
-		return response
-	
-	default:
+	command, ok := commands[strings.ToLower(cmd[0])]
+	if !ok {
+		s.metrics.errorReplies.Add(1)
 		return fmt.Sprintf("-ERR unknown command '%s'\r\n", cmd[0])
 	}
+	if (command.arity > 0 && len(cmd) != command.arity) || (command.arity < 0 && len(cmd) < -command.arity) {
+		s.metrics.rejected(command)
+		return respWrongArgs(command.name)
+	}
+	if c.subs.Load() > 0 && c.protocol() == 2 && command.flags&flagPubSub == 0 {
+		s.metrics.rejected(command)
+		return respError(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", command.name))
+	}
+	if command.flags&flagShutdown == 0 {
+		if !s.lifecycle.enter() {
+			c.closing = true
+			return ""
+		}
+		defer s.lifecycle.exit()
+	}
+	if s.pause.active.Load() && command.flags&flagNoPause == 0 {
+		s.pause.wait(command.flags&flagWrite != 0)
+	}
+	dbIndex := c.dbIndex
+	c.db = s.databases()[dbIndex]
+	start := time.Now()
+	c.mu.Lock()
+	c.lastCmd, c.lastActive = command.name, start
+	c.mu.Unlock()
+	reply := command.handler(c, cmd)
+	elapsed := time.Since(start)
+	s.metrics.called(command, elapsed, reply)
+	s.slowlog.record(c, cmd, elapsed)
+	s.latency.add(latencyEventCommand, elapsed)
+	if s.tracking.users.Load() > 0 {
+		s.trackCommand(c, command, cmd, reply)
+	}
+	if s.monitors.count.Load() > 0 && command.flags&flagSkipMonitor == 0 {
+		s.monitors.feed(c, dbIndex, start, cmd)
+	}
+	return reply
 }
 // func (s *RedisServer) handleCommand(cmd string) string {
 // 	parts := parseCommand(cmd)
@@ -0,0 +1,237 @@
+package kvstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Common RESP replies
+const (
+	respOK   = "+OK\r\n"
+	respNil  = "$-1\r\n"
+	respZero = ":0\r\n"
+	respOne  = ":1\r\n"
+)
+
+func respError(msg string) string {
+	return "-" + msg + "\r\n"
+}
+
+// respErr turns an error from the store into an error reply. Store errors that
+// are Redis replies (ERR, OOM, ...) are sent as they are.
+func respErr(err error) string {
+	for _, known := range []error{ErrOOM, ErrNotInteger, ErrOverflow, ErrNotFloat, ErrNaNOrInfinity, ErrStringTooLong, ErrNoSuchKey, ErrSameKey, ErrCrossDB} {
+		if errors.Is(err, known) {
+			return respError(known.Error())
+		}
+	}
+	return respError("ERR internal error")
+}
+
+func respWrongArgs(name string) string {
+	return respError("ERR wrong number of arguments for '" + name + "' command")
+}
+
+func respInt(n int64) string {
+	return ":" + strconv.FormatInt(n, 10) + "\r\n"
+}
+
+func respBool(b bool) string {
+	if b {
+		return respOne
+	}
+	return respZero
+}
+
+func respBulk(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+func respSimple(s string) string {
+	return "+" + s + "\r\n"
+}
+
+// respArray encodes strings as an array of bulk strings
+func respArray(items []string) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(items)) + "\r\n")
+	for _, item := range items {
+		b.WriteString(respBulk(item))
+	}
+	return b.String()
+}
+
+// respReplies encodes already encoded replies as an array, for nested replies
+func respReplies(replies []string) string {
+	return "*" + strconv.Itoa(len(replies)) + "\r\n" + strings.Join(replies, "")
+}
+
+// respPush encodes already encoded replies as a RESP3 push frame
+func respPush(replies []string) string {
+	return ">" + strconv.Itoa(len(replies)) + "\r\n" + strings.Join(replies, "")
+}
+
+// respMap encodes already encoded replies, alternating keys and values, as a
+// RESP3 map, or as a flat array for RESP2 clients
+func respMap(resp3 bool, pairs []string) string {
+	if resp3 {
+		return "%" + strconv.Itoa(len(pairs)/2) + "\r\n" + strings.Join(pairs, "")
+	}
+	return respReplies(pairs)
+}
+
+// respNullableArray encodes values as an array of bulk strings with nil where found is false
+func respNullableArray(values []string, found []bool) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(values)) + "\r\n")
+	for i, value := range values {
+		if found[i] {
+			b.WriteString(respBulk(value))
+		} else {
+			b.WriteString(respNil)
+		}
+	}
+	return b.String()
+}
+
+// replyError is an error reply decoded by decodeReply
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
+// respValue is a reply as it was sent, for readers that need more than
+// decodeReply keeps, such as its exact type or the order of a map. Scalars
+// hold their payload in str, verbatim strings with their format prefix, and
+// aggregates their elements in items, keys and values alternating for maps.
+// null is set for the null bulk string and array of RESP2 as well.
+type respValue struct {
+	kind  byte
+	str   string
+	items []respValue
+	null  bool
+}
+
+// readRESP reads one RESP2 or RESP3 reply. Attributes are skipped.
+func readRESP(r *bufio.Reader) (respValue, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return respValue{}, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return respValue{}, errors.New("invalid RESP: empty line")
+	}
+	v := respValue{kind: line[0], str: line[1:]}
+	switch v.kind {
+	case '+', '-', ':', ',', '#', '(':
+		return v, nil
+	case '_':
+		v.null = true
+		return v, nil
+	case '$', '=', '!':
+		n, err := strconv.Atoi(v.str)
+		if err != nil {
+			return respValue{}, err
+		}
+		if n < 0 {
+			return respValue{kind: v.kind, null: true}, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return respValue{}, err
+		}
+		v.str = string(buf[:n])
+		return v, nil
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.Atoi(v.str)
+		if err != nil {
+			return respValue{}, err
+		}
+		if n < 0 {
+			return respValue{kind: v.kind, null: true}, nil
+		}
+		if v.kind == '%' || v.kind == '|' {
+			n *= 2
+		}
+		v.str = ""
+		v.items = make([]respValue, n)
+		for i := range v.items {
+			if v.items[i], err = readRESP(r); err != nil {
+				return respValue{}, err
+			}
+		}
+		if v.kind == '|' {
+			return readRESP(r)
+		}
+		return v, nil
+	}
+	return respValue{}, fmt.Errorf("invalid RESP: unexpected %q", v.kind)
+}
+
+// decodeReply decodes one RESP2 or RESP3 reply: simple, bulk and verbatim
+// strings and big numbers become strings, integers int64, doubles float64,
+// booleans bool, nulls nil, arrays, sets and pushes []any, maps map[string]any
+// and errors replyError. Attributes are skipped.
+func decodeReply(r *bufio.Reader) (any, error) {
+	v, err := readRESP(r)
+	if err != nil {
+		return nil, err
+	}
+	return v.decode()
+}
+
+func (v respValue) decode() (any, error) {
+	if v.null {
+		return nil, nil
+	}
+	switch v.kind {
+	case '-', '!':
+		return replyError(v.str), nil
+	case ':':
+		return strconv.ParseInt(v.str, 10, 64)
+	case ',':
+		switch strings.ToLower(v.str) {
+		case "inf":
+			return math.Inf(1), nil
+		case "-inf":
+			return math.Inf(-1), nil
+		}
+		return strconv.ParseFloat(v.str, 64)
+	case '#':
+		return v.str == "t", nil
+	case '=':
+		// Verbatim strings start with their format, e.g. "txt:"
+		if len(v.str) >= 4 {
+			return v.str[4:], nil
+		}
+	case '*', '~', '>':
+		items := make([]any, len(v.items))
+		for i, item := range v.items {
+			var err error
+			if items[i], err = item.decode(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	case '%':
+		m := make(map[string]any, len(v.items)/2)
+		for i := 0; i < len(v.items); i += 2 {
+			key, err := v.items[i].decode()
+			if err != nil {
+				return nil, err
+			}
+			if m[fmt.Sprint(key)], err = v.items[i+1].decode(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return v.str, nil
+}
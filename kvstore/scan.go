@@ -0,0 +1,89 @@
+package kvstore
+
+import "container/heap"
+
+// defaultScanCount is the SCAN COUNT used when none is given
+const defaultScanCount = 10
+
+// scanPosition places key in the cursor space. Keys are visited in order of
+// their hash, so the cursor only depends on the keys themselves and not on
+// the layout of the map, the index or the shards. The hash is shifted to 63
+// bits so cursors stay valid signed integers for clients that parse them so.
+func scanPosition(key string) uint64 {
+	return hashKey(key) >> 1
+}
+
+type scanEntry struct {
+	pos uint64
+	key string
+}
+
+// scanHeap is a max-heap of positions used to select the smallest COUNT ones
+type scanHeap []uint64
+
+func (h scanHeap) Len() int           { return len(h) }
+func (h scanHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h scanHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scanHeap) Push(x any)        { *h = append(*h, x.(uint64)) }
+func (h *scanHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// scanKeys applies the SCAN cursor, MATCH, COUNT and TYPE rules to a snapshot of keys.
+//
+// The cursor is a position in hash space: a call returns the keys whose
+// position lies in [cursor, bound], where bound is the COUNT-th smallest
+// position at or after the cursor, and the next cursor is bound+1. Cursors
+// only ever grow and do not depend on which other keys exist, so every key
+// present for the whole scan is returned exactly once no matter what is
+// inserted, deleted or resized in between, which is the Redis SCAN guarantee.
+func scanKeys(keys []string, cursor uint64, match string, count int, keyType string) (uint64, []string) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+
+	candidates := make([]scanEntry, 0, len(keys))
+	smallest := make(scanHeap, 0, min(count, len(keys)))
+	for _, key := range keys {
+		pos := scanPosition(key)
+		if pos < cursor {
+			continue
+		}
+		candidates = append(candidates, scanEntry{pos, key})
+		if len(smallest) < count {
+			heap.Push(&smallest, pos)
+		} else if pos < smallest[0] {
+			smallest[0] = pos
+			heap.Fix(&smallest, 0)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, []string{}
+	}
+	bound := smallest[0]
+
+	result := []string{}
+	more := false
+	for _, c := range candidates {
+		if c.pos > bound {
+			more = true
+			continue
+		}
+		if match != "" && match != "*" && !GlobMatch(match, c.key) {
+			continue
+		}
+		// In this simple KV store, all values are strings, so we'll always match "string" type
+		if keyType != "" && keyType != "string" {
+			continue
+		}
+		result = append(result, c.key)
+	}
+
+	if !more {
+		return 0, result
+	}
+	return bound + 1, result
+}
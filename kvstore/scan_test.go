@@ -0,0 +1,105 @@
+package kvstore
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// TestScanCoversStableKeysUnderConcurrentWrites checks the SCAN guarantee:
+// every key present for the whole scan is returned, however much the rest of
+// the keyspace churns, grows (forcing map growth and arena compaction) or
+// shrinks in between calls.
+func TestScanCoversStableKeysUnderConcurrentWrites(t *testing.T) {
+	stores := map[string]func() KVStoreInterface{
+		"map":     func() KVStoreInterface { return New() },
+		"arena":   func() KVStoreInterface { return New(WithEngine(EngineArena)) },
+		"sharded": func() KVStoreInterface { return NewSharded(8) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			for round := 0; round < 3; round++ {
+				store := newStore()
+				stable := map[string]bool{}
+				for i := 0; i < 2000; i++ {
+					key := fmt.Sprintf("stable:%d:%d", round, i)
+					store.Set(key, "v")
+					stable[key] = false
+				}
+
+				stop := make(chan struct{})
+				var wg sync.WaitGroup
+				for w := 0; w < 4; w++ {
+					wg.Add(1)
+					go func(w int) {
+						defer wg.Done()
+						for i := 0; ; i++ {
+							select {
+							case <-stop:
+								return
+							default:
+							}
+							key := fmt.Sprintf("churn:%d:%d", w, rand.Intn(5000))
+							if rand.Intn(3) == 0 {
+								store.Del(key)
+							} else {
+								store.Set(key, randString(10, 300))
+							}
+						}
+					}(w)
+				}
+
+				seen := map[string]int{}
+				cursor := uint64(0)
+				for calls := 0; ; calls++ {
+					if calls > 1000000 {
+						t.Fatal("scan did not terminate")
+					}
+					var keys []string
+					cursor, keys = store.Scan(cursor, "", 1+rand.Intn(100), "")
+					for _, key := range keys {
+						seen[key]++
+					}
+					if cursor == 0 {
+						break
+					}
+				}
+				close(stop)
+				wg.Wait()
+
+				for key := range stable {
+					if seen[key] == 0 {
+						t.Fatalf("stable key %s was never returned", key)
+					}
+					if seen[key] > 1 {
+						t.Fatalf("stable key %s was returned %d times", key, seen[key])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestScanCursorIgnoresMatchFiltering(t *testing.T) {
+	store := New()
+	for i := 0; i < 100; i++ {
+		store.Set(fmt.Sprintf("a:%d", i), "v")
+		store.Set(fmt.Sprintf("b:%d", i), "v")
+	}
+
+	found := 0
+	cursor := uint64(0)
+	for {
+		var keys []string
+		cursor, keys = store.Scan(cursor, "b:*", 7, "")
+		found += len(keys)
+		if cursor == 0 {
+			break
+		}
+	}
+	if found != 100 {
+		t.Fatalf("expected 100 keys, got %d", found)
+	}
+}
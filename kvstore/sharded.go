@@ -0,0 +1,357 @@
+package kvstore
+
+import (
+	"runtime"
+	"sort"
+	"time"
+)
+
+// ShardedKVStore spreads keys over independently locked KVStore shards so that
+// writes to different keys do not serialize on a single mutex
+type ShardedKVStore struct {
+	shards []*KVStore
+	mask   uint64
+}
+
+// NewSharded creates a ShardedKVStore with shardCount shards, rounded up to a
+// power of two. A shardCount of 0 or less picks a default based on GOMAXPROCS.
+// The options are applied to every shard, except that maxmemory is split
+// evenly between them.
+func NewSharded(shardCount int, opts ...Option) *ShardedKVStore {
+	if shardCount <= 0 {
+		shardCount = runtime.GOMAXPROCS(0) * 16
+	}
+	n := 1
+	for n < shardCount {
+		n <<= 1
+	}
+
+	o := applyOptions(opts)
+	o.maxMemory /= int64(n)
+	shards := make([]*KVStore, n)
+	for i := range shards {
+		shards[i] = newKVStore(o)
+	}
+	return &ShardedKVStore{shards: shards, mask: uint64(n - 1)}
+}
+
+// ShardCount returns the number of shards
+func (s *ShardedKVStore) ShardCount() int {
+	return len(s.shards)
+}
+
+// hashKey is 64-bit FNV-1a over the key bytes, inlined to avoid allocating a hash.Hash
+func hashKey(key string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime64
+	}
+	return h
+}
+
+func (s *ShardedKVStore) shardIndex(key string) int {
+	return int(hashKey(key) & s.mask)
+}
+
+func (s *ShardedKVStore) shard(key string) *KVStore {
+	return s.shards[s.shardIndex(key)]
+}
+
+// lockShards locks every shard owning one of keys, always in ascending shard
+// order so that concurrent multi-key operations cannot deadlock
+func (s *ShardedKVStore) lockShards(keys []string) (unlock func()) {
+	return s.lockShardsMode(keys, true)
+}
+
+// rlockShards is lockShards with read locks
+func (s *ShardedKVStore) rlockShards(keys []string) (unlock func()) {
+	return s.lockShardsMode(keys, false)
+}
+
+func (s *ShardedKVStore) lockShardsMode(keys []string, write bool) (unlock func()) {
+	seen := make(map[int]struct{}, len(keys))
+	idx := make([]int, 0, len(keys))
+	for _, key := range keys {
+		i := s.shardIndex(key)
+		if _, ok := seen[i]; !ok {
+			seen[i] = struct{}{}
+			idx = append(idx, i)
+		}
+	}
+	sort.Ints(idx)
+
+	for _, i := range idx {
+		if write {
+			s.shards[i].mu.Lock()
+		} else {
+			s.shards[i].mu.RLock()
+		}
+	}
+	return func() {
+		for j := len(idx) - 1; j >= 0; j-- {
+			if write {
+				s.shards[idx[j]].mu.Unlock()
+			} else {
+				s.shards[idx[j]].mu.RUnlock()
+			}
+		}
+		if write {
+			for _, i := range idx {
+				s.shards[i].drainEvents()
+			}
+		}
+	}
+}
+
+func (s *ShardedKVStore) Set(key, value string) error {
+	return s.shard(key).Set(key, value)
+}
+
+func (s *ShardedKVStore) Get(key string) (string, error) {
+	return s.shard(key).Get(key)
+}
+
+func (s *ShardedKVStore) Del(key string) bool {
+	return s.shard(key).Del(key)
+}
+
+func (s *ShardedKVStore) Exists(key string) bool {
+	return s.shard(key).Exists(key)
+}
+
+// MSet stores all pairs atomically across shards
+func (s *ShardedKVStore) MSet(pairs map[string]string) error {
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+
+	unlock := s.lockShards(keys)
+	defer unlock()
+	return s.msetLocked(pairs)
+}
+
+// msetLocked writes pairs while the caller holds the locks of their shards
+func (s *ShardedKVStore) msetLocked(pairs map[string]string) error {
+	// Reserve memory in every shard before writing so a failure leaves nothing half-applied
+	perShard := map[*KVStore]map[string]string{}
+	for key, value := range pairs {
+		shard := s.shard(key)
+		if perShard[shard] == nil {
+			perShard[shard] = map[string]string{}
+		}
+		perShard[shard][key] = value
+	}
+	for shard, subset := range perShard {
+		if err := shard.reserve(shard.pairsDelta(subset), func(key string) bool { _, ok := subset[key]; return ok }); err != nil {
+			return err
+		}
+	}
+	for shard, subset := range perShard {
+		for key, value := range subset {
+			shard.storeLocked(key, value)
+			shard.notify(EventSet, key)
+		}
+	}
+	return nil
+}
+
+// MSetNX sets all pairs only if none of the keys exist, atomically across shards
+func (s *ShardedKVStore) MSetNX(pairs map[string]string) (bool, error) {
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+
+	unlock := s.lockShards(keys)
+	defer unlock()
+	for _, key := range keys {
+		if _, ok := s.shard(key).writableValue(key); ok {
+			return false, nil
+		}
+	}
+	if err := s.msetLocked(pairs); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MGet returns the values of keys in order; found[i] is false for missing keys
+func (s *ShardedKVStore) MGet(keys ...string) (values []string, found []bool) {
+	unlock := s.rlockShards(keys)
+	defer unlock()
+	values = make([]string, len(keys))
+	found = make([]bool, len(keys))
+	for i, key := range keys {
+		shard := s.shard(key)
+		if values[i], found[i] = shard.liveValue(key); found[i] {
+			shard.touch(key)
+		}
+	}
+	return values, found
+}
+
+// DelMulti removes the given keys atomically across shards and returns how many existed
+func (s *ShardedKVStore) DelMulti(keys ...string) int {
+	return s.delMulti(keys, false)
+}
+
+// Unlink is DelMulti without compacting arena shards, see KVStore.Unlink
+func (s *ShardedKVStore) Unlink(keys ...string) int {
+	return s.delMulti(keys, true)
+}
+
+func (s *ShardedKVStore) delMulti(keys []string, lazy bool) int {
+	unlock := s.lockShards(keys)
+	defer unlock()
+	deleted := 0
+	for _, key := range keys {
+		shard := s.shard(key)
+		if shard.isExpired(key) {
+			shard.expireLocked(key)
+			continue
+		}
+		if shard.removeLocked(key, lazy) {
+			shard.notify(EventDel, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (s *ShardedKVStore) SetWithOptions(key, value string, opts SetOptions) (SetResult, error) {
+	return s.shard(key).SetWithOptions(key, value, opts)
+}
+
+func (s *ShardedKVStore) SetNX(key, value string) (bool, error) {
+	return s.shard(key).SetNX(key, value)
+}
+
+func (s *ShardedKVStore) IncrBy(key string, delta int64) (int64, error) {
+	return s.shard(key).IncrBy(key, delta)
+}
+
+func (s *ShardedKVStore) IncrByFloat(key string, delta float64) (float64, error) {
+	return s.shard(key).IncrByFloat(key, delta)
+}
+
+func (s *ShardedKVStore) Append(key, value string) (int, error) {
+	return s.shard(key).Append(key, value)
+}
+
+func (s *ShardedKVStore) GetRange(key string, start, end int) string {
+	return s.shard(key).GetRange(key, start, end)
+}
+
+func (s *ShardedKVStore) SetRange(key string, offset int, value string) (int, error) {
+	return s.shard(key).SetRange(key, offset, value)
+}
+
+func (s *ShardedKVStore) StrLen(key string) int {
+	return s.shard(key).StrLen(key)
+}
+
+func (s *ShardedKVStore) GetDel(key string) (string, bool) {
+	return s.shard(key).GetDel(key)
+}
+
+func (s *ShardedKVStore) Update(key string, ttl TTLOption, fn func(value string, exists bool) (string, bool)) (bool, error) {
+	return s.shard(key).Update(key, ttl, fn)
+}
+
+func (s *ShardedKVStore) CompareAndDelete(key, value string) bool {
+	return s.shard(key).CompareAndDelete(key, value)
+}
+
+func (s *ShardedKVStore) GetEx(key string, ttl TTLOption) (string, bool) {
+	return s.shard(key).GetEx(key, ttl)
+}
+
+func (s *ShardedKVStore) Expire(key string, ttl time.Duration) bool {
+	return s.shard(key).Expire(key, ttl)
+}
+
+func (s *ShardedKVStore) ExpireAt(key string, at time.Time) bool {
+	return s.shard(key).ExpireAt(key, at)
+}
+
+func (s *ShardedKVStore) Persist(key string) bool {
+	return s.shard(key).Persist(key)
+}
+
+func (s *ShardedKVStore) TTL(key string) time.Duration {
+	return s.shard(key).TTL(key)
+}
+
+// ActiveExpire runs an active expire round on every shard
+func (s *ShardedKVStore) ActiveExpire() int {
+	total := 0
+	for _, shard := range s.shards {
+		total += shard.ActiveExpire()
+	}
+	return total
+}
+
+// Stats sums the counters of all shards
+func (s *ShardedKVStore) Stats() Stats {
+	total := Stats{}
+	for _, shard := range s.shards {
+		st := shard.Stats()
+		total.Keys += st.Keys
+		total.Expires += st.Expires
+		total.UsedMemory += st.UsedMemory
+		total.MaxMemory += st.MaxMemory
+		total.Policy = st.Policy
+		total.EvictedKeys += st.EvictedKeys
+		total.ExpiredKeys += st.ExpiredKeys
+		total.KeyspaceHits += st.KeyspaceHits
+		total.KeyspaceMisses += st.KeyspaceMisses
+		total.LazyFreePending += st.LazyFreePending
+		total.LazyFreedObjects += st.LazyFreedObjects
+	}
+	return total
+}
+
+// shareMemory counts the memory of every shard against b, see KVStore.shareMemory
+func (s *ShardedKVStore) shareMemory(b *memoryBudget) {
+	for _, shard := range s.shards {
+		shard.shareMemory(b)
+	}
+}
+
+// SetMaxMemory splits the limit evenly between the shards
+func (s *ShardedKVStore) SetMaxMemory(bytes int64, policy EvictionPolicy) {
+	for _, shard := range s.shards {
+		shard.SetMaxMemory(bytes/int64(len(s.shards)), policy)
+	}
+}
+
+// SetEvictionPolicy changes the policy of every shard, see KVStore.SetEvictionPolicy
+func (s *ShardedKVStore) SetEvictionPolicy(policy EvictionPolicy) {
+	for _, shard := range s.shards {
+		shard.SetEvictionPolicy(policy)
+	}
+}
+
+func (s *ShardedKVStore) Keys() []string {
+	keys := []string{}
+	for _, shard := range s.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+func (s *ShardedKVStore) Scan(cursor uint64, match string, count int, keyType string) (uint64, []string) {
+	keys := []string{}
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		keys = append(keys, shard.scanCandidates(match)...)
+		shard.mu.RUnlock()
+	}
+	return scanKeys(keys, cursor, match, count, keyType)
+}
@@ -0,0 +1,198 @@
+package kvstore
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Defaults of slowlog-log-slower-than and slowlog-max-len in Redis
+	defaultSlowlogThreshold = 10 * time.Millisecond
+	defaultSlowlogMaxLen    = 128
+
+	// Like Redis, only the first slowlogMaxArgs arguments are kept and each
+	// one is cut after slowlogMaxArgLen bytes
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// slowlogEntry is one command recorded by the slow log
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string
+	addr     string
+	name     string
+}
+
+// slowlog keeps the latest commands slower than a threshold in a ring buffer
+type slowlog struct {
+	// threshold is a time.Duration; a negative value disables the log
+	threshold atomic.Int64
+
+	mu sync.Mutex
+	// entries is a ring buffer of up to maxLen entries and next the index the
+	// next entry is written to, so the newest entry is the one before it
+	entries []slowlogEntry
+	next    int
+	maxLen  int
+	nextID  int64
+}
+
+func newSlowlog() *slowlog {
+	l := &slowlog{maxLen: defaultSlowlogMaxLen}
+	l.threshold.Store(int64(defaultSlowlogThreshold))
+	return l
+}
+
+// SetSlowlog configures the slow log: commands taking at least threshold are
+// recorded, a negative threshold disables it, and at most maxLen entries are kept
+func (s *RedisServer) SetSlowlog(threshold time.Duration, maxLen int) {
+	s.slowlog.threshold.Store(int64(threshold))
+	s.updateConfig(func(cfg *Config) { cfg.SlowlogLogSlowerThan, cfg.SlowlogMaxLen = threshold, max(maxLen, 0) })
+	l := s.slowlog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Lay the newest entries that still fit out oldest first again, so the
+	// ring can grow or wrap at the new size
+	kept := l.newest(maxLen)
+	slices.Reverse(kept)
+	l.entries = kept
+	l.maxLen = max(maxLen, 0)
+	l.next = 0
+	if l.maxLen > 0 {
+		l.next = len(kept) % l.maxLen
+	}
+}
+
+// record adds the command to the log if it was slow enough. The fast path is
+// a single atomic load.
+func (l *slowlog) record(c *client, cmd []string, elapsed time.Duration) {
+	threshold := time.Duration(l.threshold.Load())
+	if threshold < 0 || elapsed < threshold {
+		return
+	}
+
+	entry := slowlogEntry{time: time.Now(), duration: elapsed, args: slowlogArgs(redactArgs(cmd)), addr: c.addr(), name: c.getName()}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxLen == 0 {
+		return
+	}
+	entry.id = l.nextID
+	l.nextID++
+	if len(l.entries) < l.maxLen {
+		l.entries = append(l.entries, entry)
+	} else {
+		l.entries[l.next] = entry
+	}
+	l.next = (l.next + 1) % l.maxLen
+}
+
+// slowlogArgs copies the arguments of a command, truncated like Redis does
+func slowlogArgs(cmd []string) []string {
+	n := min(len(cmd), slowlogMaxArgs)
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if i == slowlogMaxArgs-1 && len(cmd) > slowlogMaxArgs {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(cmd)-slowlogMaxArgs+1))
+			break
+		}
+		arg := cmd[i]
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// newest returns up to n entries, newest first. Callers hold l.mu.
+func (l *slowlog) newest(n int) []slowlogEntry {
+	n = max(min(n, len(l.entries)), 0)
+	result := make([]slowlogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		result = append(result, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return result
+}
+
+func (l *slowlog) get(n int) []slowlogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.newest(n)
+}
+
+func (l *slowlog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+func (l *slowlog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+	l.next = 0
+}
+
+var slowlogHelp = []string{
+	"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET [<count>]",
+	"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+	"    Entries are made of:",
+	"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+	"    client name",
+	"LEN",
+	"    Return the length of the slowlog.",
+	"RESET",
+	"    Reset the slowlog.",
+	"HELP",
+	"    Print this help.",
+}
+
+// cmdSlowlog handles SLOWLOG GET [count], LEN, RESET and HELP
+func (c *client) cmdSlowlog(cmd []string) string {
+	l := c.server.slowlog
+	switch sub := strings.ToLower(cmd[1]); {
+	case sub == "help" && len(cmd) == 2:
+		return respArray(slowlogHelp)
+	case sub == "len" && len(cmd) == 2:
+		return respInt(int64(l.len()))
+	case sub == "reset" && len(cmd) == 2:
+		l.reset()
+		return respOK
+	case sub == "get" && len(cmd) <= 3:
+		count := int64(10)
+		if len(cmd) == 3 {
+			var ok bool
+			if count, ok = parseInt(cmd[2]); !ok || count < -1 {
+				return respError("ERR count should be greater than or equal to -1")
+			}
+			if count == -1 {
+				count = math.MaxInt32
+			}
+		}
+		entries := l.get(int(count))
+		replies := make([]string, len(entries))
+		for i, e := range entries {
+			replies[i] = respReplies([]string{
+				respInt(e.id),
+				respInt(e.time.Unix()),
+				respInt(e.duration.Microseconds()),
+				respArray(e.args),
+				respBulk(e.addr),
+				respBulk(e.name),
+			})
+		}
+		return respReplies(replies)
+	}
+	return respError(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try SLOWLOG HELP.", cmd[1]))
+}
@@ -0,0 +1,88 @@
+package kvstore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowlogRing(t *testing.T) {
+	server := NewRedisServer(nil)
+	server.SetSlowlog(0, 3)
+	c := &client{server: server}
+	for i := 0; i < 5; i++ {
+		server.handleCommand(c, []string{"SET", fmt.Sprint(i), "v"})
+	}
+
+	ids := func() string {
+		var b strings.Builder
+		for _, e := range server.slowlog.get(100) {
+			fmt.Fprintf(&b, "%d:%s ", e.id, e.args[1])
+		}
+		return b.String()
+	}
+	if got := ids(); got != "4:4 3:3 2:2 " {
+		t.Fatalf("after wrapping the ring holds %q", got)
+	}
+
+	server.SetSlowlog(0, 2)
+	if got := ids(); got != "4:4 3:3 " {
+		t.Fatalf("after shrinking the ring holds %q", got)
+	}
+	server.SetSlowlog(0, 4)
+	server.handleCommand(c, []string{"SET", "5", "v"})
+	server.handleCommand(c, []string{"SET", "6", "v"})
+	server.handleCommand(c, []string{"SET", "7", "v"})
+	if got := ids(); got != "7:7 6:6 5:5 4:4 " {
+		t.Fatalf("after growing the ring holds %q", got)
+	}
+
+	server.SetSlowlog(time.Hour, 4)
+	server.slowlog.reset()
+	server.handleCommand(c, []string{"SET", "fast", "v"})
+	if server.slowlog.len() != 0 {
+		t.Fatal("a command below the threshold was logged")
+	}
+}
+
+func TestSlowlogArgsTruncation(t *testing.T) {
+	cmd := []string{"MSET", strings.Repeat("x", 200)}
+	for i := 0; i < 40; i++ {
+		cmd = append(cmd, "v")
+	}
+	args := slowlogArgs(cmd)
+	if len(args) != slowlogMaxArgs {
+		t.Fatalf("kept %d arguments", len(args))
+	}
+	if want := strings.Repeat("x", 128) + "... (72 more bytes)"; args[1] != want {
+		t.Fatalf("long argument became %q", args[1])
+	}
+	if want := "... (11 more arguments)"; args[len(args)-1] != want {
+		t.Fatalf("last argument is %q, want %q", args[len(args)-1], want)
+	}
+}
+
+func TestLatencyMonitorMergesSamplesPerSecond(t *testing.T) {
+	m := newLatencyMonitor()
+	m.add(latencyEventCommand, time.Second)
+	if len(m.latestSamples()) != 0 {
+		t.Fatal("a disabled monitor recorded a sample")
+	}
+
+	m.threshold.Store(int64(10 * time.Millisecond))
+	m.add(latencyEventCommand, 5*time.Millisecond)
+	m.add(latencyEventCommand, 20*time.Millisecond)
+	m.add(latencyEventCommand, 30*time.Millisecond)
+	history := m.history(latencyEventCommand)
+	// Both spikes normally land in the same second; allow for a second boundary
+	if len(history) == 0 || len(history) > 2 || history[len(history)-1].latency != 30*time.Millisecond {
+		t.Fatalf("unexpected history %+v", history)
+	}
+	if latest := m.latestSamples(); len(latest) != 1 || latest[0].max != 30*time.Millisecond {
+		t.Fatalf("unexpected latest %+v", latest)
+	}
+	if m.reset() != 1 || len(m.latestSamples()) != 0 {
+		t.Fatal("reset did not forget the event")
+	}
+}
@@ -0,0 +1,71 @@
+package kvstore
+
+// storage is the engine a KVStore keeps its entries in. Callers hold the
+// KVStore lock, so implementations need no synchronization of their own.
+type storage interface {
+	get(key string) (string, bool)
+	has(key string) bool
+	set(key, value string)
+	del(key string) bool
+	// unlink is del without the work that depends on the size of the whole
+	// storage, such as compaction, which later writes take care of
+	unlink(key string) bool
+	len() int
+	// each calls fn for every key until fn returns false
+	each(fn func(key string) bool)
+	// overhead estimates the bytes an entry costs on top of its key and value
+	overhead() int
+	// clear drops every entry. It is only called on storage detached by Flush.
+	clear()
+}
+
+// mapStorage is the default engine backed by a plain Go map
+type mapStorage map[string]string
+
+func (m mapStorage) get(key string) (string, bool) {
+	value, ok := m[key]
+	return value, ok
+}
+
+func (m mapStorage) has(key string) bool {
+	_, ok := m[key]
+	return ok
+}
+
+func (m mapStorage) set(key, value string) {
+	m[key] = value
+}
+
+func (m mapStorage) del(key string) bool {
+	if _, ok := m[key]; ok {
+		delete(m, key)
+		return true
+	}
+	return false
+}
+
+// unlink is del: the garbage collector reclaims the value concurrently
+func (m mapStorage) unlink(key string) bool {
+	return m.del(key)
+}
+
+// overhead covers the key and value string headers, the map slot and allocator rounding
+func (m mapStorage) overhead() int {
+	return 64
+}
+
+func (m mapStorage) clear() {
+	clear(m)
+}
+
+func (m mapStorage) len() int {
+	return len(m)
+}
+
+func (m mapStorage) each(fn func(key string) bool) {
+	for k := range m {
+		if !fn(k) {
+			return
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package kvstore
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Errors returned by the string operations. Their text is the Redis error
+// reply, so the server can send them to clients as they are.
+var (
+	ErrNotInteger    = errors.New("ERR value is not an integer or out of range")
+	ErrOverflow      = errors.New("ERR increment or decrement would overflow")
+	ErrNotFloat      = errors.New("ERR value is not a valid float")
+	ErrNaNOrInfinity = errors.New("ERR increment would produce NaN or Infinity")
+	ErrStringTooLong = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
+// maxStringSize matches the Redis proto-max-bulk-len default of 512MB
+const maxStringSize = 512 * 1024 * 1024
+
+// TTLOption describes how a write changes the time to live of a key
+type TTLOption struct {
+	// Keep leaves the current TTL alone (SET KEEPTTL)
+	Keep bool
+	// Persist removes the TTL (GETEX PERSIST)
+	Persist bool
+	// At is the new deadline (EX, PX, EXAT, PXAT), zero when not given
+	At time.Time
+}
+
+// SetOptions are the conditions and side effects of SetWithOptions, mirroring the SET command
+type SetOptions struct {
+	// NX only sets the key if it does not exist, XX only if it does
+	NX, XX bool
+	// TTL applies a new deadline or keeps the old one; by default SET clears it
+	TTL TTLOption
+}
+
+// SetResult reports what SetWithOptions did
+type SetResult struct {
+	// Old is the previous value, valid when Existed is true
+	Old     string
+	Existed bool
+	// Written is false when an NX or XX condition prevented the write
+	Written bool
+}
+
+// parseInt parses a value the way Redis' string2ll does: no whitespace, no
+// '+' sign and no leading zeros, so only canonical integers are accepted
+func parseInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	digits := s
+	if s[0] == '-' {
+		digits = s[1:]
+	}
+	if len(digits) == 0 || digits[0] < '0' || digits[0] > '9' || (digits[0] == '0' && len(s) > 1) {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// parseFloat parses a value for INCRBYFLOAT, rejecting whitespace and NaN
+func parseFloat(s string) (float64, bool) {
+	if len(s) == 0 || s[0] == ' ' || s[len(s)-1] == ' ' {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// liveValue returns the value of key unless it is missing or expired. It
+// works under either lock and leaves expired keys for the write path.
+func (kv *KVStore) liveValue(key string) (string, bool) {
+	value, ok := kv.data.get(key)
+	if !ok || kv.isExpired(key) {
+		return "", false
+	}
+	return value, true
+}
+
+// writableValue is liveValue for callers holding the write lock: an expired
+// key is deleted first, so the write starts from a missing key.
+func (kv *KVStore) writableValue(key string) (string, bool) {
+	if kv.isExpired(key) {
+		kv.expireLocked(key)
+		return "", false
+	}
+	return kv.data.get(key)
+}
+
+// updateLocked replaces the value of key but keeps its TTL, like Redis does
+// for commands that modify a value in place. Callers hold kv.mu.
+func (kv *KVStore) updateLocked(key, value string) error {
+	deadline, hadTTL := kv.expires[key]
+	if err := kv.setLocked(key, value); err != nil {
+		return err
+	}
+	if hadTTL {
+		kv.setExpireLocked(key, deadline)
+	}
+	return nil
+}
+
+// SetWithOptions is the full SET command: a conditional write that can keep
+// or set a TTL and reports the previous value
+func (kv *KVStore) SetWithOptions(key, value string, opts SetOptions) (SetResult, error) {
+	kv.mu.Lock()
+	defer kv.unlock()
+
+	old, existed := kv.writableValue(key)
+	result := SetResult{Old: old, Existed: existed}
+	if (opts.NX && existed) || (opts.XX && !existed) {
+		return result, nil
+	}
+
+	if err := kv.writeLocked(key, value, opts.TTL); err != nil {
+		return result, err
+	}
+	result.Written = true
+	return result, nil
+}
+
+// writeLocked stores value at key with the TTL option of a SET. Callers hold
+// kv.mu.
+func (kv *KVStore) writeLocked(key, value string, ttl TTLOption) error {
+	var err error
+	if ttl.Keep {
+		err = kv.updateLocked(key, value)
+	} else {
+		err = kv.setLocked(key, value)
+	}
+	if err != nil {
+		return err
+	}
+	kv.notify(EventSet, key)
+	if !ttl.At.IsZero() {
+		kv.setExpireLocked(key, ttl.At.UnixMilli())
+		kv.notify(EventExpire, key)
+	}
+	return nil
+}
+
+// Update atomically replaces the value of key with the one fn derives from
+// the current value, which is how check-and-set style writes are built. fn
+// runs under the lock, with exists false for a missing key, and returns
+// false to leave the key alone; it must not use the store. ttl applies like
+// in SetWithOptions. Update reports whether it wrote.
+func (kv *KVStore) Update(key string, ttl TTLOption, fn func(value string, exists bool) (string, bool)) (bool, error) {
+	_, written, err := kv.updateVersion(key, ttl, func(value string, _ uint64, exists bool) (string, bool) {
+		return fn(value, exists)
+	})
+	return written, err
+}
+
+// SetNX sets key only if it does not exist and reports whether it did
+func (kv *KVStore) SetNX(key, value string) (bool, error) {
+	result, err := kv.SetWithOptions(key, value, SetOptions{NX: true})
+	return result.Written, err
+}
+
+// IncrBy atomically adds delta to the integer stored at key, treating a
+// missing key as 0, and returns the new value
+func (kv *KVStore) IncrBy(key string, delta int64) (int64, error) {
+	kv.mu.Lock()
+	defer kv.unlock()
+
+	var n int64
+	if value, ok := kv.writableValue(key); ok {
+		var valid bool
+		if n, valid = parseInt(value); !valid {
+			return 0, ErrNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	n += delta
+	if err := kv.updateLocked(key, strconv.FormatInt(n, 10)); err != nil {
+		return 0, err
+	}
+	kv.notify(EventIncrBy, key)
+	return n, nil
+}
+
+// IncrByFloat atomically adds delta to the number stored at key, treating a
+// missing key as 0, and returns the new value
+func (kv *KVStore) IncrByFloat(key string, delta float64) (float64, error) {
+	kv.mu.Lock()
+	defer kv.unlock()
+
+	var f float64
+	if value, ok := kv.writableValue(key); ok {
+		var valid bool
+		if f, valid = parseFloat(value); !valid {
+			return 0, ErrNotFloat
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, ErrNaNOrInfinity
+	}
+	if err := kv.updateLocked(key, formatFloat(f)); err != nil {
+		return 0, err
+	}
+	kv.notify(EventIncrByFloat, key)
+	return f, nil
+}
+
+// Append adds value to the end of the string at key, creating it if needed,
+// and returns the new length
+func (kv *KVStore) Append(key, value string) (int, error) {
+	kv.mu.Lock()
+	defer kv.unlock()
+
+	old, _ := kv.writableValue(key)
+	if len(old)+len(value) > maxStringSize {
+		return 0, ErrStringTooLong
+	}
+	if err := kv.updateLocked(key, old+value); err != nil {
+		return 0, err
+	}
+	kv.notify(EventAppend, key)
+	return len(old) + len(value), nil
+}
+
+// GetRange returns the substring of the value at key between the inclusive
+// offsets start and end, where negative offsets count from the end
+func (kv *KVStore) GetRange(key string, start, end int) string {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+
+	value, ok := kv.liveValue(key)
+	kv.lookup(ok)
+	if !ok || (start < 0 && end < 0 && start > end) {
+		return ""
+	}
+	n := len(value)
+	if start < 0 {
+		start = n + start
+	}
+	if end < 0 {
+		end = n + end
+	}
+	start, end = max(start, 0), max(end, 0)
+	if end >= n {
+		end = n - 1
+	}
+	if start > end || n == 0 {
+		return ""
+	}
+	return value[start : end+1]
+}
+
+// SetRange overwrites the value at key starting at offset, padding with zero
+// bytes when the string is shorter, and returns the new length
+func (kv *KVStore) SetRange(key string, offset int, value string) (int, error) {
+	kv.mu.Lock()
+	defer kv.unlock()
+
+	old, ok := kv.writableValue(key)
+	if len(value) == 0 {
+		// Like Redis, an empty value never creates the key
+		return len(old), nil
+	}
+	if offset > maxStringSize-len(value) {
+		return 0, ErrStringTooLong
+	}
+
+	buf := []byte(old)
+	if need := offset + len(value); need > len(buf) {
+		buf = append(buf, make([]byte, need-len(buf))...)
+	}
+	copy(buf[offset:], value)
+	var err error
+	if !ok {
+		err = kv.setLocked(key, string(buf))
+	} else {
+		err = kv.updateLocked(key, string(buf))
+	}
+	if err != nil {
+		return 0, err
+	}
+	kv.notify(EventSetRange, key)
+	return len(buf), nil
+}
+
+// StrLen returns the length of the value at key, 0 when it does not exist
+func (kv *KVStore) StrLen(key string) int {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	value, ok := kv.liveValue(key)
+	kv.lookup(ok)
+	return len(value)
+}
+
+// GetDel returns the value at key and deletes it
+func (kv *KVStore) GetDel(key string) (string, bool) {
+	kv.mu.Lock()
+	defer kv.unlock()
+	value, ok := kv.writableValue(key)
+	kv.lookup(ok)
+	if ok {
+		kv.delLocked(key)
+		kv.notify(EventDel, key)
+	}
+	return value, ok
+}
+
+// CompareAndDelete deletes key only while it holds value and reports whether
+// it did
+func (kv *KVStore) CompareAndDelete(key, value string) bool {
+	kv.mu.Lock()
+	defer kv.unlock()
+	current, ok := kv.writableValue(key)
+	if !ok || current != value {
+		return false
+	}
+	kv.delLocked(key)
+	kv.notify(EventDel, key)
+	return true
+}
+
+// GetEx returns the value at key and optionally changes its TTL. A zero
+// TTLOption leaves the TTL untouched.
+func (kv *KVStore) GetEx(key string, ttl TTLOption) (string, bool) {
+	kv.mu.Lock()
+	defer kv.unlock()
+	return kv.getExLocked(key, ttl)
+}
+
+// getExLocked is GetEx for callers holding kv.mu
+func (kv *KVStore) getExLocked(key string, ttl TTLOption) (string, bool) {
+	value, ok := kv.writableValue(key)
+	kv.lookup(ok)
+	if !ok {
+		return "", false
+	}
+	switch {
+	case ttl.Persist:
+		if kv.persistLocked(key) {
+			kv.notify(EventPersist, key)
+		}
+	case !ttl.At.IsZero() && !ttl.At.After(time.Now()):
+		kv.delLocked(key)
+		kv.notify(EventDel, key)
+	case !ttl.At.IsZero():
+		kv.setExpireLocked(key, ttl.At.UnixMilli())
+		kv.notify(EventExpire, key)
+	}
+	kv.touch(key)
+	return value, true
+}
+
+// MGet returns the values of keys in order; found[i] is false for missing keys
+func (kv *KVStore) MGet(keys ...string) (values []string, found []bool) {
+	kv.mu.RLock()
+	defer kv.mu.RUnlock()
+	values = make([]string, len(keys))
+	found = make([]bool, len(keys))
+	for i, key := range keys {
+		values[i], found[i] = kv.liveValue(key)
+		kv.lookup(found[i])
+		if found[i] {
+			kv.touch(key)
+		}
+	}
+	return values, found
+}
+
+// MSetNX sets all pairs only if none of the keys exist, atomically
+func (kv *KVStore) MSetNX(pairs map[string]string) (bool, error) {
+	kv.mu.Lock()
+	defer kv.unlock()
+	for key := range pairs {
+		if _, ok := kv.writableValue(key); ok {
+			return false, nil
+		}
+	}
+	if err := kv.reserve(kv.pairsDelta(pairs), func(key string) bool { _, ok := pairs[key]; return ok }); err != nil {
+		return false, err
+	}
+	for key, value := range pairs {
+		kv.storeLocked(key, value)
+		kv.notify(EventSet, key)
+	}
+	return true, nil
+}
@@ -0,0 +1,122 @@
+package kvstore
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIncrBy(t *testing.T) {
+	store := New()
+
+	if n, err := store.IncrBy("counter", 5); n != 5 || err != nil {
+		t.Fatalf("IncrBy on a missing key = %d, %v", n, err)
+	}
+	store.Set("big", "9223372036854775807")
+	if _, err := store.IncrBy("big", 1); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expected ErrOverflow, got %v", err)
+	}
+	if _, err := store.IncrBy("small", math.MinInt64); err != nil {
+		t.Fatalf("IncrBy to MinInt64 failed: %v", err)
+	}
+	for _, value := range []string{"abc", " 1", "+1", "01", "", "1.5"} {
+		store.Set("bad", value)
+		if _, err := store.IncrBy("bad", 1); !errors.Is(err, ErrNotInteger) {
+			t.Fatalf("IncrBy on %q: expected ErrNotInteger, got %v", value, err)
+		}
+	}
+
+	store.Set("ttl", "1")
+	store.Expire("ttl", time.Hour)
+	store.IncrBy("ttl", 1)
+	if ttl := store.TTL("ttl"); ttl <= 0 {
+		t.Fatalf("IncrBy dropped the TTL, got %v", ttl)
+	}
+}
+
+func TestSetWithOptions(t *testing.T) {
+	store := New()
+
+	if r, _ := store.SetWithOptions("k", "v1", SetOptions{XX: true}); r.Written {
+		t.Fatal("XX wrote a missing key")
+	}
+	if r, _ := store.SetWithOptions("k", "v1", SetOptions{NX: true}); !r.Written {
+		t.Fatal("NX did not write a missing key")
+	}
+	if r, _ := store.SetWithOptions("k", "v2", SetOptions{NX: true}); r.Written || r.Old != "v1" {
+		t.Fatalf("NX on an existing key = %+v", r)
+	}
+
+	deadline := time.Now().Add(time.Hour)
+	store.SetWithOptions("k", "v3", SetOptions{TTL: TTLOption{At: deadline}})
+	store.SetWithOptions("k", "v4", SetOptions{TTL: TTLOption{Keep: true}})
+	if ttl := store.TTL("k"); ttl <= 0 {
+		t.Fatalf("KEEPTTL lost the TTL, got %v", ttl)
+	}
+	store.SetWithOptions("k", "v5", SetOptions{})
+	if ttl := store.TTL("k"); ttl != TTLPersistent {
+		t.Fatalf("a plain SET kept the TTL, got %v", ttl)
+	}
+}
+
+func TestRangeOperations(t *testing.T) {
+	store := New()
+	store.Set("s", "Hello World")
+
+	for _, tc := range []struct {
+		start, end int
+		want       string
+	}{
+		{0, 4, "Hello"},
+		{-5, -1, "World"},
+		{-100, 100, "Hello World"},
+		{5, 3, ""},
+		{-1, -5, ""},
+	} {
+		if got := store.GetRange("s", tc.start, tc.end); got != tc.want {
+			t.Errorf("GetRange(%d, %d) = %q, want %q", tc.start, tc.end, got, tc.want)
+		}
+	}
+
+	if n, _ := store.SetRange("pad", 3, "x"); n != 4 {
+		t.Fatalf("SetRange padded to %d bytes, want 4", n)
+	}
+	if v, _ := store.Get("pad"); v != "\x00\x00\x00x" {
+		t.Fatalf("SetRange padding = %q", v)
+	}
+	if n, _ := store.SetRange("empty", 10, ""); n != 0 || store.Exists("empty") {
+		t.Fatal("SetRange with an empty value created the key")
+	}
+	if _, err := store.SetRange("huge", math.MaxInt, "ab"); !errors.Is(err, ErrStringTooLong) {
+		t.Fatalf("SetRange at a huge offset: %v", err)
+	}
+
+	send, _ := pipeClient(t, NewRedisServer(nil))
+	if got := send("SETRANGE", "k", "9223372036854775807", "ab"); got != "-"+ErrStringTooLong.Error() {
+		t.Fatalf("SETRANGE at a huge offset replied %q", got)
+	}
+	if got := send("SETRANGE", "k", "536870911", "ab"); got != "-"+ErrStringTooLong.Error() {
+		t.Fatalf("SETRANGE past the size limit replied %q", got)
+	}
+}
+
+func TestMSetNX(t *testing.T) {
+	for name, store := range map[string]interface {
+		Set(key, value string) error
+		MSetNX(pairs map[string]string) (bool, error)
+		MGet(keys ...string) ([]string, []bool)
+	}{"single": New(), "sharded": NewSharded(4)} {
+		store.Set("a", "1")
+		if ok, _ := store.MSetNX(map[string]string{"a": "2", "b": "2"}); ok {
+			t.Fatalf("%s: MSetNX wrote with an existing key", name)
+		}
+		if ok, _ := store.MSetNX(map[string]string{"b": "2", "c": "3"}); !ok {
+			t.Fatalf("%s: MSetNX refused new keys", name)
+		}
+		values, found := store.MGet("a", "b", "missing", "c")
+		if values[0] != "1" || values[1] != "2" || found[2] || values[3] != "3" {
+			t.Fatalf("%s: MGet = %q %v", name, values, found)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package kvstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultTrackingTableMaxKeys is the default of tracking-table-max-keys in Redis
+const defaultTrackingTableMaxKeys = 1_000_000
+
+// trackingChannel is where invalidations go for RESP2 clients, through the
+// connection given with REDIRECT
+const trackingChannel = "__redis__:invalidate"
+
+// clientTracking is the CLIENT TRACKING state of a connection
+type clientTracking struct {
+	// on is set while tracking is enabled, checked after every command
+	on atomic.Bool
+	// The options are guarded by the server's tracking table lock
+	trackingOptions
+
+	// caching is the CLIENT CACHING answer for the next command: 1 for yes,
+	// -1 for no. Only the connection's goroutine uses it.
+	caching int
+}
+
+// trackingOptions are the options given to CLIENT TRACKING ON
+type trackingOptions struct {
+	bcast, optin, optout, noloop bool
+	// redirect is the ID of the client receiving the invalidations, 0 for
+	// the tracking client itself
+	redirect int64
+	prefixes []string
+}
+
+// trackingTable remembers which clients read which keys, to tell them when
+// the keys change. Like Redis it holds client IDs, so entries of clients that
+// went away are simply skipped, and it is bounded by maxKeys.
+type trackingTable struct {
+	// users counts the clients with tracking on; writes skip the table
+	// entirely while it is 0
+	users   atomic.Int64
+	maxKeys atomic.Int64
+
+	mu   sync.Mutex
+	keys map[string]map[int64]bool
+	// prefixes holds the BCAST clients by registered prefix
+	prefixes map[string]map[*client]bool
+}
+
+func newTrackingTable() *trackingTable {
+	t := &trackingTable{keys: make(map[string]map[int64]bool), prefixes: make(map[string]map[*client]bool)}
+	t.maxKeys.Store(defaultTrackingTableMaxKeys)
+	return t
+}
+
+// SetTrackingTableMaxKeys bounds the number of keys remembered for client side
+// caching; when the table is full, the clients of evicted keys are told to
+// drop them. 0 means unlimited.
+func (s *RedisServer) SetTrackingTableMaxKeys(n int64) {
+	s.tracking.maxKeys.Store(max(n, 0))
+	s.updateConfig(func(cfg *Config) { cfg.TrackingTableMaxKeys = max(n, 0) })
+}
+
+// invalidation is the set of keys one tracking client must drop, or all of
+// them with flush set
+type invalidation struct {
+	keys  []string
+	flush bool
+}
+
+// enable turns tracking on for c, or changes its options while it is on
+func (t *trackingTable) enable(c *client, opts trackingOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dropPrefixes(c)
+	ct := &c.tracking
+	ct.trackingOptions, ct.caching = opts, 0
+	if ct.bcast {
+		if len(ct.prefixes) == 0 {
+			// BCAST without prefixes tracks every key
+			ct.prefixes = []string{""}
+		}
+		for _, prefix := range ct.prefixes {
+			if t.prefixes[prefix] == nil {
+				t.prefixes[prefix] = map[*client]bool{}
+			}
+			t.prefixes[prefix][c] = true
+		}
+	}
+	if !ct.on.Swap(true) {
+		t.users.Add(1)
+	}
+}
+
+// disable turns tracking off for c. Keys it read stay in the table until
+// they are invalidated, like in Redis.
+func (t *trackingTable) disable(c *client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dropPrefixes(c)
+	ct := &c.tracking
+	ct.trackingOptions, ct.caching = trackingOptions{}, 0
+	if ct.on.Swap(false) {
+		t.users.Add(-1)
+	}
+}
+
+// dropPrefixes unregisters the BCAST prefixes of c. Callers hold t.mu.
+func (t *trackingTable) dropPrefixes(c *client) {
+	for _, prefix := range c.tracking.prefixes {
+		delete(t.prefixes[prefix], c)
+		if len(t.prefixes[prefix]) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+	c.tracking.prefixes = nil
+}
+
+// invalidatesAll are the commands after which tracking clients must drop
+// their whole cache
+var invalidatesAll = map[string]bool{"flushdb": true, "flushall": true, "swapdb": true}
+
+// trackCommand updates the tracking table after a command ran: reads are
+// remembered for the tracking client, writes invalidate the keys they name
+func (s *RedisServer) trackCommand(c *client, command *command, cmd []string, reply string) {
+	switch {
+	case len(reply) > 0 && reply[0] == '-':
+	case command.flags&flagReadOnly != 0:
+		if keys := commandKeys(command.name, cmd); c.tracking.on.Load() && len(keys) > 0 {
+			s.trackRead(c, keys)
+		}
+	case invalidatesAll[command.name]:
+		s.invalidateAll()
+	case command.flags&flagWrite != 0:
+		if keys := commandKeys(command.name, cmd); len(keys) > 0 {
+			s.invalidate(c, keys)
+		}
+	}
+	// CLIENT CACHING only applies to the command after it
+	if c.tracking.caching != 0 && !(command.name == "client" && strings.EqualFold(cmd[1], "caching")) {
+		c.tracking.caching = 0
+	}
+}
+
+// trackRead remembers that c read keys, honoring OPTIN and OPTOUT
+func (s *RedisServer) trackRead(c *client, keys []string) {
+	t := s.tracking
+	t.mu.Lock()
+	ct := &c.tracking
+	if ct.bcast || (ct.optin && ct.caching != 1) || (ct.optout && ct.caching == -1) {
+		t.mu.Unlock()
+		return
+	}
+	for _, key := range keys {
+		if t.keys[key] == nil {
+			t.keys[key] = map[int64]bool{}
+		}
+		t.keys[key][c.id] = true
+	}
+
+	// Over the limit, forget arbitrary keys and tell their readers, since
+	// they will not hear about later changes
+	targets := map[*client]*invalidation{}
+	if limit := t.maxKeys.Load(); limit > 0 {
+		for key := range t.keys {
+			if int64(len(t.keys)) <= limit {
+				break
+			}
+			t.collect(s, nil, key, targets)
+		}
+	}
+	t.mu.Unlock()
+	s.sendInvalidations(targets)
+}
+
+// invalidate tells the clients tracking keys, in default or BCAST mode, that
+// writer changed them
+func (s *RedisServer) invalidate(writer *client, keys []string) {
+	t := s.tracking
+	targets := map[*client]*invalidation{}
+	t.mu.Lock()
+	for _, key := range keys {
+		t.collect(s, writer, key, targets)
+	}
+	t.mu.Unlock()
+	s.sendInvalidations(targets)
+}
+
+// collect removes key from the table and adds it to the invalidations of the
+// clients that track it. Callers hold t.mu.
+func (t *trackingTable) collect(s *RedisServer, writer *client, key string, targets map[*client]*invalidation) {
+	add := func(c *client) {
+		if c == nil || !c.tracking.on.Load() || (c == writer && c.tracking.noloop) {
+			return
+		}
+		if targets[c] == nil {
+			targets[c] = &invalidation{}
+		}
+		targets[c].keys = append(targets[c].keys, key)
+	}
+	for id := range t.keys[key] {
+		if c := s.clients.get(id); c != nil && !c.tracking.bcast {
+			add(c)
+		}
+	}
+	delete(t.keys, key)
+	for prefix, clients := range t.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			for c := range clients {
+				add(c)
+			}
+		}
+	}
+}
+
+// invalidateAll tells every tracking client to drop its whole cache, after
+// FLUSHDB, FLUSHALL or SWAPDB
+func (s *RedisServer) invalidateAll() {
+	t := s.tracking
+	targets := map[*client]*invalidation{}
+	t.mu.Lock()
+	clear(t.keys)
+	t.mu.Unlock()
+	for _, c := range s.clients.list() {
+		if c.tracking.on.Load() {
+			targets[c] = &invalidation{flush: true}
+		}
+	}
+	s.sendInvalidations(targets)
+}
+
+// sendInvalidations delivers invalidation messages: as RESP3 pushes to the
+// tracking client itself, or to its REDIRECT client as a RESP3 push or a
+// message of the __redis__:invalidate channel
+func (s *RedisServer) sendInvalidations(targets map[*client]*invalidation) {
+	for c, inv := range targets {
+		payload := respArray(inv.keys)
+		s.tracking.mu.Lock()
+		redirect := c.tracking.redirect
+		s.tracking.mu.Unlock()
+
+		if redirect == 0 {
+			if c.protocol() == 3 {
+				if inv.flush {
+					payload = "_\r\n"
+				}
+				c.push(respPush([]string{respBulk("invalidate"), payload}))
+			}
+			continue
+		}
+		to := s.clients.get(redirect)
+		if to == nil {
+			if c.protocol() == 3 {
+				c.push(respPush([]string{respBulk("tracking-redir-broken"), respInt(redirect)}))
+			}
+			continue
+		}
+		if inv.flush {
+			payload = respNil
+			if to.protocol() == 3 {
+				payload = "_\r\n"
+			}
+		}
+		if to.protocol() == 3 || s.pubsub.subscribed(to, trackingChannel) {
+			to.push(to.frame(respBulk("message"), respBulk(trackingChannel), payload))
+		}
+	}
+}
+
+// clientTrackingOn handles CLIENT TRACKING ON|OFF [REDIRECT id] [PREFIX prefix
+// ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func (c *client) clientTrackingOn(args []string) string {
+	s := c.server
+	var opts trackingOptions
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "redirect":
+			if i+1 == len(args) || opts.redirect != 0 {
+				return respError("ERR syntax error")
+			}
+			i++
+			id, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return respError(ErrNotInteger.Error())
+			}
+			if id == c.id {
+				// Redirecting to oneself is the same as not redirecting
+				continue
+			}
+			if s.clients.get(id) == nil {
+				return respError("ERR The client ID you want redirect to does not exist")
+			}
+			opts.redirect = id
+		case "prefix":
+			if i+1 == len(args) {
+				return respError("ERR syntax error")
+			}
+			i++
+			opts.prefixes = append(opts.prefixes, args[i])
+		case "bcast":
+			opts.bcast = true
+		case "optin":
+			opts.optin = true
+		case "optout":
+			opts.optout = true
+		case "noloop":
+			opts.noloop = true
+		default:
+			return respError("ERR syntax error")
+		}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "off":
+		s.tracking.disable(c)
+		s.syncKeyEventHooks()
+		return respOK
+	case "on":
+	default:
+		return respError("ERR syntax error")
+	}
+	if len(opts.prefixes) > 0 && !opts.bcast {
+		return respError("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if opts.optin && opts.optout {
+		return respError("ERR You can't use both OPTIN and OPTOUT")
+	}
+	if opts.bcast && (opts.optin || opts.optout) {
+		return respError("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if c.tracking.on.Load() {
+		s.tracking.mu.Lock()
+		bcast, optin, optout := c.tracking.bcast, c.tracking.optin, c.tracking.optout
+		s.tracking.mu.Unlock()
+		if bcast != opts.bcast {
+			return respError("ERR You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+		if optin != opts.optin || optout != opts.optout {
+			return respError("ERR You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode.")
+		}
+	}
+	for i, a := range opts.prefixes {
+		for _, b := range opts.prefixes[i+1:] {
+			if strings.HasPrefix(a, b) || strings.HasPrefix(b, a) {
+				return respError(fmt.Sprintf("ERR Prefix '%s' overlaps with another provided prefix '%s'. Prefixes for a single client must not overlap.", a, b))
+			}
+		}
+	}
+	s.tracking.enable(c, opts)
+	s.syncKeyEventHooks()
+	return respOK
+}
+
+// clientCaching handles CLIENT CACHING YES|NO, which decides whether the next
+// command is tracked in OPTIN or OPTOUT mode
+func (c *client) clientCaching(arg string) string {
+	s := c.server
+	s.tracking.mu.Lock()
+	optin, optout := c.tracking.optin, c.tracking.optout
+	s.tracking.mu.Unlock()
+	if !c.tracking.on.Load() || (!optin && !optout) {
+		return respError("ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled")
+	}
+	switch strings.ToLower(arg) {
+	case "yes":
+		if !optin {
+			return respError("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+		c.tracking.caching = 1
+	case "no":
+		if !optout {
+			return respError("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+		c.tracking.caching = -1
+	default:
+		return respError("ERR syntax error")
+	}
+	return respOK
+}
+
+// clientGetRedir handles CLIENT GETREDIR: -1 without tracking, 0 without
+// redirection, or the ID of the client invalidations go to
+func (c *client) clientGetRedir() string {
+	if !c.tracking.on.Load() {
+		return respInt(-1)
+	}
+	c.server.tracking.mu.Lock()
+	defer c.server.tracking.mu.Unlock()
+	return respInt(c.tracking.redirect)
+}
+
+// clientTrackingInfo handles CLIENT TRACKINGINFO
+func (c *client) clientTrackingInfo() string {
+	s := c.server
+	s.tracking.mu.Lock()
+	ct := &c.tracking
+	flags := []string{}
+	redirect := int64(-1)
+	if !ct.on.Load() {
+		flags = append(flags, "off")
+	} else {
+		flags = append(flags, "on")
+		redirect = ct.redirect
+		for _, flag := range []struct {
+			set  bool
+			name string
+		}{{ct.bcast, "bcast"}, {ct.optin, "optin"}, {ct.optout, "optout"}, {ct.noloop, "noloop"}} {
+			if flag.set {
+				flags = append(flags, flag.name)
+			}
+		}
+		if ct.caching == 1 {
+			flags = append(flags, "caching-yes")
+		} else if ct.caching == -1 {
+			flags = append(flags, "caching-no")
+		}
+		if redirect != 0 && s.clients.get(redirect) == nil {
+			flags = append(flags, "broken_redirect")
+		}
+	}
+	prefixes := append([]string{}, ct.prefixes...)
+	s.tracking.mu.Unlock()
+
+	return respMap(c.protocol() == 3, []string{
+		respBulk("flags"), respArray(flags),
+		respBulk("redirect"), respInt(redirect),
+		respBulk("prefixes"), respArray(prefixes),
+	})
+}
+
+// trackingFlags returns the CLIENT LIST flags and redir field for c
+func (c *client) trackingFlags() (flags string, redirect int64) {
+	if !c.tracking.on.Load() {
+		return "", -1
+	}
+	t := c.server.tracking
+	t.mu.Lock()
+	bcast, redirect := c.tracking.bcast, c.tracking.redirect
+	t.mu.Unlock()
+	flags = "t"
+	if bcast {
+		flags += "B"
+	}
+	if redirect != 0 && c.server.clients.get(redirect) == nil {
+		flags += "R"
+	}
+	return flags, redirect
+}
+
+// stats returns the number of tracked keys and BCAST prefixes
+func (t *trackingTable) stats() (keys, prefixes int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.keys), len(t.prefixes)
+}
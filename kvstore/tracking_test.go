@@ -0,0 +1,75 @@
+package kvstore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrackingDefaultModeResp3(t *testing.T) {
+	server := NewRedisServer(nil)
+	a, readA := pipeClient(t, server)
+	b, _ := pipeClient(t, server)
+
+	if got := a("HELLO", "3"); !strings.HasPrefix(got, "%[server redis") || !strings.Contains(got, "proto :3") {
+		t.Fatalf("HELLO 3 replied %q", got)
+	}
+	a("CLIENT", "TRACKING", "ON")
+	a("GET", "k")
+	b("SET", "k", "1")
+	if got := readA(); got != ">[invalidate *[k]]" {
+		t.Fatalf("got %q instead of the invalidation", got)
+	}
+
+	// Keys are tracked once: a second write without a read in between is silent
+	b("SET", "k", "2")
+	a("GET", "other")
+	b("SET", "other", "1")
+	if got := readA(); got != ">[invalidate *[other]]" {
+		t.Fatalf("got %q instead of the invalidation of other", got)
+	}
+
+	// Over the table limit, a key is evicted and its reader told so
+	server.SetTrackingTableMaxKeys(1)
+	a("GET", "x")
+	replies := a("GET", "y") + " " + readA()
+	if strings.Count(replies, ">[invalidate") != 1 {
+		t.Fatalf("eviction from the table sent %q", replies)
+	}
+}
+
+func TestTrackingBcastRedirect(t *testing.T) {
+	server := NewRedisServer(nil)
+	r, readR := pipeClient(t, server)
+	c, _ := pipeClient(t, server)
+	w, _ := pipeClient(t, server)
+
+	id := strings.TrimPrefix(r("CLIENT", "ID"), ":")
+	if got := r("SUBSCRIBE", trackingChannel); got != "*[subscribe __redis__:invalidate :1]" {
+		t.Fatalf("SUBSCRIBE replied %q", got)
+	}
+	if got := r("GET", "k"); !strings.HasPrefix(got, "-ERR Can't execute 'get'") {
+		t.Fatalf("GET while subscribed replied %q", got)
+	}
+	if got := c("CLIENT", "TRACKING", "ON", "PREFIX", "user:"); !strings.HasPrefix(got, "-ERR PREFIX") {
+		t.Fatalf("PREFIX without BCAST replied %q", got)
+	}
+	if got := c("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "PREFIX", "user:1", "REDIRECT", id); !strings.HasPrefix(got, "-ERR Prefix") {
+		t.Fatalf("overlapping prefixes replied %q", got)
+	}
+	if got := c("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:", "REDIRECT", id); got != "+OK" {
+		t.Fatalf("CLIENT TRACKING replied %q", got)
+	}
+	if got := c("CLIENT", "GETREDIR"); got != ":"+id {
+		t.Fatalf("CLIENT GETREDIR replied %q", got)
+	}
+
+	w("SET", "other", "1")
+	w("MSET", "user:1", "a", "user:2", "b")
+	if got := readR(); got != "*[message __redis__:invalidate *[user:1 user:2]]" {
+		t.Fatalf("got %q instead of the invalidation", got)
+	}
+	w("FLUSHALL")
+	if got := readR(); got != "*[message __redis__:invalidate $-1]" {
+		t.Fatalf("got %q instead of the flush invalidation", got)
+	}
+}
@@ -0,0 +1,95 @@
+package kvstore
+
+// Versions number the writes of every key, for the CAS tokens of the
+// memcached front-end: unlike a hash of the value, a version changes even
+// when a write stores a value the key held before. Stores only keep them
+// once trackVersions is called, as they cost a map entry per key.
+
+// trackVersions starts numbering the writes of the keys, giving the existing
+// keys a version each
+func (kv *KVStore) trackVersions() {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if kv.versions != nil {
+		return
+	}
+	kv.versions = make(map[string]uint64)
+	kv.data.each(func(key string) bool {
+		kv.bumpVersion(key)
+		return true
+	})
+}
+
+// bumpVersion gives key a new version. Callers hold kv.mu.
+func (kv *KVStore) bumpVersion(key string) {
+	if kv.versions != nil {
+		kv.lastVersion++
+		kv.versions[key] = kv.lastVersion
+	}
+}
+
+// getVersion is GetEx that also returns the version of the value. A zero
+// TTLOption only needs the read lock.
+func (kv *KVStore) getVersion(key string, ttl TTLOption) (string, uint64, bool) {
+	if ttl == (TTLOption{}) {
+		kv.mu.RLock()
+		defer kv.mu.RUnlock()
+		value, ok := kv.liveValue(key)
+		kv.lookup(ok)
+		if ok {
+			kv.touch(key)
+		}
+		return value, kv.versions[key], ok
+	}
+	kv.mu.Lock()
+	defer kv.unlock()
+	value, ok := kv.getExLocked(key, ttl)
+	return value, kv.versions[key], ok
+}
+
+// updateVersion is Update with the version of the current value passed to
+// fn, returning the version of the value it wrote
+func (kv *KVStore) updateVersion(key string, ttl TTLOption, fn func(value string, version uint64, exists bool) (string, bool)) (uint64, bool, error) {
+	kv.mu.Lock()
+	defer kv.unlock()
+
+	old, existed := kv.writableValue(key)
+	value, ok := fn(old, kv.versions[key], existed)
+	if !ok {
+		return 0, false, nil
+	}
+	if err := kv.writeLocked(key, value, ttl); err != nil {
+		return 0, false, err
+	}
+	return kv.versions[key], true, nil
+}
+
+// deleteVersion deletes key only while its value has the given version
+func (kv *KVStore) deleteVersion(key string, version uint64) bool {
+	kv.mu.Lock()
+	defer kv.unlock()
+	if _, ok := kv.writableValue(key); !ok || kv.versions[key] != version {
+		return false
+	}
+	kv.delLocked(key)
+	kv.notify(EventDel, key)
+	return true
+}
+
+func (s *ShardedKVStore) trackVersions() {
+	for _, shard := range s.shards {
+		shard.trackVersions()
+	}
+}
+
+func (s *ShardedKVStore) getVersion(key string, ttl TTLOption) (string, uint64, bool) {
+	return s.shard(key).getVersion(key, ttl)
+}
+
+func (s *ShardedKVStore) updateVersion(key string, ttl TTLOption, fn func(value string, version uint64, exists bool) (string, bool)) (uint64, bool, error) {
+	return s.shard(key).updateVersion(key, ttl, fn)
+}
+
+func (s *ShardedKVStore) deleteVersion(key string, version uint64) bool {
+	return s.shard(key).deleteVersion(key, version)
+}
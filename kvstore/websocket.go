@@ -0,0 +1,281 @@
+package kvstore
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGUID is the magic value of the WebSocket handshake in RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketSubprotocol is the subprotocol a client may ask for to make sure
+// it talks to a RESP endpoint
+const websocketSubprotocol = "resp"
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var errWebSocketProtocol = errors.New("websocket: protocol error")
+
+// httpWebSocket upgrades a request to a WebSocket carrying RESP: the
+// messages from the client are read as one stream of commands, and every
+// reply and pushed message is sent as a message of its own. Past the
+// handshake the connection is served like a TCP one.
+func (s *RedisServer) httpWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		writeJSONError(w, http.StatusBadRequest, "expected a WebSocket upgrade")
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeJSONError(w, http.StatusUpgradeRequired, "unsupported WebSocket version")
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		writeJSONError(w, http.StatusBadRequest, "missing Sec-WebSocket-Key")
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "connection cannot be upgraded")
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	// Drop the deadlines of the HTTP server, the connection has its own
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if headerHasToken(r.Header, "Sec-WebSocket-Protocol", websocketSubprotocol) {
+		response += "Sec-WebSocket-Protocol: " + websocketSubprotocol + "\r\n"
+	}
+	if _, err := conn.Write([]byte(response + "\r\n")); err != nil {
+		conn.Close()
+		return
+	}
+
+	ws := &wsConn{Conn: conn, reader: rw.Reader}
+	ws.text.Store(true)
+	if !s.admitConn(ws) {
+		ws.Close()
+		return
+	}
+	s.handleConnection(ws)
+}
+
+// headerHasToken reports whether a comma separated header contains token
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// originAllowed reports whether the page a browser sends a request of the
+// HTTP gateway from, or opens a WebSocket from, may do so: pages of the same
+// host and of websocket-allowed-origins may, so other sites cannot reach the
+// server through their visitors. Clients other than browsers send no Origin.
+func (s *RedisServer) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range s.config().WebSocketAllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsConn is a server side WebSocket connection that reads and writes like
+// a stream: Read returns the payload of the data frames, answering pings
+// along the way, and every Write is sent as a message of its own
+type wsConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	// remaining is what is left of the payload of the current frame, and
+	// mask and maskPos unmask it
+	remaining uint64
+	mask      [4]byte
+	maskPos   int
+	// text is set while the client sends text messages, which the replies
+	// then are as well when they are valid UTF-8
+	text atomic.Bool
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func (ws *wsConn) Read(p []byte) (int, error) {
+	for ws.remaining == 0 {
+		if err := ws.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > ws.remaining {
+		p = p[:ws.remaining]
+	}
+	n, err := ws.reader.Read(p)
+	ws.unmask(p[:n])
+	ws.remaining -= uint64(n)
+	return n, err
+}
+
+func (ws *wsConn) unmask(p []byte) {
+	for i := range p {
+		p[i] ^= ws.mask[ws.maskPos&3]
+		ws.maskPos++
+	}
+}
+
+// nextFrame reads frame headers until one of a data frame, handling the
+// control frames in between. A close frame is answered and ends the stream.
+func (ws *wsConn) nextFrame() error {
+	var header [2]byte
+	if _, err := io.ReadFull(ws.reader, header[:]); err != nil {
+		return err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.reader, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	// Clients must mask their frames
+	if !masked {
+		ws.closeWith(1002)
+		return errWebSocketProtocol
+	}
+	if _, err := io.ReadFull(ws.reader, ws.mask[:]); err != nil {
+		return err
+	}
+	ws.maskPos = 0
+
+	switch opcode {
+	case wsText, wsBinary, wsContinuation:
+		if opcode != wsContinuation {
+			ws.text.Store(opcode == wsText)
+		}
+		ws.remaining = length
+		return nil
+	case wsClose, wsPing, wsPong:
+	default:
+		ws.closeWith(1002)
+		return errWebSocketProtocol
+	}
+
+	if length > 125 || header[0]&0x80 == 0 {
+		ws.closeWith(1002)
+		return errWebSocketProtocol
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.reader, payload); err != nil {
+		return err
+	}
+	ws.unmask(payload)
+	switch opcode {
+	case wsClose:
+		ws.closeWith(1000)
+		return io.EOF
+	case wsPing:
+		if err := ws.writeFrame(wsPong, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write sends p as one message, a text message if the client sends text
+// and p is valid UTF-8, a binary one otherwise
+func (ws *wsConn) Write(p []byte) (int, error) {
+	opcode := byte(wsBinary)
+	if ws.text.Load() && utf8.Valid(p) {
+		opcode = wsText
+	}
+	if err := ws.writeFrame(opcode, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+	_, err := ws.Conn.Write(frame)
+	return err
+}
+
+// closeWith sends a close frame with a status code; nothing is written after it
+func (ws *wsConn) closeWith(code uint16) {
+	ws.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	ws.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, code))
+	ws.writeMu.Lock()
+	ws.closed = true
+	ws.writeMu.Unlock()
+}
+
+// Close ends the WebSocket with a normal closure and closes the connection
+func (ws *wsConn) Close() error {
+	ws.closeWith(1000)
+	return ws.Conn.Close()
+}
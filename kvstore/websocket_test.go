@@ -0,0 +1,155 @@
+package kvstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// dialWebSocket opens a WebSocket on the /ws endpoint of ts and returns
+// functions sending a masked frame and reading a frame
+func dialWebSocket(t *testing.T, ts *httptest.Server) (send func(opcode byte, payload string), read func() (byte, string)) {
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: "+ts.Listener.Addr().String()+"\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Protocol: resp\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The accept value of the handshake example of RFC 6455
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake returned %d %v", resp.StatusCode, resp.Header)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "resp" {
+		t.Fatalf("subprotocol is %q", got)
+	}
+
+	send = func(opcode byte, payload string) {
+		mask := []byte{1, 2, 3, 4}
+		frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+		frame = append(frame, mask...)
+		for i := range len(payload) {
+			frame = append(frame, payload[i]^mask[i%4])
+		}
+		if _, err := conn.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read = func() (byte, string) {
+		var header [2]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			t.Fatal(err)
+		}
+		length := int(header[1] & 0x7f)
+		if length == 126 {
+			var ext [2]byte
+			io.ReadFull(reader, ext[:])
+			length = int(binary.BigEndian.Uint16(ext[:]))
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			t.Fatal(err)
+		}
+		return header[0] & 0x0f, string(payload)
+	}
+	return send, read
+}
+
+func TestWebSocket(t *testing.T) {
+	server := NewRedisServer(nil)
+	ts := httptest.NewServer(server.HTTPHandler())
+	defer ts.Close()
+	send, read := dialWebSocket(t, ts)
+
+	// A command may span frames
+	send(wsText, "*3\r\n$3\r\nSET\r\n")
+	send(wsText, "$1\r\nk\r\n$1\r\nv\r\n")
+	if opcode, reply := read(); opcode != wsText || reply != respOK {
+		t.Fatalf("SET replied %d %q", opcode, reply)
+	}
+	send(wsPing, "hi")
+	if opcode, payload := read(); opcode != wsPong || payload != "hi" {
+		t.Fatalf("ping answered with %d %q", opcode, payload)
+	}
+
+	// Pushed messages arrive as messages of their own
+	send(wsBinary, "*2\r\n$9\r\nSUBSCRIBE\r\n$4\r\nnews\r\n")
+	if _, reply := read(); reply != "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n" {
+		t.Fatalf("SUBSCRIBE replied %q", reply)
+	}
+	server.handleCommand(&client{server: server}, []string{"PUBLISH", "news", "hello"})
+	if opcode, msg := read(); opcode != wsBinary || msg != "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n" {
+		t.Fatalf("got message %d %q", opcode, msg)
+	}
+	if !strings.Contains(server.handleCommand(&client{server: server}, []string{"CLIENT", "LIST"}), "sub=1") {
+		t.Fatal("the WebSocket client is not in CLIENT LIST")
+	}
+
+	send(wsClose, "\x03\xe8")
+	if opcode, _ := read(); opcode != wsClose {
+		t.Fatalf("close answered with opcode %d", opcode)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	server := NewRedisServer(nil)
+	ts := httptest.NewServer(server.HTTPHandler())
+	defer ts.Close()
+	req, _ := http.NewRequest("GET", ts.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "https://elsewhere.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross-site handshake returned %d", resp.StatusCode)
+	}
+
+	server.handleCommand(&client{server: server}, []string{"CONFIG", "SET", "websocket-allowed-origins", "https://elsewhere.example"})
+	if !server.originAllowed(req) {
+		t.Fatal("allowed origin was refused")
+	}
+}
+
+func TestWebSocketInvalidLengths(t *testing.T) {
+	server := NewRedisServer(nil)
+	ts := httptest.NewServer(server.HTTPHandler())
+	defer ts.Close()
+
+	for frame, want := range map[string]string{
+		"*-5\r\n":                    "-ERR Protocol error: invalid multibulk length\r\n",
+		"*1\r\n$-5\r\n":              "-ERR Protocol error: invalid bulk length\r\n",
+		"*2\r\n$3\r\nGET\r\n$-1\r\n": "-ERR Protocol error: invalid bulk length\r\n",
+	} {
+		send, read := dialWebSocket(t, ts)
+		send(wsText, frame)
+		if _, reply := read(); reply != want {
+			t.Fatalf("%q replied %q, want %q", frame, reply, want)
+		}
+	}
+
+	// A null array is skipped and the server keeps serving
+	send, read := dialWebSocket(t, ts)
+	send(wsText, "*-1\r\n*1\r\n$4\r\nPING\r\n")
+	if _, reply := read(); reply != "+PONG\r\n" {
+		t.Fatalf("PING after a null array replied %q", reply)
+	}
+}
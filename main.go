@@ -1,21 +1,53 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/tluyben/go-mem-kv/kvstore"
 )
 
 func main() {
-	// Define the port flag
-	port := flag.Int("port", 6379, "Port number to run the Redis-compatible server on")
+	// The cli subcommand is a client, see "go-mem-kv cli -help"
+	if len(os.Args) > 1 && os.Args[1] == "cli" {
+		os.Exit(kvstore.RunCLI(os.Args[2:]))
+	}
+
 	redisTest := flag.String("redis-test", "", "Run Redis benchmark (format: localhost:port)")	
 	slowRedisTest := flag.String("slow-redis-test", "", "Run slow Redis compatibility test (format: localhost:port)")
+	configFile := flag.String("config", "", "redis.conf style configuration file; flags given on the command line override it")
+	// The flags below share their names with the configuration parameters
+	flag.String("bind", "", "Space separated addresses to listen on, e.g. \"127.0.0.1 -::1\" (all interfaces when empty)")
+	flag.Int("port", 6379, "Port number to run the Redis-compatible server on (0 disables TCP)")
+	flag.String("unixsocket", "", "Path of a Unix domain socket to listen on as well")
+	flag.String("unixsocketperm", "0", "Octal permissions of the Unix socket, e.g. 770 (0 keeps the default)")
+	flag.String("protected-mode", "yes", "Refuse clients that are not local while listening on all interfaces (yes or no)")
+	flag.Int("memcached-port", 0, "Port of the memcached protocol listener on the bind addresses, serving database 0 (0 disables it)")
+	flag.String("engine", "map", "Storage engine: map or arena (GC-friendly for very large keyspaces)")
+	flag.String("maxmemory", "0", "Memory limit for the stored data of all databases together, e.g. 100mb or 2gb (0 means unlimited)")
+	flag.String("maxmemory-policy", "noeviction", "Eviction policy: noeviction, allkeys-lru, allkeys-lfu, allkeys-random, volatile-lru, volatile-lfu, volatile-ttl or volatile-random")
+	flag.Int("databases", 16, "Number of databases clients can SELECT")
+	flag.String("metrics-addr", "", "Address for the HTTP listener serving /metrics, /healthz and /readyz, e.g. :9121 (disabled when empty)")
+	flag.String("http-addr", "", "Address for the HTTP/JSON gateway with /keys, /command, /subscribe and the /ws WebSocket, e.g. :8080 (disabled when empty)")
+	flag.String("websocket-allowed-origins", "", "Space separated origins of other sites whose pages may use the HTTP gateway and its /ws WebSocket, e.g. \"https://dash.example.com\" (\"*\" allows any)")
+	flag.Int64("slowlog-log-slower-than", 10000, "Record commands taking at least this many microseconds in the slow log (negative disables it)")
+	flag.Int("slowlog-max-len", 128, "Number of entries the slow log keeps")
+	flag.Int64("latency-monitor-threshold", 0, "Record latency spikes of at least this many milliseconds (0 disables the latency monitor)")
+	flag.Int64("tracking-table-max-keys", 1000000, "Keys remembered for client side caching before readers of evicted keys are invalidated (0 means unlimited)")
+	flag.String("notify-keyspace-events", "", "Keyspace notification classes to publish, as in Redis, e.g. Ex for expired keys (empty disables them)")
+	flag.Int("shutdown-timeout", 10, "Seconds a shutdown waits for the commands in flight before closing connections")
+	flag.Int("maxclients", 10000, "Maximum number of connected clients")
+	flag.Int("timeout", 0, "Close connections idle for this many seconds (0 disables it)")
+	flag.Int("tcp-keepalive", 300, "TCP keepalive period in seconds (0 disables keepalives)")
+	flag.String("client-output-buffer-limit", "", "Output buffer limits as <class> <hard> <soft> <soft seconds> groups, e.g. \"pubsub 32mb 8mb 60\"")
 	flag.Parse()
 
 	if *redisTest != "" {
@@ -28,22 +60,48 @@ func main() {
 		return
 	}
 
-	// Set the REDIS_PORT environment variable
-	os.Setenv("REDIS_PORT", strconv.Itoa(*port))
-
-	// Create a new KVStore instance
-	store := kvstore.New()
+	cfg := kvstore.DefaultConfig()
+	if *configFile != "" {
+		var err error
+		if cfg, err = kvstore.LoadConfig(*configFile); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if _, ok := cfg.Get(f.Name); !ok {
+			return
+		}
+		if err := cfg.Set(f.Name, f.Value.String()); err != nil {
+			log.Fatalf("Invalid -%s: %v", f.Name, err)
+		}
+	})
 
 	// Create a new RedisServer instance
-	server := kvstore.NewRedisServer(store)
+	server := kvstore.NewRedisServer(cfg)
+
+	if cfg.MetricsAddr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(cfg.MetricsAddr, server.MetricsHandler()))
+		}()
+		fmt.Printf("Serving metrics on %s/metrics\n", cfg.MetricsAddr)
+	}
+	if cfg.HTTPAddr != "" {
+		go func() {
+			log.Fatal(http.ListenAndServe(cfg.HTTPAddr, server.HTTPHandler()))
+		}()
+		fmt.Printf("Serving the HTTP gateway on %s\n", cfg.HTTPAddr)
+	}
 
-	// Start the server
-	fmt.Printf("Starting Redis-compatible server on port %d\n", *port)
-	fmt.Printf("Use 'telnet localhost %d' to connect\n", *port)
-	err := server.Start()
-	if err != nil {
+	// Start the server; SIGINT and SIGTERM shut it down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Printf("Starting Redis-compatible server on port %d\n", cfg.Port)
+	fmt.Printf("Use 'telnet localhost %d' to connect\n", cfg.Port)
+	err := server.Serve(ctx)
+	if err != nil && !errors.Is(err, kvstore.ErrServerClosed) {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	fmt.Println("Server stopped")
 }
 
 func runRedisBenchmark(address string) {